@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/vexrina/cinemaLibrary/pkg/bulkapi"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// subcommands are run instead of the http server:
+//
+//	cinemaLibrary import -entity films [-format csv] [-dry-run] films.csv
+//	cinemaLibrary export -entity films|actors|credits [-format csv|ndjson|json] films.csv
+func runCommand(args []string, db *sql.DB) error {
+	// changes made from CLI are audited under this name
+	cliOrm := orm.NewORM(db).WithAudit(types.AuditMeta{Username: "cli"})
+
+	switch args[0] {
+	case "import":
+		return importCommand(args[1:], cliOrm)
+	case "export":
+		return exportCommand(args[1:], cliOrm)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func importCommand(args []string, orm *orm.ORM) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	entity := flags.String("entity", "films", "films or actors")
+	format := flags.String("format", "", "csv or ndjson, by default taken from file extension")
+	dryRun := flags.Bool("dry-run", false, "validate and report without saving")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: import -entity films|actors [-format csv|ndjson] [-dry-run] file")
+	}
+
+	fileName := flags.Arg(0)
+	if *format == "" {
+		*format = formatFromExtension(fileName)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report, err := bulkapi.Import(orm, file, *entity, *format, *dryRun)
+	if err != nil && !errors.Is(err, bulkapi.ErrInvalidRows) {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if encodeErr := encoder.Encode(report); encodeErr != nil {
+		return encodeErr
+	}
+	return err
+}
+
+func exportCommand(args []string, orm *orm.ORM) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	entity := flags.String("entity", "films", "films, actors or credits")
+	format := flags.String("format", "", "csv, ndjson or json, by default taken from file extension")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: export -entity films|actors|credits [-format csv|ndjson|json] file")
+	}
+
+	fileName := flags.Arg(0)
+	if *format == "" {
+		*format = formatFromExtension(fileName)
+	}
+	if err := bulkapi.ValidateExport(*entity, *format); err != nil {
+		return err
+	}
+
+	// a failed export must not leave a cut file that looks complete, the file appears only when everything is written
+	file, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*")
+	if err != nil {
+		return err
+	}
+	err = bulkapi.Export(orm, file, *entity, *format)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// temp files are private, an export is an ordinary file
+		err = os.Chmod(file.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), fileName)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// utility
+func formatFromExtension(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	case ".json":
+		return "json"
+	default:
+		return "csv"
+	}
+}
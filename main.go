@@ -1,15 +1,36 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/vexrina/cinemaLibrary/pkg/actorapi"
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/bulkapi"
+	"github.com/vexrina/cinemaLibrary/pkg/collectionapi"
 	"github.com/vexrina/cinemaLibrary/pkg/database"
 	"github.com/vexrina/cinemaLibrary/pkg/filmapi"
+	"github.com/vexrina/cinemaLibrary/pkg/imageapi"
+	"github.com/vexrina/cinemaLibrary/pkg/mailer"
+	"github.com/vexrina/cinemaLibrary/pkg/mediaapi"
+	"github.com/vexrina/cinemaLibrary/pkg/metadata"
+	"github.com/vexrina/cinemaLibrary/pkg/metadataapi"
+	"github.com/vexrina/cinemaLibrary/pkg/oidc"
 	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/passwords"
+	"github.com/vexrina/cinemaLibrary/pkg/ratelimit"
+	"github.com/vexrina/cinemaLibrary/pkg/releaseapi"
+	"github.com/vexrina/cinemaLibrary/pkg/storage"
 	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/translationapi"
 	"github.com/vexrina/cinemaLibrary/pkg/userapi"
 )
 
@@ -23,9 +44,34 @@ func main() {
 	}
 	defer db.Close()
 
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:], db); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	actorOrm := orm.NewORM(db)
 	filmOrm := orm.NewORM(db)
 	userOrm := orm.NewORM(db)
+	auditOrm := orm.NewORM(db)
+	bulkOrm := orm.NewORM(db)
+	metadataOrm := orm.NewORM(db)
+	providers := metadataProviders()
+	imageOrm := orm.NewORM(db)
+	imageStore := imageStorage()
+	collectionOrm := orm.NewORM(db)
+	mail := mailSender()
+	accountSettings()
+	if err := passwordSettings(); err != nil {
+		log.Fatal(err)
+	}
+	if err := proxySettings(); err != nil {
+		log.Fatal(err)
+	}
+	loginProviders := oidcProviders()
+	tokens.APIKeyClaims = userapi.APIKeyResolver(userOrm)
+	tokens.LoginTokenValid = userapi.LoginTokenChecker(userOrm)
 
 	http.HandleFunc("/actor", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -97,8 +143,769 @@ func main() {
 		}
 	})
 
-	http.HandleFunc("/user/register", func(w http.ResponseWriter, r *http.Request) { userapi.RegisterHandler(w, r, userOrm) })
+	http.HandleFunc("/actor/restore", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			actorapi.RestoreActorHandler(w, r, actorOrm)
+		}
+	})
+	http.HandleFunc("/actor/trash", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			actorapi.GetDeletedActorsHandler(w, r, actorOrm)
+		}
+	})
+
+	http.HandleFunc("/film/restore", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			filmapi.RestoreFilmHandler(w, r, filmOrm)
+		}
+	})
+	http.HandleFunc("/film/trash", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			filmapi.GetDeletedFilmsHandler(w, r, filmOrm)
+		}
+	})
+
+	// /actor/{id}, /actor/{id}/history, /actor/{id}/history/{rev}, /actor/{id}/revert/{rev}, /actor/{id}/photo
+	http.HandleFunc("/actor/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/photo") {
+			imageRoute(w, r, imageOrm, imageStore, imageapi.UploadActorPhotoHandler, imageapi.DeleteActorPhotoHandler)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			_, err := tokens.ValidateToken(w, r)
+			if err != nil {
+				http.Error(w, "Bad token", http.StatusUnauthorized)
+			} else if _, ok := actorapi.ParseActorPath(r.URL.Path); ok {
+				actorapi.GetActorHandler(w, r, actorOrm)
+			} else {
+				actorapi.GetActorHistoryHandler(w, r, actorOrm)
+			}
+		case http.MethodPost:
+			admin, err := tokens.ValidateToken(w, r)
+			if err != nil || !admin {
+				http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+			} else {
+				actorapi.RevertActorHandler(w, r, actorOrm)
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	// /film/{id}, /film/{id}/history, /film/{id}/history/{rev}, /film/{id}/revert/{rev}, /film/{id}/poster,
+	// /film/{id}/media, /film/{id}/media/{mediaId}, /film/{id}/translations, /film/{id}/translations/{locale},
+	// /film/{id}/releases, /film/{id}/releases/{releaseId}
+	http.HandleFunc("/film/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/poster") {
+			imageRoute(w, r, imageOrm, imageStore, imageapi.UploadFilmPosterHandler, imageapi.DeleteFilmPosterHandler)
+			return
+		}
+		if _, _, ok := mediaapi.ParseMediaPath(r.URL.Path); ok {
+			mediaRoute(w, r, filmOrm)
+			return
+		}
+		if _, _, ok := translationapi.ParseTranslationPath(r.URL.Path); ok {
+			translationRoute(w, r, filmOrm)
+			return
+		}
+		if _, _, ok := releaseapi.ParseReleasePath(r.URL.Path); ok {
+			releaseRoute(w, r, filmOrm)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			_, err := tokens.ValidateToken(w, r)
+			if err != nil {
+				http.Error(w, "Bad token", http.StatusUnauthorized)
+			} else if _, ok := filmapi.ParseFilmPath(r.URL.Path); ok {
+				filmapi.GetFilmHandler(w, r, filmOrm)
+			} else {
+				filmapi.GetFilmHistoryHandler(w, r, filmOrm)
+			}
+		case http.MethodPost:
+			admin, err := tokens.ValidateToken(w, r)
+			if err != nil || !admin {
+				http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+			} else {
+				filmapi.RevertFilmHandler(w, r, filmOrm)
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	http.HandleFunc("/collection", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			_, err := tokens.ValidateToken(w, r)
+			if err != nil {
+				http.Error(w, "Bad token", http.StatusUnauthorized)
+			} else {
+				collectionapi.GetCollectionsHandler(w, r, collectionOrm)
+			}
+		case http.MethodPost:
+			admin, err := tokens.ValidateToken(w, r)
+			if err != nil || !admin {
+				http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+			} else {
+				collectionapi.CreateCollectionHandler(w, r, collectionOrm)
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	// /collection/{id}, /collection/{id}/films, /collection/{id}/films/{filmId}
+	http.HandleFunc("/collection/", func(w http.ResponseWriter, r *http.Request) {
+		collectionRoute(w, r, collectionOrm)
+	})
+
+	http.HandleFunc("/admin/audit", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			auditapi.GetAuditLogHandler(w, r, auditOrm)
+		}
+	})
+
+	http.HandleFunc("/admin/users", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			userapi.GetUsersHandler(w, r, userOrm)
+		}
+	})
+	// /admin/users/{id}, /admin/users/{id}/{unlock,disable,enable,reset-password}
+	http.HandleFunc("/admin/users/", func(w http.ResponseWriter, r *http.Request) {
+		adminUserRoute(w, r, userOrm, mail)
+	})
+
+	http.HandleFunc("/admin/import", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			bulkapi.ImportHandler(w, r, bulkOrm)
+		}
+	})
+
+	http.HandleFunc("/admin/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			bulkapi.ExportHandler(w, r, bulkOrm)
+		}
+	})
+
+	http.HandleFunc("/admin/metadata/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			metadataapi.SearchHandler(w, r, providers)
+		}
+	})
+	http.HandleFunc("/admin/metadata/preview", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			metadataapi.PreviewHandler(w, r, metadataOrm, providers)
+		}
+	})
+	http.HandleFunc("/admin/metadata/accept", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else {
+			metadataapi.AcceptHandler(w, r, metadataOrm, providers)
+		}
+	})
+
+	// public, image urls are returned in film/actor json and used directly in <img>
+	http.HandleFunc("/images/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		imageapi.ServeImageHandler(w, r, imageStore)
+	})
+
+	go purgeTrash(filmOrm, imageStore, trashRetention())
+	go deleteAccounts(userOrm)
+
+	http.HandleFunc("/user/register", func(w http.ResponseWriter, r *http.Request) { userapi.RegisterHandler(w, r, userOrm, mail) })
 	http.HandleFunc("/user/login", func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, userOrm) })
+	http.HandleFunc("/user/login/2fa", postOnly(func(w http.ResponseWriter, r *http.Request) { userapi.LoginTwoFactorHandler(w, r, userOrm) }))
+	http.HandleFunc("/user/2fa", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			userapi.GetTwoFactorHandler(w, r, userOrm)
+		case http.MethodDelete:
+			userapi.DisableTwoFactorHandler(w, r, userOrm)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	http.HandleFunc("/user/2fa/setup", postOnly(func(w http.ResponseWriter, r *http.Request) { userapi.SetupTwoFactorHandler(w, r, userOrm) }))
+	http.HandleFunc("/user/2fa/enable", postOnly(func(w http.ResponseWriter, r *http.Request) { userapi.EnableTwoFactorHandler(w, r, userOrm) }))
+	http.HandleFunc("/user/2fa/recovery-codes", postOnly(func(w http.ResponseWriter, r *http.Request) { userapi.RecoveryCodesHandler(w, r, userOrm) }))
+	http.HandleFunc("/user/me", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			userapi.GetMeHandler(w, r, userOrm)
+		case http.MethodPatch:
+			userapi.UpdateMeHandler(w, r, userOrm, mail)
+		case http.MethodDelete:
+			userapi.DeleteMeHandler(w, r, userOrm, mail)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	http.HandleFunc("/user/me/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userapi.ExportMeHandler(w, r, userOrm)
+	})
+	http.HandleFunc("/user/me/restore", postOnly(func(w http.ResponseWriter, r *http.Request) { userapi.RestoreMeHandler(w, r, userOrm) }))
+	http.HandleFunc("/user/api-keys", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			userapi.GetAPIKeysHandler(w, r, userOrm)
+		case http.MethodPost:
+			userapi.CreateAPIKeyHandler(w, r, userOrm)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	http.HandleFunc("/user/api-keys/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		userapi.RevokeAPIKeyHandler(w, r, userOrm)
+	})
+	http.HandleFunc("/user/oidc/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, action, _ := userapi.ParseOIDCPath(r.URL.Path); action == "callback" {
+			userapi.OIDCCallbackHandler(w, r, userOrm, loginProviders)
+			return
+		}
+		userapi.OIDCLoginHandler(w, r, userOrm, loginProviders)
+	})
+	http.HandleFunc("/user/verify", postOnly(func(w http.ResponseWriter, r *http.Request) { userapi.VerifyEmailHandler(w, r, userOrm) }))
+	http.HandleFunc("/user/verify/resend", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		userapi.ResendVerificationHandler(w, r, userOrm, mail)
+	}))
+	http.HandleFunc("/user/password/forgot", postOnly(func(w http.ResponseWriter, r *http.Request) {
+		userapi.ForgotPasswordHandler(w, r, userOrm, mail)
+	}))
+	http.HandleFunc("/user/password/reset", postOnly(func(w http.ResponseWriter, r *http.Request) { userapi.ResetPasswordHandler(w, r, userOrm) }))
+
+	log.Fatal(http.ListenAndServe(":8080", rateLimited(http.DefaultServeMux)))
+}
+
+// TRASH_RETENTION_DAYS - how long soft-deleted films/actors are kept before purge, default 30
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func purgeTrash(orm *orm.ORM, store storage.Storage, retention time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		purged, imageKeys, err := orm.PurgeDeleted(time.Now().Add(-retention))
+		// the rows are gone even when a later query failed, so are their images
+		for _, key := range imageKeys {
+			if err := store.DeletePrefix(key); err != nil {
+				log.Println("Error deleting image files:", key, err)
+			}
+		}
+		if err != nil {
+			log.Println("Error purging trash:", err)
+			continue
+		}
+		if purged > 0 {
+			log.Println("Purged from trash:", purged)
+		}
+	}
+}
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// accounts are deleted when the grace period after DELETE /user/me is over
+func deleteAccounts(orm *orm.ORM) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for ; true; <-ticker.C {
+		deleted, err := orm.DeleteScheduledUsers()
+		if err != nil {
+			log.Println("Error deleting accounts:", err)
+			continue
+		}
+		if deleted > 0 {
+			log.Println("Deleted accounts:", deleted)
+		}
+	}
+}
+
+// TMDB_API_TOKEN - read access token of TMDB, without it the provider is disabled
+// TMDB_API_URL - base url of the API, default metadata.TMDBDefaultURL
+func metadataProviders() metadata.Providers {
+	providers := metadata.Providers{}
+	if token := os.Getenv("TMDB_API_TOKEN"); token != "" {
+		baseURL := os.Getenv("TMDB_API_URL")
+		if baseURL == "" {
+			baseURL = metadata.TMDBDefaultURL
+		}
+		providers.Add(metadata.NewTMDB(baseURL, token))
+	}
+	return providers
+}
+
+// IMAGE_DIR - where uploaded posters and photos are stored, default ./images
+func imageStorage() storage.Storage {
+	dir := os.Getenv("IMAGE_DIR")
+	if dir == "" {
+		dir = "images"
+	}
+	return storage.NewLocal(dir)
+}
+
+// SMTP_ADDR - host:port of the mail server, with SMTP_USERNAME and SMTP_PASSWORD for auth
+// MAIL_DIR - without SMTP_ADDR mails are saved there as .eml files, without both they go to the log
+// MAIL_FROM - sender address, default noreply@localhost
+func mailSender() mailer.Mailer {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@localhost"
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		return mailer.NewSMTP(addr, from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	}
+	if dir := os.Getenv("MAIL_DIR"); dir != "" {
+		return mailer.NewFile(dir, from)
+	}
+	return mailer.NewLog(from)
+}
+
+// APP_URL - frontend url used in links of the mails, default userapi.AppURL
+// EMAIL_VERIFICATION - "off" lets users log in before the email is verified
+// LOGIN_LOCKOUT_THRESHOLD - failed logins in a row that lock the account, default 10
+// LOGIN_LOCKOUT_MINUTES - how long the account stays locked, default 30
+// TWO_FACTOR_ADMINS - "off" gives admins their rights without two-factor authentication
+// ACCOUNT_DELETION_DAYS - how long a deleted account can be restored before it is deleted for good, default 30
+func accountSettings() {
+	if appURL := os.Getenv("APP_URL"); appURL != "" {
+		userapi.AppURL = strings.TrimSuffix(appURL, "/")
+	}
+	if os.Getenv("EMAIL_VERIFICATION") == "off" {
+		userapi.RequireVerification = false
+	}
+	if os.Getenv("TWO_FACTOR_ADMINS") == "off" {
+		userapi.RequireAdminTwoFactor = false
+	}
+	if threshold, err := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_THRESHOLD")); err == nil && threshold > 0 {
+		userapi.LockoutThreshold = threshold
+	}
+	if minutes, err := strconv.Atoi(os.Getenv("LOGIN_LOCKOUT_MINUTES")); err == nil && minutes > 0 {
+		userapi.LockoutDuration = time.Duration(minutes) * time.Minute
+	}
+	if days, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_DAYS")); err == nil && days > 0 {
+		userapi.AccountDeletionGrace = time.Duration(days) * 24 * time.Hour
+	}
+}
+
+// TRUSTED_PROXIES - addresses and CIDR ranges of the proxies in front of the service, "10.0.0.0/8, 192.0.2.1".
+// X-Forwarded-For is honoured only in requests from them; by default nobody is trusted and the client ip is the remote address
+func proxySettings() error {
+	proxies, err := auditapi.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return err
+	}
+	auditapi.TrustedProxies = proxies
+	return nil
+}
+
+// PASSWORD_HASH - "argon2id" hashes new passwords with argon2id, default bcrypt. Hashes of the other algorithm
+// or with other parameters keep working and are replaced at the next login
+// BCRYPT_COST - default 10
+// ARGON2_MEMORY_KIB, ARGON2_TIME, ARGON2_THREADS - argon2id parameters, default 65536, 3, 4
+// PASSWORD_MIN_LENGTH - characters, default 8
+// PASSWORD_MAX_LENGTH - bytes, default 72, more only with argon2id because bcrypt ignores the rest
+// BREACHED_PASSWORDS_FILE - list of known passwords, one per line, that can not be chosen
+func passwordSettings() error {
+	number := func(name string, fallback int) int {
+		value, err := strconv.Atoi(os.Getenv(name))
+		if err != nil || value <= 0 {
+			return fallback
+		}
+		return value
+	}
+	switch algorithm := os.Getenv("PASSWORD_HASH"); algorithm {
+	case "", "bcrypt":
+		cost := number("BCRYPT_COST", bcrypt.DefaultCost)
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return fmt.Errorf("BCRYPT_COST must be from %d to %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		passwords.Default = passwords.Bcrypt{Cost: cost}
+	case "argon2id":
+		threads := number("ARGON2_THREADS", 4)
+		if threads > 255 {
+			return errors.New("ARGON2_THREADS must be up to 255")
+		}
+		passwords.Default = passwords.Argon2id{
+			Memory:  uint32(number("ARGON2_MEMORY_KIB", 64*1024)),
+			Time:    uint32(number("ARGON2_TIME", 3)),
+			Threads: uint8(threads),
+		}
+	default:
+		return fmt.Errorf("unknown PASSWORD_HASH %q, bcrypt or argon2id", algorithm)
+	}
+
+	policy := passwords.DefaultPolicy
+	policy.MinLength = number("PASSWORD_MIN_LENGTH", policy.MinLength)
+	policy.MaxLength = number("PASSWORD_MAX_LENGTH", policy.MaxLength)
+	if _, ok := passwords.Default.(passwords.Bcrypt); ok && policy.MaxLength > passwords.BcryptMaxLength {
+		return fmt.Errorf("PASSWORD_MAX_LENGTH must be up to %d with bcrypt", passwords.BcryptMaxLength)
+	}
+	if policy.MaxLength < policy.MinLength {
+		return errors.New("PASSWORD_MAX_LENGTH must not be less than PASSWORD_MIN_LENGTH")
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := passwords.LoadBreached(path)
+		if err != nil {
+			return err
+		}
+		policy.Breached = breached
+		log.Println("Breached passwords loaded:", len(breached))
+	}
+	passwords.DefaultPolicy = policy
+	return nil
+}
+
+// OIDC_PROVIDERS - comma separated names of OpenID Connect providers for /user/oidc/{name}/login, for every name
+// OIDC_{NAME}_ISSUER, OIDC_{NAME}_CLIENT_ID - required, a provider without them is skipped
+// OIDC_{NAME}_CLIENT_SECRET - empty for public clients, PKCE is used either way
+// OIDC_{NAME}_REDIRECT_URL - default the API callback under APP_URL
+// OIDC_{NAME}_SCOPES - space separated, default "openid email profile"
+// OIDC_{NAME}_GROUPS_CLAIM - default "groups"
+// OIDC_{NAME}_ADMIN_GROUPS - comma separated groups that make admins, without it roles are not taken from the provider
+// OIDC_{NAME}_TRUST_EMAIL - "on" for providers that do not send email_verified
+func oidcProviders() oidc.Providers {
+	providers := oidc.Providers{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		env := func(key string) string {
+			return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key)
+		}
+		config := oidc.Config{
+			Name:         name,
+			Issuer:       env("ISSUER"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			RedirectURL:  env("REDIRECT_URL"),
+			Scopes:       strings.Fields(env("SCOPES")),
+			GroupsClaim:  env("GROUPS_CLAIM"),
+			TrustEmail:   env("TRUST_EMAIL") == "on",
+		}
+		if config.Issuer == "" || config.ClientID == "" {
+			log.Println("OIDC provider", name, "has no issuer or client id, skipped")
+			continue
+		}
+		if config.RedirectURL == "" {
+			config.RedirectURL = userapi.AppURL + "/user/oidc/" + name + "/callback"
+		}
+		for _, group := range strings.Split(env("ADMIN_GROUPS"), ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				config.AdminGroups = append(config.AdminGroups, group)
+			}
+		}
+		providers.Add(oidc.New(config))
+	}
+	return providers
+}
+
+// RATE_LIMIT - "off" disables the limiter
+// RATE_LIMIT_ANONYMOUS, RATE_LIMIT_USER, RATE_LIMIT_ADMIN - requests per minute, 0 for no limit, default 60, 300, 1200.
+// Search (film and actor lists) and login get less, endpoints that send mail even less, for admins too:
+// the first matching rule applies, so the roles only set the budget of everything else
+func rateLimited(handler http.Handler) http.Handler {
+	if os.Getenv("RATE_LIMIT") == "off" {
+		return handler
+	}
+	perMinute := func(name string, fallback int) ratelimit.Limit {
+		requests, err := strconv.Atoi(os.Getenv(name))
+		if err != nil || requests < 0 {
+			requests = fallback
+		}
+		return ratelimit.PerMinute(requests)
+	}
+	limiter := ratelimit.New(ratelimit.NewMemory(),
+		ratelimit.Rule{Name: "login", Method: http.MethodPost, Path: "/user/login", Limit: ratelimit.PerMinute(10)},
+		ratelimit.Rule{Name: "login", Method: http.MethodPost, Path: "/user/login/2fa", Limit: ratelimit.PerMinute(10)},
+		ratelimit.Rule{Name: "login", Method: http.MethodGet, Path: "/user/oidc/", Limit: ratelimit.PerMinute(10)},
+		ratelimit.Rule{Name: "mail", Method: http.MethodPost, Path: "/user/register", Limit: ratelimit.PerMinute(5)},
+		ratelimit.Rule{Name: "mail", Method: http.MethodPost, Path: "/user/verify/resend", Limit: ratelimit.PerMinute(5)},
+		ratelimit.Rule{Name: "mail", Method: http.MethodPost, Path: "/user/password/forgot", Limit: ratelimit.PerMinute(5)},
+		ratelimit.Rule{Name: "export", Method: http.MethodGet, Path: "/user/me/export", Limit: ratelimit.PerMinute(2)},
+		ratelimit.Rule{Name: "search", Method: http.MethodGet, Path: "/film", Limit: ratelimit.PerMinute(30)},
+		ratelimit.Rule{Name: "search", Method: http.MethodGet, Path: "/actor", Limit: ratelimit.PerMinute(30)},
+		ratelimit.Rule{Name: "admin", Role: ratelimit.RoleAdmin, Limit: perMinute("RATE_LIMIT_ADMIN", 1200)},
+		ratelimit.Rule{Name: "user", Role: ratelimit.RoleUser, Limit: perMinute("RATE_LIMIT_USER", 300)},
+		ratelimit.Rule{Name: "anonymous", Limit: perMinute("RATE_LIMIT_ANONYMOUS", 60)},
+	)
+	return limiter.Middleware(handler)
+}
+
+// utility: the account endpoints take only post
+func postOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+type imageHandler func(w http.ResponseWriter, r *http.Request, orm *orm.ORM, store storage.Storage)
+
+// post uploads, delete removes, both for admin only
+func imageRoute(w http.ResponseWriter, r *http.Request, orm *orm.ORM, store storage.Storage, upload, remove imageHandler) {
+	var handler imageHandler
+	switch r.Method {
+	case http.MethodPost:
+		handler = upload
+	case http.MethodDelete:
+		handler = remove
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, err := tokens.ValidateToken(w, r)
+	if err != nil || !admin {
+		http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+	} else {
+		handler(w, r, orm, store)
+	}
+}
+
+// get for any user, changes for admin only
+func mediaRoute(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	switch r.Method {
+	case http.MethodGet:
+		_, err := tokens.ValidateToken(w, r)
+		if err != nil {
+			http.Error(w, "Bad token", http.StatusUnauthorized)
+		} else {
+			mediaapi.GetFilmMediaHandler(w, r, orm)
+		}
+	case http.MethodPost, http.MethodPatch, http.MethodDelete:
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			mediaapi.CreateFilmMediaHandler(w, r, orm)
+		case http.MethodPatch:
+			mediaapi.UpdateFilmMediaHandler(w, r, orm)
+		default:
+			mediaapi.DeleteFilmMediaHandler(w, r, orm)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// get for any user, changes for admin only
+func translationRoute(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	switch r.Method {
+	case http.MethodGet:
+		_, err := tokens.ValidateToken(w, r)
+		if err != nil {
+			http.Error(w, "Bad token", http.StatusUnauthorized)
+		} else {
+			translationapi.GetTranslationsHandler(w, r, orm)
+		}
+	case http.MethodPut, http.MethodDelete:
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else if r.Method == http.MethodPut {
+			translationapi.SetTranslationHandler(w, r, orm)
+		} else {
+			translationapi.DeleteTranslationHandler(w, r, orm)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// get for any user, changes for admin only
+func releaseRoute(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	switch r.Method {
+	case http.MethodGet:
+		_, err := tokens.ValidateToken(w, r)
+		if err != nil {
+			http.Error(w, "Bad token", http.StatusUnauthorized)
+		} else {
+			releaseapi.GetFilmReleasesHandler(w, r, orm)
+		}
+	case http.MethodPost, http.MethodDelete:
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		} else if r.Method == http.MethodPost {
+			releaseapi.CreateFilmReleaseHandler(w, r, orm)
+		} else {
+			releaseapi.DeleteFilmReleaseHandler(w, r, orm)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// admin only, /admin/users/{id} and its actions
+func adminUserRoute(w http.ResponseWriter, r *http.Request, orm *orm.ORM, mail mailer.Mailer) {
+	_, action, ok := userapi.ParseAdminUserPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	admin, err := tokens.ValidateToken(w, r)
+	if err != nil || !admin {
+		http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && action == "":
+		userapi.GetUserHandler(w, r, orm)
+	case r.Method == http.MethodPatch && action == "":
+		userapi.UpdateUserRoleHandler(w, r, orm)
+	case r.Method == http.MethodDelete && action == "":
+		userapi.DeleteUserHandler(w, r, orm)
+	case r.Method == http.MethodPost && action == "unlock":
+		userapi.UnlockUserHandler(w, r, orm)
+	case r.Method == http.MethodPost && action == "disable":
+		userapi.DisableUserHandler(w, r, orm)
+	case r.Method == http.MethodPost && action == "enable":
+		userapi.EnableUserHandler(w, r, orm)
+	case r.Method == http.MethodPost && action == "reset-password":
+		userapi.ForcePasswordResetHandler(w, r, orm, mail)
+	case action == "" || action == "unlock" || action == "disable" || action == "enable" || action == "reset-password":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// get for any user, changes for admin only
+func collectionRoute(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	_, films, _, ok := collectionapi.ParseCollectionPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		_, err := tokens.ValidateToken(w, r)
+		if err != nil {
+			http.Error(w, "Bad token", http.StatusUnauthorized)
+		} else {
+			collectionapi.GetCollectionHandler(w, r, orm)
+		}
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		admin, err := tokens.ValidateToken(w, r)
+		if err != nil || !admin {
+			http.Error(w, "Method not allowed by role", http.StatusMethodNotAllowed)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPatch && !films:
+			collectionapi.UpdateCollectionHandler(w, r, orm)
+		case r.Method == http.MethodDelete && !films:
+			collectionapi.DeleteCollectionHandler(w, r, orm)
+		case r.Method == http.MethodPost && films:
+			collectionapi.AddFilmHandler(w, r, orm)
+		case r.Method == http.MethodPut && films:
+			collectionapi.ReorderFilmsHandler(w, r, orm)
+		case r.Method == http.MethodDelete && films:
+			collectionapi.RemoveFilmHandler(w, r, orm)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
 }
@@ -3,8 +3,17 @@ package actorapi
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/isocode"
 	"github.com/vexrina/cinemaLibrary/pkg/orm"
 	"github.com/vexrina/cinemaLibrary/pkg/types"
 )
@@ -17,7 +26,11 @@ func CreateActorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	err = orm.CreateActor(actor)
+	if err := Validate(actor); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).CreateActor(actor)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -35,15 +48,107 @@ func UpdateActorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 		return
 	}
 
-	err = orm.UpdateActor(actor)
+	version, err := httperror.VersionFromRequest(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	actor.Version = version
+	if err := Validate(actor); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).UpdateActor(actor)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// sites of external profile links
+var LinkSites = map[string]bool{
+	"imdb":      true,
+	"wikipedia": true,
+	"tmdb":      true,
+	"instagram": true,
+	"twitter":   true,
+	"official":  true,
+}
+
+// utility: limits are the ones of actors, actor_aliases and actor_links tables
+func Validate(actor types.Actor) error {
+	today := time.Now().UTC().Format("2006-01-02")
+	birthdate, err := time.Parse("2006-01-02", actor.Birthdate)
+	if err != nil {
+		return errors.New("birthdate must look like 2006-01-02")
+	}
+	if actor.Birthdate > today {
+		return errors.New("birthdate must not be in the future")
+	}
+	if actor.DeathDate != "" {
+		deathDate, err := time.Parse("2006-01-02", actor.DeathDate)
+		if err != nil {
+			return errors.New("death_date must look like 2006-01-02")
+		}
+		if actor.DeathDate > today {
+			return errors.New("death_date must not be in the future")
+		}
+		if deathDate.Before(birthdate) {
+			return errors.New("death_date must not be before birthdate")
+		}
+	} else if actor.DeathPlace != "" {
+		return errors.New("death_place is set without death_date")
+	}
+	if utf8.RuneCountInString(actor.Biography) > 10000 {
+		return errors.New("biography must be at most 10000 characters long")
+	}
+	if utf8.RuneCountInString(actor.Birthplace) > 150 || utf8.RuneCountInString(actor.DeathPlace) > 150 {
+		return errors.New("birthplace and death_place must be at most 150 characters long")
+	}
+	if actor.Nationality != "" && !isocode.Country.MatchString(actor.Nationality) {
+		return errors.New("nationality must be a country code like US")
+	}
+	for _, alias := range actor.Aliases {
+		aliasLength := utf8.RuneCountInString(alias)
+		if aliasLength < 1 || aliasLength > 100 {
+			return errors.New("alias must be 1-100 characters long")
+		}
+	}
+	for _, link := range actor.Links {
+		if !LinkSites[link.Site] {
+			return errors.New("site of link must be one of imdb, wikipedia, tmdb, instagram, twitter, official")
+		}
+		parsed, err := url.Parse(link.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || len(link.URL) > 500 {
+			return errors.New("url of link must be an http(s) url up to 500 characters long")
+		}
+	}
+	return nil
+}
+
+// utility: full years on today, or on the day of death; nil when birthdate is not a date
+func Age(actor types.Actor, today time.Time) *int {
+	birthdate, err := time.Parse("2006-01-02", actor.Birthdate)
+	if err != nil {
+		return nil
+	}
+	until := today
+	if deathDate, err := time.Parse("2006-01-02", actor.DeathDate); err == nil {
+		until = deathDate
+	}
+	age := until.Year() - birthdate.Year()
+	if until.Month() < birthdate.Month() || (until.Month() == birthdate.Month() && until.Day() < birthdate.Day()) {
+		age--
+	}
+	if age < 0 {
+		return nil
+	}
+	return &age
+}
+
 // method delete
 func DeleteActorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
     var actor types.Actor
@@ -58,9 +163,9 @@ func DeleteActorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 		return
 	}
 
-	err = orm.DeleteActorByID(actor.ID)
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).DeleteActorByID(actor.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), httperror.Status(err))
 		return
 	}
 
@@ -93,4 +198,118 @@ func GetActorsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
         w.Header().Set("Content-Type", "application/json")
         json.NewEncoder(w).Encode(actors)
     }
-}
\ No newline at end of file
+}
+
+// utility
+// /actor/{id} -> id
+func ParseActorPath(path string) (int, bool) {
+	id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(path, "/actor/"), "/"))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// method detail
+// url like /actor/{id}, the actor with the whole profile and age
+func GetActorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	actorID, ok := ParseActorPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	actor, err := orm.GetActorByID(actorID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	actor.Age = Age(*actor, time.Now().UTC())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(actor)
+}
+
+// method restore (from trash)
+func RestoreActorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	var actor types.Actor
+	err := json.NewDecoder(r.Body).Decode(&actor)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if actor.ID == 0 {
+		http.Error(w, "Actor ID is required", http.StatusBadRequest)
+		return
+	}
+
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).RestoreActorByID(actor.ID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// method trash
+func GetDeletedActorsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	actors, err := orm.GetDeletedActors()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(actors)
+}
+
+// method history
+// url like /actor/{id}/history or /actor/{id}/history/{rev}
+func GetActorHistoryHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	actorID, action, revision, ok := auditapi.ParseHistoryPath(r.URL.Path, "/actor/")
+	if !ok || action != "history" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var response interface{}
+	var err error
+	if revision == 0 {
+		response, err = orm.GetActorHistory(actorID)
+	} else {
+		response, err = orm.GetActorRevision(actorID, revision)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// method revert
+// url like /actor/{id}/revert/{rev}, If-Match works the same way as in patch
+func RevertActorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	actorID, action, revision, ok := auditapi.ParseHistoryPath(r.URL.Path, "/actor/")
+	if !ok || action != "revert" {
+		http.NotFound(w, r)
+		return
+	}
+
+	version, err := httperror.VersionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).RevertActor(actorID, revision, version)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
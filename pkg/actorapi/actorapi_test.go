@@ -8,6 +8,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -26,12 +27,17 @@ func TestCreateActorHandler_Success(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	mock.ExpectExec(`INSERT INTO actors \(name, gender, date_of_birth\) VALUES \(\$1, \$2, \$3\)`).
-		WithArgs("John Doe", "male", "01.01.2000").
-		WillReturnResult(sqlmock.NewResult(1, 1)).
-		WillReturnError(nil)
-
-	jsonData := []byte(`{"name": "John Doe", "gender":"male", "birthdate":"01.01.2000"}`)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO actors \(name, gender, date_of_birth, (.+)\) VALUES \(\$1, \$2, \$3, (.+)\) RETURNING id`).
+		WithArgs("John Doe", "male", "2000-01-01", "", "", "", nil, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO actor_revisions").WithArgs(1, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("", "create", "actor", 1, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	jsonData := []byte(`{"name": "John Doe", "gender":"male", "birthdate":"2000-01-01"}`)
 	req, err := http.NewRequest("POST", "/", bytes.NewBuffer(jsonData))
 	if err != nil {
 		t.Fatal(err)
@@ -58,9 +64,18 @@ func TestUpdateActorHandler_Success(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    mock.ExpectExec("UPDATE actors SET name = \\$1, gender = \\$2, date_of_birth = \\$3 WHERE id = \\$4").
-        WithArgs("John Doe", "male", "2000-01-01", 1).
+    mock.ExpectBegin()
+    mock.ExpectQuery("SELECT id, name, gender, (.+) FROM actors WHERE id = \\$1").
+        WithArgs(1).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "name", "gender", "date_of_birth", "version", "biography", "birthplace", "nationality", "date_of_death", "death_place"}).AddRow(1, "John", "male", "2000-01-01", 1, "", "", "", "", ""))
+    mock.ExpectExec("UPDATE actors SET name = \\$1, gender = \\$2, date_of_birth = \\$3, (.+) WHERE id = \\$9").
+        WithArgs("John Doe", "male", "2000-01-01", "", "", "", nil, "", 1, 0).
         WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectExec("INSERT INTO actor_revisions").WithArgs(1, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectExec("INSERT INTO audit_log").
+        WithArgs("", "update", "actor", 1, []byte(`{"name":"John","version":1}`), []byte(`{"name":"John Doe","version":2}`), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+        WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectCommit()
 
     jsonData := []byte(`{"ID": 1, "Name": "John Doe", "Gender": "male", "Birthdate": "2000-01-01"}`)
     req, err := http.NewRequest("PUT", "/", bytes.NewBuffer(jsonData))
@@ -93,12 +108,17 @@ func TestDeleteActorHandler_Success(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    mock.ExpectExec("DELETE FROM film_actors WHERE actor_id = \\$1").
+    mock.ExpectBegin()
+    mock.ExpectQuery("SELECT id, name, gender, (.+) FROM actors WHERE id = \\$1").
         WithArgs(1).
-        WillReturnResult(sqlmock.NewResult(1, 1))
-    mock.ExpectExec("DELETE FROM actors WHERE id = \\$1").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "name", "gender", "date_of_birth", "version", "biography", "birthplace", "nationality", "date_of_death", "death_place"}).AddRow(1, "John Doe", "male", "2000-01-01", 3, "", "", "", "", ""))
+    mock.ExpectExec("UPDATE actors SET deleted_at = NOW\\(\\) WHERE id = \\$1").
         WithArgs(1).
         WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectExec("INSERT INTO audit_log").
+        WithArgs("", "delete", "actor", 1, sqlmock.AnyArg(), nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+        WillReturnResult(sqlmock.NewResult(1, 1))
+    mock.ExpectCommit()
 
     jsonData := []byte(`{"ID": 1}`)
     req, err := http.NewRequest("DELETE", "/", bytes.NewBuffer(jsonData))
@@ -131,10 +151,7 @@ func TestDeleteActorHandler_DecodingError(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    mock.ExpectExec("DELETE FROM film_actors WHERE actor_id = \\$1").
-        WithArgs(1).
-        WillReturnResult(sqlmock.NewResult(1, 1))
-    mock.ExpectExec("DELETE FROM actors WHERE id = \\$1").
+    mock.ExpectExec("UPDATE actors SET deleted_at = NOW\\(\\) WHERE id = \\$1").
         WithArgs(1).
         WillReturnResult(sqlmock.NewResult(1, 1))
 
@@ -167,10 +184,10 @@ func TestGetActorsHandler_Success_AllActors(t *testing.T) {
         {ID: 2, Name: "Jane Smith", FilmTitles: []string{"Film 3", "Film 4"}},
     }
 
-    mock.ExpectQuery("SELECT id, name FROM actors").
-        WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
-            AddRow(expectedActors[0].ID, expectedActors[0].Name).
-            AddRow(expectedActors[1].ID, expectedActors[1].Name))
+    mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors").
+        WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version", "photo_key"}).
+            AddRow(expectedActors[0].ID, expectedActors[0].Name, 1, "").
+            AddRow(expectedActors[1].ID, expectedActors[1].Name, 1, ""))
 
     for _, actor := range expectedActors {
         mock.ExpectQuery("SELECT f.title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id WHERE fa.actor_id = ?").
@@ -196,7 +213,7 @@ func TestGetActorsHandler_Success_AllActors(t *testing.T) {
         t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
     }
 
-    expectedResponse := `[{"id":1,"name":"John Doe","film_titles":["Film 1","Film 2"]},{"id":2,"name":"Jane Smith","film_titles":["Film 3","Film 4"]}]`
+    expectedResponse := `[{"id":1,"name":"John Doe","film_titles":["Film 1","Film 2"],"version":1},{"id":2,"name":"Jane Smith","film_titles":["Film 3","Film 4"],"version":1}]`
 	if strings.TrimSpace(rr.Body.String()) != expectedResponse {
 		fmt.Printf("expectedResponse: %v__\n", expectedResponse)
 		// idk why, but rr.Body.String() return body with \n on end, so...
@@ -223,10 +240,10 @@ func TestGetActorsHandler_Success_ByFragment(t *testing.T) {
 
     fragment := "Doe"
 
-    mock.ExpectQuery("SELECT id, name FROM actors WHERE name LIKE ?").
-        WithArgs("%"+fragment+"%").
-        WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
-            AddRow(expectedActors[0].ID, expectedActors[0].Name))
+    mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors a WHERE \\(a.name LIKE (.+) OR a.id IN \\(SELECT actor_id FROM actor_aliases").
+        WithArgs(fragment).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "name", "version", "photo_key"}).
+            AddRow(expectedActors[0].ID, expectedActors[0].Name, 1, ""))
 
     for _, actor := range expectedActors {
         mock.ExpectQuery("SELECT f.title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id WHERE fa.actor_id = ?").
@@ -252,7 +269,7 @@ func TestGetActorsHandler_Success_ByFragment(t *testing.T) {
         t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
     }
 
-    expectedResponse := `[{"id":1,"name":"John Doe","film_titles":["Film 1","Film 2"]}]`
+    expectedResponse := `[{"id":1,"name":"John Doe","film_titles":["Film 1","Film 2"],"version":1}]`
     if strings.TrimSpace(rr.Body.String()) != expectedResponse {
         t.Errorf("handler returned unexpected body:\ngot %v\nwant %v", strings.TrimSpace(rr.Body.String()), expectedResponse)
     }
@@ -272,7 +289,7 @@ func TestGetActorsHandler_InternalServerError(t *testing.T) {
     orm := orm.NewORM(db)
 
     expectedError := errors.New("database error")
-    mock.ExpectQuery("SELECT id, name FROM actors").
+    mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors").
         WillReturnError(expectedError)
 
     req, err := http.NewRequest("GET", "/actors", nil)
@@ -311,7 +328,7 @@ func TestGetActorsHandler_FragmentInternalServerError(t *testing.T) {
     orm := orm.NewORM(db)
 
     expectedError := errors.New("database error")
-    mock.ExpectQuery("SELECT id, name FROM actors").
+    mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors").
         WillReturnError(expectedError)
 
     req, err := http.NewRequest("GET", "/actors?fragment=fragment", nil)
@@ -338,4 +355,117 @@ func TestGetActorsHandler_FragmentInternalServerError(t *testing.T) {
     if err := mock.ExpectationsWereMet(); err != nil {
         t.Errorf("there were unfulfilled expectations: %s", err)
     }
-}
\ No newline at end of file
+}
+func TestUpdateActorHandler_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, gender, (.+) FROM actors WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "gender", "date_of_birth", "version", "biography", "birthplace", "nationality", "date_of_death", "death_place"}))
+	mock.ExpectRollback()
+
+	jsonData := []byte(`{"ID": 5, "Name": "John Doe", "Gender": "male", "Birthdate": "2000-01-01"}`)
+	req, err := http.NewRequest("PATCH", "/", bytes.NewBuffer(jsonData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", `W/"2"`)
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actorapi.UpdateActorHandler(w, r, orm)
+	})
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, actorapi.Validate(types.Actor{Name: "John Doe", Birthdate: "1950-05-01"}))
+	assert.NoError(t, actorapi.Validate(types.Actor{
+		Name:        "Andrei Tarkovsky",
+		Birthdate:   "1932-04-04",
+		Birthplace:  "Zavrazhye",
+		Nationality: "RU",
+		DeathDate:   "1986-12-29",
+		DeathPlace:  "Paris",
+		Aliases:     []string{"Андрей Тарковский"},
+		Links:       []types.ActorLink{{Site: "wikipedia", URL: "https://en.wikipedia.org/wiki/Andrei_Tarkovsky"}},
+	}))
+
+	invalid := []types.Actor{
+		{Birthdate: "04.04.1932"},
+		{Birthdate: "2999-01-01"},
+		{Birthdate: "1932-04-04", DeathDate: "1931-12-29"},
+		{Birthdate: "1932-04-04", DeathDate: "2999-12-29"},
+		{Birthdate: "1932-04-04", DeathPlace: "Paris"},
+		{Birthdate: "1932-04-04", Nationality: "RUS"},
+		{Birthdate: "1932-04-04", Aliases: []string{""}},
+		{Birthdate: "1932-04-04", Links: []types.ActorLink{{Site: "myspace", URL: "https://myspace.com/x"}}},
+		{Birthdate: "1932-04-04", Links: []types.ActorLink{{Site: "imdb", URL: "javascript:alert(1)"}}},
+	}
+	for _, actor := range invalid {
+		assert.Error(t, actorapi.Validate(actor), actor)
+	}
+}
+
+func TestAge(t *testing.T) {
+	today := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 34, *actorapi.Age(types.Actor{Birthdate: "1990-03-15"}, today))
+	assert.Equal(t, 33, *actorapi.Age(types.Actor{Birthdate: "1990-03-16"}, today))
+	// counted up to the death date, not today
+	assert.Equal(t, 54, *actorapi.Age(types.Actor{Birthdate: "1932-04-04", DeathDate: "1986-12-29"}, today))
+	assert.Nil(t, actorapi.Age(types.Actor{Birthdate: "unknown"}, today))
+}
+
+func TestGetActorHandler_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, name, gender, (.+) FROM actors WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "gender", "date_of_birth", "version", "photo_key", "biography", "birthplace", "nationality", "date_of_death", "death_place"}).
+			AddRow(3, "Andrei Tarkovsky", "male", "1932-04-04", 2, "", "Director", "Zavrazhye", "RU", "1986-12-29", "Paris"))
+	mock.ExpectQuery("SELECT name FROM actor_aliases WHERE actor_id = \\$1 ORDER BY id").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Андрей Тарковский"))
+	mock.ExpectQuery("SELECT site, url FROM actor_links WHERE actor_id = \\$1 ORDER BY id").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"site", "url"}).AddRow("imdb", "https://www.imdb.com/name/nm0001789/"))
+
+	rr := httptest.NewRecorder()
+	actorapi.GetActorHandler(rr, httptest.NewRequest("GET", "/actor/3", nil), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"id": 3, "name": "Andrei Tarkovsky", "gender": "male", "birthdate": "1932-04-04", "version": 2,
+		"biography": "Director", "birthplace": "Zavrazhye", "nationality": "RU", "death_date": "1986-12-29", "death_place": "Paris",
+		"aliases": ["Андрей Тарковский"], "links": [{"site": "imdb", "url": "https://www.imdb.com/name/nm0001789/"}], "age": 54}`, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateActorHandler_DeathBeforeBirth(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	jsonData := []byte(`{"name": "John Doe", "gender": "male", "birthdate": "2000-01-01", "death_date": "1999-01-01"}`)
+	rr := httptest.NewRecorder()
+	actorapi.CreateActorHandler(rr, httptest.NewRequest("POST", "/actor", bytes.NewBuffer(jsonData)), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
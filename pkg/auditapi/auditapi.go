@@ -0,0 +1,213 @@
+// pkg/auditapi/auditapi.go
+package auditapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// X-Request-ID that is reused, it has to fit audit_log.request_id VARCHAR(64)
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// utility
+// X-Request-ID from the client (or proxy) is reused when it looks like one, otherwise a new one is generated
+func RequestID(r *http.Request) string {
+	requestID := r.Header.Get("X-Request-ID")
+	if requestIDPattern.MatchString(requestID) {
+		return requestID
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	requestID = hex.EncodeToString(buf)
+	r.Header.Set("X-Request-ID", requestID)
+	return requestID
+}
+
+// proxies in front of the service, see TRUSTED_PROXIES in main.go. X-Forwarded-For is honoured only
+// when the request comes from one of them, anybody else can put any address there
+var TrustedProxies []*net.IPNet
+
+// utility: comma separated addresses and CIDR ranges, "10.0.0.0/8, 192.0.2.1"
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", item)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// utility
+// remote address. When it is a trusted proxy, X-Forwarded-For is walked from the right and the first address
+// that is not a trusted proxy is the client, the addresses left of it are whatever the client sent
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trustedProxy(ip) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && trustedProxy(ip); i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+	}
+	return ip.String()
+}
+
+// utility
+func trustedProxy(ip net.IP) bool {
+	for _, network := range TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// who makes the request, for orm.WithAudit
+func MetaFromRequest(r *http.Request) types.AuditMeta {
+	meta := types.AuditMeta{
+		RequestID: RequestID(r),
+		IP:        ClientIP(r),
+	}
+	claims, err := tokens.GetClaims(r)
+	if err == nil {
+		meta.UserID = claims.UserID
+		meta.Username = claims.Username
+	}
+	return meta
+}
+
+// get method
+// url like /admin/audit?user={username}&entity_type=film&entity_id=1&from=2024-03-01&to=2024-03-31T12:00:00Z&limit=100
+func GetAuditLogHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	queryValues := r.URL.Query()
+
+	filter := types.AuditFilter{
+		Username:   queryValues.Get("user"),
+		EntityType: queryValues.Get("entity_type"),
+		Limit:      defaultAuditPageSize,
+	}
+
+	var err error
+	if entityID := queryValues.Get("entity_id"); entityID != "" {
+		filter.EntityID, err = strconv.Atoi(entityID)
+		if err != nil {
+			http.Error(w, "Invalid value for entity_id parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if from := queryValues.Get("from"); from != "" {
+		filter.From, err = parseTime(from)
+		if err != nil {
+			http.Error(w, "Invalid value for from parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if to := queryValues.Get("to"); to != "" {
+		filter.To, err = parseTime(to)
+		if err != nil {
+			http.Error(w, "Invalid value for to parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit := queryValues.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxAuditPageSize {
+			http.Error(w, "Invalid value for limit parameter, 1 to "+strconv.Itoa(maxAuditPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := orm.GetAuditLog(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// utility: accepts both 2024-03-01 and RFC3339
+func parseTime(value string) (time.Time, error) {
+	if parsed, err := time.Parse("2006-01-02", value); err == nil {
+		return parsed, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// utility
+// prefix + {id}/history, {id}/history/{rev} or {id}/revert/{rev}; rev is 0 for the whole history
+func ParseHistoryPath(path, prefix string) (id int, action string, rev int, ok bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, prefix), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, "", 0, false
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 {
+		return 0, "", 0, false
+	}
+	action = parts[1]
+	if len(parts) == 3 {
+		rev, err = strconv.Atoi(parts[2])
+		if err != nil || rev <= 0 {
+			return 0, "", 0, false
+		}
+	}
+
+	switch {
+	case action == "history":
+		return id, action, rev, true
+	case action == "revert" && rev > 0:
+		return id, action, rev, true
+	default:
+		return 0, "", 0, false
+	}
+}
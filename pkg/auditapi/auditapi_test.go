@@ -0,0 +1,209 @@
+package auditapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+func withTrustedProxies(t *testing.T, value string) {
+	proxies, err := auditapi.ParseTrustedProxies(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := auditapi.TrustedProxies
+	auditapi.TrustedProxies = proxies
+	t.Cleanup(func() { auditapi.TrustedProxies = previous })
+}
+
+func TestMetaFromRequest(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8")
+	token, err := tokens.CreateToken(1, "admin", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("PATCH", "/film", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Request-ID", "req-42")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	req.RemoteAddr = "10.0.0.2:5555"
+
+	meta := auditapi.MetaFromRequest(req)
+	assert.Equal(t, types.AuditMeta{UserID: 1, Username: "admin", RequestID: "req-42", IP: "203.0.113.7"}, meta)
+}
+
+func TestMetaFromRequest_NoHeaders(t *testing.T) {
+	req, err := http.NewRequest("POST", "/film", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RemoteAddr = "192.0.2.10:5555"
+
+	meta := auditapi.MetaFromRequest(req)
+	assert.Equal(t, "", meta.Username)
+	assert.Equal(t, "192.0.2.10", meta.IP)
+	assert.Len(t, meta.RequestID, 16)
+}
+
+func TestMetaFromRequest_BadRequestID(t *testing.T) {
+	for _, requestID := range []string{strings.Repeat("a", 65), "req 42", "req-42\r\nX-Admin: 1"} {
+		req, err := http.NewRequest("POST", "/user/register", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Request-ID", requestID)
+
+		// a new one instead, an oversized one would not fit audit_log and fail the write
+		meta := auditapi.MetaFromRequest(req)
+		assert.Len(t, meta.RequestID, 16, requestID)
+		assert.Equal(t, meta.RequestID, req.Header.Get("X-Request-ID"))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	withTrustedProxies(t, "10.0.0.0/8, 192.0.2.1")
+
+	for _, test := range []struct {
+		remoteAddr string
+		forwarded  []string
+		ip         string
+	}{
+		// not from a proxy, the header is whatever the client sent
+		{"198.51.100.4:5555", []string{"203.0.113.7"}, "198.51.100.4"},
+		{"10.0.0.2:5555", nil, "10.0.0.2"},
+		{"10.0.0.2:5555", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"192.0.2.1:5555", []string{"203.0.113.7, 10.0.0.1"}, "203.0.113.7"},
+		// the client put an address of its own in front
+		{"10.0.0.2:5555", []string{"1.2.3.4, 203.0.113.7"}, "203.0.113.7"},
+		{"10.0.0.2:5555", []string{"1.2.3.4", "203.0.113.7"}, "203.0.113.7"},
+		{"10.0.0.2:5555", []string{"10.0.0.3, 10.0.0.1"}, "10.0.0.3"},
+		{"10.0.0.2:5555", []string{"garbage, 10.0.0.1"}, "10.0.0.1"},
+		{"192.0.2.2:5555", []string{"203.0.113.7"}, "192.0.2.2"},
+	} {
+		req := httptest.NewRequest("GET", "/film", nil)
+		req.RemoteAddr = test.remoteAddr
+		for _, value := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		assert.Equal(t, test.ip, auditapi.ClientIP(req), test)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := auditapi.ParseTrustedProxies(" 10.0.0.0/8, 192.0.2.1,,::1 ")
+	assert.NoError(t, err)
+	if assert.Len(t, proxies, 3) {
+		assert.Equal(t, "10.0.0.0/8", proxies[0].String())
+		assert.Equal(t, "192.0.2.1/32", proxies[1].String())
+		assert.Equal(t, "::1/128", proxies[2].String())
+	}
+
+	proxies, err = auditapi.ParseTrustedProxies("")
+	assert.NoError(t, err)
+	assert.Empty(t, proxies)
+
+	_, err = auditapi.ParseTrustedProxies("10.0.0.0/8, proxy.local")
+	assert.Error(t, err)
+	_, err = auditapi.ParseTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestGetAuditLogHandler_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	// 100 entries when the limit is not given
+	mock.ExpectQuery("FROM audit_log WHERE 1 = 1 AND entity_type = \\$1 AND entity_id = \\$2 AND created_at < \\$3 ORDER BY created_at DESC, id DESC LIMIT \\$4").
+		WithArgs("film", 3, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "action", "entity_type", "entity_id", "before_data", "after_data", "request_id", "ip", "created_at"}).
+			AddRow(1, "admin", "delete", "film", 3, []byte(`{"title":"Film"}`), nil, "req-1", "127.0.0.1", time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)))
+
+	req, err := http.NewRequest("GET", "/admin/audit?entity_type=film&entity_id=3&to=2024-04-01", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auditapi.GetAuditLogHandler(w, r, orm)
+	})
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var entries []types.AuditEntry
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "delete", entries[0].Action)
+	assert.Nil(t, entries[0].After)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAuditLogHandler_BadParams(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auditapi.GetAuditLogHandler(w, r, orm)
+	})
+
+	for _, url := range []string{"/admin/audit?entity_id=abc", "/admin/audit?from=yesterday", "/admin/audit?limit=-1", "/admin/audit?limit=0", "/admin/audit?limit=1001"} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+	}
+}
+
+func TestParseHistoryPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		id       int
+		action   string
+		revision int
+		ok       bool
+	}{
+		{"/film/1/history", 1, "history", 0, true},
+		{"/film/1/history/", 1, "history", 0, true},
+		{"/film/12/history/3", 12, "history", 3, true},
+		{"/film/12/revert/3", 12, "revert", 3, true},
+		{"/film/12/revert", 0, "", 0, false},
+		{"/film/abc/history", 0, "", 0, false},
+		{"/film/1/history/0", 0, "", 0, false},
+		{"/film/1/cast", 0, "", 0, false},
+		{"/film/1", 0, "", 0, false},
+	}
+
+	for _, test := range tests {
+		id, action, revision, ok := auditapi.ParseHistoryPath(test.path, "/film/")
+		assert.Equal(t, test.ok, ok, test.path)
+		assert.Equal(t, test.id, id, test.path)
+		assert.Equal(t, test.action, action, test.path)
+		assert.Equal(t, test.revision, revision, test.path)
+	}
+}
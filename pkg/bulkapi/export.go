@@ -0,0 +1,199 @@
+// pkg/bulkapi/export.go
+package bulkapi
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// writes rows of one export in the requested format as soon as they are read
+type rowWriter interface {
+	Write(value interface{}, record []string) error
+	Close() error
+}
+
+type csvRowWriter struct {
+	writer *csv.Writer
+}
+
+func (c *csvRowWriter) Write(value interface{}, record []string) error {
+	return c.writer.Write(record)
+}
+
+func (c *csvRowWriter) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type ndjsonRowWriter struct {
+	encoder *json.Encoder
+}
+
+func (n *ndjsonRowWriter) Write(value interface{}, record []string) error {
+	return n.encoder.Encode(value)
+}
+
+func (n *ndjsonRowWriter) Close() error {
+	return nil
+}
+
+// json array written element by element
+type jsonRowWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonRowWriter) Write(value interface{}, record []string) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	separator := ","
+	if j.count == 0 {
+		separator = "["
+	}
+	j.count++
+	if _, err := io.WriteString(j.w, separator); err != nil {
+		return err
+	}
+	_, err = j.w.Write(raw)
+	return err
+}
+
+func (j *jsonRowWriter) Close() error {
+	end := "]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+// csv header of every entity
+var exportColumns = map[string][]string{
+	"films":   {"id", "title", "description", "release_date", "rating", "version", "actors"},
+	"actors":  {"id", "name", "gender", "birthdate", "version"},
+	"credits": {"film_id", "film_title", "actor_id", "actor_name"},
+}
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv",
+	"ndjson": "application/x-ndjson",
+	"json":   "application/json",
+}
+
+// utility: ErrBadInput for unknown entity or format, nothing is written in this case
+func ValidateExport(entity, format string) error {
+	if _, ok := exportColumns[entity]; !ok {
+		return fmt.Errorf("%w: unknown entity %q, expected films, actors or credits", ErrBadInput, entity)
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		return fmt.Errorf("%w: unknown format %q, expected csv, ndjson or json", ErrBadInput, format)
+	}
+	return nil
+}
+
+// utility
+// streams the entity to w, used by /admin/export and by the export subcommand
+func Export(orm *orm.ORM, w io.Writer, entity, format string) error {
+	if err := ValidateExport(entity, format); err != nil {
+		return err
+	}
+
+	var writer rowWriter
+	switch format {
+	case "csv":
+		csvWriter := csv.NewWriter(w)
+		if err := csvWriter.Write(exportColumns[entity]); err != nil {
+			return err
+		}
+		writer = &csvRowWriter{writer: csvWriter}
+	case "ndjson":
+		writer = &ndjsonRowWriter{encoder: json.NewEncoder(w)}
+	case "json":
+		writer = &jsonRowWriter{w: w}
+	}
+
+	var err error
+	switch entity {
+	case "films":
+		err = orm.ExportFilms(func(film types.Film) error {
+			actors := make([]string, 0, len(film.Actors))
+			for _, actorID := range film.Actors {
+				actors = append(actors, strconv.Itoa(actorID))
+			}
+			return writer.Write(film, []string{
+				strconv.Itoa(film.ID), film.Title, film.Description, film.ReleaseDate,
+				strconv.FormatFloat(film.Rating, 'f', -1, 64), strconv.Itoa(film.Version), strings.Join(actors, ";"),
+			})
+		})
+	case "actors":
+		err = orm.ExportActors(func(actor types.Actor) error {
+			return writer.Write(actor, []string{
+				strconv.Itoa(actor.ID), actor.Name, actor.Gender, actor.Birthdate, strconv.Itoa(actor.Version),
+			})
+		})
+	case "credits":
+		err = orm.ExportCredits(func(credit types.Credit) error {
+			return writer.Write(credit, []string{
+				strconv.Itoa(credit.FilmID), credit.FilmTitle, strconv.Itoa(credit.ActorID), credit.ActorName,
+			})
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// get method
+// url like /admin/export?entity=films&format=csv
+func ExportHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	queryValues := r.URL.Query()
+	entity := queryValues.Get("entity")
+	format := queryValues.Get("format")
+	if format == "" {
+		format = "json"
+	}
+
+	if err := ValidateExport(entity, format); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", `attachment; filename="`+entity+"."+format+`"`)
+
+	tracked := &trackingWriter{w: w}
+	err := Export(orm, tracked, entity, format)
+	if err == nil {
+		return
+	}
+	if !tracked.written {
+		w.Header().Del("Content-Disposition")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the status is already sent with the first rows, so the error can only be logged and the body stays truncated
+	log.Println("Export of", entity, "failed:", err)
+}
+
+// utility: tells whether the response has been started
+type trackingWriter struct {
+	w       io.Writer
+	written bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.written = true
+	return t.w.Write(p)
+}
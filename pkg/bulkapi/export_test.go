@@ -0,0 +1,118 @@
+package bulkapi_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/bulkapi"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+)
+
+func TestExportHandler_FilmsCSV(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE export_cursor NO SCROLL CURSOR FOR SELECT f.id, f.title").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FETCH FORWARD 500 FROM export_cursor").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "actors"}).
+			AddRow(1, "Film 1", "About, with comma", "2020-01-01", 7.5, 2, []byte("{1,2}")).
+			AddRow(2, "Film 2", "", "2021-01-01", 6.0, 1, []byte("{}")))
+	mock.ExpectRollback()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bulkapi.ExportHandler(w, r, orm)
+	})
+
+	req, err := http.NewRequest("GET", "/admin/export?entity=films&format=csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv", rr.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="films.csv"`, rr.Header().Get("Content-Disposition"))
+	assert.Equal(t, "id,title,description,release_date,rating,version,actors\n"+
+		"1,Film 1,\"About, with comma\",2020-01-01,7.5,2,1;2\n"+
+		"2,Film 2,,2021-01-01,6,1,\n", rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportHandler_CreditsJSON(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE export_cursor NO SCROLL CURSOR FOR SELECT f.id, f.title, a.id, a.name FROM film_actors").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FETCH FORWARD").
+		WillReturnRows(sqlmock.NewRows([]string{"film_id", "film_title", "actor_id", "actor_name"}).
+			AddRow(1, "Film 1", 1, "John Doe").
+			AddRow(1, "Film 1", 2, "Jane Doe"))
+	mock.ExpectRollback()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bulkapi.ExportHandler(w, r, orm)
+	})
+
+	req, err := http.NewRequest("GET", "/admin/export?entity=credits&format=json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[
+		{"film_id": 1, "film_title": "Film 1", "actor_id": 1, "actor_name": "John Doe"},
+		{"film_id": 1, "film_title": "Film 1", "actor_id": 2, "actor_name": "Jane Doe"}
+	]`, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestExportHandler_BadParams(t *testing.T) {
+	for _, url := range []string{"/admin/export?entity=users", "/admin/export?entity=films&format=xml"} {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		bulkapi.ExportHandler(rr, req, nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+	}
+}
+
+func TestExportHandler_DatabaseError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin().WillReturnError(errors.New("database error"))
+
+	orm := orm.NewORM(db)
+	req, err := http.NewRequest("GET", "/admin/export?entity=actors&format=ndjson", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	bulkapi.ExportHandler(rr, req, orm)
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Empty(t, rr.Header().Get("Content-Disposition"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,330 @@
+// pkg/bulkapi/import.go
+package bulkapi
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// larger files should be split or loaded with the CLI
+const maxImportSize = 32 << 20
+
+// the file can't be read at all (unknown format/entity, bad csv header, broken csv)
+var ErrBadInput = errors.New("bad input")
+
+// some rows did not pass validation, nothing was imported
+var ErrInvalidRows = errors.New("import has invalid rows")
+
+// csv columns; for films the actors column looks like "Name|1990-01-01|male; Other Name|1985-02-03"
+// (gender is optional, it is used only when the actor is created)
+var filmColumns = []string{"title", "description", "release_date", "rating", "actors"}
+var actorColumns = []string{"name", "gender", "birthdate"}
+
+// utility
+// parses, validates and loads the file, used by /admin/import and by the import subcommand.
+// With dryRun rows are still loaded (and rolled back), so the report contains the counters.
+func Import(orm *orm.ORM, r io.Reader, entity, format string, dryRun bool) (types.ImportReport, error) {
+	var report types.ImportReport
+	var err error
+
+	switch entity {
+	case "films":
+		var films []types.ImportFilm
+		films, report, err = ParseFilms(r, format)
+		if err != nil || len(films) == 0 || (len(report.Invalid) > 0 && !dryRun) {
+			break
+		}
+		var counts types.ImportReport
+		counts, err = orm.ImportFilms(films, dryRun)
+		report.FilmsCreated, report.ActorsCreated, report.ActorsMatched = counts.FilmsCreated, counts.ActorsCreated, counts.ActorsMatched
+	case "actors":
+		var actors []types.Actor
+		actors, report, err = ParseActors(r, format)
+		if err != nil || len(actors) == 0 || (len(report.Invalid) > 0 && !dryRun) {
+			break
+		}
+		var counts types.ImportReport
+		counts, err = orm.ImportActors(actors, dryRun)
+		report.ActorsCreated, report.ActorsMatched = counts.ActorsCreated, counts.ActorsMatched
+	default:
+		return report, fmt.Errorf("%w: unknown entity %q", ErrBadInput, entity)
+	}
+
+	report.DryRun = dryRun
+	if err != nil {
+		return report, err
+	}
+	if len(report.Invalid) > 0 && !dryRun {
+		return report, ErrInvalidRows
+	}
+	return report, nil
+}
+
+// utility
+// valid rows are returned, invalid ones are listed in report.Invalid
+func ParseFilms(r io.Reader, format string) ([]types.ImportFilm, types.ImportReport, error) {
+	var films []types.ImportFilm
+	var report types.ImportReport
+
+	err := eachRow(r, format, filmColumns, &report, func(values map[string]string, raw []byte) []string {
+		var film types.ImportFilm
+		if raw != nil {
+			if err := json.Unmarshal(raw, &film); err != nil {
+				return []string{err.Error()}
+			}
+		} else {
+			var errs []string
+			film, errs = filmFromCSV(values)
+			if len(errs) > 0 {
+				return errs
+			}
+		}
+		if errs := validateFilm(film); len(errs) > 0 {
+			return errs
+		}
+		films = append(films, film)
+		return nil
+	})
+	return films, report, err
+}
+
+// utility
+func ParseActors(r io.Reader, format string) ([]types.Actor, types.ImportReport, error) {
+	var actors []types.Actor
+	var report types.ImportReport
+
+	err := eachRow(r, format, actorColumns, &report, func(values map[string]string, raw []byte) []string {
+		var actor types.Actor
+		if raw != nil {
+			if err := json.Unmarshal(raw, &actor); err != nil {
+				return []string{err.Error()}
+			}
+		} else {
+			actor = types.Actor{Name: values["name"], Gender: values["gender"], Birthdate: values["birthdate"]}
+		}
+		if errs := validateActor(actor, ""); len(errs) > 0 {
+			return errs
+		}
+		actors = append(actors, actor)
+		return nil
+	})
+	return actors, report, err
+}
+
+// utility
+// calls fn for every non-empty row: csv rows come as values by column name, ndjson rows as raw json.
+// Errors returned by fn are added to report.Invalid with the line number of the row
+func eachRow(r io.Reader, format string, columns []string, report *types.ImportReport, fn func(values map[string]string, raw []byte) []string) error {
+	addRow := func(line int, errs []string) {
+		report.Rows++
+		if len(errs) > 0 {
+			report.Invalid = append(report.Invalid, types.ImportRowError{Line: line, Errors: errs})
+		}
+	}
+
+	switch format {
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.TrimLeadingSpace = true
+		header, err := reader.Read()
+		if err != nil {
+			return fmt.Errorf("%w: can't read csv header: %v", ErrBadInput, err)
+		}
+		for i, column := range header {
+			header[i] = strings.ToLower(strings.TrimSpace(column))
+			if !contains(columns, header[i]) {
+				return fmt.Errorf("%w: unknown csv column %q, expected some of %s", ErrBadInput, column, strings.Join(columns, ", "))
+			}
+		}
+
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+				return fmt.Errorf("%w: %v", ErrBadInput, err)
+			}
+			line, _ := reader.FieldPos(0)
+			if err != nil {
+				addRow(line, []string{fmt.Sprintf("expected %d columns, got %d", len(header), len(record))})
+				continue
+			}
+			values := make(map[string]string, len(header))
+			for i, column := range header {
+				values[column] = strings.TrimSpace(record[i])
+			}
+			addRow(line, fn(values, nil))
+		}
+	case "ndjson":
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			raw := strings.TrimSpace(scanner.Text())
+			if raw == "" {
+				continue
+			}
+			addRow(line, fn(nil, []byte(raw)))
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("%w: %v", ErrBadInput, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown format %q, expected csv or ndjson", ErrBadInput, format)
+	}
+}
+
+// utility
+func filmFromCSV(values map[string]string) (types.ImportFilm, []string) {
+	film := types.ImportFilm{
+		Title:       values["title"],
+		Description: values["description"],
+		ReleaseDate: values["release_date"],
+	}
+
+	var errs []string
+	if values["rating"] != "" {
+		rating, err := strconv.ParseFloat(values["rating"], 64)
+		if err != nil {
+			errs = append(errs, "rating must be a number")
+		}
+		film.Rating = rating
+	}
+
+	for _, cell := range strings.Split(values["actors"], ";") {
+		cell = strings.TrimSpace(cell)
+		if cell == "" {
+			continue
+		}
+		parts := strings.Split(cell, "|")
+		if len(parts) < 2 || len(parts) > 3 {
+			errs = append(errs, fmt.Sprintf("actor %q must look like name|birthdate or name|birthdate|gender", cell))
+			continue
+		}
+		actor := types.Actor{Name: strings.TrimSpace(parts[0]), Birthdate: strings.TrimSpace(parts[1])}
+		if len(parts) == 3 {
+			actor.Gender = strings.TrimSpace(parts[2])
+		}
+		film.Actors = append(film.Actors, actor)
+	}
+	return film, errs
+}
+
+// utility: limits are the ones of films table
+func validateFilm(film types.ImportFilm) []string {
+	var errs []string
+	if film.Title == "" {
+		errs = append(errs, "title is required")
+	} else if utf8.RuneCountInString(film.Title) > 150 {
+		errs = append(errs, "title is longer than 150 characters")
+	}
+	if utf8.RuneCountInString(film.Description) > 1000 {
+		errs = append(errs, "description is longer than 1000 characters")
+	}
+	if !isDate(film.ReleaseDate) {
+		errs = append(errs, "release_date must look like 2006-01-02")
+	}
+	if film.Rating < 0 || film.Rating > 10 {
+		errs = append(errs, "rating must be between 0 and 10")
+	}
+	for _, actor := range film.Actors {
+		errs = append(errs, validateActor(actor, "actor "+strconv.Quote(actor.Name)+": ")...)
+	}
+	return errs
+}
+
+// utility: limits are the ones of actors table, prefix tells which actor of the film is wrong
+func validateActor(actor types.Actor, prefix string) []string {
+	var errs []string
+	if actor.Name == "" {
+		errs = append(errs, prefix+"name is required")
+	} else if utf8.RuneCountInString(actor.Name) > 100 {
+		errs = append(errs, prefix+"name is longer than 100 characters")
+	}
+	if utf8.RuneCountInString(actor.Gender) > 10 {
+		errs = append(errs, prefix+"gender is longer than 10 characters")
+	}
+	if !isDate(actor.Birthdate) {
+		errs = append(errs, prefix+"birthdate must look like 2006-01-02")
+	}
+	return errs
+}
+
+func isDate(value string) bool {
+	_, err := time.Parse("2006-01-02", value)
+	return err == nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// post method
+// url like /admin/import?entity=films&format=csv&dry_run=true, body is the file itself.
+// format may be omitted when Content-Type is text/csv or application/x-ndjson
+func ImportHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	queryValues := r.URL.Query()
+
+	format := queryValues.Get("format")
+	if format == "" {
+		switch strings.Split(r.Header.Get("Content-Type"), ";")[0] {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson":
+			format = "ndjson"
+		}
+	}
+
+	dryRun := false
+	if value := queryValues.Get("dry_run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid value for dry_run parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	report, err := Import(orm.WithAudit(auditapi.MetaFromRequest(r)), body, queryValues.Get("entity"), format, dryRun)
+	switch {
+	case errors.Is(err, ErrBadInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrInvalidRows):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(report)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !dryRun {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(report)
+}
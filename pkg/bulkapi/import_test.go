@@ -0,0 +1,143 @@
+package bulkapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/bulkapi"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+func TestParseFilms_CSV(t *testing.T) {
+	input := `title,release_date,rating,actors
+Film 1,2020-01-01,7.5,"John Doe|1980-05-01|male; Jane Doe|1985-02-03"
+,2020-01-01,7.5,
+Film 3,01.01.2020,11,John Doe|1980
+Film 4,2021-01-01
+`
+	films, report, err := bulkapi.ParseFilms(strings.NewReader(input), "csv")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, report.Rows)
+	assert.Equal(t, []types.ImportFilm{{
+		Title:       "Film 1",
+		ReleaseDate: "2020-01-01",
+		Rating:      7.5,
+		Actors: []types.Actor{
+			{Name: "John Doe", Birthdate: "1980-05-01", Gender: "male"},
+			{Name: "Jane Doe", Birthdate: "1985-02-03"},
+		},
+	}}, films)
+
+	assert.Len(t, report.Invalid, 3)
+	assert.Equal(t, 3, report.Invalid[0].Line)
+	assert.Equal(t, []string{"title is required"}, report.Invalid[0].Errors)
+	assert.Equal(t, 4, report.Invalid[1].Line)
+	assert.Equal(t, []string{
+		"release_date must look like 2006-01-02",
+		"rating must be between 0 and 10",
+		`actor "John Doe": birthdate must look like 2006-01-02`,
+	}, report.Invalid[1].Errors)
+	assert.Equal(t, 5, report.Invalid[2].Line)
+}
+
+func TestParseFilms_NDJSON(t *testing.T) {
+	input := `{"title": "Film 1", "release_date": "2020-01-01", "rating": 5, "actors": [{"name": "John Doe", "birthdate": "1980-05-01"}]}
+
+{"title": "Film 2", "release_date": "2020-01-01", "rating": "bad"}
+`
+	films, report, err := bulkapi.ParseFilms(strings.NewReader(input), "ndjson")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Rows)
+	assert.Len(t, films, 1)
+	assert.Equal(t, "John Doe", films[0].Actors[0].Name)
+	assert.Len(t, report.Invalid, 1)
+	assert.Equal(t, 3, report.Invalid[0].Line)
+}
+
+func TestParseActors_BadInput(t *testing.T) {
+	_, _, err := bulkapi.ParseActors(strings.NewReader("name,height\n"), "csv")
+	assert.True(t, errors.Is(err, bulkapi.ErrBadInput))
+
+	_, _, err = bulkapi.ParseActors(strings.NewReader(""), "xml")
+	assert.True(t, errors.Is(err, bulkapi.ErrBadInput))
+}
+
+func TestImportHandler_InvalidRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bulkapi.ImportHandler(w, r, orm)
+	})
+
+	body := "name,gender,birthdate\nJohn Doe,male,1980-05-01\n,female,1985-02-03\n"
+	req, err := http.NewRequest("POST", "/admin/import?entity=actors", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/csv")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	var report types.ImportReport
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, 2, report.Rows)
+	assert.Equal(t, []types.ImportRowError{{Line: 3, Errors: []string{"name is required"}}}, report.Invalid)
+	// nothing is loaded when some rows are invalid
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportHandler_DryRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE import_actors").WillReturnResult(sqlmock.NewResult(0, 0))
+	copyStmt := mock.ExpectPrepare(`COPY "import_actors"`)
+	copyStmt.ExpectExec().WithArgs("John Doe", "male", "1980-05-01").WillReturnResult(sqlmock.NewResult(0, 1))
+	copyStmt.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT\\(DISTINCT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("INSERT INTO actors").WithArgs("", nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("", "import", "actor", 0, nil, []byte(`{"actors_created":1,"actors_matched":0,"films_created":0}`), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bulkapi.ImportHandler(w, r, orm)
+	})
+
+	body := `{"name": "John Doe", "gender": "male", "birthdate": "1980-05-01"}`
+	req, err := http.NewRequest("POST", "/admin/import?entity=actors&format=ndjson&dry_run=true", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var report types.ImportReport
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, types.ImportReport{DryRun: true, Rows: 1, ActorsCreated: 1}, report)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,233 @@
+// pkg/collectionapi/collectionapi.go
+package collectionapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// utility
+// /collection/{id} -> id, false, 0; /collection/{id}/films -> id, true, 0; /collection/{id}/films/{filmId} -> id, true, filmId
+func ParseCollectionPath(path string) (collectionID int, films bool, filmID int, ok bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/collection/"), "/"), "/")
+	if len(parts) > 3 || (len(parts) > 1 && parts[1] != "films") {
+		return 0, false, 0, false
+	}
+	collectionID, err := strconv.Atoi(parts[0])
+	if err != nil || collectionID <= 0 {
+		return 0, false, 0, false
+	}
+	if len(parts) == 3 {
+		filmID, err = strconv.Atoi(parts[2])
+		if err != nil || filmID <= 0 {
+			return 0, false, 0, false
+		}
+	}
+	return collectionID, len(parts) > 1, filmID, true
+}
+
+// utility: the same limits as for the film title and description
+func Validate(collection types.Collection) error {
+	nameLength := utf8.RuneCountInString(collection.Name)
+	if nameLength < 1 || nameLength > 150 {
+		return errors.New("name must be 1-150 characters long")
+	}
+	if utf8.RuneCountInString(collection.Description) > 1000 {
+		return errors.New("description must be at most 1000 characters long")
+	}
+	if collection.Position < 0 {
+		return errors.New("position must not be negative")
+	}
+	return nil
+}
+
+// get method
+// url like /collection
+func GetCollectionsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	collections, err := orm.GetCollections()
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collections)
+}
+
+// post method
+// url like /collection, response is {"id": <new collection id>}
+func CreateCollectionHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	var collection types.Collection
+	if err := json.NewDecoder(r.Body).Decode(&collection); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := Validate(collection); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := orm.WithAudit(auditapi.MetaFromRequest(r)).CreateCollection(collection)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{"id": id})
+}
+
+// get method
+// url like /collection/{id}, the collection with its films and sub-collections
+func GetCollectionHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	id, films, _, ok := ParseCollectionPath(r.URL.Path)
+	if !ok || films {
+		http.NotFound(w, r)
+		return
+	}
+
+	collection, err := orm.GetCollectionByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(collection)
+}
+
+// patch method
+// url like /collection/{id}, fields missing in the body keep their values, "parent_id": null makes it top-level
+func UpdateCollectionHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	id, films, _, ok := ParseCollectionPath(r.URL.Path)
+	if !ok || films {
+		http.NotFound(w, r)
+		return
+	}
+
+	collection, err := orm.GetCollectionByID(id)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(collection); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	collection.ID = id
+	if err := Validate(*collection); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).UpdateCollection(*collection)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete method
+// url like /collection/{id}
+func DeleteCollectionHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	id, films, _, ok := ParseCollectionPath(r.URL.Path)
+	if !ok || films {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := orm.WithAudit(auditapi.MetaFromRequest(r)).DeleteCollection(id)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// post method
+// url like /collection/{id}/films, body is {"film_id": 1, "position": 2}; without position the film goes to the end
+func AddFilmHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	id, films, filmID, ok := ParseCollectionPath(r.URL.Path)
+	if !ok || !films || filmID != 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var body struct {
+		FilmID   int `json:"film_id"`
+		Position int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.FilmID <= 0 {
+		http.Error(w, "film_id is required", http.StatusBadRequest)
+		return
+	}
+
+	err := orm.WithAudit(auditapi.MetaFromRequest(r)).AddFilmToCollection(id, body.FilmID, body.Position)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// put method
+// url like /collection/{id}/films, body is {"film_ids": [3, 1, 2]} - every film of the collection in the new order
+func ReorderFilmsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	id, films, filmID, ok := ParseCollectionPath(r.URL.Path)
+	if !ok || !films || filmID != 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var body struct {
+		FilmIDs []int `json:"film_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := orm.WithAudit(auditapi.MetaFromRequest(r)).ReorderCollectionFilms(id, body.FilmIDs)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete method
+// url like /collection/{id}/films/{filmId}
+func RemoveFilmHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	id, _, filmID, ok := ParseCollectionPath(r.URL.Path)
+	if !ok || filmID == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := orm.WithAudit(auditapi.MetaFromRequest(r)).RemoveFilmFromCollection(id, filmID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
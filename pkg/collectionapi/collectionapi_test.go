@@ -0,0 +1,180 @@
+package collectionapi_test
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/collectionapi"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+var collectionColumns = []string{"id", "name", "description", "parent_id", "position"}
+
+func TestParseCollectionPath(t *testing.T) {
+	id, films, filmID, ok := collectionapi.ParseCollectionPath("/collection/3")
+	assert.True(t, ok)
+	assert.Equal(t, 3, id)
+	assert.False(t, films)
+	assert.Equal(t, 0, filmID)
+
+	id, films, filmID, ok = collectionapi.ParseCollectionPath("/collection/3/films/12")
+	assert.True(t, ok)
+	assert.Equal(t, 3, id)
+	assert.True(t, films)
+	assert.Equal(t, 12, filmID)
+
+	for _, path := range []string{"/collection/", "/collection/x", "/collection/3/actors", "/collection/3/films/0", "/collection/3/films/1/2"} {
+		_, _, _, ok := collectionapi.ParseCollectionPath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, collectionapi.Validate(types.Collection{Name: "The Lord of the Rings"}))
+	assert.Error(t, collectionapi.Validate(types.Collection{}))
+	assert.Error(t, collectionapi.Validate(types.Collection{Name: "Trilogy", Position: -1}))
+}
+
+func TestCreateCollectionHandler_BadParent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("WITH RECURSIVE ancestors").
+		WithArgs(7, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"ok"}).AddRow(false))
+	mock.ExpectRollback()
+
+	body := []byte(`{"name": "The Hobbit", "parent_id": 7}`)
+	rr := httptest.NewRecorder()
+	collectionapi.CreateCollectionHandler(rr, httptest.NewRequest(http.MethodPost, "/collection", bytes.NewReader(body)), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddFilmHandler_InsertsAtPosition(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, description, parent_id, position FROM collections WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(collectionColumns).AddRow(1, "Trilogy", "", nil, 0))
+	mock.ExpectQuery("SELECT film_id FROM collection_films WHERE collection_id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"film_id"}).AddRow(4).AddRow(6))
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(position\\), 0\\) FROM collection_films").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(2))
+	mock.ExpectExec("UPDATE collection_films SET position = position \\+ 1 WHERE collection_id = \\$1 AND position >= \\$2").
+		WithArgs(1, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO collection_films").
+		WithArgs(1, 5, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"film_id"}).AddRow(5))
+	mock.ExpectQuery("SELECT film_id FROM collection_films WHERE collection_id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"film_id"}).AddRow(4).AddRow(5).AddRow(6))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("", "update", "collection", 1, []byte(`{"films":[4,6]}`), []byte(`{"films":[4,5,6]}`), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	body := []byte(`{"film_id": 5, "position": 2}`)
+	rr := httptest.NewRecorder()
+	collectionapi.AddFilmHandler(rr, httptest.NewRequest(http.MethodPost, "/collection/1/films", bytes.NewReader(body)), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddFilmHandler_AlreadyThere(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM collections WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(collectionColumns).AddRow(1, "Trilogy", "", nil, 0))
+	mock.ExpectQuery("SELECT film_id FROM collection_films").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"film_id"}).AddRow(5))
+	mock.ExpectRollback()
+
+	body := []byte(`{"film_id": 5}`)
+	rr := httptest.NewRecorder()
+	collectionapi.AddFilmHandler(rr, httptest.NewRequest(http.MethodPost, "/collection/1/films", bytes.NewReader(body)), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReorderFilmsHandler_MissingFilm(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM collections WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(collectionColumns).AddRow(1, "Trilogy", "", nil, 0))
+	mock.ExpectQuery("SELECT film_id FROM collection_films").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"film_id"}).AddRow(4).AddRow(5).AddRow(6))
+	mock.ExpectQuery("SELECT cf.film_id FROM collection_films cf JOIN films f ON f.id = cf.film_id WHERE cf.collection_id = \\$1 AND f.deleted_at IS NOT NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"film_id"}))
+	mock.ExpectRollback()
+
+	body := []byte(`{"film_ids": [6, 4, 4]}`)
+	rr := httptest.NewRecorder()
+	collectionapi.ReorderFilmsHandler(rr, httptest.NewRequest(http.MethodPut, "/collection/1/films", bytes.NewReader(body)), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRemoveFilmHandler_NotInCollection(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM collections WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(collectionColumns).AddRow(1, "Trilogy", "", nil, 0))
+	mock.ExpectQuery("SELECT film_id FROM collection_films").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"film_id"}).AddRow(4))
+	mock.ExpectQuery("DELETE FROM collection_films WHERE collection_id = \\$1 AND film_id = \\$2 RETURNING position").
+		WithArgs(1, 9).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	rr := httptest.NewRecorder()
+	collectionapi.RemoveFilmHandler(rr, httptest.NewRequest(http.MethodDelete, "/collection/1/films/9", nil), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
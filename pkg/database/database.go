@@ -2,6 +2,7 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	
 	_ "github.com/lib/pq"
@@ -14,28 +15,273 @@ var TableQuery = map[string]string{
 		username VARCHAR(50) NOT NULL,
 		email VARCHAR(100) NOT NULL,
 		password VARCHAR(100) NOT NULL,
-		adminflag BOOLEAN NOT NULL DEFAULT false)`,
+		adminflag BOOLEAN NOT NULL DEFAULT false,
+		verified_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		disabled_at TIMESTAMP,
+		sessions_revoked_at TIMESTAMP,
+		delete_after TIMESTAMP)`,
 	"films": `CREATE TABLE films (
 		id SERIAL PRIMARY KEY,
 		title VARCHAR(150) NOT NULL,
+		original_title VARCHAR(150) NOT NULL DEFAULT '',
 		description TEXT,
 		release_date DATE NOT NULL,
-		rating DECIMAL(3,1) NOT NULL CHECK (rating >= 0 AND rating <= 10))`,
+		rating DECIMAL(3,1) NOT NULL CHECK (rating >= 0 AND rating <= 10),
+		version INTEGER NOT NULL DEFAULT 1,
+		poster_key VARCHAR(100) NOT NULL DEFAULT '',
+		runtime INTEGER CHECK (runtime > 0),
+		status VARCHAR(20) NOT NULL DEFAULT 'released',
+		budget BIGINT CHECK (budget >= 0),
+		budget_currency VARCHAR(3) NOT NULL DEFAULT '',
+		box_office BIGINT CHECK (box_office >= 0),
+		box_office_currency VARCHAR(3) NOT NULL DEFAULT '',
+		deleted_at TIMESTAMP)`,
 	"actors": `CREATE TABLE actors (
 		id SERIAL PRIMARY KEY,
 		name VARCHAR(100) NOT NULL,
 		gender VARCHAR(10) NOT NULL,
-		date_of_birth DATE NOT NULL)`,
+		date_of_birth DATE NOT NULL,
+		version INTEGER NOT NULL DEFAULT 1,
+		photo_key VARCHAR(100) NOT NULL DEFAULT '',
+		biography TEXT NOT NULL DEFAULT '',
+		birthplace VARCHAR(150) NOT NULL DEFAULT '',
+		nationality VARCHAR(2) NOT NULL DEFAULT '',
+		date_of_death DATE CHECK (date_of_death >= date_of_birth),
+		death_place VARCHAR(150) NOT NULL DEFAULT '',
+		deleted_at TIMESTAMP)`,
 	"film_actors": `CREATE TABLE film_actors (
 		film_id INTEGER REFERENCES films(id) ON DELETE CASCADE,
 		actor_id INTEGER REFERENCES actors(id) ON DELETE CASCADE,
 		PRIMARY KEY (film_id, actor_id))`,
+	"audit_log": `CREATE TABLE audit_log (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER,
+		username VARCHAR(50) NOT NULL,
+		action VARCHAR(20) NOT NULL,
+		entity_type VARCHAR(20) NOT NULL,
+		entity_id INTEGER NOT NULL,
+		before_data JSONB,
+		after_data JSONB,
+		request_id VARCHAR(64) NOT NULL DEFAULT '',
+		ip VARCHAR(45) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW())`,
+	"film_revisions": `CREATE TABLE film_revisions (
+		film_id INTEGER REFERENCES films(id) ON DELETE CASCADE,
+		revision INTEGER NOT NULL,
+		snapshot JSONB NOT NULL,
+		user_id INTEGER,
+		username VARCHAR(50) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (film_id, revision))`,
+	"actor_revisions": `CREATE TABLE actor_revisions (
+		actor_id INTEGER REFERENCES actors(id) ON DELETE CASCADE,
+		revision INTEGER NOT NULL,
+		snapshot JSONB NOT NULL,
+		user_id INTEGER,
+		username VARCHAR(50) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (actor_id, revision))`,
+	"film_external_ids": `CREATE TABLE film_external_ids (
+		film_id INTEGER NOT NULL REFERENCES films(id) ON DELETE CASCADE,
+		provider VARCHAR(20) NOT NULL,
+		external_id VARCHAR(64) NOT NULL,
+		PRIMARY KEY (provider, external_id))`,
+	"actor_external_ids": `CREATE TABLE actor_external_ids (
+		actor_id INTEGER NOT NULL REFERENCES actors(id) ON DELETE CASCADE,
+		provider VARCHAR(20) NOT NULL,
+		external_id VARCHAR(64) NOT NULL,
+		PRIMARY KEY (provider, external_id))`,
+	"film_media": `CREATE TABLE film_media (
+		id SERIAL PRIMARY KEY,
+		film_id INTEGER NOT NULL REFERENCES films(id) ON DELETE CASCADE,
+		type VARCHAR(20) NOT NULL,
+		provider VARCHAR(20) NOT NULL,
+		url VARCHAR(500) NOT NULL,
+		language VARCHAR(10) NOT NULL DEFAULT '',
+		duration INTEGER NOT NULL DEFAULT 0 CHECK (duration >= 0))`,
+	"film_translations": `CREATE TABLE film_translations (
+		film_id INTEGER NOT NULL REFERENCES films(id) ON DELETE CASCADE,
+		locale VARCHAR(10) NOT NULL,
+		title VARCHAR(150) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (film_id, locale))`,
+	"film_alt_titles": `CREATE TABLE film_alt_titles (
+		id SERIAL PRIMARY KEY,
+		film_id INTEGER NOT NULL REFERENCES films(id) ON DELETE CASCADE,
+		title VARCHAR(150) NOT NULL,
+		region VARCHAR(2) NOT NULL DEFAULT '',
+		type VARCHAR(20) NOT NULL)`,
+	"collections": `CREATE TABLE collections (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(150) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		parent_id INTEGER REFERENCES collections(id) ON DELETE SET NULL,
+		position INTEGER NOT NULL DEFAULT 0)`,
+	"collection_films": `CREATE TABLE collection_films (
+		collection_id INTEGER NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+		film_id INTEGER NOT NULL REFERENCES films(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL CHECK (position > 0),
+		PRIMARY KEY (collection_id, film_id))`,
+	"film_releases": `CREATE TABLE film_releases (
+		id SERIAL PRIMARY KEY,
+		film_id INTEGER NOT NULL REFERENCES films(id) ON DELETE CASCADE,
+		country VARCHAR(2) NOT NULL,
+		release_date DATE NOT NULL,
+		type VARCHAR(20) NOT NULL,
+		certification VARCHAR(10) NOT NULL DEFAULT '')`,
+	"film_countries": `CREATE TABLE film_countries (
+		film_id INTEGER NOT NULL REFERENCES films(id) ON DELETE CASCADE,
+		country VARCHAR(2) NOT NULL,
+		PRIMARY KEY (film_id, country))`,
+	"film_languages": `CREATE TABLE film_languages (
+		film_id INTEGER NOT NULL REFERENCES films(id) ON DELETE CASCADE,
+		language VARCHAR(3) NOT NULL,
+		PRIMARY KEY (film_id, language))`,
+	"actor_aliases": `CREATE TABLE actor_aliases (
+		id SERIAL PRIMARY KEY,
+		actor_id INTEGER NOT NULL REFERENCES actors(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL)`,
+	"actor_links": `CREATE TABLE actor_links (
+		id SERIAL PRIMARY KEY,
+		actor_id INTEGER NOT NULL REFERENCES actors(id) ON DELETE CASCADE,
+		site VARCHAR(20) NOT NULL,
+		url VARCHAR(500) NOT NULL)`,
+	"user_tokens": `CREATE TABLE user_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		kind VARCHAR(20) NOT NULL,
+		token_hash VARCHAR(64) NOT NULL UNIQUE,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP)`,
+	"login_failures": `CREATE TABLE login_failures (
+		scope VARCHAR(10) NOT NULL,
+		subject VARCHAR(100) NOT NULL,
+		failures INTEGER NOT NULL DEFAULT 0,
+		last_failed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		blocked_until TIMESTAMP,
+		PRIMARY KEY (scope, subject))`,
+	"api_keys": `CREATE TABLE api_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		prefix VARCHAR(12) NOT NULL,
+		key_hash VARCHAR(64) NOT NULL UNIQUE,
+		scopes TEXT[] NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		expires_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP)`,
+	"user_identities": `CREATE TABLE user_identities (
+		provider VARCHAR(50) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		email VARCHAR(100) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		last_login_at TIMESTAMP NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (provider, subject))`,
+	"oidc_states": `CREATE TABLE oidc_states (
+		state_hash VARCHAR(64) PRIMARY KEY,
+		provider VARCHAR(50) NOT NULL,
+		nonce VARCHAR(100) NOT NULL,
+		verifier VARCHAR(100) NOT NULL,
+		expires_at TIMESTAMP NOT NULL)`,
+	"user_totp": `CREATE TABLE user_totp (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		secret VARCHAR(64) NOT NULL,
+		enabled_at TIMESTAMP,
+		last_step BIGINT NOT NULL DEFAULT 0)`,
+	"recovery_codes": `CREATE TABLE recovery_codes (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash VARCHAR(64) NOT NULL,
+		used_at TIMESTAMP)`,
 }
 var TableColumn = map[string][]string{
-	"users":  {"id", "username", "email", "password", "adminflag"},
-	"films":  {"id", "title", "description", "release_date", "rating"},
-	"actors": {"id", "name", "gender", "date_of_birth"},
+	"users":  {"id", "username", "email", "password", "adminflag", "verified_at", "created_at", "disabled_at", "sessions_revoked_at", "delete_after"},
+	"films":  {"id", "title", "original_title", "description", "release_date", "rating", "version", "poster_key", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency", "deleted_at"},
+	"actors": {"id", "name", "gender", "date_of_birth", "version", "photo_key", "biography", "birthplace", "nationality", "date_of_death", "death_place", "deleted_at"},
 	"film_actors": {"film_id", "actor_id"},
+	"audit_log":   {"id", "user_id", "username", "action", "entity_type", "entity_id", "before_data", "after_data", "request_id", "ip", "created_at"},
+	"film_revisions":  {"film_id", "revision", "snapshot", "user_id", "username", "created_at"},
+	"actor_revisions": {"actor_id", "revision", "snapshot", "user_id", "username", "created_at"},
+	"film_external_ids":  {"film_id", "provider", "external_id"},
+	"actor_external_ids": {"actor_id", "provider", "external_id"},
+	"film_media":         {"id", "film_id", "type", "provider", "url", "language", "duration"},
+	"film_translations":  {"film_id", "locale", "title", "description"},
+	"film_alt_titles":    {"id", "film_id", "title", "region", "type"},
+	"collections":        {"id", "name", "description", "parent_id", "position"},
+	"collection_films":   {"collection_id", "film_id", "position"},
+	"film_releases":      {"id", "film_id", "country", "release_date", "type", "certification"},
+	"film_countries":     {"film_id", "country"},
+	"film_languages":     {"film_id", "language"},
+	"actor_aliases":      {"id", "actor_id", "name"},
+	"actor_links":        {"id", "actor_id", "site", "url"},
+	"user_tokens":        {"id", "user_id", "kind", "token_hash", "expires_at", "used_at"},
+	"login_failures":     {"scope", "subject", "failures", "last_failed_at", "blocked_until"},
+	"api_keys":           {"id", "user_id", "name", "prefix", "key_hash", "scopes", "created_at", "expires_at", "last_used_at", "revoked_at"},
+	"user_identities":    {"provider", "subject", "user_id", "email", "created_at", "last_login_at"},
+	"oidc_states":        {"state_hash", "provider", "nonce", "verifier", "expires_at"},
+	"user_totp":          {"user_id", "secret", "enabled_at", "last_step"},
+	"recovery_codes":     {"id", "user_id", "code_hash", "used_at"},
+}
+
+// tables are created in this order, so that referenced tables exist before the foreign keys to them
+var TableOrder = []string{"users", "films", "actors", "film_actors", "audit_log", "film_revisions", "actor_revisions", "film_external_ids", "actor_external_ids", "film_media", "film_translations", "film_alt_titles", "collections", "collection_films", "film_releases", "film_countries", "film_languages", "actor_aliases", "actor_links", "user_tokens", "login_failures", "api_keys", "user_identities", "oidc_states", "user_totp", "recovery_codes"}
+
+// columns added to tables that databases of older versions have already. Checker runs all of them on every start,
+// so each one must be safe to run again; new tables get the columns from TableQuery
+var Migrations = []string{
+	// optimistic locking
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1",
+	"ALTER TABLE actors ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1",
+	// trash
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP",
+	"ALTER TABLE actors ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP",
+	// uploaded images
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS poster_key VARCHAR(100) NOT NULL DEFAULT ''",
+	"ALTER TABLE actors ADD COLUMN IF NOT EXISTS photo_key VARCHAR(100) NOT NULL DEFAULT ''",
+	// film details
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS original_title VARCHAR(150) NOT NULL DEFAULT ''",
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS runtime INTEGER CHECK (runtime > 0)",
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'released'",
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS budget BIGINT CHECK (budget >= 0)",
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS budget_currency VARCHAR(3) NOT NULL DEFAULT ''",
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS box_office BIGINT CHECK (box_office >= 0)",
+	"ALTER TABLE films ADD COLUMN IF NOT EXISTS box_office_currency VARCHAR(3) NOT NULL DEFAULT ''",
+	// actor details
+	"ALTER TABLE actors ADD COLUMN IF NOT EXISTS biography TEXT NOT NULL DEFAULT ''",
+	"ALTER TABLE actors ADD COLUMN IF NOT EXISTS birthplace VARCHAR(150) NOT NULL DEFAULT ''",
+	"ALTER TABLE actors ADD COLUMN IF NOT EXISTS nationality VARCHAR(2) NOT NULL DEFAULT ''",
+	"ALTER TABLE actors ADD COLUMN IF NOT EXISTS date_of_death DATE CHECK (date_of_death >= date_of_birth)",
+	"ALTER TABLE actors ADD COLUMN IF NOT EXISTS death_place VARCHAR(150) NOT NULL DEFAULT ''",
+	// accounts. Users registered before the email verification are counted as verified, otherwise
+	// userapi.RequireVerification would lock every one of them out; the block does nothing once the column exists
+	`DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'verified_at') THEN
+			ALTER TABLE users ADD COLUMN verified_at TIMESTAMP;
+			UPDATE users SET verified_at = NOW();
+		END IF;
+	END $$`,
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NOT NULL DEFAULT NOW()",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS sessions_revoked_at TIMESTAMP",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS delete_after TIMESTAMP",
+	// authors of the changes
+	authorIDMigration("audit_log"),
+	authorIDMigration("film_revisions"),
+	authorIDMigration("actor_revisions"),
+}
+
+// utility: user_id of the author, without a foreign key since the rows outlive the user. Rows written before
+// the column get the id of the user who has their username now, the only way to tell who wrote them
+func authorIDMigration(table string) string {
+	return `DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = '` + table + `' AND column_name = 'user_id') THEN
+			ALTER TABLE ` + table + ` ADD COLUMN user_id INTEGER;
+			UPDATE ` + table + ` t SET user_id = u.id FROM users u WHERE t.username = u.username;
+		END IF;
+	END $$`
 }
 
 
@@ -52,8 +298,8 @@ func ConnectToPG(connString string) (*sql.DB, error) {
 
 	log.Println("Success connect")
 
-	if Checker(db) != nil {
-		log.Fatal(err)
+	if err := Checker(db); err != nil {
+		db.Close()
 		return nil, err
 	}
 	return db, nil
@@ -97,26 +343,32 @@ func ColumnsExist(db *sql.DB, tableName string, columnNames []string) (bool, err
 	return true, nil
 }
 
+// creates the missing tables and adds the missing columns, data is never dropped
 func Checker(db *sql.DB) error {
-	for table, columns := range TableColumn {
+	for _, table := range TableOrder {
 		exists, err := TableExists(db, table)
 		if err != nil {
-			log.Fatal(err)
 			return err
 		}
 		if !exists {
-			db.Exec(TableQuery[table])
-			continue
+			if _, err := db.Exec(TableQuery[table]); err != nil {
+				return fmt.Errorf("creating table %s: %w", table, err)
+			}
 		}
-		exists, err = ColumnsExist(db, table, columns)
+	}
+	for _, migration := range Migrations {
+		if _, err := db.Exec(migration); err != nil {
+			return fmt.Errorf("%s: %w", migration, err)
+		}
+	}
+	// a column without a migration is a bug, the queries using it would fail later
+	for _, table := range TableOrder {
+		exists, err := ColumnsExist(db, table, TableColumn[table])
 		if err != nil {
-			log.Fatal(err)
 			return err
 		}
 		if !exists {
-			db.Exec("DROP TABLE" + table)
-			db.Exec(TableQuery[table])
-			continue
+			return fmt.Errorf("table %s has not all of the columns %v", table, TableColumn[table])
 		}
 	}
 	return nil
@@ -3,6 +3,9 @@ package database_test
 import (
 	"database/sql"
 	"errors"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -169,40 +172,106 @@ func TestColumnsExist_Error(t *testing.T) {
     }
 }
 
-// I used map for create tables, but the map in golang is not deterministic, so
-// that testcase is not deterministic
-func TestChecker_AllTablesAndColumnsExist(t *testing.T) {
-    db, mock, err := sqlmock.New()
-    if err != nil {
-        t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
-    }
-    defer db.Close()
+func expectTables(mock sqlmock.Sqlmock, exists bool) {
+	for _, table := range database.TableOrder {
+		mock.ExpectQuery("SELECT EXISTS (.+)").WithArgs(table).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(exists))
+		if !exists {
+			mock.ExpectExec(regexp.QuoteMeta(database.TableQuery[table])).WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+	}
+	for _, migration := range database.Migrations {
+		mock.ExpectExec(regexp.QuoteMeta(migration)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+}
 
-    tableOrder := []string{"users", "films", "actors", "film_actors"}
+func expectColumns(mock sqlmock.Sqlmock, table string, columns []string) {
+	rows := sqlmock.NewRows([]string{"column_name"})
+	for _, column := range columns {
+		rows.AddRow(column)
+	}
+	mock.ExpectQuery("SELECT column_name FROM information_schema.columns WHERE table_name = (.+)").WithArgs(table).WillReturnRows(rows)
+}
+
+func TestChecker_AllTablesAndColumnsExist(t *testing.T) {
+	for _, exists := range []bool{true, false} {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		expectTables(mock, exists)
+		for _, table := range database.TableOrder {
+			expectColumns(mock, table, database.TableColumn[table])
+		}
+
+		if err := database.Checker(db); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+		db.Close()
+	}
+}
 
-	for _, table := range tableOrder {
-        columns := database.TableColumn[table]
-        exists := true
+func TestChecker_MissingColumn(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
 
-        rows := sqlmock.NewRows([]string{"exists"}).AddRow(exists)
-        mock.ExpectQuery("SELECT EXISTS (.+)").WithArgs(table).WillReturnRows(rows)
+	// no DROP TABLE, the data stays and the start fails
+	expectTables(mock, true)
+	expectColumns(mock, "users", database.TableColumn["users"][:5])
 
-        if !exists {
-            mock.ExpectExec(database.TableQuery[table]).WillReturnResult(sqlmock.NewResult(0, 0))
-        }
-        mock.ExpectQuery("SELECT column_name FROM information_schema.columns WHERE table_name = (.+)").
-            WithArgs(table).
-            WillReturnRows(sqlmock.NewRows([]string{"column_name"}).AddRow(columns[0]).AddRow(columns[1]))
-    }
+	if err := database.Checker(db); err == nil {
+		t.Fatalf("Expected an error but got nil")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
 
-    err = database.Checker(db)
-    if err != nil {
-        t.Fatalf("Unexpected error: %v", err)
-    }
+func TestMigrations_CoverColumns(t *testing.T) {
+	// every column of these tables that is not in their first schema needs a migration
+	original := map[string][]string{
+		"users":           {"id", "username", "email", "password", "adminflag"},
+		"films":           {"id", "title", "description", "release_date", "rating"},
+		"actors":          {"id", "name", "gender", "date_of_birth"},
+		"audit_log":       {"id", "username", "action", "entity_type", "entity_id", "before_data", "after_data", "request_id", "ip", "created_at"},
+		"film_revisions":  {"film_id", "revision", "snapshot", "username", "created_at"},
+		"actor_revisions": {"actor_id", "revision", "snapshot", "username", "created_at"},
+	}
+	for table, columns := range original {
+		for _, column := range database.TableColumn[table] {
+			if slices.Contains(columns, column) {
+				continue
+			}
+			prefix := "ALTER TABLE " + table + " ADD COLUMN IF NOT EXISTS " + column + " "
+			// or a DO block that adds the column and fills it
+			statement := "ALTER TABLE " + table + " ADD COLUMN " + column + " "
+			if !slices.ContainsFunc(database.Migrations, func(migration string) bool {
+				return strings.HasPrefix(migration, prefix) || strings.Contains(migration, statement)
+			}) {
+				t.Errorf("no migration for %s.%s", table, column)
+			}
+		}
+	}
+}
 
-    if err := mock.ExpectationsWereMet(); err != nil {
-        t.Errorf("there were unfulfilled expectations: %s", err)
-    }
+func TestTableOrder_CoversAllTables(t *testing.T) {
+	if len(database.TableOrder) != len(database.TableQuery) {
+		t.Fatalf("TableOrder has %d tables, TableQuery has %d", len(database.TableOrder), len(database.TableQuery))
+	}
+	for _, table := range database.TableOrder {
+		if _, ok := database.TableQuery[table]; !ok {
+			t.Errorf("no TableQuery for %s", table)
+		}
+		if _, ok := database.TableColumn[table]; !ok {
+			t.Errorf("no TableColumn for %s", table)
+		}
+	}
 }
 
 func TestConnectToPG_Success(t *testing.T) {
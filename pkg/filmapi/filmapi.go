@@ -5,9 +5,18 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/isocode"
 	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/translationapi"
 	"github.com/vexrina/cinemaLibrary/pkg/types"
 )
 
@@ -20,8 +29,17 @@ func CreateFilmHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 		return
 	}
 
+	if err := ValidateTitles(film); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ValidateDetails(film); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Insert film data to database
-	_, err = orm.CreateFilm(film)
+	_, err = orm.WithAudit(auditapi.MetaFromRequest(r)).CreateFilm(film)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -39,15 +57,101 @@ func UpdateFilmHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 		return
 	}
 
-	err = orm.UpdateFilm(film)
+	version, err := httperror.VersionFromRequest(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	film.Version = version
+	if err := ValidateTitles(film); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ValidateDetails(film); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).UpdateFilm(film)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// kinds of alternative titles
+var AltTitleTypes = map[string]bool{
+	"working":   true,
+	"festival":  true,
+	"localized": true,
+}
+
+// utility: original and alternative titles have the same 150 characters limit as the title
+func ValidateTitles(film types.Film) error {
+	if utf8.RuneCountInString(film.OriginalTitle) > 150 {
+		return errors.New("original_title must be at most 150 characters long")
+	}
+	for _, title := range film.AltTitles {
+		titleLength := utf8.RuneCountInString(title.Title)
+		if titleLength < 1 || titleLength > 150 {
+			return errors.New("alternative title must be 1-150 characters long")
+		}
+		if title.Region != "" && !isocode.Country.MatchString(title.Region) {
+			return errors.New("region of alternative title must be a country code like US")
+		}
+		if !AltTitleTypes[title.Type] {
+			return errors.New("type of alternative title must be one of working, festival, localized")
+		}
+	}
+	return nil
+}
+
+// longest film ever released runs a bit under 14000 minutes
+const maxRuntime = 14400
+
+// ISO 4217, "USD", "RUB"
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ISO 639-1 or 639-2, "en", "rus"
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// utility: runtime, status, money, production countries and spoken languages
+func ValidateDetails(film types.Film) error {
+	if film.Runtime < 0 || film.Runtime > maxRuntime {
+		return errors.New("runtime must be 1-14400 minutes")
+	}
+	if film.Status != "" && !types.FilmStatuses[film.Status] {
+		return errors.New("status must be one of announced, in_production, released")
+	}
+	for _, money := range []struct {
+		name  string
+		value *types.Money
+	}{{"budget", film.Budget}, {"box_office", film.BoxOffice}} {
+		if money.value == nil {
+			continue
+		}
+		if money.value.Amount < 0 {
+			return errors.New(money.name + " amount must not be negative")
+		}
+		if !currencyPattern.MatchString(money.value.Currency) {
+			return errors.New(money.name + " currency must be a currency code like USD")
+		}
+	}
+	for _, country := range film.Countries {
+		if !isocode.Country.MatchString(country) {
+			return errors.New("countries must be country codes like US")
+		}
+	}
+	for _, language := range film.Languages {
+		if !languagePattern.MatchString(language) {
+			return errors.New("languages must be language codes like en")
+		}
+	}
+	return nil
+}
+
 // get method
 // utility
 type EnumType string
@@ -56,11 +160,14 @@ const (
 	EnumValue1 EnumType = "rating"
 	EnumValue2 EnumType = "title"
 	EnumValue3 EnumType = "release_date"
+	EnumValue4 EnumType = "runtime"
+	EnumValue5 EnumType = "budget"
+	EnumValue6 EnumType = "box_office"
 )
 
 func IsValidEnumType(value EnumType) bool {
 	switch value {
-	case EnumValue1, EnumValue2, EnumValue3:
+	case EnumValue1, EnumValue2, EnumValue3, EnumValue4, EnumValue5, EnumValue6:
 		return true
 	default:
 		return false
@@ -90,12 +197,100 @@ func ReturnAnswer(response []types.Film, w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// utility
+// list filters from the query; consumed parameters are removed from queryValues
+func FilterFromQuery(queryValues url.Values) (types.FilmFilter, error) {
+	var filter types.FilmFilter
+	if _, ok := queryValues["has_trailer"]; ok {
+		hasTrailer, err := strconv.ParseBool(queryValues.Get("has_trailer"))
+		if err != nil {
+			return filter, errors.New("Invalid value for has_trailer parameter")
+		}
+		filter.HasTrailer = &hasTrailer
+		queryValues.Del("has_trailer")
+	}
+	if _, ok := queryValues["country"]; ok {
+		filter.Country = queryValues.Get("country")
+		if !isocode.Country.MatchString(filter.Country) {
+			return filter, errors.New("Invalid value for country parameter")
+		}
+		queryValues.Del("country")
+	}
+	if _, ok := queryValues["certification"]; ok {
+		maxAge, known := types.CertificationAges[queryValues.Get("certification")]
+		if !known {
+			return filter, errors.New("Invalid value for certification parameter")
+		}
+		filter.MaxAge = &maxAge
+		queryValues.Del("certification")
+	}
+	for _, bound := range []struct {
+		name  string
+		value *string
+	}{{"released_from", &filter.ReleasedFrom}, {"released_to", &filter.ReleasedTo}} {
+		if _, ok := queryValues[bound.name]; !ok {
+			continue
+		}
+		*bound.value = queryValues.Get(bound.name)
+		if _, err := time.Parse("2006-01-02", *bound.value); err != nil {
+			return filter, errors.New("Invalid value for " + bound.name + " parameter")
+		}
+		queryValues.Del(bound.name)
+	}
+	if _, ok := queryValues["status"]; ok {
+		filter.Status = queryValues.Get("status")
+		if !types.FilmStatuses[filter.Status] {
+			return filter, errors.New("Invalid value for status parameter")
+		}
+		queryValues.Del("status")
+	}
+	if _, ok := queryValues["production_country"]; ok {
+		filter.ProductionCountry = queryValues.Get("production_country")
+		if !isocode.Country.MatchString(filter.ProductionCountry) {
+			return filter, errors.New("Invalid value for production_country parameter")
+		}
+		queryValues.Del("production_country")
+	}
+	if _, ok := queryValues["language"]; ok {
+		filter.Language = queryValues.Get("language")
+		if !languagePattern.MatchString(filter.Language) {
+			return filter, errors.New("Invalid value for language parameter")
+		}
+		queryValues.Del("language")
+	}
+	for _, bound := range []struct {
+		name  string
+		value *int
+	}{{"min_runtime", &filter.MinRuntime}, {"max_runtime", &filter.MaxRuntime}} {
+		if _, ok := queryValues[bound.name]; !ok {
+			continue
+		}
+		runtime, err := strconv.Atoi(queryValues.Get(bound.name))
+		if err != nil || runtime <= 0 {
+			return filter, errors.New("Invalid value for " + bound.name + " parameter")
+		}
+		*bound.value = runtime
+		queryValues.Del(bound.name)
+	}
+	return filter, nil
+}
+
 // main func for get method
 func GetFilmsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	orm = orm.WithLocales(translationapi.LocalesFromRequest(r))
+	w.Header().Set("Vary", "Accept-Language")
 	queryValues := r.URL.Query()
+	// lang selects the translation only, it is not a filter
+	queryValues.Del("lang")
+
+	filter, err := FilterFromQuery(queryValues)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	if len(queryValues) == 0 {
-		films, err := orm.GetFilms("", false)
+		films, err := orm.GetFilms("", false, filter)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -123,14 +318,14 @@ func GetFilmsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 	}
 	if sortOk {
 		if ascOk {
-			films, err := orm.GetFilms(sortBy[0], asc)
+			films, err := orm.GetFilms(sortBy[0], asc, filter)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 			}
 			ReturnAnswer(films, w, r)
 			return
 		}
-		films, err := orm.GetFilms(sortBy[0], false)
+		films, err := orm.GetFilms(sortBy[0], false, filter)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -139,7 +334,7 @@ func GetFilmsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 	}
 
 	if ascOk {
-		films, err := orm.GetFilms("", asc)
+		films, err := orm.GetFilms("", asc, filter)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
@@ -157,6 +352,11 @@ func GetFilmsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 		http.Error(w, "Invalid request for filtеr", http.StatusBadRequest)
 		return
 	}
+	// filters apply to the sorted list only
+	if (okActor || okTitle || okBoth) && filter != (types.FilmFilter{}) {
+		http.Error(w, "List filters can not be combined with search", http.StatusBadRequest)
+		return
+	}
 	if okActor {
 		films, err := orm.SearchFilmsByActorFragment(actor[0])
 		if err != nil {
@@ -199,11 +399,123 @@ func DeleteFilmHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 		return
 	}
 	
-	err = orm.DeleteFilmByID(film.ID)
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).DeleteFilmByID(film.ID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// restore method (from trash)
+func RestoreFilmHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	var film types.Film
+	err := json.NewDecoder(r.Body).Decode(&film)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if film.ID == 0 {
+		http.Error(w, "Film ID is required", http.StatusBadRequest)
+		return
+	}
+
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).RestoreFilmByID(film.ID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// trash method
+func GetDeletedFilmsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	films, err := orm.GetDeletedFilms()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	ReturnAnswer(films, w, r)
+}
+
+// utility
+// /film/{id} -> id
+func ParseFilmPath(path string) (int, bool) {
+	id, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(path, "/film/"), "/"))
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// detail method
+// url like /film/{id}, the film with actor ids, media and alternative titles. Texts are translated the same way as in the list
+func GetFilmHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, ok := ParseFilmPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Vary", "Accept-Language")
+	film, err := orm.WithLocales(translationapi.LocalesFromRequest(r)).GetFilmByID(filmID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(film)
+}
+
+// history method
+// url like /film/{id}/history or /film/{id}/history/{rev}
+func GetFilmHistoryHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, action, revision, ok := auditapi.ParseHistoryPath(r.URL.Path, "/film/")
+	if !ok || action != "history" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var response interface{}
+	var err error
+	if revision == 0 {
+		response, err = orm.GetFilmHistory(filmID)
+	} else {
+		response, err = orm.GetFilmRevision(filmID, revision)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// revert method
+// url like /film/{id}/revert/{rev}, If-Match works the same way as in patch
+func RevertFilmHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, action, revision, ok := auditapi.ParseHistoryPath(r.URL.Path, "/film/")
+	if !ok || action != "revert" {
+		http.NotFound(w, r)
+		return
+	}
+
+	version, err := httperror.VersionFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).RevertFilm(filmID, revision, version)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
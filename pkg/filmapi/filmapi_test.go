@@ -2,16 +2,21 @@ package filmapi_test
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/vexrina/cinemaLibrary/pkg/filmapi"
 	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
 	"github.com/vexrina/cinemaLibrary/pkg/types"
 )
 
@@ -32,7 +37,8 @@ func TestCreateFilmHandler_Success(t *testing.T) {
 		Actors:      []int{1, 2, 3},
 	}
 
-	mock.ExpectQuery("INSERT INTO films").WithArgs(fakeFilm.Title, fakeFilm.Description, fakeFilm.ReleaseDate, fakeFilm.Rating).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO films").WithArgs(fakeFilm.Title, fakeFilm.Description, fakeFilm.ReleaseDate, fakeFilm.Rating, fakeFilm.OriginalTitle, nil, "released", nil, "", nil, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
 	for _, actorID := range fakeFilm.Actors {
 		mock.ExpectExec("INSERT INTO film_actors").WithArgs(1, actorID).WillReturnResult(sqlmock.NewResult(1, 1))
@@ -47,10 +53,14 @@ func TestCreateFilmHandler_Success(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	req.Header.Set("X-Request-ID", "req-1")
+	req.RemoteAddr = "10.0.0.5:41234"
 
-	mock.ExpectExec("INSERT INTO films").
-		WithArgs(fakeFilm.Title, fakeFilm.Description, fakeFilm.ReleaseDate, fakeFilm.Rating).
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(1, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("", "create", "film", 1, nil, sqlmock.AnyArg(), "req-1", "10.0.0.5", nil).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		filmapi.CreateFilmHandler(w, r, orm)
@@ -108,7 +118,15 @@ func TestUpdateFilmHandler_Success(t *testing.T) {
 	}
 	defer db.Close()
 	orm := orm.NewORM(db)
-	mock.ExpectExec("UPDATE films").WithArgs(fakeFilm.ID, fakeFilm.Title, fakeFilm.Description, fakeFilm.ReleaseDate, fakeFilm.Rating).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, description, (.+) FROM films WHERE id = \\$1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}).AddRow(1, "Old Title", fakeFilm.Description, fakeFilm.ReleaseDate, fakeFilm.Rating, 4, "", 0, "released", nil, "", nil, ""))
+	mock.ExpectExec("UPDATE films").WithArgs(fakeFilm.ID, fakeFilm.Title, fakeFilm.Description, fakeFilm.ReleaseDate, fakeFilm.Rating, fakeFilm.OriginalTitle, nil, "released", nil, "", nil, "", 0).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(1, "admin", 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("admin", "update", "film", 1, []byte(`{"title":"Old Title","version":4}`), []byte(`{"title":"Updated Film Title","version":5}`), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	body, err := json.Marshal(fakeFilm)
 	if err != nil {
@@ -119,6 +137,11 @@ func TestUpdateFilmHandler_Success(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	token, err := tokens.CreateToken(1, "admin", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		filmapi.UpdateFilmHandler(w, r, orm)
@@ -144,7 +167,7 @@ func TestUpdateFilmHandler_Error(t *testing.T) {
 	defer db.Close()
 	orm := orm.NewORM(db)
 
-	mock.ExpectExec("UPDATE films").WithArgs(fakeFilm.ID, fakeFilm.Title, fakeFilm.Description, fakeFilm.ReleaseDate, fakeFilm.Rating).WillReturnError(errors.New("database error"))
+	mock.ExpectExec("UPDATE films").WithArgs(fakeFilm.ID, fakeFilm.Title, fakeFilm.Description, fakeFilm.ReleaseDate, fakeFilm.Rating, fakeFilm.OriginalTitle, nil, "released", nil, "", nil, "", 0).WillReturnError(errors.New("database error"))
 
 	body, err := json.Marshal(fakeFilm)
 	if err != nil {
@@ -180,6 +203,26 @@ func TestIsValidEnumType(t *testing.T) {
 	}
 }
 
+func TestValidateTitles(t *testing.T) {
+	valid := types.Film{
+		OriginalTitle: "Sen to Chihiro no kamikakushi",
+		AltTitles: []types.AltTitle{
+			{Title: "Spirited Away", Region: "US", Type: "localized"},
+			{Title: "Chihiro", Type: "working"},
+		},
+	}
+	assert.NoError(t, filmapi.ValidateTitles(valid))
+
+	for _, title := range []types.AltTitle{
+		{Title: "", Type: "working"},
+		{Title: "Spirited Away", Region: "usa", Type: "localized"},
+		{Title: "Spirited Away", Type: "dvd"},
+	} {
+		assert.Error(t, filmapi.ValidateTitles(types.Film{AltTitles: []types.AltTitle{title}}), title)
+	}
+	assert.Error(t, filmapi.ValidateTitles(types.Film{OriginalTitle: strings.Repeat("a", 151)}))
+}
+
 func TestValidateEnumType(t *testing.T) {
 	validValues := []filmapi.EnumType{filmapi.EnumValue1, filmapi.EnumValue2, filmapi.EnumValue3}
 	for _, value := range validValues {
@@ -239,6 +282,12 @@ func TestReturnAnswer_Success(t *testing.T) {
 	}
 }
 
+// utility for test: the film list queries load the alternative titles of the films found
+func expectAltTitles(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT film_id, title, region, type FROM film_alt_titles WHERE film_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"film_id", "title", "region", "type"}))
+}
+
 func TestGetFilmsHandler_Success_Default(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -248,12 +297,13 @@ func TestGetFilmsHandler_Success_Default(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-	mock.ExpectQuery("SELECT \\* FROM films ORDER BY rating DESC").
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL ORDER BY rating DESC").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
 	req, err := http.NewRequest("GET", "/", nil)
 	if err != nil {
@@ -284,12 +334,13 @@ func TestGetFilmsHandler_Success_DefaultWithAsc(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-	mock.ExpectQuery("SELECT \\* FROM films ORDER BY rating ASC").
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL ORDER BY rating ASC").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
 	req, err := http.NewRequest("GET", "/?asc=true", nil)
 	if err != nil {
@@ -320,12 +371,13 @@ func TestGetFilmsHandler_Success_AlternativeSort(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-	mock.ExpectQuery("SELECT \\* FROM films ORDER BY release_date DESC").
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL ORDER BY release_date DESC").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
 	req, err := http.NewRequest("GET", "/?sortby=release_date", nil)
 	if err != nil {
@@ -355,12 +407,13 @@ func TestGetFilmsHandler_Success_AlternativeSortWithAsc(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-	mock.ExpectQuery("SELECT \\* FROM films ORDER BY title ASC").
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL ORDER BY title ASC").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
 	req, err := http.NewRequest("GET", "/?sortby=title&asc=true", nil)
 	if err != nil {
@@ -399,9 +452,10 @@ func TestGetFilmsHandler_Success_SearchByActor(t *testing.T) {
         WHERE a.name LIKE '%' || $1 || '%'
 		`
 	mock.ExpectQuery(query).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-			AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-			AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0))
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+			AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+			AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", ""))
+	expectAltTitles(mock)
 
 	mock.ExpectExec("INSERT INTO films_actor").WithArgs(1, "John Doe").WillReturnResult(sqlmock.NewResult(1, 1))
 
@@ -429,12 +483,13 @@ func TestGetFilmsHandler_Success_SearchByTitle(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Matrix", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "John Wik", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Matrix", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "John Wik", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
 	mock.ExpectQuery("SELECT * FROM films WHERE title LIKE '%' || $1 || '%'").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
 	req, err := http.NewRequest("GET", "/?title=John", nil)
 	if err != nil {
@@ -465,9 +520,9 @@ func TestGetFilmsHandler_Success_SearchByBoth(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Matrix", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "John Wik", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Matrix", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "John Wik", "Description 2", "2023-01-01", 8.0, 1, "", "")
 	
 	
 	queryByActor := `
@@ -485,6 +540,7 @@ func TestGetFilmsHandler_Success_SearchByBoth(t *testing.T) {
 	query := queryByActor + " UNION ALL " + queryByTitle
 		
 	mock.ExpectQuery(query).WillReturnRows(rows)
+	expectAltTitles(mock)
 	mock.ExpectExec("INSERT INTO films_actor").WithArgs(1, "John Doe").WillReturnResult(sqlmock.NewResult(1, 1))
 	
 
@@ -516,13 +572,16 @@ func TestDeleteFilmHandler_SuccessfulDelete(t *testing.T) {
 	}
 	defer db.Close()
 
-	mock.ExpectExec("DELETE FROM film_actors WHERE film_id = ?").
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, description, (.+) FROM films WHERE id = \\$1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}).AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 3, "", 0, "released", nil, "", nil, ""))
+	mock.ExpectExec("UPDATE films SET deleted_at").
 		WithArgs(1).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	mock.ExpectExec("DELETE FROM films WHERE id = ?").
-		WithArgs(1).
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("", "delete", "film", 1, sqlmock.AnyArg(), nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	orm := orm.NewORM(db)
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -563,4 +622,422 @@ func TestDeleteFilmHandler_DecodingError(t *testing.T) {
 	rr := httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 	assert.Equal(t, http.StatusBadRequest, rr.Code, "status code is not BadRequest")
-}
\ No newline at end of file
+}
+func TestUpdateFilmHandler_IfMatchConflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	orm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, description, (.+) FROM films WHERE id = \\$1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}).AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 3, "", 0, "released", nil, "", nil, ""))
+	mock.ExpectExec("UPDATE films").
+		WithArgs(1, "Title", "", "2024-03-16", 9.0, "", nil, "released", nil, "", nil, "", 3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	body := []byte(`{"id": 1, "title": "Title", "release_date": "2024-03-16", "rating": 9.0}`)
+	req, err := http.NewRequest("PATCH", "/", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", `"3"`)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filmapi.UpdateFilmHandler(w, r, orm)
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateFilmHandler_BadIfMatch(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	orm := orm.NewORM(db)
+
+	req, err := http.NewRequest("PATCH", "/", bytes.NewReader([]byte(`{"id": 1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", "abc")
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filmapi.UpdateFilmHandler(w, r, orm)
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestDeleteFilmHandler_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, description, (.+) FROM films WHERE id = \\$1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}))
+	mock.ExpectRollback()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filmapi.DeleteFilmHandler(w, r, orm)
+	})
+
+	req, err := http.NewRequest("DELETE", "/", bytes.NewBuffer([]byte(`{"ID": 1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRestoreFilmHandler_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE films SET deleted_at = NULL").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT id, title, description, (.+) FROM films WHERE id = \\$1").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}).AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 3, "", 0, "released", nil, "", nil, ""))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("", "restore", "film", 1, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filmapi.RestoreFilmHandler(w, r, orm)
+	})
+
+	req, err := http.NewRequest("POST", "/film/restore", bytes.NewBuffer([]byte(`{"id": 1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetDeletedFilmsHandler_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("FROM films WHERE deleted_at IS NOT NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "deleted_at"}).
+			AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "2024-03-20T10:00:00Z"))
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filmapi.GetDeletedFilmsHandler(w, r, orm)
+	})
+
+	req, err := http.NewRequest("GET", "/film/trash", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var films []types.Film
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &films))
+	assert.Len(t, films, 1)
+	assert.NotNil(t, films[0].DeletedAt)
+}
+
+func TestGetFilmHistoryHandler_Revision(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	snapshot := `{"id": 1, "title": "Film 1", "actors": [2]}`
+	mock.ExpectQuery("SELECT revision, username, created_at, snapshot FROM film_revisions WHERE film_id = \\$1 AND revision = \\$2").
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "username", "created_at", "snapshot"}).
+			AddRow(2, "admin", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), []byte(snapshot)))
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filmapi.GetFilmHistoryHandler(w, r, orm)
+	})
+
+	req, err := http.NewRequest("GET", "/film/1/history/2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var revision types.Revision
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &revision))
+	assert.Equal(t, 2, revision.Revision)
+	assert.JSONEq(t, snapshot, string(revision.Snapshot))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevertFilmHandler_BadPath(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filmapi.RevertFilmHandler(w, r, orm)
+	})
+
+	req, err := http.NewRequest("POST", "/film/1/history/2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevertFilmHandler_UnknownRevision(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT revision, username, created_at, snapshot FROM film_revisions").
+		WithArgs(1, 9).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "username", "created_at", "snapshot"}))
+	mock.ExpectRollback()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filmapi.RevertFilmHandler(w, r, orm)
+	})
+
+	req, err := http.NewRequest("POST", "/film/1/revert/9", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFilmsHandler_HasTrailer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL AND EXISTS \\(SELECT 1 FROM film_media m WHERE m.film_id = films.id AND m.type = \\$1\\) ORDER BY title ASC").
+		WithArgs("trailer").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+			AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", ""))
+	expectAltTitles(mock)
+	mock.ExpectQuery("FROM films WHERE deleted_at IS NULL AND NOT EXISTS").
+		WithArgs("trailer").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}))
+
+	orm := orm.NewORM(db)
+	for _, url := range []string{"/film?has_trailer=true&sortby=title&asc=true", "/film?has_trailer=false"} {
+		rr := httptest.NewRecorder()
+		filmapi.GetFilmsHandler(rr, httptest.NewRequest("GET", url, nil), orm)
+		assert.Equal(t, http.StatusOK, rr.Code, url)
+	}
+
+	for _, url := range []string{"/film?has_trailer=maybe", "/film?has_trailer=true&title=Film"} {
+		rr := httptest.NewRecorder()
+		filmapi.GetFilmsHandler(rr, httptest.NewRequest("GET", url, nil), orm)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFilmsHandler_ReleaseFilter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL AND EXISTS \\(SELECT 1 FROM film_releases r WHERE r.film_id = films.id AND r.country = \\$1 AND r.certification = ANY\\(\\$2\\) AND r.release_date >= \\$3 AND r.release_date <= \\$4\\) ORDER BY rating DESC").
+		WithArgs("RU", pq.Array([]string{"0+", "12+", "6+", "G", "PG"}), "2023-01-01", "2023-12-31").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+			AddRow(1, "Film 1", "Description 1", "2023-03-01", 7.5, 1, "", ""))
+	expectAltTitles(mock)
+
+	orm := orm.NewORM(db)
+	rr := httptest.NewRecorder()
+	filmapi.GetFilmsHandler(rr, httptest.NewRequest("GET", "/film?country=RU&certification=12%2B&released_from=2023-01-01&released_to=2023-12-31", nil), orm)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	for _, url := range []string{"/film?country=russia", "/film?certification=21%2B", "/film?released_from=01.01.2023", "/film?country=RU&actor=John"} {
+		rr := httptest.NewRecorder()
+		filmapi.GetFilmsHandler(rr, httptest.NewRequest("GET", url, nil), orm)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFilmsHandler_DetailsFilterAndSort(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL AND status = \\$1 AND EXISTS \\(SELECT 1 FROM film_countries fc WHERE fc.film_id = films.id AND fc.country = \\$2\\) AND EXISTS \\(SELECT 1 FROM film_languages fl WHERE fl.film_id = films.id AND fl.language = \\$3\\) AND runtime >= \\$4 AND runtime <= \\$5 ORDER BY budget ASC NULLS LAST").
+		WithArgs("released", "US", "en", 90, 150).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+			AddRow(1, "Film 1", "Description 1", "2023-03-01", 7.5, 1, "", ""))
+	expectAltTitles(mock)
+
+	orm := orm.NewORM(db)
+	rr := httptest.NewRecorder()
+	filmapi.GetFilmsHandler(rr, httptest.NewRequest("GET", "/film?status=released&production_country=US&language=en&min_runtime=90&max_runtime=150&sortby=budget&asc=true", nil), orm)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	for _, url := range []string{"/film?status=filming", "/film?production_country=usa", "/film?language=English", "/film?min_runtime=0", "/film?max_runtime=long"} {
+		rr := httptest.NewRecorder()
+		filmapi.GetFilmsHandler(rr, httptest.NewRequest("GET", url, nil), orm)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, url)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidateDetails(t *testing.T) {
+	assert.NoError(t, filmapi.ValidateDetails(types.Film{}))
+	assert.NoError(t, filmapi.ValidateDetails(types.Film{
+		Runtime:   169,
+		Status:    "released",
+		Budget:    &types.Money{Amount: 165000000, Currency: "USD"},
+		BoxOffice: &types.Money{Amount: 0, Currency: "RUB"},
+		Countries: []string{"US", "GB"},
+		Languages: []string{"en", "rus"},
+	}))
+
+	invalid := []types.Film{
+		{Runtime: -1},
+		{Runtime: 20000},
+		{Status: "filming"},
+		{Budget: &types.Money{Amount: -5, Currency: "USD"}},
+		{BoxOffice: &types.Money{Amount: 5, Currency: "usd"}},
+		{Countries: []string{"USA"}},
+		{Languages: []string{"English"}},
+	}
+	for _, film := range invalid {
+		assert.Error(t, filmapi.ValidateDetails(film), film)
+	}
+}
+
+func TestGetFilmHandler_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title, (.+) FROM films WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}).
+			AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 2, "films/1/poster/ab", "Фильм 1", 136, "released", 63000000, "USD", nil, ""))
+	mock.ExpectQuery("SELECT fa.actor_id FROM film_actors fa").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"actor_id"}).AddRow(3).AddRow(4))
+	mock.ExpectQuery("SELECT id, film_id, type, provider, url, language, duration FROM film_media").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "film_id", "type", "provider", "url", "language", "duration"}).
+			AddRow(5, 1, "trailer", "youtube", "https://youtu.be/abc", "en", 120))
+	mock.ExpectQuery("SELECT title, region, type FROM film_alt_titles WHERE film_id = \\$1 ORDER BY id").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"title", "region", "type"}).AddRow("Working Title", "", "working"))
+	mock.ExpectQuery("SELECT collection_id, name, position, prev_id, prev_title, next_id, next_title FROM").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"collection_id", "name", "position", "prev_id", "prev_title", "next_id", "next_title"}).
+			AddRow(2, "Trilogy", 1, nil, nil, 6, "Film 2"))
+	mock.ExpectQuery("SELECT id, film_id, country, to_char\\(release_date, 'YYYY-MM-DD'\\), type, certification FROM film_releases WHERE film_id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "film_id", "country", "release_date", "type", "certification"}).
+			AddRow(8, 1, "RU", "2022-01-20", "theatrical", "16+"))
+	mock.ExpectQuery("SELECT country FROM film_countries WHERE film_id = \\$1 ORDER BY country").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"country"}).AddRow("AU").AddRow("US"))
+	mock.ExpectQuery("SELECT language FROM film_languages WHERE film_id = \\$1 ORDER BY language").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"language"}).AddRow("en"))
+
+	rr := httptest.NewRecorder()
+	filmapi.GetFilmHandler(rr, httptest.NewRequest("GET", "/film/1", nil), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var film types.Film
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &film))
+	assert.Equal(t, []int{3, 4}, film.Actors)
+	assert.Equal(t, "/images/films/1/poster/ab/small.jpg", film.Poster.Small)
+	assert.Len(t, film.Media, 1)
+	assert.Equal(t, "trailer", film.Media[0].Type)
+	assert.Equal(t, "Фильм 1", film.OriginalTitle)
+	assert.Equal(t, []types.AltTitle{{Title: "Working Title", Region: "", Type: "working"}}, film.AltTitles)
+	assert.Equal(t, []types.FilmRelease{{ID: 8, FilmID: 1, Country: "RU", ReleaseDate: "2022-01-20", Type: "theatrical", Certification: "16+"}}, film.Releases)
+	assert.Equal(t, []types.SeriesEntry{{CollectionID: 2, CollectionName: "Trilogy", Position: 1, Next: &types.FilmRef{ID: 6, Title: "Film 2"}}}, film.Series)
+	assert.Equal(t, 136, film.Runtime)
+	assert.Equal(t, "released", film.Status)
+	assert.Equal(t, &types.Money{Amount: 63000000, Currency: "USD"}, film.Budget)
+	assert.Nil(t, film.BoxOffice)
+	assert.Equal(t, []string{"AU", "US"}, film.Countries)
+	assert.Equal(t, []string{"en"}, film.Languages)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFilmHandler_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("FROM films WHERE id = \\$1").WithArgs(9).WillReturnError(sql.ErrNoRows)
+
+	rr := httptest.NewRecorder()
+	filmapi.GetFilmHandler(rr, httptest.NewRequest("GET", "/film/9", nil), orm.NewORM(db))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	filmapi.GetFilmHandler(rr, httptest.NewRequest("GET", "/film/abc", nil), orm.NewORM(db))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
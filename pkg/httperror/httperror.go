@@ -0,0 +1,43 @@
+// pkg/httperror/httperror.go
+package httperror
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+)
+
+// response status for the errors of the orm package, 500 for everything else.
+// A package of its own because the handlers name their *orm.ORM parameter orm and can not see the package
+func Status(err error) int {
+	switch {
+	case errors.Is(err, orm.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, orm.ErrConflict), errors.Is(err, orm.ErrIdentityLink):
+		return http.StatusConflict
+	case errors.Is(err, orm.ErrBadToken), errors.Is(err, orm.ErrBadParent), errors.Is(err, orm.ErrBadOrder):
+		return http.StatusBadRequest
+	case errors.Is(err, orm.ErrIdentityEmail):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// If-Match: "3" (or W/"3") -> 3; missing header -> 0, which disables the version check.
+// Update and revert of films and actors take the expected version from it
+func VersionFromRequest(r *http.Request) (int, error) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, nil
+	}
+	ifMatch = strings.TrimPrefix(ifMatch, "W/")
+	version, err := strconv.Atoi(strings.Trim(ifMatch, `"`))
+	if err != nil || version <= 0 {
+		return 0, errors.New("invalid If-Match header")
+	}
+	return version, nil
+}
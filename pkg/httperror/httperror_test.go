@@ -0,0 +1,44 @@
+package httperror_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+)
+
+func TestStatus(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, httperror.Status(orm.ErrNotFound))
+	assert.Equal(t, http.StatusNotFound, httperror.Status(fmt.Errorf("film 3: %w", orm.ErrNotFound)))
+	assert.Equal(t, http.StatusConflict, httperror.Status(orm.ErrConflict))
+	assert.Equal(t, http.StatusConflict, httperror.Status(orm.ErrIdentityLink))
+	assert.Equal(t, http.StatusBadRequest, httperror.Status(orm.ErrBadToken))
+	assert.Equal(t, http.StatusBadRequest, httperror.Status(orm.ErrBadParent))
+	assert.Equal(t, http.StatusBadRequest, httperror.Status(orm.ErrBadOrder))
+	assert.Equal(t, http.StatusForbidden, httperror.Status(orm.ErrIdentityEmail))
+	assert.Equal(t, http.StatusInternalServerError, httperror.Status(errors.New("connection refused")))
+}
+
+func TestVersionFromRequest(t *testing.T) {
+	for header, expected := range map[string]int{"": 0, "*": 0, `"3"`: 3, `W/"3"`: 3, "12": 12} {
+		r := httptest.NewRequest(http.MethodPatch, "/film", nil)
+		if header != "" {
+			r.Header.Set("If-Match", header)
+		}
+		version, err := httperror.VersionFromRequest(r)
+		assert.NoError(t, err, header)
+		assert.Equal(t, expected, version, header)
+	}
+	for _, header := range []string{`"abc"`, `"0"`, `"-1"`} {
+		r := httptest.NewRequest(http.MethodPatch, "/film", nil)
+		r.Header.Set("If-Match", header)
+		_, err := httperror.VersionFromRequest(r)
+		assert.Error(t, err, header)
+	}
+}
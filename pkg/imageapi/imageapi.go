@@ -0,0 +1,215 @@
+// pkg/imageapi/imageapi.go
+package imageapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/storage"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// utility
+// prefix + {id}/{name}, e.g. /film/1/poster
+func ParseImagePath(path, prefix, name string) (int, bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, prefix), "/"), "/")
+	if len(parts) != 2 || parts[1] != name {
+		return 0, false
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// post method
+// url like /film/{id}/poster, multipart/form-data with the image in the "file" field
+func UploadFilmPosterHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, store storage.Storage) {
+	filmID, ok := ParseImagePath(r.URL.Path, "/film/", "poster")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	upload(w, r, store, fmt.Sprintf("films/%d/poster", filmID), orm.WithAudit(auditapi.MetaFromRequest(r)).SetFilmPoster, filmID)
+}
+
+// delete method
+// url like /film/{id}/poster
+func DeleteFilmPosterHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, store storage.Storage) {
+	filmID, ok := ParseImagePath(r.URL.Path, "/film/", "poster")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	remove(w, store, orm.WithAudit(auditapi.MetaFromRequest(r)).SetFilmPoster, filmID)
+}
+
+// post method
+// url like /actor/{id}/photo, multipart/form-data with the image in the "file" field
+func UploadActorPhotoHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, store storage.Storage) {
+	actorID, ok := ParseImagePath(r.URL.Path, "/actor/", "photo")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	upload(w, r, store, fmt.Sprintf("actors/%d/photo", actorID), orm.WithAudit(auditapi.MetaFromRequest(r)).SetActorPhoto, actorID)
+}
+
+// delete method
+// url like /actor/{id}/photo
+func DeleteActorPhotoHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, store storage.Storage) {
+	actorID, ok := ParseImagePath(r.URL.Path, "/actor/", "photo")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	remove(w, store, orm.WithAudit(auditapi.MetaFromRequest(r)).SetActorPhoto, actorID)
+}
+
+// get method
+// url like /images/films/1/poster/{hash}/small.jpg, public. Keys contain the hash of the content,
+// so a key never changes its content and can be cached forever
+func ServeImageHandler(w http.ResponseWriter, r *http.Request, store storage.Storage) {
+	key := strings.TrimPrefix(r.URL.Path, "/images/")
+	object, err := store.Open(key)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrBadKey) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer object.Close()
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// original has no extension, ServeContent sniffs its type
+	http.ServeContent(w, r, key, object.ModTime, object)
+}
+
+// utility: stores original and thumbnails under a new key, then points the row to it and drops the old files
+func upload(w http.ResponseWriter, r *http.Request, store storage.Storage, prefix string, set func(int, string) (string, error), id int) {
+	data, err := readUpload(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+	img, err := Decode(data)
+	if err != nil {
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+	thumbnails, err := Thumbnails(img)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// every upload gets a prefix of its own, even for the same content, so that cleaning up a failed upload
+	// can never delete the files the row points to
+	hash := sha256.Sum256(data)
+	nonce := make([]byte, 4)
+	if _, err := rand.Read(nonce); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	key := prefix + "/" + hex.EncodeToString(hash[:8]) + hex.EncodeToString(nonce)
+	thumbnails["original"] = data
+	for name, content := range thumbnails {
+		if err := store.Put(key+"/"+name, content); err != nil {
+			store.DeletePrefix(key)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	oldKey, err := set(id, key)
+	if err != nil {
+		store.DeletePrefix(key)
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+	if oldKey != "" {
+		deleteFiles(store, oldKey)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types.NewImageURLs(key))
+}
+
+// utility
+func remove(w http.ResponseWriter, store storage.Storage, set func(int, string) (string, error), id int) {
+	oldKey, err := set(id, "")
+	if err != nil {
+		http.Error(w, err.Error(), uploadErrorStatus(err))
+		return
+	}
+	if oldKey != "" {
+		deleteFiles(store, oldKey)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// utility: the row no longer points to the files, failing to delete them leaves garbage but breaks nothing
+func deleteFiles(store storage.Storage, key string) {
+	if err := store.DeletePrefix(key); err != nil {
+		log.Println("Error deleting image files:", key, err)
+	}
+}
+
+var errTooLarge = fmt.Errorf("image is larger than %d bytes", MaxUploadSize)
+var errNoFile = errors.New("multipart form with \"file\" field is required")
+
+// utility
+func readUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	// room for the multipart headers
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize+64<<10)
+	file, _, err := r.FormFile("file")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return nil, errTooLarge
+	}
+	if err != nil {
+		return nil, errNoFile
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxUploadSize+1))
+	if err != nil {
+		return nil, errNoFile
+	}
+	if len(data) > MaxUploadSize {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
+
+// utility: the errors of the upload, the rest are the errors of the orm
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errNoFile):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrBadImage), errors.Is(err, ErrTooManyPixels):
+		return http.StatusUnprocessableEntity
+	default:
+		return httperror.Status(err)
+	}
+}
@@ -0,0 +1,245 @@
+package imageapi_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/imageapi"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/storage"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+func multipartRequest(t *testing.T, method, url string, content []byte) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "poster.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req := httptest.NewRequest(method, url, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestParseImagePath(t *testing.T) {
+	id, ok := imageapi.ParseImagePath("/film/12/poster", "/film/", "poster")
+	assert.True(t, ok)
+	assert.Equal(t, 12, id)
+
+	for _, path := range []string{"/film/poster", "/film/0/poster", "/film/x/poster", "/film/1/photo", "/film/1/poster/2"} {
+		_, ok := imageapi.ParseImagePath(path, "/film/", "poster")
+		assert.False(t, ok, path)
+	}
+}
+
+func TestUploadFilmPosterHandler_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := storage.NewLocal(t.TempDir())
+	assert.NoError(t, store.Put("films/1/poster/old/original", []byte("old")))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT poster_key FROM films WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"poster_key"}).AddRow("films/1/poster/old"))
+	mock.ExpectExec("UPDATE films SET poster_key = \\$1 WHERE id = \\$2").
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	req := multipartRequest(t, http.MethodPost, "/film/1/poster", encodePNG(t, image.NewRGBA(image.Rect(0, 0, 400, 600))))
+	rr := httptest.NewRecorder()
+	imageapi.UploadFilmPosterHandler(rr, req, orm.NewORM(db), store)
+
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var urls types.ImageURLs
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &urls))
+	assert.Regexp(t, "^/images/films/1/poster/[0-9a-f]{24}/small.jpg$", urls.Small)
+
+	for _, url := range []string{urls.Original, urls.Small, urls.Medium, urls.Large} {
+		object, err := store.Open(url[len("/images/"):])
+		assert.NoError(t, err, url)
+		if object != nil {
+			object.Close()
+		}
+	}
+	_, err = store.Open("films/1/poster/old/original")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUploadFilmPosterHandler_FilmNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	store := storage.NewLocal(dir)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT poster_key FROM films").
+		WithArgs(7).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	req := multipartRequest(t, http.MethodPost, "/film/7/poster", encodePNG(t, image.NewRGBA(image.Rect(0, 0, 10, 10))))
+	rr := httptest.NewRecorder()
+	imageapi.UploadFilmPosterHandler(rr, req, orm.NewORM(db), store)
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	// uploaded files are cleaned up
+	entries, _ := os.ReadDir(filepath.Join(dir, "films", "7", "poster"))
+	assert.Empty(t, entries)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUploadFilmPosterHandler_SameContentFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := storage.NewLocal(t.TempDir())
+	content := encodePNG(t, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+
+	upload := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		imageapi.UploadFilmPosterHandler(rr, multipartRequest(t, http.MethodPost, "/film/1/poster", content), orm.NewORM(db), store)
+		return rr
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT poster_key FROM films").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"poster_key"}).AddRow(""))
+	mock.ExpectExec("UPDATE films SET poster_key").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	rr := upload()
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var urls types.ImageURLs
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &urls))
+
+	// the same file again, and the database fails: the stored poster stays
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT poster_key FROM films").WithArgs(1).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	rr = upload()
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+
+	object, err := store.Open(urls.Original[len("/images/"):])
+	if assert.NoError(t, err) {
+		object.Close()
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUploadActorPhotoHandler_BadInput(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	store := storage.NewLocal(t.TempDir())
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"not an image", multipartRequest(t, http.MethodPost, "/actor/1/photo", []byte("plain text")), http.StatusUnsupportedMediaType},
+		{"broken png", multipartRequest(t, http.MethodPost, "/actor/1/photo", encodePNG(t, image.NewRGBA(image.Rect(0, 0, 10, 10)))[:40]), http.StatusUnprocessableEntity},
+		{"too large", multipartRequest(t, http.MethodPost, "/actor/1/photo", make([]byte, imageapi.MaxUploadSize+1)), http.StatusRequestEntityTooLarge},
+		{"no file", httptest.NewRequest(http.MethodPost, "/actor/1/photo", nil), http.StatusBadRequest},
+		{"bad path", multipartRequest(t, http.MethodPost, "/actor/x/photo", []byte("plain text")), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		imageapi.UploadActorPhotoHandler(rr, tt.req, orm.NewORM(db), store)
+		assert.Equal(t, tt.status, rr.Code, tt.name)
+	}
+}
+
+func TestDeleteActorPhotoHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	store := storage.NewLocal(t.TempDir())
+	assert.NoError(t, store.Put("actors/2/photo/ab/original", []byte("photo")))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT photo_key FROM actors WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"photo_key"}).AddRow("actors/2/photo/ab"))
+	mock.ExpectExec("UPDATE actors SET photo_key = \\$1 WHERE id = \\$2").
+		WithArgs("", 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+	imageapi.DeleteActorPhotoHandler(rr, httptest.NewRequest(http.MethodDelete, "/actor/2/photo", nil), orm.NewORM(db), store)
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	_, err = store.Open("actors/2/photo/ab/original")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestServeImageHandler(t *testing.T) {
+	store := storage.NewLocal(t.TempDir())
+	original := encodePNG(t, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+	assert.NoError(t, store.Put("films/1/poster/ab/original", original))
+
+	rr := httptest.NewRecorder()
+	imageapi.ServeImageHandler(rr, httptest.NewRequest(http.MethodGet, "/images/films/1/poster/ab/original", nil), store)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "image/png", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=31536000, immutable", rr.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, rr.Header().Get("Last-Modified"))
+	assert.Equal(t, original, rr.Body.Bytes())
+
+	for _, path := range []string{"/images/films/1/poster/ab/small.jpg", "/images/../secret", "/images/"} {
+		req := httptest.NewRequest(http.MethodGet, "/images/x", nil)
+		req.URL.Path = path
+		rr = httptest.NewRecorder()
+		imageapi.ServeImageHandler(rr, req, store)
+		assert.Equal(t, http.StatusNotFound, rr.Code, path)
+	}
+}
@@ -0,0 +1,118 @@
+// pkg/imageapi/resize.go
+package imageapi
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+const (
+	MaxUploadSize = 10 << 20
+	// limits memory used for decoding, 25 Mpx is 100MB as RGBA
+	MaxDimension = 8000
+	MaxPixels    = 25000000
+	jpegQuality  = 85
+)
+
+// thumbnail names and widths, height keeps the aspect ratio. Names must match types.NewImageURLs
+var Sizes = []struct {
+	Name  string
+	Width int
+}{
+	{"small", 92},
+	{"medium", 342},
+	{"large", 780},
+}
+
+var allowedTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+var ErrUnsupportedType = errors.New("only jpeg and png images are accepted")
+var ErrBadImage = errors.New("cannot decode image")
+var ErrTooManyPixels = errors.New("image dimensions are too large")
+
+// type is sniffed from the content, the client supplied Content-Type is not trusted
+func Decode(data []byte) (image.Image, error) {
+	if !allowedTypes[http.DetectContentType(data)] {
+		return nil, ErrUnsupportedType
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrBadImage
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension || config.Width*config.Height > MaxPixels {
+		return nil, ErrTooManyPixels
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrBadImage
+	}
+	return img, nil
+}
+
+// jpeg thumbnail of every size by name, "small.jpg" etc. Images narrower than a size are not upscaled
+func Thumbnails(img image.Image) (map[string][]byte, error) {
+	flat := flatten(img)
+	thumbnails := make(map[string][]byte, len(Sizes))
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, downscale(flat, size.Width), &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+		thumbnails[size.Name+".jpg"] = buf.Bytes()
+	}
+	return thumbnails, nil
+}
+
+// utility: RGBA copy of img on white background, jpeg has no transparency
+func flatten(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+	return flat
+}
+
+// utility: area average, every destination pixel is the mean of the source pixels it covers
+func downscale(src *image.RGBA, width int) *image.RGBA {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	if width >= srcWidth {
+		return src
+	}
+	height := srcHeight * width / srcWidth
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, (y+1)*srcHeight/height
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, (x+1)*srcWidth/width
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					sum[0] += int(src.Pix[i])
+					sum[1] += int(src.Pix[i+1])
+					sum[2] += int(src.Pix[i+2])
+					sum[3] += int(src.Pix[i+3])
+					i += 4
+				}
+			}
+			count := (x1 - x0) * (y1 - y0)
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8(sum[c] / count)
+			}
+		}
+	}
+	return dst
+}
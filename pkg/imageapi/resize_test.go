@@ -0,0 +1,90 @@
+package imageapi_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/imageapi"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	data := encodePNG(t, image.NewRGBA(image.Rect(0, 0, 10, 20)))
+
+	img, err := imageapi.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, img.Bounds().Dx())
+
+	_, err = imageapi.Decode([]byte("GIF89a not really"))
+	assert.ErrorIs(t, err, imageapi.ErrUnsupportedType)
+
+	_, err = imageapi.Decode([]byte("<html><body>hello</body></html>"))
+	assert.ErrorIs(t, err, imageapi.ErrUnsupportedType)
+
+	_, err = imageapi.Decode(data[:40])
+	assert.ErrorIs(t, err, imageapi.ErrBadImage)
+}
+
+func TestDecode_TooManyPixels(t *testing.T) {
+	// only the header is read, the pixels are never decoded
+	data := encodePNG(t, image.NewGray(image.Rect(0, 0, imageapi.MaxDimension+1, 1)))
+
+	_, err := imageapi.Decode(data)
+	assert.ErrorIs(t, err, imageapi.ErrTooManyPixels)
+}
+
+func TestThumbnails(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 1500))
+	for y := 0; y < 1500; y++ {
+		for x := 0; x < 1000; x++ {
+			img.Set(x, y, color.RGBA{200, 0, 0, 255})
+		}
+	}
+
+	thumbnails, err := imageapi.Thumbnails(img)
+	assert.NoError(t, err)
+	assert.Len(t, thumbnails, len(imageapi.Sizes))
+
+	for _, size := range imageapi.Sizes {
+		thumbnail, err := jpeg.Decode(bytes.NewReader(thumbnails[size.Name+".jpg"]))
+		assert.NoError(t, err)
+		assert.Equal(t, size.Width, thumbnail.Bounds().Dx(), size.Name)
+		assert.Equal(t, size.Width*3/2, thumbnail.Bounds().Dy(), size.Name)
+
+		r, g, b, _ := thumbnail.At(size.Width/2, size.Width/2).RGBA()
+		assert.InDelta(t, 200, r>>8, 8)
+		assert.InDelta(t, 0, g>>8, 8)
+		assert.InDelta(t, 0, b>>8, 8)
+	}
+}
+
+func TestThumbnails_NoUpscaleAndWhiteBackground(t *testing.T) {
+	// fully transparent
+	img := image.NewNRGBA(image.Rect(0, 0, 50, 80))
+
+	thumbnails, err := imageapi.Thumbnails(img)
+	assert.NoError(t, err)
+
+	large, err := jpeg.Decode(bytes.NewReader(thumbnails["large.jpg"]))
+	assert.NoError(t, err)
+	assert.Equal(t, 50, large.Bounds().Dx())
+	assert.Equal(t, 80, large.Bounds().Dy())
+
+	r, g, b, _ := large.At(25, 40).RGBA()
+	assert.InDelta(t, 255, r>>8, 2)
+	assert.InDelta(t, 255, g>>8, 2)
+	assert.InDelta(t, 255, b>>8, 2)
+}
@@ -0,0 +1,11 @@
+// pkg/isocode/isocode.go
+package isocode
+
+import "regexp"
+
+// ISO 3166-1 alpha-2, "US", "RU". Films, releases and actors all check their countries against it
+var Country = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// ISO 639 language, optionally with the ISO 3166-1 country, "en", "ru", "pt-BR".
+// The language of film media and the locale of translations
+var Locale = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z]{2})?$`)
@@ -0,0 +1,27 @@
+package isocode_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/isocode"
+)
+
+func TestCountry(t *testing.T) {
+	for _, code := range []string{"US", "RU", "DE"} {
+		assert.True(t, isocode.Country.MatchString(code), code)
+	}
+	for _, code := range []string{"", "us", "USA", "U", "U1", " US"} {
+		assert.False(t, isocode.Country.MatchString(code), code)
+	}
+}
+
+func TestLocale(t *testing.T) {
+	for _, code := range []string{"en", "rus", "pt-BR"} {
+		assert.True(t, isocode.Locale.MatchString(code), code)
+	}
+	for _, code := range []string{"", "EN", "pt-br", "pt_BR", "english", "en-US-x"} {
+		assert.False(t, isocode.Locale.MatchString(code), code)
+	}
+}
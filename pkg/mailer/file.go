@@ -0,0 +1,60 @@
+// pkg/mailer/file.go
+package mailer
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// every message is saved as a separate .eml file under dir, to be opened by a mail client
+type File struct {
+	dir  string
+	from string
+
+	mu   sync.Mutex
+	sent int
+}
+
+func NewFile(dir, from string) *File {
+	return &File{dir: dir, from: from}
+}
+
+func (f *File) Send(msg Message) error {
+	now := time.Now()
+	data, err := Format(f.from, msg, now)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return err
+	}
+
+	// the counter keeps names unique when several messages are sent within the same nanosecond
+	f.mu.Lock()
+	f.sent++
+	name := strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.Itoa(f.sent) + ".eml"
+	f.mu.Unlock()
+	return os.WriteFile(filepath.Join(f.dir, name), data, 0644)
+}
+
+// every message is written to the standard logger, nothing is sent
+type Log struct {
+	from string
+}
+
+func NewLog(from string) *Log {
+	return &Log{from: from}
+}
+
+func (l *Log) Send(msg Message) error {
+	data, err := Format(l.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("Mail to %s:\n%s", msg.To, data)
+	return nil
+}
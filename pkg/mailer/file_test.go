@@ -0,0 +1,40 @@
+package mailer_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/mailer"
+)
+
+func TestFile_Send(t *testing.T) {
+	dir := t.TempDir()
+	mail := mailer.NewFile(dir, "noreply@cinema.local")
+
+	assert.NoError(t, mail.Send(mailer.Message{To: "user@example.com", Subject: "Hello", Body: "line 1\nline 2"}))
+	assert.NoError(t, mail.Send(mailer.Message{To: "other@example.com", Subject: "Hello again", Body: "text"}))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	content, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "From: noreply@cinema.local\r\nTo: user@example.com\r\nSubject: Hello\r\n"), string(content))
+	assert.True(t, strings.HasSuffix(string(content), "\r\n\r\nline 1\r\nline 2"), string(content))
+}
+
+func TestFile_SendRejectsHeaderInjection(t *testing.T) {
+	dir := t.TempDir()
+	mail := mailer.NewFile(dir, "noreply@cinema.local")
+
+	err := mail.Send(mailer.Message{To: "user@example.com\r\nBcc: victim@example.com", Subject: "Hello", Body: "text"})
+	assert.ErrorIs(t, err, mailer.ErrBadHeader)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.Empty(t, files)
+}
@@ -0,0 +1,42 @@
+// pkg/mailer/mailer.go
+package mailer
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// outgoing mail (email verification, password reset). SMTP is used in production,
+// File and Log are for local development and tests, where nothing should leave the machine
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// plain text message
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+var ErrBadHeader = errors.New("mail header contains a line break")
+
+// utility: RFC 5322 message with headers, shared by all implementations so that saved and sent mail look the same
+func Format(from string, msg Message, now time.Time) ([]byte, error) {
+	for _, header := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrBadHeader
+		}
+	}
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
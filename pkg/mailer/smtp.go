@@ -0,0 +1,33 @@
+// pkg/mailer/smtp.go
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// sends through an SMTP server, with PLAIN auth when username is set
+type SMTP struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// addr is host:port of the server, from is the sender address
+func NewSMTP(addr, from, username, password string) *SMTP {
+	mailer := &SMTP{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+func (s *SMTP) Send(msg Message) error {
+	data, err := Format(s.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{msg.To}, data)
+}
@@ -0,0 +1,212 @@
+// pkg/mediaapi/mediaapi.go
+package mediaapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/isocode"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// allowlist of video providers: name -> hosts the media url may point to
+var Providers = map[string][]string{
+	"youtube":     {"youtube.com", "www.youtube.com", "m.youtube.com", "youtu.be"},
+	"vimeo":       {"vimeo.com", "www.vimeo.com", "player.vimeo.com"},
+	"dailymotion": {"dailymotion.com", "www.dailymotion.com", "dai.ly"},
+}
+
+var Types = map[string]bool{
+	"trailer": true,
+	"teaser":  true,
+	"clip":    true,
+}
+
+const (
+	maxURLLength = 500
+	// 24h in seconds
+	maxDuration = 86400
+)
+
+// utility
+// /film/{id}/media -> id, 0; /film/{id}/media/{mediaId} -> id, mediaId
+func ParseMediaPath(path string) (filmID int, mediaID int, ok bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/film/"), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "media" {
+		return 0, 0, false
+	}
+	filmID, err := strconv.Atoi(parts[0])
+	if err != nil || filmID <= 0 {
+		return 0, 0, false
+	}
+	if len(parts) == 3 {
+		mediaID, err = strconv.Atoi(parts[2])
+		if err != nil || mediaID <= 0 {
+			return 0, 0, false
+		}
+	}
+	return filmID, mediaID, true
+}
+
+// utility
+// the provider is taken from the url host when empty, otherwise it has to match it
+func Validate(media *types.FilmMedia) error {
+	if !Types[media.Type] {
+		return errors.New("type must be one of trailer, teaser, clip")
+	}
+	if media.Language != "" && !isocode.Locale.MatchString(media.Language) {
+		return errors.New("language must look like en or pt-BR")
+	}
+	if media.Duration < 0 || media.Duration > maxDuration {
+		return errors.New("duration must be between 0 and 86400 seconds")
+	}
+	if len(media.URL) > maxURLLength {
+		return errors.New("url is longer than 500 characters")
+	}
+
+	parsed, err := url.Parse(media.URL)
+	if err != nil || parsed.Scheme != "https" || parsed.User != nil {
+		return errors.New("url must be an https link")
+	}
+	provider := providerByHost(parsed.Hostname())
+	if provider == "" {
+		return errors.New("url host is not an allowed video provider")
+	}
+	if media.Provider != "" && media.Provider != provider {
+		return errors.New("url does not belong to provider " + media.Provider)
+	}
+	media.Provider = provider
+	return nil
+}
+
+// utility
+func providerByHost(host string) string {
+	host = strings.ToLower(host)
+	for provider, hosts := range Providers {
+		for _, allowed := range hosts {
+			if host == allowed {
+				return provider
+			}
+		}
+	}
+	return ""
+}
+
+// get method
+// url like /film/{id}/media or /film/{id}/media/{mediaId}
+func GetFilmMediaHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, mediaID, ok := ParseMediaPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var response interface{}
+	var err error
+	if mediaID == 0 {
+		response, err = orm.GetFilmMedia(filmID)
+	} else {
+		response, err = orm.GetFilmMediaByID(filmID, mediaID)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// post method
+// url like /film/{id}/media, response is {"id": <new media id>}
+func CreateFilmMediaHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, mediaID, ok := ParseMediaPath(r.URL.Path)
+	if !ok || mediaID != 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var media types.FilmMedia
+	if err := json.NewDecoder(r.Body).Decode(&media); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	media.FilmID = filmID
+	if err := Validate(&media); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mediaID, err := orm.WithAudit(auditapi.MetaFromRequest(r)).CreateFilmMedia(media)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{"id": mediaID})
+}
+
+// patch method
+// url like /film/{id}/media/{mediaId}, fields missing in the body keep their values
+func UpdateFilmMediaHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, mediaID, ok := ParseMediaPath(r.URL.Path)
+	if !ok || mediaID == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	media, err := orm.GetFilmMediaByID(filmID, mediaID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	old := *media
+	if err := json.NewDecoder(r.Body).Decode(media); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	media.ID, media.FilmID = mediaID, filmID
+	// a new url may belong to another provider, it is taken from the url again
+	if media.URL != old.URL && media.Provider == old.Provider {
+		media.Provider = ""
+	}
+	if err := Validate(media); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).UpdateFilmMedia(*media)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete method
+// url like /film/{id}/media/{mediaId}
+func DeleteFilmMediaHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, mediaID, ok := ParseMediaPath(r.URL.Path)
+	if !ok || mediaID == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := orm.WithAudit(auditapi.MetaFromRequest(r)).DeleteFilmMedia(filmID, mediaID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
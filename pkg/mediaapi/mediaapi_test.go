@@ -0,0 +1,196 @@
+package mediaapi_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/mediaapi"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+var mediaColumns = []string{"id", "film_id", "type", "provider", "url", "language", "duration"}
+
+func TestParseMediaPath(t *testing.T) {
+	filmID, mediaID, ok := mediaapi.ParseMediaPath("/film/3/media")
+	assert.True(t, ok)
+	assert.Equal(t, 3, filmID)
+	assert.Equal(t, 0, mediaID)
+
+	filmID, mediaID, ok = mediaapi.ParseMediaPath("/film/3/media/12")
+	assert.True(t, ok)
+	assert.Equal(t, 3, filmID)
+	assert.Equal(t, 12, mediaID)
+
+	for _, path := range []string{"/film/3", "/film/x/media", "/film/3/media/0", "/film/3/history", "/film/3/media/1/2"} {
+		_, _, ok := mediaapi.ParseMediaPath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	media := types.FilmMedia{Type: "trailer", URL: "https://www.youtube.com/watch?v=abc", Language: "en", Duration: 150}
+	assert.NoError(t, mediaapi.Validate(&media))
+	assert.Equal(t, "youtube", media.Provider)
+
+	media = types.FilmMedia{Type: "clip", URL: "https://VIMEO.com/123", Provider: "vimeo", Language: "pt-BR"}
+	assert.NoError(t, mediaapi.Validate(&media))
+
+	invalid := []types.FilmMedia{
+		{Type: "movie", URL: "https://youtu.be/abc"},
+		{Type: "trailer", URL: "http://youtu.be/abc"},
+		{Type: "trailer", URL: "https://example.com/trailer.mp4"},
+		{Type: "trailer", URL: "https://youtube.com.evil.com/watch"},
+		{Type: "trailer", URL: "https://user@youtube.com/watch"},
+		{Type: "trailer", URL: "https://youtu.be/abc", Provider: "vimeo"},
+		{Type: "trailer", URL: "https://youtu.be/abc", Language: "english"},
+		{Type: "trailer", URL: "https://youtu.be/abc", Duration: -1},
+	}
+	for _, media := range invalid {
+		assert.Error(t, mediaapi.Validate(&media), media.URL)
+	}
+}
+
+func TestCreateFilmMediaHandler_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO film_media").
+		WithArgs(1, "trailer", "youtube", "https://youtu.be/abc", "en", 120).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	body := []byte(`{"type": "trailer", "url": "https://youtu.be/abc", "language": "en", "duration": 120}`)
+	req := httptest.NewRequest(http.MethodPost, "/film/1/media", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	mediaapi.CreateFilmMediaHandler(rr, req, orm.NewORM(db))
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.JSONEq(t, `{"id": 5}`, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateFilmMediaHandler_FilmNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO film_media").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	body := []byte(`{"type": "teaser", "url": "https://vimeo.com/1"}`)
+	req := httptest.NewRequest(http.MethodPost, "/film/9/media", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	mediaapi.CreateFilmMediaHandler(rr, req, orm.NewORM(db))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateFilmMediaHandler_BadURL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	body := []byte(`{"type": "trailer", "url": "https://example.com/trailer.mp4"}`)
+	req := httptest.NewRequest(http.MethodPost, "/film/1/media", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	mediaapi.CreateFilmMediaHandler(rr, req, orm.NewORM(db))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateFilmMediaHandler_NewProvider(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT m.id, m.film_id, m.type, m.provider, m.url, m.language, m.duration FROM film_media m").
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows(mediaColumns).AddRow(5, 1, "trailer", "youtube", "https://youtu.be/abc", "en", 120))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT m.id, m.film_id, m.type, m.provider, m.url, m.language, m.duration FROM film_media m").
+		WithArgs(5, 1).
+		WillReturnRows(sqlmock.NewRows(mediaColumns).AddRow(5, 1, "trailer", "youtube", "https://youtu.be/abc", "en", 120))
+	mock.ExpectExec("UPDATE film_media SET").
+		WithArgs("trailer", "vimeo", "https://vimeo.com/42", "en", 120, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest(http.MethodPatch, "/film/1/media/5", bytes.NewReader([]byte(`{"url": "https://vimeo.com/42"}`)))
+	rr := httptest.NewRecorder()
+	mediaapi.UpdateFilmMediaHandler(rr, req, orm.NewORM(db))
+
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFilmMediaHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT id, film_id, type, provider, url, language, duration FROM film_media WHERE film_id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(mediaColumns).AddRow(5, 1, "trailer", "youtube", "https://youtu.be/abc", "en", 120))
+
+	rr := httptest.NewRecorder()
+	mediaapi.GetFilmMediaHandler(rr, httptest.NewRequest(http.MethodGet, "/film/1/media", nil), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var media []types.FilmMedia
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &media))
+	assert.Len(t, media, 1)
+	assert.Equal(t, "youtube", media[0].Provider)
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	rr = httptest.NewRecorder()
+	mediaapi.GetFilmMediaHandler(rr, httptest.NewRequest(http.MethodGet, "/film/2/media", nil), orm.NewORM(db))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteFilmMediaHandler_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT m.id").WithArgs(7, 1).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	rr := httptest.NewRecorder()
+	mediaapi.DeleteFilmMediaHandler(rr, httptest.NewRequest(http.MethodDelete, "/film/1/media/7", nil), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,29 @@
+// pkg/metadata/metadata.go
+package metadata
+
+import (
+	"errors"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// external catalog of films (TMDB and alike). Adapters return films normalized to types.Film/types.Actor,
+// so the rest of the code does not depend on the format of a particular provider
+type Provider interface {
+	// name stored in film_external_ids/actor_external_ids, e.g. "tmdb"
+	Name() string
+	// search by title, results have no cast
+	LookupFilm(query string) ([]types.ExternalFilm, error)
+	// full record with cast
+	FetchFilm(externalID string) (types.ExternalFilm, error)
+}
+
+// returned by adapters when the provider has no such record
+var ErrNotFound = errors.New("not found in provider")
+
+// configured providers by name
+type Providers map[string]Provider
+
+func (p Providers) Add(provider Provider) {
+	p[provider.Name()] = provider
+}
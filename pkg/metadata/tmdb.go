@@ -0,0 +1,164 @@
+// pkg/metadata/tmdb.go
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+const TMDBDefaultURL = "https://api.themoviedb.org/3"
+
+// only top billed actors are fetched, every one of them costs a request for the birthday
+const tmdbMaxCast = 15
+
+// adapter for TMDB API v3 (or anything serving the same JSON), authorized with a v4 read access token
+type TMDB struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func NewTMDB(baseURL, token string) *TMDB {
+	return &TMDB{
+		baseURL: baseURL,
+		token:   token,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (t *TMDB) Name() string {
+	return "tmdb"
+}
+
+type tmdbMovie struct {
+	ID            int     `json:"id"`
+	Title         string  `json:"title"`
+	OriginalTitle string  `json:"original_title"`
+	Overview      string  `json:"overview"`
+	ReleaseDate   string  `json:"release_date"`
+	VoteAverage   float64 `json:"vote_average"`
+	Credits       struct {
+		Cast []struct {
+			ID int `json:"id"`
+		} `json:"cast"`
+	} `json:"credits"`
+}
+
+type tmdbPerson struct {
+	ID       int     `json:"id"`
+	Name     string  `json:"name"`
+	Gender   int     `json:"gender"`
+	Birthday *string `json:"birthday"`
+}
+
+func (t *TMDB) LookupFilm(query string) ([]types.ExternalFilm, error) {
+	var response struct {
+		Results []tmdbMovie `json:"results"`
+	}
+	if err := t.get("/search/movie", url.Values{"query": {query}}, &response); err != nil {
+		return nil, err
+	}
+
+	films := make([]types.ExternalFilm, 0, len(response.Results))
+	for _, movie := range response.Results {
+		films = append(films, t.film(movie))
+	}
+	return films, nil
+}
+
+func (t *TMDB) FetchFilm(externalID string) (types.ExternalFilm, error) {
+	if _, err := strconv.Atoi(externalID); err != nil {
+		return types.ExternalFilm{}, ErrNotFound
+	}
+
+	var movie tmdbMovie
+	if err := t.get("/movie/"+externalID, url.Values{"append_to_response": {"credits"}}, &movie); err != nil {
+		return types.ExternalFilm{}, err
+	}
+	film := t.film(movie)
+
+	for i, member := range movie.Credits.Cast {
+		if i == tmdbMaxCast {
+			break
+		}
+		var person tmdbPerson
+		if err := t.get("/person/"+strconv.Itoa(member.ID), nil, &person); err != nil {
+			return types.ExternalFilm{}, err
+		}
+		actor := types.Actor{Name: person.Name, Gender: tmdbGender(person.Gender)}
+		if person.Birthday != nil {
+			actor.Birthdate = *person.Birthday
+		}
+		film.Cast = append(film.Cast, types.ExternalActor{ExternalID: strconv.Itoa(person.ID), Actor: actor})
+	}
+	return film, nil
+}
+
+// utility
+func (t *TMDB) film(movie tmdbMovie) types.ExternalFilm {
+	film := types.ExternalFilm{
+		Provider:   t.Name(),
+		ExternalID: strconv.Itoa(movie.ID),
+		Film: types.Film{
+			Title:       movie.Title,
+			Description: movie.Overview,
+			ReleaseDate: movie.ReleaseDate,
+			// our rating has one decimal place
+			Rating: math.Round(movie.VoteAverage*10) / 10,
+		},
+	}
+	// TMDB repeats the title for films in English
+	if movie.OriginalTitle != movie.Title {
+		film.Film.OriginalTitle = movie.OriginalTitle
+	}
+	return film
+}
+
+// utility: TMDB codes genders as 0 - not set, 1 - female, 2 - male, 3 - non-binary
+func tmdbGender(code int) string {
+	switch code {
+	case 1:
+		return "female"
+	case 2:
+		return "male"
+	case 3:
+		return "non-binary"
+	default:
+		return ""
+	}
+}
+
+// utility: GET and decode, 404 of the provider -> ErrNotFound
+func (t *TMDB) get(path string, query url.Values, result interface{}) error {
+	requestURL := t.baseURL + path
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("tmdb: %s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,84 @@
+package metadata_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/metadata"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// stub of the TMDB endpoints used by the adapter
+func tmdbStub(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/search/movie":
+			assert.Equal(t, "the matrix", r.URL.Query().Get("query"))
+			w.Write([]byte(`{"page": 1, "results": [
+				{"id": 603, "title": "The Matrix", "overview": "Neo", "release_date": "1999-03-30", "vote_average": 8.217},
+				{"id": 604, "title": "The Matrix Reloaded", "overview": "", "release_date": "2003-05-15", "vote_average": 7.0}
+			]}`))
+		case "/movie/603":
+			assert.Equal(t, "credits", r.URL.Query().Get("append_to_response"))
+			w.Write([]byte(`{"id": 603, "title": "The Matrix", "overview": "Neo", "release_date": "1999-03-30", "vote_average": 8.217,
+				"credits": {"cast": [{"id": 6384, "name": "Keanu Reeves"}, {"id": 2975, "name": "Laurence Fishburne"}]}}`))
+		case "/person/6384":
+			w.Write([]byte(`{"id": 6384, "name": "Keanu Reeves", "gender": 2, "birthday": "1964-09-02"}`))
+		case "/person/2975":
+			w.Write([]byte(`{"id": 2975, "name": "Laurence Fishburne", "gender": 0, "birthday": null}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"status_code": 34, "status_message": "The resource you requested could not be found."}`))
+		}
+	}))
+}
+
+func TestTMDB_LookupFilm(t *testing.T) {
+	server := tmdbStub(t)
+	defer server.Close()
+
+	films, err := metadata.NewTMDB(server.URL, "secret").LookupFilm("the matrix")
+	assert.NoError(t, err)
+	assert.Len(t, films, 2)
+	assert.Equal(t, types.ExternalFilm{
+		Provider:   "tmdb",
+		ExternalID: "603",
+		Film:       types.Film{Title: "The Matrix", Description: "Neo", ReleaseDate: "1999-03-30", Rating: 8.2},
+	}, films[0])
+}
+
+func TestTMDB_FetchFilm(t *testing.T) {
+	server := tmdbStub(t)
+	defer server.Close()
+
+	film, err := metadata.NewTMDB(server.URL, "secret").FetchFilm("603")
+	assert.NoError(t, err)
+	assert.Equal(t, "The Matrix", film.Film.Title)
+	assert.Equal(t, []types.ExternalActor{
+		{ExternalID: "6384", Actor: types.Actor{Name: "Keanu Reeves", Gender: "male", Birthdate: "1964-09-02"}},
+		{ExternalID: "2975", Actor: types.Actor{Name: "Laurence Fishburne"}},
+	}, film.Cast)
+}
+
+func TestTMDB_Errors(t *testing.T) {
+	server := tmdbStub(t)
+	defer server.Close()
+
+	_, err := metadata.NewTMDB(server.URL, "secret").FetchFilm("1")
+	assert.ErrorIs(t, err, metadata.ErrNotFound)
+
+	_, err = metadata.NewTMDB(server.URL, "secret").FetchFilm("../search/movie")
+	assert.ErrorIs(t, err, metadata.ErrNotFound)
+
+	_, err = metadata.NewTMDB(server.URL, "wrong").LookupFilm("the matrix")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, metadata.ErrNotFound)
+}
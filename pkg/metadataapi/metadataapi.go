@@ -0,0 +1,128 @@
+// pkg/metadataapi/metadataapi.go
+package metadataapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/metadata"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// get method
+// url like /admin/metadata/search?provider=tmdb&query=matrix
+func SearchHandler(w http.ResponseWriter, r *http.Request, providers metadata.Providers) {
+	queryValues := r.URL.Query()
+	provider, ok := providers[queryValues.Get("provider")]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusBadRequest)
+		return
+	}
+	query := queryValues.Get("query")
+	if query == "" {
+		http.Error(w, "query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	films, err := provider.LookupFilm(query)
+	if err != nil {
+		http.Error(w, err.Error(), providerErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(films)
+}
+
+// get method
+// url like /admin/metadata/preview?provider=tmdb&id=603
+// shows the fetched film and what accepting it would do with every cast member
+func PreviewHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, providers metadata.Providers) {
+	queryValues := r.URL.Query()
+	provider, ok := providers[queryValues.Get("provider")]
+	if !ok {
+		http.Error(w, "Unknown provider", http.StatusBadRequest)
+		return
+	}
+	externalID := queryValues.Get("id")
+	if externalID == "" {
+		http.Error(w, "id parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	external, err := provider.FetchFilm(externalID)
+	if err != nil {
+		http.Error(w, err.Error(), providerErrorStatus(err))
+		return
+	}
+
+	preview := types.ExternalFilmPreview{ExternalFilm: external}
+	preview.ExistingFilmID, err = orm.FindFilmByExternalID(external.Provider, external.ExternalID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	preview.CastMatches, err = orm.MatchCast(external.Provider, external.Cast)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// post method
+// body is the preview (possibly edited by admin), response is {"id": <new film id>}
+func AcceptHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, providers metadata.Providers) {
+	var external types.ExternalFilm
+	err := json.NewDecoder(r.Body).Decode(&external)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := providers[external.Provider]; !ok {
+		http.Error(w, "Unknown provider", http.StatusBadRequest)
+		return
+	}
+	if external.ExternalID == "" || external.Film.Title == "" {
+		http.Error(w, "external_id and film title are required", http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", external.Film.ReleaseDate); err != nil {
+		http.Error(w, "release_date must look like 2006-01-02", http.StatusBadRequest)
+		return
+	}
+
+	filmID, err := orm.WithAudit(auditapi.MetaFromRequest(r)).AcceptExternalFilm(external)
+	if isConflict(err) {
+		http.Error(w, "Film "+external.Provider+"/"+external.ExternalID+" is already accepted", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{"id": filmID})
+}
+
+// utility: orm parameter of the handlers hides the package
+func isConflict(err error) bool {
+	return errors.Is(err, orm.ErrConflict)
+}
+
+// utility
+func providerErrorStatus(err error) int {
+	if errors.Is(err, metadata.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
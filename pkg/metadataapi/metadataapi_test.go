@@ -0,0 +1,159 @@
+package metadataapi_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/metadata"
+	"github.com/vexrina/cinemaLibrary/pkg/metadataapi"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+type fakeProvider struct {
+	films map[string]types.ExternalFilm
+}
+
+func (f *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeProvider) LookupFilm(query string) ([]types.ExternalFilm, error) {
+	var result []types.ExternalFilm
+	for _, film := range f.films {
+		if film.Film.Title == query {
+			result = append(result, film)
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeProvider) FetchFilm(externalID string) (types.ExternalFilm, error) {
+	film, ok := f.films[externalID]
+	if !ok {
+		return film, metadata.ErrNotFound
+	}
+	return film, nil
+}
+
+func fakeProviders() metadata.Providers {
+	providers := metadata.Providers{}
+	providers.Add(&fakeProvider{films: map[string]types.ExternalFilm{
+		"1": {
+			Provider:   "fake",
+			ExternalID: "1",
+			Film:       types.Film{Title: "Film 1", ReleaseDate: "2020-01-01", Rating: 7},
+			Cast:       []types.ExternalActor{{ExternalID: "10", Actor: types.Actor{Name: "John Doe", Birthdate: "1980-05-01"}}},
+		},
+	}})
+	return providers
+}
+
+func TestPreviewHandler_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT film_id FROM film_external_ids").WithArgs("fake", "1").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT a.id FROM actor_external_ids").WithArgs("fake", "10").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT id FROM actors WHERE name = \\$1").WithArgs("John Doe", "1980-05-01").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+
+	previewOrm := orm.NewORM(db)
+	req, err := http.NewRequest("GET", "/admin/metadata/preview?provider=fake&id=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	metadataapi.PreviewHandler(rr, req, previewOrm, fakeProviders())
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var preview types.ExternalFilmPreview
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &preview))
+	assert.Equal(t, "Film 1", preview.Film.Title)
+	assert.Equal(t, 0, preview.ExistingFilmID)
+	assert.Len(t, preview.CastMatches, 1)
+	assert.Equal(t, orm.CastActionMatch, preview.CastMatches[0].Action)
+	assert.Equal(t, 4, preview.CastMatches[0].ActorID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPreviewHandler_Errors(t *testing.T) {
+	tests := map[string]int{
+		"/admin/metadata/preview?provider=unknown&id=1": http.StatusBadRequest,
+		"/admin/metadata/preview?provider=fake":         http.StatusBadRequest,
+		"/admin/metadata/preview?provider=fake&id=2":    http.StatusNotFound,
+	}
+	for url, status := range tests {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		metadataapi.PreviewHandler(rr, req, nil, fakeProviders())
+		assert.Equal(t, status, rr.Code, url)
+	}
+}
+
+func TestSearchHandler_Success(t *testing.T) {
+	req, err := http.NewRequest("GET", "/admin/metadata/search?provider=fake&query=Film+1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	metadataapi.SearchHandler(rr, req, fakeProviders())
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var films []types.ExternalFilm
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &films))
+	assert.Len(t, films, 1)
+}
+
+func TestAcceptHandler_Conflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT film_id FROM film_external_ids").WithArgs("fake", "1").WillReturnRows(sqlmock.NewRows([]string{"film_id"}).AddRow(3))
+	mock.ExpectRollback()
+
+	orm := orm.NewORM(db)
+	body := `{"provider": "fake", "external_id": "1", "film": {"title": "Film 1", "release_date": "2020-01-01"}}`
+	req, err := http.NewRequest("POST", "/admin/metadata/accept", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	metadataapi.AcceptHandler(rr, req, orm, fakeProviders())
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAcceptHandler_BadBody(t *testing.T) {
+	bodies := []string{
+		`{"provider": "other", "external_id": "1", "film": {"title": "Film 1", "release_date": "2020-01-01"}}`,
+		`{"provider": "fake", "external_id": "1", "film": {"title": "", "release_date": "2020-01-01"}}`,
+		`{"provider": "fake", "external_id": "1", "film": {"title": "Film 1", "release_date": "01.01.2020"}}`,
+		`not json`,
+	}
+	for _, body := range bodies {
+		req, err := http.NewRequest("POST", "/admin/metadata/accept", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		metadataapi.AcceptHandler(rr, req, nil, fakeProviders())
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
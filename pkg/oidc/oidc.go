@@ -0,0 +1,344 @@
+// pkg/oidc/oidc.go
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// returned when the id token of the provider can not be trusted
+var ErrInvalidToken = errors.New("invalid id token")
+
+// settings of one provider, Issuer, ClientID and RedirectURL are required
+type Config struct {
+	// part of the urls /user/oidc/{name}/...
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// where the provider sends the browser back with the code, the callback of the API or a frontend page that forwards the query
+	RedirectURL string
+	// default openid, email, profile
+	Scopes []string
+	// claim with the groups of the user, default "groups"
+	GroupsClaim string
+	// members of any of these groups are admins, the others are not. Without them roles are not touched
+	AdminGroups []string
+	// take emails as verified even without the email_verified claim, for providers that do not send it
+	TrustEmail bool
+}
+
+// OpenID Connect provider, the endpoints and keys are discovered from the issuer on first use
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func New(config Config) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// the provider sends the browser back over https, cookies for the callback can be Secure
+func (p *Provider) SecureCallback() bool {
+	return strings.HasPrefix(p.config.RedirectURL, "https://")
+}
+
+// configured providers by name
+type Providers map[string]*Provider
+
+func (p Providers) Add(provider *Provider) {
+	p[provider.Name()] = provider
+}
+
+// utility: random PKCE code verifier, state and nonce are made the same way
+func NewVerifier() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// utility: S256 code challenge of the verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// url of the provider's login page, the provider sends the browser to RedirectURL with code and state
+func (p *Provider) AuthURL(state, nonce, verifier string) (string, error) {
+	d, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// trades the code for an id token and returns the user from it, nonce and verifier are those of AuthURL
+func (p *Provider) Exchange(code, verifier, nonce string) (types.ExternalIdentity, error) {
+	d, err := p.discover()
+	if err != nil {
+		return types.ExternalIdentity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+	response, err := p.client.PostForm(d.TokenEndpoint, form)
+	if err != nil {
+		return types.ExternalIdentity{}, err
+	}
+	defer response.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return types.ExternalIdentity{}, fmt.Errorf("oidc %s: token endpoint answered %d: %w", p.config.Name, response.StatusCode, err)
+	}
+	if response.StatusCode != http.StatusOK || body.IDToken == "" {
+		return types.ExternalIdentity{}, fmt.Errorf("oidc %s: code exchange failed: %s %s", p.config.Name, body.Error, body.ErrorDescription)
+	}
+
+	claims, err := p.verify(body.IDToken, nonce)
+	if err != nil {
+		return types.ExternalIdentity{}, err
+	}
+	return p.identity(claims), nil
+}
+
+// utility: true when the groups make an admin, mapped is false when the provider does not map roles
+func (p *Provider) Admin(groups []string) (admin bool, mapped bool) {
+	if len(p.config.AdminGroups) == 0 {
+		return false, false
+	}
+	for _, group := range groups {
+		for _, adminGroup := range p.config.AdminGroups {
+			if group == adminGroup {
+				return true, true
+			}
+		}
+	}
+	return false, true
+}
+
+// utility: signature, issuer, audience, expiry and nonce of the id token
+func (p *Provider) verify(idToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if issuer, _ := claims["iss"].(string); issuer != p.config.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, issuer)
+	}
+	if !audienceHas(claims["aud"], p.config.ClientID) {
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidToken)
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, fmt.Errorf("%w: no expiry", ErrInvalidToken)
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidToken)
+	}
+	if subject, _ := claims["sub"].(string); subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// utility: aud is a string or a list of strings
+func audienceHas(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, value := range aud {
+			if value == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// utility: the username is preferred_username, else the part of the email before @
+func (p *Provider) identity(claims jwt.MapClaims) types.ExternalIdentity {
+	identity := types.ExternalIdentity{Provider: p.config.Name}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	if p.config.TrustEmail && identity.Email != "" {
+		identity.EmailVerified = true
+	}
+
+	identity.Username, _ = claims["preferred_username"].(string)
+	if identity.Username == "" {
+		identity.Username, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	switch groups := claims[p.config.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	}
+	return identity
+}
+
+// utility: openid configuration of the issuer, kept after the first success
+func (p *Provider) discover() (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d discovery
+	if err := p.get(p.config.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("oidc %s: discovery is for issuer %q", p.config.Name, d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc %s: discovery misses endpoints", p.config.Name)
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// utility: signing key by id. The keys are fetched again for an unknown id, since providers rotate them
+func (p *Provider) key(kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.get(d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	p.keys = keys
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// utility
+func (p *Provider) get(url string, v interface{}) error {
+	response, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc %s: %s answered %d", p.config.Name, url, response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
@@ -0,0 +1,122 @@
+package oidc_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/oidc"
+	"github.com/vexrina/cinemaLibrary/pkg/oidc/oidctest"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+const redirectURL = "http://app.test/user/oidc/corp/callback"
+
+// follows the auth url to the mock provider and returns the code and state it sends back
+func authorize(t *testing.T, authURL string) (code, state string) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(authURL)
+	if !assert.NoError(t, err) {
+		return "", ""
+	}
+	defer response.Body.Close()
+	if !assert.Equal(t, http.StatusFound, response.StatusCode) {
+		return "", ""
+	}
+	location, err := url.Parse(response.Header.Get("Location"))
+	assert.NoError(t, err)
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestChallenge(t *testing.T) {
+	// example of RFC 7636, appendix B
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", oidc.Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+
+	verifier, err := oidc.NewVerifier()
+	assert.NoError(t, err)
+	assert.Len(t, verifier, 43)
+}
+
+func TestProvider_Login(t *testing.T) {
+	server := oidctest.NewServer("cinema", "secret")
+	defer server.Close()
+	config := server.Config("corp", redirectURL)
+	config.AdminGroups = []string{"cinema-admins"}
+	provider := oidc.New(config)
+
+	server.Login(map[string]interface{}{
+		"sub": "u-1", "email": "alice@corp.test", "email_verified": true, "preferred_username": "alice",
+		"groups": []string{"staff", "cinema-admins"},
+	})
+	authURL, err := provider.AuthURL("state-1", "nonce-1", "verifier-verifier-verifier-verifier-1234")
+	assert.NoError(t, err)
+	code, state := authorize(t, authURL)
+	assert.Equal(t, "state-1", state)
+
+	identity, err := provider.Exchange(code, "verifier-verifier-verifier-verifier-1234", "nonce-1")
+	assert.NoError(t, err)
+	assert.Equal(t, types.ExternalIdentity{
+		Provider: "corp", Subject: "u-1", Email: "alice@corp.test", EmailVerified: true, Username: "alice",
+		Groups: []string{"staff", "cinema-admins"},
+	}, identity)
+
+	admin, mapped := provider.Admin(identity.Groups)
+	assert.True(t, admin)
+	assert.True(t, mapped)
+
+	// the code is single use
+	_, err = provider.Exchange(code, "verifier-verifier-verifier-verifier-1234", "nonce-1")
+	assert.Error(t, err)
+}
+
+func TestProvider_ExchangeChecks(t *testing.T) {
+	server := oidctest.NewServer("cinema", "secret")
+	defer server.Close()
+	provider := oidc.New(server.Config("corp", redirectURL))
+	server.Login(map[string]interface{}{"sub": "u-1", "email": "alice@corp.test"})
+
+	// wrong PKCE verifier
+	authURL, _ := provider.AuthURL("state", "nonce", "right-verifier")
+	code, _ := authorize(t, authURL)
+	_, err := provider.Exchange(code, "wrong-verifier", "nonce")
+	assert.Error(t, err)
+
+	// replayed id token of another login
+	authURL, _ = provider.AuthURL("state", "nonce", "right-verifier")
+	code, _ = authorize(t, authURL)
+	_, err = provider.Exchange(code, "right-verifier", "other-nonce")
+	assert.ErrorIs(t, err, oidc.ErrInvalidToken)
+
+	// emails are not verified without the claim
+	authURL, _ = provider.AuthURL("state", "nonce", "right-verifier")
+	code, _ = authorize(t, authURL)
+	identity, err := provider.Exchange(code, "right-verifier", "nonce")
+	assert.NoError(t, err)
+	assert.False(t, identity.EmailVerified)
+	assert.Equal(t, "alice", identity.Username)
+
+	_, mapped := provider.Admin([]string{"cinema-admins"})
+	assert.False(t, mapped)
+}
+
+func TestProvider_WrongClientSecret(t *testing.T) {
+	server := oidctest.NewServer("cinema", "secret")
+	defer server.Close()
+	config := server.Config("corp", redirectURL)
+	config.ClientSecret = "guess"
+	provider := oidc.New(config)
+	server.Login(map[string]interface{}{"sub": "u-1"})
+
+	authURL, _ := provider.AuthURL("state", "nonce", "verifier")
+	code, _ := authorize(t, authURL)
+	_, err := provider.Exchange(code, "verifier", "nonce")
+	assert.Error(t, err)
+}
+
+func TestProvider_UnreachableIssuer(t *testing.T) {
+	provider := oidc.New(oidc.Config{Name: "corp", Issuer: "http://127.0.0.1:1", ClientID: "cinema", RedirectURL: redirectURL})
+	_, err := provider.AuthURL("state", "nonce", "verifier")
+	assert.Error(t, err)
+}
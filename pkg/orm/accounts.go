@@ -0,0 +1,204 @@
+// pkg/orm/accounts.go
+package orm
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// kinds of user_tokens
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+	// password was right, the second factor is still to come
+	TokenLoginChallenge = "login_challenge"
+)
+
+// returned when the token does not exist, was used already or has expired
+var ErrBadToken = errors.New("invalid or expired token")
+
+const userProfileSQL = "SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users"
+
+// *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// utility
+func scanUserProfile(row scanner) (types.UserProfile, error) {
+	var user types.UserProfile
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Admin, &user.Verified, &user.Disabled, &user.Password)
+	if err == sql.ErrNoRows {
+		return types.UserProfile{}, ErrNotFound
+	}
+	return user, err
+}
+
+// endpoint: /user/me
+// get, ErrNotFound when the user was deleted after the token was issued
+func (orm *ORM) GetUserByID(userID int) (types.UserProfile, error) {
+	return scanUserProfile(orm.db.QueryRow(userProfileSQL+" WHERE id = $1", userID))
+}
+
+// patch, empty arguments keep the stored values. ErrConflict when the username or email belongs to another user.
+// A new email has to be verified again, and the tokens mailed to the old one stop working;
+// a new password makes the pending reset tokens stop working
+func (orm *ORM) UpdateUser(userID int, username, email, hashedPassword string) (types.UserProfile, error) {
+	var after types.UserProfile
+	err := orm.withTx(func(tx *sql.Tx) error {
+		before, err := scanUserProfile(tx.QueryRow(userProfileSQL+" WHERE id = $1 FOR UPDATE", userID))
+		if err != nil {
+			return err
+		}
+		after = before
+		if username != "" {
+			after.Username = username
+		}
+		if email != "" {
+			after.Email = email
+		}
+		if hashedPassword != "" {
+			after.Password = hashedPassword
+		}
+		emailChanged := after.Email != before.Email
+		if emailChanged {
+			after.Verified = false
+		}
+
+		if after.Username != before.Username || emailChanged {
+			var count int
+			err = tx.QueryRow("SELECT COUNT(*) FROM users WHERE id <> $1 AND (username = $2 OR email = $3)", userID, after.Username, after.Email).Scan(&count)
+			if err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrConflict
+			}
+		}
+
+		_, err = tx.Exec(
+			"UPDATE users SET username = $1, email = $2, password = $3, verified_at = CASE WHEN $4 THEN NULL ELSE verified_at END WHERE id = $5",
+			after.Username, after.Email, after.Password, emailChanged, userID,
+		)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case emailChanged:
+			_, err = tx.Exec("UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL", userID)
+		case hashedPassword != "":
+			_, err = tx.Exec("UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND kind = $2 AND used_at IS NULL", userID, TokenResetPassword)
+		}
+		if err != nil {
+			return err
+		}
+
+		// password hash never goes to the audit log, only the fact of the change
+		afterData := userAuditData(after)
+		if hashedPassword != "" {
+			afterData["password_changed"] = true
+		}
+		return orm.writeAudit(tx, "update", "user", userID, userAuditData(before), afterData)
+	})
+	if err != nil {
+		return types.UserProfile{}, err
+	}
+	return after, nil
+}
+
+// utility
+func userAuditData(user types.UserProfile) map[string]interface{} {
+	return map[string]interface{}{"username": user.Username, "email": user.Email, "verified": user.Verified}
+}
+
+// endpoint: /user
+// utility, ErrNotFound when there is no user with the email
+func (orm *ORM) GetUserByEmail(email string) (userID int, username string, verified bool, err error) {
+	err = orm.db.QueryRow("SELECT id, username, verified_at IS NOT NULL FROM users WHERE email = $1", email).Scan(&userID, &username, &verified)
+	if err == sql.ErrNoRows {
+		return 0, "", false, ErrNotFound
+	}
+	return userID, username, verified, err
+}
+
+// utility: only the hash of the token is stored, the token itself goes to the user by mail.
+// Expiry is counted by postgres, so that it is compared with NOW() in the same time zone
+func (orm *ORM) CreateUserToken(userID int, kind, tokenHash string, ttl time.Duration) error {
+	_, err := orm.db.Exec(
+		"INSERT INTO user_tokens (user_id, kind, token_hash, expires_at) VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')",
+		userID, kind, tokenHash, int(ttl.Seconds()),
+	)
+	return err
+}
+
+// endpoint: /user/verify
+// post, ErrBadToken for unknown, used or expired tokens
+func (orm *ORM) VerifyEmail(tokenHash string) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		userID, err := useUserToken(tx, TokenVerifyEmail, tokenHash)
+		if err != nil {
+			return err
+		}
+		result, err := tx.Exec("UPDATE users SET verified_at = NOW() WHERE id = $1 AND verified_at IS NULL", userID)
+		if err != nil {
+			return err
+		}
+		// verified by an older token already, nothing changed
+		if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+			return err
+		}
+		return orm.writeAudit(tx, "verify", "user", userID, nil, map[string]interface{}{"verified": true})
+	})
+}
+
+// endpoint: /user/password/reset
+// post, ErrBadToken for unknown, used or expired tokens. The other reset tokens of the user stop working,
+// the email counts as verified, since the token came by mail, and a lockout is lifted
+func (orm *ORM) ResetPassword(tokenHash, hashedPassword string) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		userID, err := useUserToken(tx, TokenResetPassword, tokenHash)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE users SET password = $1, verified_at = COALESCE(verified_at, NOW()) WHERE id = $2", hashedPassword, userID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND kind = $2 AND used_at IS NULL", userID, TokenResetPassword)
+		if err != nil {
+			return err
+		}
+		// the token proves access to the mailbox, so a lockout of the account is lifted
+		_, err = tx.Exec("DELETE FROM login_failures WHERE scope = $1 AND subject = $2", LoginScopeUser, strconv.Itoa(userID))
+		if err != nil {
+			return err
+		}
+		// password hash never goes to the audit log
+		return orm.writeAudit(tx, "reset_password", "user", userID, nil, nil)
+	})
+}
+
+// utility: marks the token as used and returns its user, single use is guaranteed by the row lock of UPDATE
+func useUserToken(tx *sql.Tx, kind, tokenHash string) (int, error) {
+	var userID int
+	err := tx.QueryRow(
+		"UPDATE user_tokens SET used_at = NOW() WHERE token_hash = $1 AND kind = $2 AND used_at IS NULL AND expires_at > NOW() RETURNING user_id",
+		tokenHash, kind,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrBadToken
+	}
+	return userID, err
+}
+
+// the stored hash is replaced with one of the current algorithm after a login. Nothing changes for the user,
+// so it is not audited, and a password changed in the meantime is kept
+func (orm *ORM) RehashPassword(userID int, oldHash, newHash string) error {
+	_, err := orm.db.Exec("UPDATE users SET password = $3 WHERE id = $1 AND password = $2", userID, oldHash, newHash)
+	return err
+}
@@ -0,0 +1,112 @@
+// pkg/orm/actorprofile.go
+package orm
+
+import (
+	"database/sql"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// matches actors by name or by any of their aliases, $1 is the fragment without wildcards; a is the actors row
+const actorMatchSQL = `(a.name LIKE '%' || $1 || '%' OR a.id IN (SELECT actor_id FROM actor_aliases WHERE name LIKE '%' || $1 || '%'))`
+
+// endpoint: /actor/{id}
+// get, actor with the whole profile, ErrNotFound for missing or trashed actors
+func (orm *ORM) GetActorByID(actorID int) (*types.Actor, error) {
+	var actor types.Actor
+	var photoKey string
+	query := `SELECT id, name, gender, to_char(date_of_birth, 'YYYY-MM-DD'), version, photo_key,
+		biography, birthplace, nationality, COALESCE(to_char(date_of_death, 'YYYY-MM-DD'), ''), death_place
+		FROM actors WHERE id = $1 AND deleted_at IS NULL`
+	err := orm.db.QueryRow(query, actorID).
+		Scan(&actor.ID, &actor.Name, &actor.Gender, &actor.Birthdate, &actor.Version, &photoKey,
+			&actor.Biography, &actor.Birthplace, &actor.Nationality, &actor.DeathDate, &actor.DeathPlace)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	actor.Photo = types.NewImageURLs(photoKey)
+
+	actor.Aliases, err = getActorAliases(orm.db, actorID)
+	if err != nil {
+		return nil, err
+	}
+	actor.Links, err = getActorLinks(orm.db, actorID)
+	if err != nil {
+		return nil, err
+	}
+	return &actor, nil
+}
+
+// utility: unknown death date is stored as NULL
+func deathDateArg(deathDate string) interface{} {
+	if deathDate == "" {
+		return nil
+	}
+	return deathDate
+}
+
+// utility
+func getActorAliases(q querier, actorID int) ([]string, error) {
+	rows, err := q.Query("SELECT name FROM actor_aliases WHERE actor_id = $1 ORDER BY id", actorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := []string{}
+	for rows.Next() {
+		var alias string
+		if err := rows.Scan(&alias); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases, rows.Err()
+}
+
+// utility
+func getActorLinks(q querier, actorID int) ([]types.ActorLink, error) {
+	rows, err := q.Query("SELECT site, url FROM actor_links WHERE actor_id = $1 ORDER BY id", actorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []types.ActorLink{}
+	for rows.Next() {
+		var link types.ActorLink
+		if err := rows.Scan(&link.Site, &link.URL); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// utility: the actor gets exactly the given aliases and links, nil leaves the stored ones as they are
+func replaceActorProfileLists(tx *sql.Tx, actor types.Actor) error {
+	if actor.Aliases != nil {
+		if _, err := tx.Exec("DELETE FROM actor_aliases WHERE actor_id = $1", actor.ID); err != nil {
+			return err
+		}
+		for _, alias := range actor.Aliases {
+			if _, err := tx.Exec("INSERT INTO actor_aliases (actor_id, name) VALUES ($1, $2)", actor.ID, alias); err != nil {
+				return err
+			}
+		}
+	}
+	if actor.Links != nil {
+		if _, err := tx.Exec("DELETE FROM actor_links WHERE actor_id = $1", actor.ID); err != nil {
+			return err
+		}
+		for _, link := range actor.Links {
+			if _, err := tx.Exec("INSERT INTO actor_links (actor_id, site, url) VALUES ($1, $2, $3)", actor.ID, link.Site, link.URL); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
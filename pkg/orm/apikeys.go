@@ -0,0 +1,108 @@
+// pkg/orm/apikeys.go
+package orm
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// endpoint: /user/api-keys
+// post, expiry is counted by postgres, so that it is compared with NOW() in the same time zone.
+// Returns the stored key without the secret
+func (orm *ORM) CreateAPIKey(userID int, name, prefix, keyHash string, scopes []string, expiresInDays int) (types.APIKey, error) {
+	key := types.APIKey{Name: name, Prefix: prefix, Scopes: scopes}
+	err := orm.withTx(func(tx *sql.Tx) error {
+		var createdAt, expiresAt time.Time
+		err := tx.QueryRow(
+			"INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, NOW() + $6 * INTERVAL '1 day') RETURNING id, created_at, expires_at",
+			userID, name, prefix, keyHash, pq.Array(scopes), expiresInDays,
+		).Scan(&key.ID, &createdAt, &expiresAt)
+		if err != nil {
+			return err
+		}
+		key.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		key.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+		return orm.writeAudit(tx, "create", "api_key", key.ID, nil, map[string]interface{}{
+			"user_id": userID, "name": name, "prefix": prefix, "scopes": scopes, "expires_at": key.ExpiresAt,
+		})
+	})
+	if err != nil {
+		return types.APIKey{}, err
+	}
+	return key, nil
+}
+
+// get, keys of the user that are not revoked, expired ones included, newest first
+func (orm *ORM) GetAPIKeys(userID int) ([]types.APIKey, error) {
+	rows, err := orm.db.Query(
+		"SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []types.APIKey{}
+	for rows.Next() {
+		var key types.APIKey
+		var createdAt, expiresAt time.Time
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &createdAt, &expiresAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		key.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		key.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+		if lastUsedAt.Valid {
+			key.LastUsedAt = lastUsedAt.Time.UTC().Format(time.RFC3339)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// delete, the row is kept for the audit. ErrNotFound when the user has no such active key
+func (orm *ORM) RevokeAPIKey(userID, keyID int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL", keyID, userID)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(result); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "revoke", "api_key", keyID, nil, map[string]interface{}{"user_id": userID})
+	})
+}
+
+// utility: claims of the key owner, with the scopes of the key, and whether the owner has two-factor authentication.
+// Marks the key as used, at most once a minute, so that a busy key does not write a row on every request.
+// ErrNotFound for unknown, revoked and expired keys and keys of disabled users
+func (orm *ORM) GetAPIKeyClaims(keyHash string) (*types.Claims, bool, error) {
+	claims := &types.Claims{}
+	var twoFactor bool
+	err := orm.db.QueryRow(
+		`WITH key AS (
+			SELECT k.id, k.scopes, k.last_used_at, u.id AS user_id, u.username, u.adminflag,
+			EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = u.id AND t.enabled_at IS NOT NULL) AS two_factor
+			FROM api_keys k JOIN users u ON u.id = k.user_id
+			WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND k.expires_at > NOW() AND u.disabled_at IS NULL
+		), used AS (
+			UPDATE api_keys SET last_used_at = NOW()
+			WHERE id IN (SELECT id FROM key WHERE last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+		)
+		SELECT id, scopes, user_id, username, adminflag, two_factor FROM key`,
+		keyHash,
+	).Scan(&claims.KeyID, pq.Array(&claims.Scopes), &claims.UserID, &claims.Username, &claims.Admin, &twoFactor)
+	if err == sql.ErrNoRows {
+		return nil, false, ErrNotFound
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return claims, twoFactor, nil
+}
@@ -0,0 +1,193 @@
+// pkg/orm/audit.go
+package orm
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// WithAudit returns a copy of ORM which writes an audit_log row for every mutation it makes.
+// Without it mutations are not audited (background jobs, tests).
+func (orm *ORM) WithAudit(meta types.AuditMeta) *ORM {
+	audited := *orm
+	audited.audit = &meta
+	return &audited
+}
+
+// utility: commit when fn succeeds, rollback otherwise
+func (orm *ORM) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := orm.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// utility: writes audit row in the same transaction as the change itself.
+// For updates only changed fields are stored in before/after.
+func (orm *ORM) writeAudit(tx *sql.Tx, action, entityType string, entityID int, before, after interface{}) error {
+	if orm.audit == nil {
+		return nil
+	}
+
+	beforeJSON, afterJSON, err := jsonDiff(before, after)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO audit_log (username, action, entity_type, entity_id, before_data, after_data, request_id, ip, user_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		orm.audit.Username, action, entityType, entityID, beforeJSON, afterJSON, orm.audit.RequestID, orm.audit.IP, orm.authorID(),
+	)
+	return err
+}
+
+// utility: user_id of the audit_log and revision rows, NULL when the author is not a user
+func (orm *ORM) authorID() interface{} {
+	if orm.audit == nil || orm.audit.UserID == 0 {
+		return nil
+	}
+	return orm.audit.UserID
+}
+
+// utility: a user who is not logged in yet (registration, login through a provider) is the author of their own record
+func (orm *ORM) selfAuthored(userID int, username string) *ORM {
+	if orm.audit == nil {
+		return orm
+	}
+	meta := *orm.audit
+	meta.UserID, meta.Username = userID, username
+	return orm.WithAudit(meta)
+}
+
+// utility: nil snapshot -> NULL, both snapshots present -> only differing keys are kept
+func jsonDiff(before, after interface{}) (interface{}, interface{}, error) {
+	beforeMap, err := toJSONMap(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterMap, err := toJSONMap(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if beforeMap != nil && afterMap != nil {
+		for key, value := range beforeMap {
+			if otherValue, ok := afterMap[key]; ok && reflect.DeepEqual(value, otherValue) {
+				delete(beforeMap, key)
+				delete(afterMap, key)
+			}
+		}
+	}
+
+	beforeJSON, err := marshalOrNil(beforeMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterJSON, err := marshalOrNil(afterMap)
+	if err != nil {
+		return nil, nil, err
+	}
+	return beforeJSON, afterJSON, nil
+}
+
+func toJSONMap(value interface{}) (map[string]interface{}, error) {
+	if value == nil || (reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil()) {
+		return nil, nil
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// untyped nil, so that the driver writes NULL
+func marshalOrNil(value map[string]interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+// endpoint: /admin/audit
+// get
+func (orm *ORM) GetAuditLog(filter types.AuditFilter) ([]types.AuditEntry, error) {
+	query := "SELECT id, username, action, entity_type, entity_id, before_data, after_data, request_id, ip, created_at FROM audit_log WHERE 1 = 1"
+	var args []interface{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		query += " AND " + condition + " $" + strconv.Itoa(len(args))
+	}
+
+	if filter.Username != "" {
+		addCondition("username =", filter.Username)
+	}
+	if filter.EntityType != "" {
+		addCondition("entity_type =", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		addCondition("entity_id =", filter.EntityID)
+	}
+	if !filter.From.IsZero() {
+		addCondition("created_at >=", filter.From)
+	}
+	if !filter.To.IsZero() {
+		addCondition("created_at <", filter.To)
+	}
+	args = append(args, filter.Limit)
+	query += " ORDER BY created_at DESC, id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := orm.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAuditEntries(rows)
+}
+
+// utility: rows of id, username, action, entity_type, entity_id, before_data, after_data, request_id, ip, created_at
+func scanAuditEntries(rows *sql.Rows) ([]types.AuditEntry, error) {
+	var entries []types.AuditEntry
+	for rows.Next() {
+		var entry types.AuditEntry
+		var before, after []byte
+		var createdAt time.Time
+		if err := rows.Scan(&entry.ID, &entry.Username, &entry.Action, &entry.EntityType, &entry.EntityID, &before, &after, &entry.RequestID, &entry.IP, &createdAt); err != nil {
+			return nil, err
+		}
+		if before != nil {
+			entry.Before = json.RawMessage(before)
+		}
+		if after != nil {
+			entry.After = json.RawMessage(after)
+		}
+		entry.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
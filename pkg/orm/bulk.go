@@ -0,0 +1,285 @@
+// pkg/orm/bulk.go
+package orm
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/lib/pq"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// endpoint: /admin/import
+// rows are loaded with COPY into temporary tables and moved to films/actors/film_actors by a few INSERT ... SELECT.
+// With dryRun everything is executed and rolled back, so the report shows what would be created.
+func (orm *ORM) ImportFilms(films []types.ImportFilm, dryRun bool) (types.ImportReport, error) {
+	var report types.ImportReport
+	err := orm.withDryRunTx(dryRun, func(tx *sql.Tx) error {
+		var refs []types.Actor
+		for _, film := range films {
+			refs = append(refs, film.Actors...)
+		}
+		if err := orm.importActors(tx, refs, &report); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`CREATE TEMP TABLE import_films (
+			row_no INTEGER NOT NULL,
+			id INTEGER,
+			title VARCHAR(150) NOT NULL,
+			description TEXT,
+			release_date DATE NOT NULL,
+			rating DECIMAL(3,1) NOT NULL) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`CREATE TEMP TABLE import_credits (
+			row_no INTEGER NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			date_of_birth DATE NOT NULL) ON COMMIT DROP`)
+		if err != nil {
+			return err
+		}
+
+		var filmRows, creditRows [][]interface{}
+		for i, film := range films {
+			filmRows = append(filmRows, []interface{}{i, film.Title, film.Description, film.ReleaseDate, film.Rating})
+			for _, actor := range film.Actors {
+				creditRows = append(creditRows, []interface{}{i, actor.Name, actor.Birthdate})
+			}
+		}
+		if err := copyRows(tx, "import_films", []string{"row_no", "title", "description", "release_date", "rating"}, filmRows); err != nil {
+			return err
+		}
+		if err := copyRows(tx, "import_credits", []string{"row_no", "name", "date_of_birth"}, creditRows); err != nil {
+			return err
+		}
+
+		// ids are taken from the films sequence beforehand, so that credits can be linked without RETURNING per row
+		if _, err := tx.Exec("UPDATE import_films SET id = nextval(pg_get_serial_sequence('films', 'id'))"); err != nil {
+			return err
+		}
+		result, err := tx.Exec("INSERT INTO films (id, title, description, release_date, rating) SELECT id, title, description, release_date, rating FROM import_films ORDER BY row_no")
+		if err != nil {
+			return err
+		}
+		report.FilmsCreated, err = result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO film_actors (film_id, actor_id)
+			SELECT DISTINCT f.id, a.id FROM import_credits c
+			JOIN import_films f ON f.row_no = c.row_no
+			JOIN actors a ON a.name = c.name AND a.date_of_birth = c.date_of_birth AND a.deleted_at IS NULL
+			ON CONFLICT DO NOTHING`)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO film_revisions (film_id, revision, snapshot, username, user_id)
+			SELECT f.id, f.version, `+filmSnapshotJSON+`, $1, $2
+			FROM films f JOIN import_films i ON i.id = f.id`, orm.username(), orm.authorID())
+		if err != nil {
+			return err
+		}
+
+		return orm.writeAudit(tx, "import", "film", 0, nil, importCounts(report))
+	})
+	if err != nil {
+		return types.ImportReport{}, err
+	}
+
+	report.DryRun = dryRun
+	return report, nil
+}
+
+// endpoint: /admin/import
+func (orm *ORM) ImportActors(actors []types.Actor, dryRun bool) (types.ImportReport, error) {
+	var report types.ImportReport
+	err := orm.withDryRunTx(dryRun, func(tx *sql.Tx) error {
+		if err := orm.importActors(tx, actors, &report); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "import", "actor", 0, nil, importCounts(report))
+	})
+	if err != nil {
+		return types.ImportReport{}, err
+	}
+
+	report.DryRun = dryRun
+	return report, nil
+}
+
+// utility: creates actors which are not matched by name + birthdate, fills actor counters of the report
+func (orm *ORM) importActors(tx *sql.Tx, actors []types.Actor, report *types.ImportReport) error {
+	if len(actors) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`CREATE TEMP TABLE import_actors (
+		name VARCHAR(100) NOT NULL,
+		gender VARCHAR(10) NOT NULL,
+		date_of_birth DATE NOT NULL) ON COMMIT DROP`)
+	if err != nil {
+		return err
+	}
+
+	var rows [][]interface{}
+	for _, actor := range actors {
+		rows = append(rows, []interface{}{actor.Name, actor.Gender, actor.Birthdate})
+	}
+	if err := copyRows(tx, "import_actors", []string{"name", "gender", "date_of_birth"}, rows); err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(`SELECT COUNT(DISTINCT (i.name, i.date_of_birth)) FROM import_actors i
+		WHERE EXISTS (SELECT 1 FROM actors a WHERE a.name = i.name AND a.date_of_birth = i.date_of_birth AND a.deleted_at IS NULL)`).
+		Scan(&report.ActorsMatched)
+	if err != nil {
+		return err
+	}
+
+	// the same actor may be listed in several rows, first row wins
+	result, err := tx.Exec(`WITH created AS (
+			INSERT INTO actors (name, gender, date_of_birth)
+			SELECT DISTINCT ON (i.name, i.date_of_birth) i.name, i.gender, i.date_of_birth FROM import_actors i
+			WHERE NOT EXISTS (SELECT 1 FROM actors a WHERE a.name = i.name AND a.date_of_birth = i.date_of_birth AND a.deleted_at IS NULL)
+			RETURNING id, name, gender, date_of_birth, version)
+		INSERT INTO actor_revisions (actor_id, revision, snapshot, username, user_id)
+		SELECT a.id, a.version, `+actorSnapshotJSON+`, $1, $2 FROM created a`, orm.username(), orm.authorID())
+	if err != nil {
+		return err
+	}
+	report.ActorsCreated, err = result.RowsAffected()
+	return err
+}
+
+// utility: imports are audited as a single row with counters, entity_id is 0
+func importCounts(report types.ImportReport) map[string]interface{} {
+	return map[string]interface{}{
+		"films_created":  report.FilmsCreated,
+		"actors_created": report.ActorsCreated,
+		"actors_matched": report.ActorsMatched,
+	}
+}
+
+// utility: COPY rows into table within tx
+func copyRows(tx *sql.Tx, table string, columns []string, rows [][]interface{}) error {
+	stmt, err := tx.Prepare(pq.CopyIn(table, columns...))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if _, err := stmt.Exec(row...); err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+	// empty Exec flushes the buffered rows
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return err
+	}
+	return stmt.Close()
+}
+
+// utility: like withTx, but dry run is always rolled back
+func (orm *ORM) withDryRunTx(dryRun bool, fn func(tx *sql.Tx) error) error {
+	tx, err := orm.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if dryRun {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+// rows fetched from the export cursor at once
+const exportBatchSize = 500
+
+// endpoint: /admin/export
+// rows are passed to fn one by one, so the whole table is never held in memory
+func (orm *ORM) ExportFilms(fn func(film types.Film) error) error {
+	query := `SELECT f.id, f.title, f.description, to_char(f.release_date, 'YYYY-MM-DD'), f.rating, f.version,
+		ARRAY(SELECT fa.actor_id FROM film_actors fa JOIN actors a ON a.id = fa.actor_id WHERE fa.film_id = f.id AND a.deleted_at IS NULL ORDER BY fa.actor_id)
+		FROM films f WHERE f.deleted_at IS NULL ORDER BY f.id`
+	return orm.eachCursorRow(query, func(rows *sql.Rows) error {
+		var film types.Film
+		var actorIDs []int64
+		if err := rows.Scan(&film.ID, &film.Title, &film.Description, &film.ReleaseDate, &film.Rating, &film.Version, pq.Array(&actorIDs)); err != nil {
+			return err
+		}
+		film.Actors = make([]int, 0, len(actorIDs))
+		for _, actorID := range actorIDs {
+			film.Actors = append(film.Actors, int(actorID))
+		}
+		return fn(film)
+	})
+}
+
+func (orm *ORM) ExportActors(fn func(actor types.Actor) error) error {
+	query := "SELECT id, name, gender, to_char(date_of_birth, 'YYYY-MM-DD'), version FROM actors WHERE deleted_at IS NULL ORDER BY id"
+	return orm.eachCursorRow(query, func(rows *sql.Rows) error {
+		var actor types.Actor
+		if err := rows.Scan(&actor.ID, &actor.Name, &actor.Gender, &actor.Birthdate, &actor.Version); err != nil {
+			return err
+		}
+		return fn(actor)
+	})
+}
+
+func (orm *ORM) ExportCredits(fn func(credit types.Credit) error) error {
+	query := `SELECT f.id, f.title, a.id, a.name FROM film_actors fa
+		JOIN films f ON f.id = fa.film_id
+		JOIN actors a ON a.id = fa.actor_id
+		WHERE f.deleted_at IS NULL AND a.deleted_at IS NULL ORDER BY f.id, a.id`
+	return orm.eachCursorRow(query, func(rows *sql.Rows) error {
+		var credit types.Credit
+		if err := rows.Scan(&credit.FilmID, &credit.FilmTitle, &credit.ActorID, &credit.ActorName); err != nil {
+			return err
+		}
+		return fn(credit)
+	})
+}
+
+// utility: reads query through a server side cursor, exportBatchSize rows at a time.
+// The transaction only reads, so it is always rolled back
+func (orm *ORM) eachCursorRow(query string, scan func(rows *sql.Rows) error) error {
+	tx, err := orm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DECLARE export_cursor NO SCROLL CURSOR FOR " + query); err != nil {
+		return err
+	}
+	for {
+		rows, err := tx.Query("FETCH FORWARD " + strconv.Itoa(exportBatchSize) + " FROM export_cursor")
+		if err != nil {
+			return err
+		}
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			if err := scan(rows); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if fetched < exportBatchSize {
+			return nil
+		}
+	}
+}
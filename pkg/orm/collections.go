@@ -0,0 +1,395 @@
+// pkg/orm/collections.go
+package orm
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// returned when parent_id points to a missing collection, to the collection itself or to one of its sub-collections
+var ErrBadParent = errors.New("parent collection does not exist or is inside the collection")
+
+// returned by ReorderCollectionFilms when the ids are not exactly the films of the collection
+var ErrBadOrder = errors.New("film ids must list every film of the collection once")
+
+// films of the collection $1 not in trash, in series order
+const collectionFilmsQuery = `SELECT cf.position, f.id, f.title, to_char(f.release_date, 'YYYY-MM-DD')
+	FROM collection_films cf JOIN films f ON f.id = cf.film_id
+	WHERE cf.collection_id = $1 AND f.deleted_at IS NULL ORDER BY cf.position, f.id`
+
+// endpoint: /collection
+// get, all collections without their films, sub-collections go after their parent's siblings
+func (orm *ORM) GetCollections() ([]types.Collection, error) {
+	rows, err := orm.db.Query("SELECT id, name, description, parent_id, position FROM collections ORDER BY parent_id NULLS FIRST, position, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCollections(rows)
+}
+
+// endpoint: /collection/{id}
+// get, the collection with its films and direct sub-collections
+func (orm *ORM) GetCollectionByID(id int) (*types.Collection, error) {
+	collection, err := collectionSnapshot(orm.db, id, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := orm.db.Query(collectionFilmsQuery, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	collection.Films = []types.CollectionFilm{}
+	for rows.Next() {
+		var film types.CollectionFilm
+		if err := rows.Scan(&film.Position, &film.FilmID, &film.Title, &film.ReleaseDate); err != nil {
+			return nil, err
+		}
+		collection.Films = append(collection.Films, film)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	children, err := orm.db.Query("SELECT id, name, description, parent_id, position FROM collections WHERE parent_id = $1 ORDER BY position, id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer children.Close()
+	collection.Collections, err = scanCollections(children)
+	if err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+// endpoint: /collection
+// post
+func (orm *ORM) CreateCollection(collection types.Collection) (int, error) {
+	err := orm.withTx(func(tx *sql.Tx) error {
+		if err := checkParent(tx, 0, collection.ParentID); err != nil {
+			return err
+		}
+		err := tx.QueryRow(
+			"INSERT INTO collections (name, description, parent_id, position) VALUES ($1, $2, $3, $4) RETURNING id",
+			collection.Name, collection.Description, collection.ParentID, collection.Position,
+		).Scan(&collection.ID)
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "create", "collection", collection.ID, nil, collection)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return collection.ID, nil
+}
+
+// endpoint: /collection/{id}
+// patch
+func (orm *ORM) UpdateCollection(collection types.Collection) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		before, err := collectionSnapshot(tx, collection.ID, true)
+		if err != nil {
+			return err
+		}
+		if err := checkParent(tx, collection.ID, collection.ParentID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"UPDATE collections SET name = $1, description = $2, parent_id = $3, position = $4 WHERE id = $5",
+			collection.Name, collection.Description, collection.ParentID, collection.Position, collection.ID,
+		)
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "update", "collection", collection.ID, before, collection)
+	})
+}
+
+// endpoint: /collection/{id}
+// delete, films stay, sub-collections become top-level ones
+func (orm *ORM) DeleteCollection(id int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		before, err := collectionSnapshot(tx, id, true)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM collections WHERE id = $1", id); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "delete", "collection", id, before, nil)
+	})
+}
+
+// endpoint: /collection/{id}/films
+// post, puts the film at position (1-based) moving the following films down; position <= 0 or past the end appends.
+// ErrNotFound when the collection or the film does not exist, ErrConflict when the film is already there
+func (orm *ORM) AddFilmToCollection(collectionID, filmID, position int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		before, err := lockCollectionFilms(tx, collectionID)
+		if err != nil {
+			return err
+		}
+		for _, id := range before {
+			if id == filmID {
+				return ErrConflict
+			}
+		}
+
+		var last int
+		if err := tx.QueryRow("SELECT COALESCE(MAX(position), 0) FROM collection_films WHERE collection_id = $1", collectionID).Scan(&last); err != nil {
+			return err
+		}
+		if position <= 0 || position > last {
+			position = last + 1
+		} else {
+			_, err := tx.Exec("UPDATE collection_films SET position = position + 1 WHERE collection_id = $1 AND position >= $2", collectionID, position)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.QueryRow(
+			`INSERT INTO collection_films (collection_id, film_id, position)
+			SELECT $1, id, $3 FROM films WHERE id = $2 AND deleted_at IS NULL
+			RETURNING film_id`,
+			collectionID, filmID, position,
+		).Scan(&filmID)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return orm.writeCollectionFilmsAudit(tx, collectionID, before)
+	})
+}
+
+// endpoint: /collection/{id}/films
+// put, filmIDs are the films of the collection in the new order. Films in trash keep their order after them
+func (orm *ORM) ReorderCollectionFilms(collectionID int, filmIDs []int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		before, err := lockCollectionFilms(tx, collectionID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query("SELECT cf.film_id FROM collection_films cf JOIN films f ON f.id = cf.film_id WHERE cf.collection_id = $1 AND f.deleted_at IS NOT NULL ORDER BY cf.position", collectionID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		trashed := make(map[int]bool)
+		var order []int
+		for rows.Next() {
+			var filmID int
+			if err := rows.Scan(&filmID); err != nil {
+				return err
+			}
+			trashed[filmID] = true
+			order = append(order, filmID)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		members := make(map[int]bool, len(before))
+		for _, id := range before {
+			if !trashed[id] {
+				members[id] = true
+			}
+		}
+		if len(filmIDs) != len(members) {
+			return ErrBadOrder
+		}
+		for _, id := range filmIDs {
+			if !members[id] {
+				return ErrBadOrder
+			}
+			// a repeated id is not a member the second time
+			delete(members, id)
+		}
+
+		order = append(append([]int{}, filmIDs...), order...)
+		for i, filmID := range order {
+			_, err := tx.Exec("UPDATE collection_films SET position = $1 WHERE collection_id = $2 AND film_id = $3", i+1, collectionID, filmID)
+			if err != nil {
+				return err
+			}
+		}
+		return orm.writeCollectionFilmsAudit(tx, collectionID, before)
+	})
+}
+
+// endpoint: /collection/{id}/films/{filmId}
+// delete, the following films move up
+func (orm *ORM) RemoveFilmFromCollection(collectionID, filmID int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		before, err := lockCollectionFilms(tx, collectionID)
+		if err != nil {
+			return err
+		}
+
+		var position int
+		err = tx.QueryRow("DELETE FROM collection_films WHERE collection_id = $1 AND film_id = $2 RETURNING position", collectionID, filmID).Scan(&position)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE collection_films SET position = position - 1 WHERE collection_id = $1 AND position > $2", collectionID, position)
+		if err != nil {
+			return err
+		}
+		return orm.writeCollectionFilmsAudit(tx, collectionID, before)
+	})
+}
+
+// utility: neighbours of the film in every collection it belongs to, films in trash are skipped
+func getFilmSeries(q querier, filmID int) ([]types.SeriesEntry, error) {
+	rows, err := q.Query(
+		`SELECT collection_id, name, position, prev_id, prev_title, next_id, next_title FROM (
+			SELECT cf.collection_id, c.name, cf.position, cf.film_id,
+				LAG(f.id) OVER w AS prev_id, LAG(f.title) OVER w AS prev_title,
+				LEAD(f.id) OVER w AS next_id, LEAD(f.title) OVER w AS next_title
+			FROM collection_films cf
+			JOIN collections c ON c.id = cf.collection_id
+			JOIN films f ON f.id = cf.film_id
+			WHERE f.deleted_at IS NULL AND cf.collection_id IN (SELECT collection_id FROM collection_films WHERE film_id = $1)
+			WINDOW w AS (PARTITION BY cf.collection_id ORDER BY cf.position, f.id)
+		) series WHERE film_id = $1 ORDER BY collection_id`,
+		filmID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []types.SeriesEntry
+	for rows.Next() {
+		var entry types.SeriesEntry
+		var prevID, nextID sql.NullInt64
+		var prevTitle, nextTitle sql.NullString
+		if err := rows.Scan(&entry.CollectionID, &entry.CollectionName, &entry.Position, &prevID, &prevTitle, &nextID, &nextTitle); err != nil {
+			return nil, err
+		}
+		if prevID.Valid {
+			entry.Previous = &types.FilmRef{ID: int(prevID.Int64), Title: prevTitle.String}
+		}
+		if nextID.Valid {
+			entry.Next = &types.FilmRef{ID: int(nextID.Int64), Title: nextTitle.String}
+		}
+		series = append(series, entry)
+	}
+	return series, rows.Err()
+}
+
+// utility: ErrNotFound when there is no such collection, the row is locked when lock is set
+func collectionSnapshot(q querier, id int, lock bool) (*types.Collection, error) {
+	query := "SELECT id, name, description, parent_id, position FROM collections WHERE id = $1"
+	if lock {
+		query += " FOR UPDATE"
+	}
+	var collection types.Collection
+	var parentID sql.NullInt64
+	err := q.QueryRow(query, id).Scan(&collection.ID, &collection.Name, &collection.Description, &parentID, &collection.Position)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		parent := int(parentID.Int64)
+		collection.ParentID = &parent
+	}
+	return &collection, nil
+}
+
+// utility
+func scanCollections(rows *sql.Rows) ([]types.Collection, error) {
+	collections := []types.Collection{}
+	for rows.Next() {
+		var collection types.Collection
+		var parentID sql.NullInt64
+		if err := rows.Scan(&collection.ID, &collection.Name, &collection.Description, &parentID, &collection.Position); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			parent := int(parentID.Int64)
+			collection.ParentID = &parent
+		}
+		collections = append(collections, collection)
+	}
+	return collections, rows.Err()
+}
+
+// utility: the parent has to exist and must not be the collection id or one of its descendants.
+// id is 0 for a new collection, nil parent is always fine
+func checkParent(tx *sql.Tx, id int, parentID *int) error {
+	if parentID == nil {
+		return nil
+	}
+	var ok bool
+	err := tx.QueryRow(
+		`WITH RECURSIVE ancestors(id, parent_id) AS (
+			SELECT id, parent_id FROM collections WHERE id = $1
+			UNION SELECT c.id, c.parent_id FROM collections c JOIN ancestors a ON c.id = a.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors) AND NOT EXISTS (SELECT 1 FROM ancestors WHERE id = $2)`,
+		*parentID, id,
+	).Scan(&ok)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBadParent
+	}
+	return nil
+}
+
+// utility: locks the collection row, so that positions are changed by one transaction at a time.
+// Returns film ids of the collection in series order, films in trash included
+func lockCollectionFilms(tx *sql.Tx, collectionID int) ([]int, error) {
+	if _, err := collectionSnapshot(tx, collectionID, true); err != nil {
+		return nil, err
+	}
+	return collectionFilmIDs(tx, collectionID)
+}
+
+// utility
+func collectionFilmIDs(q querier, collectionID int) ([]int, error) {
+	rows, err := q.Query("SELECT film_id FROM collection_films WHERE collection_id = $1 ORDER BY position, film_id", collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	filmIDs := []int{}
+	for rows.Next() {
+		var filmID int
+		if err := rows.Scan(&filmID); err != nil {
+			return nil, err
+		}
+		filmIDs = append(filmIDs, filmID)
+	}
+	return filmIDs, rows.Err()
+}
+
+// utility: membership changes are audited as an update of the collection's "films" list
+func (orm *ORM) writeCollectionFilmsAudit(tx *sql.Tx, collectionID int, before []int) error {
+	if orm.audit == nil {
+		return nil
+	}
+	after, err := collectionFilmIDs(tx, collectionID)
+	if err != nil {
+		return err
+	}
+	return orm.writeAudit(tx, "update", "collection", collectionID, map[string][]int{"films": before}, map[string][]int{"films": after})
+}
@@ -0,0 +1,132 @@
+// pkg/orm/external.go
+package orm
+
+import (
+	"database/sql"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// CastMatch.Action values
+const (
+	CastActionMatch  = "match"
+	CastActionCreate = "create"
+	// actor is unknown here and the provider has no birthdate, which is required for actors
+	CastActionSkip = "skip"
+)
+
+// endpoint: /admin/metadata/preview
+// 0 if the external film was not accepted yet
+func (orm *ORM) FindFilmByExternalID(provider, externalID string) (int, error) {
+	return findFilmByExternalID(orm.db, provider, externalID)
+}
+
+// endpoint: /admin/metadata/preview
+func (orm *ORM) MatchCast(provider string, cast []types.ExternalActor) ([]types.CastMatch, error) {
+	matches := make([]types.CastMatch, 0, len(cast))
+	for _, member := range cast {
+		match, err := matchActor(orm.db, provider, member)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// endpoint: /admin/metadata/accept
+// creates the film with its cast (matched actors are reused, missing ones are created) and remembers external ids.
+// ErrConflict if the film was accepted before
+func (orm *ORM) AcceptExternalFilm(external types.ExternalFilm) (int, error) {
+	var filmID int
+	err := orm.withTx(func(tx *sql.Tx) error {
+		existingID, err := findFilmByExternalID(tx, external.Provider, external.ExternalID)
+		if err != nil {
+			return err
+		}
+		if existingID != 0 {
+			return ErrConflict
+		}
+
+		film := external.Film
+		film.Actors = nil
+		linked := make(map[int]bool)
+		for _, member := range external.Cast {
+			match, err := matchActor(tx, external.Provider, member)
+			if err != nil {
+				return err
+			}
+			switch match.Action {
+			case CastActionSkip:
+				continue
+			case CastActionCreate:
+				match.ActorID, err = orm.createActor(tx, member.Actor)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = tx.Exec("INSERT INTO actor_external_ids (actor_id, provider, external_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", match.ActorID, external.Provider, member.ExternalID)
+			if err != nil {
+				return err
+			}
+			if !linked[match.ActorID] {
+				linked[match.ActorID] = true
+				film.Actors = append(film.Actors, match.ActorID)
+			}
+		}
+
+		filmID, err = orm.createFilm(tx, film)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO film_external_ids (film_id, provider, external_id) VALUES ($1, $2, $3)", filmID, external.Provider, external.ExternalID)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return filmID, nil
+}
+
+// utility
+func findFilmByExternalID(q querier, provider, externalID string) (int, error) {
+	var filmID int
+	err := q.QueryRow("SELECT film_id FROM film_external_ids WHERE provider = $1 AND external_id = $2", provider, externalID).Scan(&filmID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return filmID, err
+}
+
+// utility: by external id first, then by name + birthdate like the bulk import does
+func matchActor(q querier, provider string, member types.ExternalActor) (types.CastMatch, error) {
+	match := types.CastMatch{ExternalActor: member}
+
+	err := q.QueryRow(`SELECT a.id FROM actor_external_ids e JOIN actors a ON a.id = e.actor_id
+		WHERE e.provider = $1 AND e.external_id = $2 AND a.deleted_at IS NULL`, provider, member.ExternalID).Scan(&match.ActorID)
+	if err == nil {
+		match.Action = CastActionMatch
+		return match, nil
+	}
+	if err != sql.ErrNoRows {
+		return match, err
+	}
+
+	if member.Actor.Birthdate == "" {
+		match.Action = CastActionSkip
+		return match, nil
+	}
+
+	err = q.QueryRow("SELECT id FROM actors WHERE name = $1 AND date_of_birth = $2 AND deleted_at IS NULL ORDER BY id LIMIT 1", member.Actor.Name, member.Actor.Birthdate).Scan(&match.ActorID)
+	switch err {
+	case nil:
+		match.Action = CastActionMatch
+	case sql.ErrNoRows:
+		match.Action = CastActionCreate
+	default:
+		return match, err
+	}
+	return match, nil
+}
@@ -0,0 +1,135 @@
+// pkg/orm/filminfo.go
+package orm
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// status of films created without one
+const defaultFilmStatus = "released"
+
+// utility
+func filmStatus(status string) string {
+	if status == "" {
+		return defaultFilmStatus
+	}
+	return status
+}
+
+// utility: runtime, status, budget or box office is omitted from the update
+func omitsDetails(film types.Film) bool {
+	return film.Runtime == 0 || film.Status == "" || film.Budget == nil || film.BoxOffice == nil
+}
+
+// utility: on update zero runtime, empty status and nil money keep the stored values,
+// the same way nil countries and languages do
+func keepStoredDetails(film *types.Film, stored *types.Film) {
+	if film.Runtime == 0 {
+		film.Runtime = stored.Runtime
+	}
+	if film.Status == "" {
+		film.Status = stored.Status
+	}
+	if film.Budget == nil {
+		film.Budget = stored.Budget
+	}
+	if film.BoxOffice == nil {
+		film.BoxOffice = stored.BoxOffice
+	}
+}
+
+// utility: unknown runtime is stored as NULL, so that it goes last when sorted
+func runtimeArg(runtime int) interface{} {
+	if runtime == 0 {
+		return nil
+	}
+	return runtime
+}
+
+// utility: amount and currency columns of the money, NULL amount for nil
+func moneyArgs(money *types.Money) (interface{}, string) {
+	if money == nil {
+		return nil, ""
+	}
+	return money.Amount, money.Currency
+}
+
+// utility
+func scanMoney(amount sql.NullInt64, currency string) *types.Money {
+	if !amount.Valid {
+		return nil
+	}
+	return &types.Money{Amount: amount.Int64, Currency: currency}
+}
+
+// utility: film_countries.country or film_languages.language of the film, sorted
+func getFilmCodes(q querier, table, column string, filmID int) ([]string, error) {
+	rows, err := q.Query("SELECT "+column+" FROM "+table+" WHERE film_id = $1 ORDER BY "+column, filmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+// utility: the film gets exactly the given codes, nothing is done for nil
+func replaceFilmCodes(tx *sql.Tx, table, column string, filmID int, codes []string) error {
+	if codes == nil {
+		return nil
+	}
+	if _, err := tx.Exec("DELETE FROM "+table+" WHERE film_id = $1", filmID); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		_, err := tx.Exec("INSERT INTO "+table+" (film_id, "+column+") VALUES ($1, $2) ON CONFLICT DO NOTHING", filmID, code)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// utility: countries and languages of createFilm / updateFilm
+func replaceFilmCountriesAndLanguages(tx *sql.Tx, film types.Film) error {
+	if err := replaceFilmCodes(tx, "film_countries", "country", film.ID, film.Countries); err != nil {
+		return err
+	}
+	return replaceFilmCodes(tx, "film_languages", "language", film.ID, film.Languages)
+}
+
+// utility: the metadata part of filmFilterSQL
+func filmInfoFilterSQL(filter types.FilmFilter, args []interface{}) (string, []interface{}) {
+	var conditions string
+	placeholder := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if filter.Status != "" {
+		conditions += " AND status = " + placeholder(filter.Status)
+	}
+	if filter.ProductionCountry != "" {
+		conditions += " AND EXISTS (SELECT 1 FROM film_countries fc WHERE fc.film_id = films.id AND fc.country = " + placeholder(filter.ProductionCountry) + ")"
+	}
+	if filter.Language != "" {
+		conditions += " AND EXISTS (SELECT 1 FROM film_languages fl WHERE fl.film_id = films.id AND fl.language = " + placeholder(filter.Language) + ")"
+	}
+	if filter.MinRuntime > 0 {
+		conditions += " AND runtime >= " + placeholder(filter.MinRuntime)
+	}
+	if filter.MaxRuntime > 0 {
+		conditions += " AND runtime <= " + placeholder(filter.MaxRuntime)
+	}
+	return conditions, args
+}
@@ -0,0 +1,182 @@
+// pkg/orm/history.go
+package orm
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// snapshots are built by postgres, so the stored json has the same keys as types.Film / types.Actor.
+// f / a are the films / actors rows the snapshot is taken from
+const filmSnapshotJSON = `jsonb_build_object(
+		'id', f.id, 'title', f.title, 'original_title', f.original_title, 'description', f.description,
+		'release_date', to_char(f.release_date, 'YYYY-MM-DD'), 'rating', f.rating, 'version', f.version,
+		'runtime', COALESCE(f.runtime, 0), 'status', f.status,
+		'budget', CASE WHEN f.budget IS NULL THEN NULL ELSE jsonb_build_object('amount', f.budget, 'currency', f.budget_currency) END,
+		'box_office', CASE WHEN f.box_office IS NULL THEN NULL ELSE jsonb_build_object('amount', f.box_office, 'currency', f.box_office_currency) END,
+		'countries', COALESCE((SELECT jsonb_agg(c.country ORDER BY c.country) FROM film_countries c WHERE c.film_id = f.id), '[]'::jsonb),
+		'languages', COALESCE((SELECT jsonb_agg(l.language ORDER BY l.language) FROM film_languages l WHERE l.film_id = f.id), '[]'::jsonb),
+		'actors', COALESCE((SELECT jsonb_agg(fa.actor_id ORDER BY fa.actor_id) FROM film_actors fa WHERE fa.film_id = f.id), '[]'::jsonb),
+		'alternative_titles', COALESCE((SELECT jsonb_agg(jsonb_build_object('title', t.title, 'region', t.region, 'type', t.type) ORDER BY t.id)
+			FROM film_alt_titles t WHERE t.film_id = f.id), '[]'::jsonb)
+	)`
+
+const actorSnapshotJSON = `jsonb_build_object(
+		'id', a.id, 'name', a.name, 'gender', a.gender,
+		'birthdate', to_char(a.date_of_birth, 'YYYY-MM-DD'), 'version', a.version,
+		'biography', a.biography, 'birthplace', a.birthplace, 'nationality', a.nationality,
+		'death_date', COALESCE(to_char(a.date_of_death, 'YYYY-MM-DD'), ''), 'death_place', a.death_place,
+		'aliases', COALESCE((SELECT jsonb_agg(al.name ORDER BY al.id) FROM actor_aliases al WHERE al.actor_id = a.id), '[]'::jsonb),
+		'links', COALESCE((SELECT jsonb_agg(jsonb_build_object('site', l.site, 'url', l.url) ORDER BY l.id)
+			FROM actor_links l WHERE l.actor_id = a.id), '[]'::jsonb)
+	)`
+
+const filmRevisionQuery = `INSERT INTO film_revisions (film_id, revision, snapshot, username, user_id)
+	SELECT f.id, f.version, ` + filmSnapshotJSON + `, $2, $3
+	FROM films f WHERE f.id = $1`
+
+const actorRevisionQuery = `INSERT INTO actor_revisions (actor_id, revision, snapshot, username, user_id)
+	SELECT a.id, a.version, ` + actorSnapshotJSON + `, $2, $3
+	FROM actors a WHERE a.id = $1`
+
+// utility: stores the current state of the film (with its cast) as a new revision
+func (orm *ORM) writeFilmRevision(tx *sql.Tx, filmID int) error {
+	_, err := tx.Exec(filmRevisionQuery, filmID, orm.username(), orm.authorID())
+	return err
+}
+
+// utility: stores the current state of the actor as a new revision
+func (orm *ORM) writeActorRevision(tx *sql.Tx, actorID int) error {
+	_, err := tx.Exec(actorRevisionQuery, actorID, orm.username(), orm.authorID())
+	return err
+}
+
+// utility: author of the change, empty for unaudited ORM
+func (orm *ORM) username() string {
+	if orm.audit == nil {
+		return ""
+	}
+	return orm.audit.Username
+}
+
+// endpoint: /film/{id}/history
+// get
+func (orm *ORM) GetFilmHistory(filmID int) ([]types.Revision, error) {
+	return getHistory(orm.db, "SELECT revision, username, created_at FROM film_revisions WHERE film_id = $1 ORDER BY revision DESC", filmID)
+}
+
+// endpoint: /film/{id}/history/{rev}
+// get
+func (orm *ORM) GetFilmRevision(filmID, revision int) (types.Revision, error) {
+	return getRevision(orm.db, "SELECT revision, username, created_at, snapshot FROM film_revisions WHERE film_id = $1 AND revision = $2", filmID, revision)
+}
+
+// endpoint: /film/{id}/revert/{rev}
+// post
+// the film gets fields and cast of the revision and a new version, so revert is a revision itself.
+// expectedVersion works the same way as film.Version in UpdateFilm
+func (orm *ORM) RevertFilm(filmID, revision, expectedVersion int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		stored, err := getRevision(tx, "SELECT revision, username, created_at, snapshot FROM film_revisions WHERE film_id = $1 AND revision = $2", filmID, revision)
+		if err != nil {
+			return err
+		}
+		var film types.Film
+		if err := json.Unmarshal(stored.Snapshot, &film); err != nil {
+			return err
+		}
+		film.ID = filmID
+		film.Version = expectedVersion
+
+		// cast is replaced first, so the revision written by updateFilm already contains it.
+		// actors purged since then are skipped
+		if _, err := tx.Exec("DELETE FROM film_actors WHERE film_id = $1", filmID); err != nil {
+			return err
+		}
+		for _, actorID := range film.Actors {
+			_, err := tx.Exec("INSERT INTO film_actors (film_id, actor_id) SELECT $1, id FROM actors WHERE id = $2", filmID, actorID)
+			if err != nil {
+				return err
+			}
+		}
+
+		// revisions written before films had a status are of released films
+		film.Status = filmStatus(film.Status)
+		return orm.updateFilm(tx, film, "revert", false)
+	})
+}
+
+// endpoint: /actor/{id}/history
+// get
+func (orm *ORM) GetActorHistory(actorID int) ([]types.Revision, error) {
+	return getHistory(orm.db, "SELECT revision, username, created_at FROM actor_revisions WHERE actor_id = $1 ORDER BY revision DESC", actorID)
+}
+
+// endpoint: /actor/{id}/history/{rev}
+// get
+func (orm *ORM) GetActorRevision(actorID, revision int) (types.Revision, error) {
+	return getRevision(orm.db, "SELECT revision, username, created_at, snapshot FROM actor_revisions WHERE actor_id = $1 AND revision = $2", actorID, revision)
+}
+
+// endpoint: /actor/{id}/revert/{rev}
+// post
+func (orm *ORM) RevertActor(actorID, revision, expectedVersion int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		stored, err := getRevision(tx, "SELECT revision, username, created_at, snapshot FROM actor_revisions WHERE actor_id = $1 AND revision = $2", actorID, revision)
+		if err != nil {
+			return err
+		}
+		var actor types.Actor
+		if err := json.Unmarshal(stored.Snapshot, &actor); err != nil {
+			return err
+		}
+		actor.ID = actorID
+		actor.Version = expectedVersion
+		return orm.updateActor(tx, actor, "revert")
+	})
+}
+
+// utility: revision list without snapshots
+func getHistory(q querier, query string, id int) ([]types.Revision, error) {
+	rows, err := q.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []types.Revision
+	for rows.Next() {
+		var revision types.Revision
+		var createdAt time.Time
+		if err := rows.Scan(&revision.Revision, &revision.Username, &createdAt); err != nil {
+			return nil, err
+		}
+		revision.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// utility: single revision with snapshot, ErrNotFound if there is no such revision
+func getRevision(q querier, query string, id, number int) (types.Revision, error) {
+	var revision types.Revision
+	var createdAt time.Time
+	var snapshot []byte
+	err := q.QueryRow(query, id, number).Scan(&revision.Revision, &revision.Username, &createdAt, &snapshot)
+	if err == sql.ErrNoRows {
+		return revision, ErrNotFound
+	}
+	if err != nil {
+		return revision, err
+	}
+	revision.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	revision.Snapshot = json.RawMessage(snapshot)
+	return revision, nil
+}
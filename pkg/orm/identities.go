@@ -0,0 +1,166 @@
+// pkg/orm/identities.go
+package orm
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+var (
+	// a new identity needs an email confirmed by the provider, to link it or to make a user for it
+	ErrIdentityEmail = errors.New("the provider did not confirm an email for the account")
+	// the user with the email of a new identity has not verified it, so the email may belong to somebody else
+	ErrIdentityLink = errors.New("an account with this email exists, log in with the password and verify the email to link it")
+)
+
+// endpoint: /user/oidc/{provider}/login
+// utility: state of a started login, expiry is counted by postgres. States of abandoned logins are dropped here
+func (orm *ORM) CreateOIDCState(stateHash, provider, nonce, verifier string, ttl time.Duration) error {
+	if _, err := orm.db.Exec("DELETE FROM oidc_states WHERE expires_at < NOW()"); err != nil {
+		return err
+	}
+	_, err := orm.db.Exec(
+		"INSERT INTO oidc_states (state_hash, provider, nonce, verifier, expires_at) VALUES ($1, $2, $3, $4, NOW() + $5 * INTERVAL '1 second')",
+		stateHash, provider, nonce, verifier, int(ttl.Seconds()),
+	)
+	return err
+}
+
+// endpoint: /user/oidc/{provider}/callback
+// utility: nonce and PKCE verifier of the login, a state is used once. ErrBadToken for unknown, used or expired states
+func (orm *ORM) UseOIDCState(stateHash, provider string) (nonce, verifier string, err error) {
+	err = orm.db.QueryRow(
+		"DELETE FROM oidc_states WHERE state_hash = $1 AND provider = $2 AND expires_at > NOW() RETURNING nonce, verifier",
+		stateHash, provider,
+	).Scan(&nonce, &verifier)
+	if err == sql.ErrNoRows {
+		return "", "", ErrBadToken
+	}
+	return nonce, verifier, err
+}
+
+// utility: user of the identity. An identity seen before logs in its user; a new one is linked to the user
+// with its email, or a user is made for it. admin is the role from the provider's groups, nil keeps the stored role
+func (orm *ORM) LoginWithIdentity(identity types.ExternalIdentity, admin *bool) (types.UserProfile, error) {
+	var user types.UserProfile
+	err := orm.withTx(func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUserProfile(tx.QueryRow(
+			userProfileSQL+" WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2) FOR UPDATE",
+			identity.Provider, identity.Subject,
+		))
+		switch {
+		case err == nil:
+			_, err = tx.Exec(
+				"UPDATE user_identities SET email = $1, last_login_at = NOW() WHERE provider = $2 AND subject = $3",
+				identity.Email, identity.Provider, identity.Subject,
+			)
+		case errors.Is(err, ErrNotFound):
+			user, err = orm.linkIdentity(tx, identity)
+		}
+		if err != nil {
+			return err
+		}
+
+		if admin == nil || *admin == user.Admin {
+			return nil
+		}
+		// tokens issued as admin stop working with the role, like with SetUserAdmin. The token of this login is issued
+		// within the same second and carries whole seconds, so the revocation counts from the start of the second
+		_, err = tx.Exec(
+			"UPDATE users SET adminflag = $1, sessions_revoked_at = CASE WHEN $1 THEN sessions_revoked_at ELSE date_trunc('second', NOW()) END WHERE id = $2",
+			*admin, user.ID,
+		)
+		if err != nil {
+			return err
+		}
+		before := map[string]interface{}{"admin": user.Admin}
+		user.Admin = *admin
+		return orm.selfAuthored(user.ID, user.Username).writeAudit(tx, "update", "user", user.ID, before, map[string]interface{}{"admin": user.Admin, "provider": identity.Provider})
+	})
+	if err != nil {
+		return types.UserProfile{}, err
+	}
+	return user, nil
+}
+
+// utility: both sides must have verified the email, otherwise whoever registered it first would get the account
+func (orm *ORM) linkIdentity(tx *sql.Tx, identity types.ExternalIdentity) (types.UserProfile, error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		return types.UserProfile{}, ErrIdentityEmail
+	}
+
+	user, err := scanUserProfile(tx.QueryRow(userProfileSQL+" WHERE email = $1 FOR UPDATE", identity.Email))
+	switch {
+	case err == nil:
+		if !user.Verified {
+			return types.UserProfile{}, ErrIdentityLink
+		}
+	case errors.Is(err, ErrNotFound):
+		user, err = orm.provisionUser(tx, identity)
+		if err != nil {
+			return types.UserProfile{}, err
+		}
+	default:
+		return types.UserProfile{}, err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4)",
+		identity.Provider, identity.Subject, user.ID, identity.Email,
+	)
+	if err != nil {
+		return types.UserProfile{}, err
+	}
+	return user, orm.selfAuthored(user.ID, user.Username).writeAudit(tx, "link", "user", user.ID, nil, map[string]interface{}{"provider": identity.Provider, "subject": identity.Subject})
+}
+
+// utility: the user has no password, it can be set through /user/password/forgot
+func (orm *ORM) provisionUser(tx *sql.Tx, identity types.ExternalIdentity) (types.UserProfile, error) {
+	username, err := freeUsername(tx, identity.Username)
+	if err != nil {
+		return types.UserProfile{}, err
+	}
+
+	user := types.UserProfile{Username: username, Email: identity.Email, Verified: true}
+	err = tx.QueryRow(
+		"INSERT INTO users (username, email, password, verified_at) VALUES ($1, $2, '', NOW()) RETURNING id",
+		username, identity.Email,
+	).Scan(&user.ID)
+	if err != nil {
+		return types.UserProfile{}, err
+	}
+	return user, orm.selfAuthored(user.ID, username).writeAudit(tx, "create", "user", user.ID, nil, map[string]interface{}{"username": username, "email": identity.Email, "provider": identity.Provider})
+}
+
+// utility: the wanted username without @ and what follows, with -2, -3... when it is taken
+func freeUsername(tx *sql.Tx, wanted string) (string, error) {
+	base, _, _ := strings.Cut(wanted, "@")
+	if strings.HasPrefix(base, DeletedUsernamePrefix) {
+		base = ""
+	}
+	// characters, not bytes, so that a name is not cut in the middle of one
+	if runes := []rune(base); len(runes) > 45 {
+		base = string(runes[:45])
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for n := 2; ; n++ {
+		var taken bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)", candidate).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = base + "-" + strconv.Itoa(n)
+	}
+}
@@ -0,0 +1,41 @@
+// pkg/orm/images.go
+package orm
+
+import (
+	"database/sql"
+)
+
+// endpoint: /film/{id}/poster
+// key is the storage prefix of the new poster, "" removes it. Returns the previous key, so its files can be deleted.
+// The film version is not bumped, the poster is not part of the film revisions
+func (orm *ORM) SetFilmPoster(filmID int, key string) (string, error) {
+	return orm.setImageKey("films", "poster_key", "film", filmID, key)
+}
+
+// endpoint: /actor/{id}/photo
+func (orm *ORM) SetActorPhoto(actorID int, key string) (string, error) {
+	return orm.setImageKey("actors", "photo_key", "actor", actorID, key)
+}
+
+// utility: table and column are never user input
+func (orm *ORM) setImageKey(table, column, entityType string, id int, key string) (string, error) {
+	var oldKey string
+	err := orm.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow("SELECT "+column+" FROM "+table+" WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&oldKey)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE "+table+" SET "+column+" = $1 WHERE id = $2", key, id); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "update", entityType, id, map[string]string{column: oldKey}, map[string]string{column: key})
+	})
+	if err != nil {
+		return "", err
+	}
+	return oldKey, nil
+}
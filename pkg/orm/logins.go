@@ -0,0 +1,95 @@
+// pkg/orm/logins.go
+package orm
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// scopes of login_failures: failed logins are counted per account (subject is the user id) and per client ip
+const (
+	LoginScopeUser = "user"
+	LoginScopeIP   = "ip"
+)
+
+// endpoint: /user/login
+// utility: how long the subject still has to wait, 0 when it is not blocked.
+// Counted by postgres, so that it is compared with NOW() in the same time zone
+func (orm *ORM) GetLoginBlock(scope, subject string) (time.Duration, error) {
+	var seconds int
+	err := orm.db.QueryRow(
+		"SELECT CEIL(EXTRACT(EPOCH FROM blocked_until - NOW()))::int FROM login_failures WHERE scope = $1 AND subject = $2 AND blocked_until > NOW()",
+		scope, subject,
+	).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// utility: counts one more failure and returns the count. Failures are forgotten when the last one is older than window
+func (orm *ORM) RecordLoginFailure(scope, subject string, window time.Duration) (int, error) {
+	var failures int
+	err := orm.db.QueryRow(
+		`INSERT INTO login_failures (scope, subject, failures, last_failed_at) VALUES ($1, $2, 1, NOW())
+		ON CONFLICT (scope, subject) DO UPDATE SET
+			failures = CASE WHEN login_failures.last_failed_at < NOW() - $3 * INTERVAL '1 second' THEN 1 ELSE login_failures.failures + 1 END,
+			last_failed_at = NOW()
+		RETURNING failures`,
+		scope, subject, int(window.Seconds()),
+	).Scan(&failures)
+	return failures, err
+}
+
+// utility: no logins of the subject for d, the failures are kept so that the next delay grows
+func (orm *ORM) BlockLogin(scope, subject string, d time.Duration) error {
+	_, err := orm.db.Exec(
+		"UPDATE login_failures SET blocked_until = NOW() + $3 * INTERVAL '1 second' WHERE scope = $1 AND subject = $2",
+		scope, subject, int(d.Seconds()),
+	)
+	return err
+}
+
+// utility: after a successful login the account starts from zero, the ip does not,
+// otherwise one known password would let an ip try the others without limits
+func (orm *ORM) ClearLoginFailures(scope, subject string) error {
+	_, err := orm.db.Exec("DELETE FROM login_failures WHERE scope = $1 AND subject = $2", scope, subject)
+	return err
+}
+
+// utility: lockout is the block of an account that the admins can see in the audit log and lift
+func (orm *ORM) LockUser(userID int, d time.Duration, failures int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"UPDATE login_failures SET blocked_until = NOW() + $3 * INTERVAL '1 second' WHERE scope = $1 AND subject = $2",
+			LoginScopeUser, strconv.Itoa(userID), int(d.Seconds()),
+		)
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "lock", "user", userID, nil, map[string]interface{}{"failures": failures, "locked_for": int(d.Seconds())})
+	})
+}
+
+// endpoint: /admin/users/{id}/unlock
+// post, the failures of the account are forgotten as well. ErrNotFound when there is no such user
+func (orm *ORM) UnlockUser(userID int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", userID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		_, err := tx.Exec("DELETE FROM login_failures WHERE scope = $1 AND subject = $2", LoginScopeUser, strconv.Itoa(userID))
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "unlock", "user", userID, nil, nil)
+	})
+}
@@ -0,0 +1,213 @@
+// pkg/orm/media.go
+package orm
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// endpoint: /film/{id}
+// get, film with the ids of its actors (not in trash), its media, alternative titles, neighbours in collections, releases, countries and languages
+func (orm *ORM) GetFilmByID(filmID int) (*types.Film, error) {
+	var film types.Film
+	var posterKey string
+	var budget, boxOffice sql.NullInt64
+	var budgetCurrency, boxOfficeCurrency string
+	query := `SELECT id, title, description, release_date, rating, version, poster_key, original_title,
+		COALESCE(runtime, 0), status, budget, budget_currency, box_office, box_office_currency
+		FROM films WHERE id = $1 AND deleted_at IS NULL`
+	err := orm.db.QueryRow(query, filmID).
+		Scan(&film.ID, &film.Title, &film.Description, &film.ReleaseDate, &film.Rating, &film.Version, &posterKey, &film.OriginalTitle,
+			&film.Runtime, &film.Status, &budget, &budgetCurrency, &boxOffice, &boxOfficeCurrency)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	film.Poster = types.NewImageURLs(posterKey)
+	film.Budget = scanMoney(budget, budgetCurrency)
+	film.BoxOffice = scanMoney(boxOffice, boxOfficeCurrency)
+
+	rows, err := orm.db.Query("SELECT fa.actor_id FROM film_actors fa JOIN actors a ON a.id = fa.actor_id WHERE fa.film_id = $1 AND a.deleted_at IS NULL ORDER BY fa.actor_id", filmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	film.Actors = []int{}
+	for rows.Next() {
+		var actorID int
+		if err := rows.Scan(&actorID); err != nil {
+			return nil, err
+		}
+		film.Actors = append(film.Actors, actorID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	film.Media, err = getFilmMedia(orm.db, filmID)
+	if err != nil {
+		return nil, err
+	}
+	film.AltTitles, err = getAltTitles(orm.db, filmID)
+	if err != nil {
+		return nil, err
+	}
+	film.Series, err = getFilmSeries(orm.db, filmID)
+	if err != nil {
+		return nil, err
+	}
+	film.Releases, err = getFilmReleases(orm.db, filmID)
+	if err != nil {
+		return nil, err
+	}
+	film.Countries, err = getFilmCodes(orm.db, "film_countries", "country", filmID)
+	if err != nil {
+		return nil, err
+	}
+	film.Languages, err = getFilmCodes(orm.db, "film_languages", "language", filmID)
+	if err != nil {
+		return nil, err
+	}
+
+	films := []types.Film{film}
+	if err := orm.translate(films); err != nil {
+		return nil, err
+	}
+	return &films[0], nil
+}
+
+// endpoint: /film/{id}/media
+// get, ErrNotFound when there is no such film
+func (orm *ORM) GetFilmMedia(filmID int) ([]types.FilmMedia, error) {
+	var exists bool
+	err := orm.db.QueryRow("SELECT EXISTS (SELECT 1 FROM films WHERE id = $1 AND deleted_at IS NULL)", filmID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return getFilmMedia(orm.db, filmID)
+}
+
+// endpoint: /film/{id}/media/{mediaId}
+// get
+func (orm *ORM) GetFilmMediaByID(filmID, mediaID int) (*types.FilmMedia, error) {
+	return filmMediaSnapshot(orm.db, filmID, mediaID)
+}
+
+// endpoint: /film/{id}/media
+// post, ErrNotFound when the film does not exist or is in trash
+func (orm *ORM) CreateFilmMedia(media types.FilmMedia) (int, error) {
+	err := orm.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(
+			`INSERT INTO film_media (film_id, type, provider, url, language, duration)
+			SELECT $1, $2, $3, $4, $5, $6 WHERE EXISTS (SELECT 1 FROM films WHERE id = $1 AND deleted_at IS NULL)
+			RETURNING id`,
+			media.FilmID, media.Type, media.Provider, media.URL, media.Language, media.Duration,
+		).Scan(&media.ID)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "create", "film_media", media.ID, nil, media)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return media.ID, nil
+}
+
+// endpoint: /film/{id}/media/{mediaId}
+// patch, the media does not take part in film versions and revisions
+func (orm *ORM) UpdateFilmMedia(media types.FilmMedia) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		before, err := filmMediaSnapshot(tx, media.FilmID, media.ID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"UPDATE film_media SET type = $1, provider = $2, url = $3, language = $4, duration = $5 WHERE id = $6",
+			media.Type, media.Provider, media.URL, media.Language, media.Duration, media.ID,
+		)
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "update", "film_media", media.ID, before, media)
+	})
+}
+
+// endpoint: /film/{id}/media/{mediaId}
+// delete
+func (orm *ORM) DeleteFilmMedia(filmID, mediaID int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		before, err := filmMediaSnapshot(tx, filmID, mediaID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM film_media WHERE id = $1", mediaID); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "delete", "film_media", mediaID, before, nil)
+	})
+}
+
+// utility: media of the film not in trash, ErrNotFound otherwise
+func filmMediaSnapshot(q querier, filmID, mediaID int) (*types.FilmMedia, error) {
+	var media types.FilmMedia
+	err := q.QueryRow(
+		`SELECT m.id, m.film_id, m.type, m.provider, m.url, m.language, m.duration
+		FROM film_media m JOIN films f ON f.id = m.film_id
+		WHERE m.id = $1 AND m.film_id = $2 AND f.deleted_at IS NULL`,
+		mediaID, filmID,
+	).Scan(&media.ID, &media.FilmID, &media.Type, &media.Provider, &media.URL, &media.Language, &media.Duration)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
+
+// utility
+func getFilmMedia(q querier, filmID int) ([]types.FilmMedia, error) {
+	rows, err := q.Query("SELECT id, film_id, type, provider, url, language, duration FROM film_media WHERE film_id = $1 ORDER BY id", filmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	media := []types.FilmMedia{}
+	for rows.Next() {
+		var item types.FilmMedia
+		if err := rows.Scan(&item.ID, &item.FilmID, &item.Type, &item.Provider, &item.URL, &item.Language, &item.Duration); err != nil {
+			return nil, err
+		}
+		media = append(media, item)
+	}
+	return media, rows.Err()
+}
+
+// utility: conditions of the film list appended to "WHERE deleted_at IS NULL", films must not be aliased.
+// Values go to args, placeholders continue their numbering
+func filmFilterSQL(filter types.FilmFilter, args []interface{}) (string, []interface{}) {
+	var conditions string
+	if filter.HasTrailer != nil {
+		args = append(args, "trailer")
+		exists := "EXISTS (SELECT 1 FROM film_media m WHERE m.film_id = films.id AND m.type = $" + strconv.Itoa(len(args)) + ")"
+		if !*filter.HasTrailer {
+			exists = "NOT " + exists
+		}
+		conditions += " AND " + exists
+	}
+	releases, args := releaseFilterSQL(filter, args)
+	info, args := filmInfoFilterSQL(filter, args)
+	return conditions + releases + info, args
+}
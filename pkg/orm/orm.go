@@ -3,6 +3,8 @@ package orm
 
 import (
 	"database/sql"
+	"errors"
+	"time"
 
 	"github.com/vexrina/cinemaLibrary/pkg/types"
 )
@@ -12,45 +14,220 @@ Not sure how to correctly divide ORM into different packages/modules for prod, f
 Wrote all the ORMs in one file, although it doesn't seem to be quite correct
 */
 type ORM struct {
-	db *sql.DB
+	db    *sql.DB
+	audit *types.AuditMeta
+	// preferred locales of film texts, see WithLocales
+	locales []string
 }
 
 func NewORM(db *sql.DB) *ORM {
 	return &ORM{db: db}
 }
 
+// returned by update/delete when the row with given id does not exist
+var ErrNotFound = errors.New("not found")
+
+// returned by update when the row exists, but its version differs from the expected one
+var ErrConflict = errors.New("version conflict")
+
+// utility: 0 rows affected by update means either missing row or stale version
+func missingOrConflict(q querier, table string, id int) error {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrConflict
+	}
+	return ErrNotFound
+}
+
+// utility: maps 0 rows affected to ErrNotFound
+func checkAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // endpoint: /actor
 // post
 func (orm *ORM) CreateActor(actor types.Actor) error {
-	_, err := orm.db.Exec("INSERT INTO actors (name, gender, date_of_birth) VALUES ($1, $2, $3)", actor.Name, actor.Gender, actor.Birthdate)
-	if err != nil {
+	return orm.withTx(func(tx *sql.Tx) error {
+		_, err := orm.createActor(tx, actor)
 		return err
+	})
+}
+
+// utility: shared by CreateActor and AcceptExternalFilm
+func (orm *ORM) createActor(tx *sql.Tx, actor types.Actor) (int, error) {
+	query := `INSERT INTO actors (name, gender, date_of_birth, biography, birthplace, nationality, date_of_death, death_place)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	err := tx.QueryRow(query, actor.Name, actor.Gender, actor.Birthdate,
+		actor.Biography, actor.Birthplace, actor.Nationality, deathDateArg(actor.DeathDate), actor.DeathPlace).Scan(&actor.ID)
+	if err != nil {
+		return 0, err
 	}
-	return nil
+	if err := replaceActorProfileLists(tx, actor); err != nil {
+		return 0, err
+	}
+	if err := orm.writeActorRevision(tx, actor.ID); err != nil {
+		return 0, err
+	}
+	return actor.ID, orm.writeAudit(tx, "create", "actor", actor.ID, nil, actor)
 }
 
 // patch
+// actor.Version is the expected version of the row, 0 means "update whatever is stored"
 func (orm *ORM) UpdateActor(actor types.Actor) error {
-	_, err := orm.db.Exec("UPDATE actors SET name = $1, gender = $2, date_of_birth = $3 WHERE id = $4", actor.Name, actor.Gender, actor.Birthdate, actor.ID)
+	return orm.withTx(func(tx *sql.Tx) error {
+		return orm.updateActor(tx, actor, "update")
+	})
+}
+
+// utility: shared by UpdateActor and RevertActor, action is written to audit
+func (orm *ORM) updateActor(tx *sql.Tx, actor types.Actor, action string) error {
+	var before *types.Actor
+	if orm.audit != nil {
+		var err error
+		before, err = actorSnapshot(tx, actor.ID)
+		if err != nil {
+			return err
+		}
+		// aliases and links are diffed only when they are replaced
+		if actor.Aliases != nil {
+			before.Aliases, err = getActorAliases(tx, actor.ID)
+			if err != nil {
+				return err
+			}
+		}
+		if actor.Links != nil {
+			before.Links, err = getActorLinks(tx, actor.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	query := `UPDATE actors SET name = $1, gender = $2, date_of_birth = $3, biography = $4, birthplace = $5, nationality = $6,
+		date_of_death = $7, death_place = $8, version = version + 1
+		WHERE id = $9 AND deleted_at IS NULL AND ($10 = 0 OR version = $10)`
+	result, err := tx.Exec(query, actor.Name, actor.Gender, actor.Birthdate, actor.Biography, actor.Birthplace, actor.Nationality,
+		deathDateArg(actor.DeathDate), actor.DeathPlace, actor.ID, actor.Version)
 	if err != nil {
 		return err
 	}
-	return nil
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return missingOrConflict(tx, "actors", actor.ID)
+	}
+	if err := replaceActorProfileLists(tx, actor); err != nil {
+		return err
+	}
+	if err := orm.writeActorRevision(tx, actor.ID); err != nil {
+		return err
+	}
+	if before != nil {
+		actor.Version = before.Version + 1
+	}
+	return orm.writeAudit(tx, action, "actor", actor.ID, before, actor)
+}
+
+// utility: current state of the actor for audit
+func actorSnapshot(q querier, id int) (*types.Actor, error) {
+	var actor types.Actor
+	query := `SELECT id, name, gender, to_char(date_of_birth, 'YYYY-MM-DD'), version,
+		biography, birthplace, nationality, COALESCE(to_char(date_of_death, 'YYYY-MM-DD'), ''), death_place
+		FROM actors WHERE id = $1 AND deleted_at IS NULL`
+	err := q.QueryRow(query, id).
+		Scan(&actor.ID, &actor.Name, &actor.Gender, &actor.Birthdate, &actor.Version,
+			&actor.Biography, &actor.Birthplace, &actor.Nationality, &actor.DeathDate, &actor.DeathPlace)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &actor, nil
 }
 
 // delete
+// soft delete: the row and its film_actors links stay in DB until RestoreActorByID or PurgeDeleted
 func (orm *ORM) DeleteActorByID(id int) error {
-	_, err := orm.db.Exec("DELETE FROM film_actors WHERE actor_id = $1", id)
+	return orm.withTx(func(tx *sql.Tx) error {
+		var before *types.Actor
+		if orm.audit != nil {
+			var err error
+			before, err = actorSnapshot(tx, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.Exec("UPDATE actors SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL", id)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(result); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "delete", "actor", id, before, nil)
+	})
+}
+
+func (orm *ORM) RestoreActorByID(id int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE actors SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL", id)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(result); err != nil {
+			return err
+		}
+		if orm.audit == nil {
+			return nil
+		}
+		after, err := actorSnapshot(tx, id)
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "restore", "actor", id, nil, after)
+	})
+}
+
+// trash
+func (orm *ORM) GetDeletedActors() ([]types.Actor, error) {
+	query := `SELECT id, name, gender, date_of_birth, version, photo_key, deleted_at FROM actors WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC`
+
+	rows, err := orm.db.Query(query)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	_, err = orm.db.Exec("DELETE FROM actors WHERE id = $1", id)
-	if err != nil {
-		return err
+	var actors []types.Actor
+	for rows.Next() {
+		var actor types.Actor
+		var photoKey string
+		if err := rows.Scan(&actor.ID, &actor.Name, &actor.Gender, &actor.Birthdate, &actor.Version, &photoKey, &actor.DeletedAt); err != nil {
+			return nil, err
+		}
+		actor.Photo = types.NewImageURLs(photoKey)
+		actors = append(actors, actor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return actors, nil
 }
 
 // get
@@ -60,7 +237,7 @@ func (orm *ORM) GetFilmsWithActor(actorId int) ([]string, error){
 		SELECT f.title
 		FROM films AS f
 		JOIN film_actors AS fa ON f.id = fa.film_id
-		WHERE fa.actor_id = $1
+		WHERE fa.actor_id = $1 AND f.deleted_at IS NULL
 	`
 	filmsRows, err := orm.db.Query(filmsQuery, actorId)
 	if err != nil {
@@ -84,7 +261,7 @@ func (orm *ORM) GetFilmsWithActor(actorId int) ([]string, error){
 }
 
 func (orm *ORM) GetActors() ([]types.ActorWithFilms, error) {
-	query := `SELECT id, name FROM actors`
+	query := `SELECT id, name, version, photo_key FROM actors WHERE deleted_at IS NULL`
 
 	rows, err := orm.db.Query(query)
 	if err != nil {
@@ -95,9 +272,11 @@ func (orm *ORM) GetActors() ([]types.ActorWithFilms, error) {
 	var actorsWithFilms []types.ActorWithFilms
 	for rows.Next() {
 		var actor types.ActorWithFilms
-		if err := rows.Scan(&actor.ID, &actor.Name); err != nil {
+		var photoKey string
+		if err := rows.Scan(&actor.ID, &actor.Name, &actor.Version, &photoKey); err != nil {
 			return nil, err
 		}
+		actor.Photo = types.NewImageURLs(photoKey)
 		filmTitles, err := orm.GetFilmsWithActor(actor.ID)
 		if err!=nil{
 			return nil, err
@@ -113,8 +292,7 @@ func (orm *ORM) GetActors() ([]types.ActorWithFilms, error) {
 }
 
 func (orm *ORM) GetActorsWithFragment(actorFragment string) ([]types.ActorWithFilms, error) {
-	query := `SELECT id, name FROM actors WHERE name LIKE $1`
-	actorFragment = "%" + actorFragment + "%"
+	query := `SELECT id, name, version, photo_key FROM actors a WHERE ` + actorMatchSQL + ` AND deleted_at IS NULL`
 	rows, err := orm.db.Query(query, actorFragment)
 	if err != nil {
 		return nil, err
@@ -124,9 +302,11 @@ func (orm *ORM) GetActorsWithFragment(actorFragment string) ([]types.ActorWithFi
 	var actorsWithFilms []types.ActorWithFilms
 	for rows.Next() {
 		var actor types.ActorWithFilms
-		if err := rows.Scan(&actor.ID, &actor.Name); err != nil {
+		var photoKey string
+		if err := rows.Scan(&actor.ID, &actor.Name, &actor.Version, &photoKey); err != nil {
 			return nil, err
 		}
+		actor.Photo = types.NewImageURLs(photoKey)
 		filmTitles, err := orm.GetFilmsWithActor(actor.ID)
 		if err!=nil{
 			return nil, err
@@ -146,35 +326,155 @@ func (orm *ORM) GetActorsWithFragment(actorFragment string) ([]types.ActorWithFi
 // endpoint: /film
 // post
 func (orm *ORM) CreateFilm(film types.Film) (int, error) {
-	var filmID int
-	err := orm.db.QueryRow("INSERT INTO films (title, description, release_date, rating) VALUES ($1, $2, $3, $4) RETURNING id", film.Title, film.Description, film.ReleaseDate, film.Rating).Scan(&filmID)
+	err := orm.withTx(func(tx *sql.Tx) error {
+		var err error
+		film.ID, err = orm.createFilm(tx, film)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
 
+	return film.ID, nil
+}
+
+// utility: shared by CreateFilm and AcceptExternalFilm
+func (orm *ORM) createFilm(tx *sql.Tx, film types.Film) (int, error) {
+	film.Status = filmStatus(film.Status)
+	budget, budgetCurrency := moneyArgs(film.Budget)
+	boxOffice, boxOfficeCurrency := moneyArgs(film.BoxOffice)
+	query := `INSERT INTO films (title, description, release_date, rating, original_title, runtime, status, budget, budget_currency, box_office, box_office_currency)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`
+	err := tx.QueryRow(query, film.Title, film.Description, film.ReleaseDate, film.Rating, film.OriginalTitle,
+		runtimeArg(film.Runtime), film.Status, budget, budgetCurrency, boxOffice, boxOfficeCurrency).Scan(&film.ID)
+	if err != nil {
+		return 0, err
+	}
+	if err := replaceAltTitles(tx, film.ID, film.AltTitles); err != nil {
+		return 0, err
+	}
+	if err := replaceFilmCountriesAndLanguages(tx, film); err != nil {
+		return 0, err
+	}
+
 	for _, actorID := range film.Actors {
-		_, err := orm.db.Exec("INSERT INTO film_actors (film_id, actor_id) VALUES ($1, $2)", filmID, actorID)
+		_, err := tx.Exec("INSERT INTO film_actors (film_id, actor_id) VALUES ($1, $2)", film.ID, actorID)
 		if err != nil {
 			return 0, err
 		}
 	}
 
-	return filmID, nil
+	if err := orm.writeFilmRevision(tx, film.ID); err != nil {
+		return 0, err
+	}
+	return film.ID, orm.writeAudit(tx, "create", "film", film.ID, nil, film)
 }
 
 // patch
+// film.Version is the expected version of the row, 0 means "update whatever is stored"
 func (orm *ORM) UpdateFilm(film types.Film) error {
-	query := "UPDATE films SET title = $2, description = $3, release_date = $4, rating = $5 WHERE id = $1"
-	_, err := orm.db.Exec(query, film.ID, film.Title, film.Description, film.ReleaseDate, film.Rating)
+	return orm.withTx(func(tx *sql.Tx) error {
+		return orm.updateFilm(tx, film, "update", true)
+	})
+}
+
+// utility: shared by UpdateFilm and RevertFilm, action is written to audit.
+// With keepOmitted the details omitted from the film keep their stored values, see keepStoredDetails;
+// revert passes the whole revision and replaces them
+func (orm *ORM) updateFilm(tx *sql.Tx, film types.Film, action string, keepOmitted bool) error {
+	var before *types.Film
+	if orm.audit != nil || keepOmitted && omitsDetails(film) {
+		var err error
+		before, err = filmSnapshot(tx, film.ID)
+		if err != nil {
+			return err
+		}
+		if keepOmitted {
+			keepStoredDetails(&film, before)
+		}
+	}
+	if orm.audit != nil {
+		var err error
+		// alternative titles are diffed only when they are replaced
+		if film.AltTitles != nil {
+			before.AltTitles, err = getAltTitles(tx, film.ID)
+			if err != nil {
+				return err
+			}
+		}
+		if film.Countries != nil {
+			before.Countries, err = getFilmCodes(tx, "film_countries", "country", film.ID)
+			if err != nil {
+				return err
+			}
+		}
+		if film.Languages != nil {
+			before.Languages, err = getFilmCodes(tx, "film_languages", "language", film.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	budget, budgetCurrency := moneyArgs(film.Budget)
+	boxOffice, boxOfficeCurrency := moneyArgs(film.BoxOffice)
+	query := `UPDATE films SET title = $2, description = $3, release_date = $4, rating = $5, original_title = $6,
+		runtime = $7, status = $8, budget = $9, budget_currency = $10, box_office = $11, box_office_currency = $12, version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL AND ($13 = 0 OR version = $13)`
+	result, err := tx.Exec(query, film.ID, film.Title, film.Description, film.ReleaseDate, film.Rating, film.OriginalTitle,
+		runtimeArg(film.Runtime), film.Status, budget, budgetCurrency, boxOffice, boxOfficeCurrency, film.Version)
 	if err != nil {
 		return err
 	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return missingOrConflict(tx, "films", film.ID)
+	}
+	if err := replaceAltTitles(tx, film.ID, film.AltTitles); err != nil {
+		return err
+	}
+	if err := replaceFilmCountriesAndLanguages(tx, film); err != nil {
+		return err
+	}
+	if err := orm.writeFilmRevision(tx, film.ID); err != nil {
+		return err
+	}
 
-	return nil
+	// cast is not part of the film row, keep it out of the diff
+	if before != nil {
+		film.Actors = before.Actors
+		film.Version = before.Version + 1
+	}
+	return orm.writeAudit(tx, action, "film", film.ID, before, film)
+}
+
+// utility: current state of the film for audit
+func filmSnapshot(q querier, id int) (*types.Film, error) {
+	var film types.Film
+	var budget, boxOffice sql.NullInt64
+	var budgetCurrency, boxOfficeCurrency string
+	query := `SELECT id, title, description, to_char(release_date, 'YYYY-MM-DD'), rating, version, original_title,
+		COALESCE(runtime, 0), status, budget, budget_currency, box_office, box_office_currency
+		FROM films WHERE id = $1 AND deleted_at IS NULL`
+	err := q.QueryRow(query, id).
+		Scan(&film.ID, &film.Title, &film.Description, &film.ReleaseDate, &film.Rating, &film.Version, &film.OriginalTitle,
+			&film.Runtime, &film.Status, &budget, &budgetCurrency, &boxOffice, &boxOfficeCurrency)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	film.Budget = scanMoney(budget, budgetCurrency)
+	film.BoxOffice = scanMoney(boxOffice, boxOfficeCurrency)
+	return &film, nil
 }
 
 // get
-func (orm *ORM) GetFilms(sortBy string, ascending bool) ([]types.Film, error) {
+func (orm *ORM) GetFilms(sortBy string, ascending bool, filter types.FilmFilter) ([]types.Film, error) {
 	// Default query
 	orderBy := "rating"
 
@@ -184,48 +484,69 @@ func (orm *ORM) GetFilms(sortBy string, ascending bool) ([]types.Film, error) {
 		orderBy = "title"
 	case "release_date":
 		orderBy = "release_date"
+	case "runtime", "budget", "box_office":
+		orderBy = sortBy
 	}
 	if ascending {
 		orderBy = orderBy + " ASC"
 	} else {
 		orderBy = orderBy + " DESC"
 	}
+	// films with unknown runtime or money go last in both directions
+	if sortBy == "runtime" || sortBy == "budget" || sortBy == "box_office" {
+		orderBy = orderBy + " NULLS LAST"
+	}
 
-	query := "SELECT * FROM films ORDER BY " + orderBy
-	rows, err := orm.db.Query(query)
+	conditions, args := filmFilterSQL(filter, nil)
+	query := "SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL" + conditions + " ORDER BY " + orderBy
+	rows, err := orm.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	// create list of films
+	return orm.scanFilmList(rows)
+}
+
+// utility: rows of the film list queries, which select
+// id, title, description, release_date, rating, version, poster_key, original_title
+func (orm *ORM) scanFilmList(rows *sql.Rows) ([]types.Film, error) {
 	var films []types.Film
 	for rows.Next() {
 		var film types.Film
-		if err := rows.Scan(&film.ID, &film.Title, &film.Description, &film.ReleaseDate, &film.Rating); err != nil {
+		var posterKey string
+		if err := rows.Scan(&film.ID, &film.Title, &film.Description, &film.ReleaseDate, &film.Rating, &film.Version, &posterKey, &film.OriginalTitle); err != nil {
 			return nil, err
 		}
+		film.Poster = types.NewImageURLs(posterKey)
 		films = append(films, film)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	rows.Close()
 
+	if err := addAltTitles(orm.db, films); err != nil {
+		return nil, err
+	}
+	if err := orm.translate(films); err != nil {
+		return nil, err
+	}
 	return films, nil
 }
 
 func (orm *ORM) SearchFilmsByFragment(fragment string) ([]types.Film, error) {
 	queryByActor := `
-        SELECT f.id, f.title, f.description, f.release_date, f.rating
+        SELECT f.id, f.title, f.description, f.release_date, f.rating, f.version, f.poster_key, f.original_title
         FROM films AS f
         JOIN film_actors AS fa ON f.id = fa.film_id
         JOIN actors AS a ON fa.actor_id = a.id
-        WHERE a.name LIKE '%' || $1 || '%'
+        WHERE ` + actorMatchSQL + ` AND f.deleted_at IS NULL AND a.deleted_at IS NULL
     `
 	queryByTitle := `
-        SELECT id, title, description, release_date, rating
+        SELECT id, title, description, release_date, rating, version, poster_key, original_title
         FROM films
-        WHERE title LIKE '%' || $1 || '%'
+        WHERE ` + titleMatchSQL + ` AND deleted_at IS NULL
     `
 	query := queryByActor + " UNION ALL " + queryByTitle
 
@@ -235,28 +556,16 @@ func (orm *ORM) SearchFilmsByFragment(fragment string) ([]types.Film, error) {
 	}
 	defer rows.Close()
 
-	var films []types.Film
-	for rows.Next() {
-		var film types.Film
-		if err := rows.Scan(&film.ID, &film.Title, &film.Description, &film.ReleaseDate, &film.Rating); err != nil {
-			return nil, err
-		}
-		films = append(films, film)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
-	return films, nil
+	return orm.scanFilmList(rows)
 }
 
 func (orm *ORM) SearchFilmsByActorFragment(actorFragment string) ([]types.Film, error) {
 	query := `
-        SELECT f.id, f.title, f.description, f.release_date, f.rating
+        SELECT f.id, f.title, f.description, f.release_date, f.rating, f.version, f.poster_key, f.original_title
         FROM films AS f
         JOIN film_actors AS fa ON f.id = fa.film_id
         JOIN actors AS a ON fa.actor_id = a.id
-        WHERE a.name LIKE '%' || $1 || '%'
+        WHERE ` + actorMatchSQL + ` AND f.deleted_at IS NULL AND a.deleted_at IS NULL
     `
 
 	rows, err := orm.db.Query(query, actorFragment)
@@ -265,25 +574,72 @@ func (orm *ORM) SearchFilmsByActorFragment(actorFragment string) ([]types.Film,
 	}
 	defer rows.Close()
 
-	var films []types.Film
-	for rows.Next() {
-		var film types.Film
-		if err := rows.Scan(&film.ID, &film.Title, &film.Description, &film.ReleaseDate, &film.Rating); err != nil {
-			return nil, err
-		}
-		films = append(films, film)
-	}
-	if err := rows.Err(); err != nil {
+	return orm.scanFilmList(rows)
+}
+
+func (orm *ORM) SearchFilmsByTitleFragment(titleFragment string) ([]types.Film, error) {
+	query := "SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE " + titleMatchSQL + " AND deleted_at IS NULL"
+
+	rows, err := orm.db.Query(query, titleFragment)
+	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return films, nil
+	return orm.scanFilmList(rows)
 }
 
-func (orm *ORM) SearchFilmsByTitleFragment(titleFragment string) ([]types.Film, error) {
-	query := "SELECT * FROM films WHERE title LIKE '%' || $1 || '%'"
+// delete
+// soft delete: the row and its film_actors links stay in DB until RestoreFilmByID or PurgeDeleted
+func (orm *ORM) DeleteFilmByID(filmID int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		var before *types.Film
+		if orm.audit != nil {
+			var err error
+			before, err = filmSnapshot(tx, filmID)
+			if err != nil {
+				return err
+			}
+		}
 
-	rows, err := orm.db.Query(query, titleFragment)
+		deleteFilmQuery := "UPDATE films SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL"
+		result, err := tx.Exec(deleteFilmQuery, filmID)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(result); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "delete", "film", filmID, before, nil)
+	})
+}
+
+func (orm *ORM) RestoreFilmByID(filmID int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		restoreFilmQuery := "UPDATE films SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL"
+		result, err := tx.Exec(restoreFilmQuery, filmID)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(result); err != nil {
+			return err
+		}
+		if orm.audit == nil {
+			return nil
+		}
+		after, err := filmSnapshot(tx, filmID)
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "restore", "film", filmID, nil, after)
+	})
+}
+
+// trash
+func (orm *ORM) GetDeletedFilms() ([]types.Film, error) {
+	query := "SELECT id, title, description, release_date, rating, version, poster_key, deleted_at FROM films WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC"
+
+	rows, err := orm.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -292,9 +648,11 @@ func (orm *ORM) SearchFilmsByTitleFragment(titleFragment string) ([]types.Film,
 	var films []types.Film
 	for rows.Next() {
 		var film types.Film
-		if err := rows.Scan(&film.ID, &film.Title, &film.Description, &film.ReleaseDate, &film.Rating); err != nil {
+		var posterKey string
+		if err := rows.Scan(&film.ID, &film.Title, &film.Description, &film.ReleaseDate, &film.Rating, &film.Version, &posterKey, &film.DeletedAt); err != nil {
 			return nil, err
 		}
+		film.Poster = types.NewImageURLs(posterKey)
 		films = append(films, film)
 	}
 	if err := rows.Err(); err != nil {
@@ -304,25 +662,41 @@ func (orm *ORM) SearchFilmsByTitleFragment(titleFragment string) ([]types.Film,
 	return films, nil
 }
 
-// delete
-func (orm *ORM) DeleteFilmByID(filmID int) error {
-	deleteFilmActorsQuery := "DELETE FROM film_actors WHERE film_id = $1"
-	_, err := orm.db.Exec(deleteFilmActorsQuery, filmID)
-	if err != nil {
-		return err
-	}
+// endpoint: /film
 
-	deleteFilmQuery := "DELETE FROM films WHERE id = $1"
-	_, err = orm.db.Exec(deleteFilmQuery, filmID)
-	if err != nil {
-		return err
+// retention
+// hard-deletes films and actors that stay in trash since before `before`, film_actors are removed by cascade.
+// Storage keys of their posters and photos are returned, the files are deleted by the caller
+func (orm *ORM) PurgeDeleted(before time.Time) (int64, []string, error) {
+	var purged int64
+	var imageKeys []string
+	for _, query := range []string{
+		"DELETE FROM films WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING poster_key",
+		"DELETE FROM actors WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING photo_key",
+	} {
+		rows, err := orm.db.Query(query, before)
+		if err != nil {
+			return purged, imageKeys, err
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return purged, imageKeys, err
+			}
+			purged++
+			if key != "" {
+				imageKeys = append(imageKeys, key)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return purged, imageKeys, err
+		}
 	}
-
-	return nil
+	return purged, imageKeys, nil
 }
 
-// endpoint: /film
-
 // endpoint: /user
 // utility function
 func (orm *ORM) CountUsersWithUsernameAndEmail(username, email string) (int, error) {
@@ -334,23 +708,27 @@ func (orm *ORM) CountUsersWithUsernameAndEmail(username, email string) (int, err
 	return count, nil
 }
 
-// post
-func (orm *ORM) CreateUser(username, email, hashedPassword string) error {
-	_, err := orm.db.Exec("INSERT INTO users (username, email, password) VALUES ($1, $2, $3)", username, email, hashedPassword)
+// post, id of the new user
+func (orm *ORM) CreateUser(username, email, hashedPassword string) (int, error) {
+	var userID int
+	err := orm.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow("INSERT INTO users (username, email, password) VALUES ($1, $2, $3) RETURNING id", username, email, hashedPassword).Scan(&userID)
+		if err != nil {
+			return err
+		}
+		// password hash never goes to the audit log
+		return orm.selfAuthored(userID, username).writeAudit(tx, "create", "user", userID, nil, map[string]interface{}{"username": username, "email": email})
+	})
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return userID, nil
 }
 
-func (orm *ORM) GetUserPasswordByEmail(email string) (string, bool, error) {
-	var storedPassword string
-	var adminflag bool
-	err := orm.db.QueryRow("SELECT password, adminflag FROM users WHERE email=$1", email).Scan(&storedPassword, &adminflag)
-	if err != nil {
-		return "", false, err
-	}
-	return storedPassword, adminflag, err
+// utility: login is an email or a username, the email wins when one user has another's email as username.
+// ErrNotFound when nobody matches
+func (orm *ORM) GetUserByLogin(login string) (types.UserProfile, error) {
+	return scanUserProfile(orm.db.QueryRow(userProfileSQL+" WHERE email = $1 OR username = $1 ORDER BY email = $1 DESC LIMIT 1", login))
 }
 
 // endpoint: /user
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -32,9 +34,12 @@ func TestCreateActor_Success(t *testing.T) {
 		Birthdate: "1990-01-01",
 	}
 
-	mock.ExpectExec("INSERT INTO actors").
-		WithArgs(actor.Name, actor.Gender, actor.Birthdate).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO actors").
+		WithArgs(actor.Name, actor.Gender, actor.Birthdate, "", "", "", nil, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO actor_revisions").WithArgs(1, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	err = orm.CreateActor(actor)
 	if err != nil {
@@ -55,8 +60,10 @@ func TestCreateActor_Error(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	mock.ExpectExec("INSERT INTO actors").
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO actors").
 		WillReturnError(fmt.Errorf("database error"))
+	mock.ExpectRollback()
 
 	err = orm.CreateActor(types.Actor{})
 
@@ -86,9 +93,12 @@ func TestUpdateActor_Success(t *testing.T) {
 		Birthdate: "1985-02-02",
 	}
 
+	mock.ExpectBegin()
 	mock.ExpectExec("UPDATE actors").
-		WithArgs(actor.Name, actor.Gender, actor.Birthdate, actor.ID).
+		WithArgs(actor.Name, actor.Gender, actor.Birthdate, "", "", "", nil, "", actor.ID, 0).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO actor_revisions").WithArgs(actor.ID, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	err = orm.UpdateActor(actor)
 	if err != nil {
@@ -100,6 +110,39 @@ func TestUpdateActor_Success(t *testing.T) {
 	}
 }
 
+func TestUpdateActor_ReplacesAliasesAndLinks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	actor := types.Actor{
+		ID:        1,
+		Name:      "Jane Doe",
+		Gender:    "Female",
+		Birthdate: "1930-02-02",
+		DeathDate: "2001-07-10",
+		Aliases:   []string{"J. Doe"},
+		Links:     []types.ActorLink{},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE actors").
+		WithArgs(actor.Name, actor.Gender, actor.Birthdate, "", "", "", "2001-07-10", "", actor.ID, 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM actor_aliases WHERE actor_id = \\$1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO actor_aliases \\(actor_id, name\\)").WithArgs(1, "J. Doe").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM actor_links WHERE actor_id = \\$1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO actor_revisions").WithArgs(actor.ID, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, orm.UpdateActor(actor))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestUpdateActor_Error(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -109,8 +152,10 @@ func TestUpdateActor_Error(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
+	mock.ExpectBegin()
 	mock.ExpectExec("UPDATE actors SET").
 		WillReturnError(fmt.Errorf("database error"))
+	mock.ExpectRollback()
 
 	err = orm.UpdateActor(types.Actor{})
 
@@ -133,13 +178,11 @@ func TestDeleteActorByID_Success(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    mock.ExpectExec("DELETE FROM film_actors WHERE actor_id = \\$1").
-        WithArgs(1).
-        WillReturnResult(sqlmock.NewResult(0, 0))
-
-    mock.ExpectExec("DELETE FROM actors WHERE id = \\$1").
+    mock.ExpectBegin()
+    mock.ExpectExec("UPDATE actors SET deleted_at = NOW\\(\\) WHERE id = \\$1 AND deleted_at IS NULL").
         WithArgs(1).
         WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectCommit()
 
     err = orm.DeleteActorByID(1)
     if err != nil {
@@ -147,7 +190,7 @@ func TestDeleteActorByID_Success(t *testing.T) {
     }
 }
 
-func TestDeleteActorByID_DeleteFilmActorsError(t *testing.T) {
+func TestDeleteActorByID_Error(t *testing.T) {
     db, mock, err := sqlmock.New()
     if err != nil {
         t.Fatalf("Failed to create mock database connection: %v", err)
@@ -156,9 +199,11 @@ func TestDeleteActorByID_DeleteFilmActorsError(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    mock.ExpectExec("DELETE FROM film_actors WHERE actor_id = \\$1").
+    mock.ExpectBegin()
+    mock.ExpectExec("UPDATE actors SET deleted_at").
         WithArgs(1).
         WillReturnError(errors.New("ошибка удаления"))
+    mock.ExpectRollback()
 
     err = orm.DeleteActorByID(1)
     if err == nil || err.Error() != "ошибка удаления" {
@@ -180,6 +225,12 @@ func compareStringSlices(slice1, slice2 []string) bool {
 	return true
 }
 
+// utility for test: the film list queries load the alternative titles of the films found
+func expectAltTitles(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT film_id, title, region, type FROM film_alt_titles WHERE film_id = ANY").
+		WillReturnRows(sqlmock.NewRows([]string{"film_id", "title", "region", "type"}))
+}
+
 // utility func for get
 func TestGetFilmsWithActor_Success(t *testing.T) {
 	db, mock, err := sqlmock.New()
@@ -236,11 +287,11 @@ func TestGetActors_Success_NoFilms(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "name"}).
-		AddRow(1, "Actor 1").
-		AddRow(2, "Actor 2")
+	rows := sqlmock.NewRows([]string{"id", "name", "version", "photo_key"}).
+		AddRow(1, "Actor 1", 1, "").
+		AddRow(2, "Actor 2", 1, "")
 
-	mock.ExpectQuery("SELECT id, name FROM actors").
+	mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors").
 		WillReturnRows(rows)
 
 	mock.ExpectQuery("SELECT f.title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id WHERE fa.actor_id = \\$1").
@@ -280,11 +331,11 @@ func TestGetActors_Success_WithFilms(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "name"}).
-		AddRow(1, "Actor 1").
-		AddRow(2, "Actor 2")
+	rows := sqlmock.NewRows([]string{"id", "name", "version", "photo_key"}).
+		AddRow(1, "Actor 1", 1, "").
+		AddRow(2, "Actor 2", 1, "")
 
-	mock.ExpectQuery("SELECT id, name FROM actors").
+	mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors").
 		WillReturnRows(rows)
 
 	mock.ExpectQuery("SELECT f.title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id WHERE fa.actor_id = \\$1").
@@ -327,7 +378,7 @@ func TestGetActors_DBError(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	mock.ExpectQuery("SELECT id, name FROM actors").
+	mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors").
 		WillReturnError(errors.New("database error"))
 
 	_, err = orm.GetActors()
@@ -344,11 +395,11 @@ func TestGetActors_ScanError(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "name"}).
-		AddRow(1, "Actor 1").
-		AddRow(2, "Actor 2")
+	rows := sqlmock.NewRows([]string{"id", "name", "version", "photo_key"}).
+		AddRow(1, "Actor 1", 1, "").
+		AddRow(2, "Actor 2", 1, "")
 
-	mock.ExpectQuery("SELECT id, name FROM actors").
+	mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors").
 		WillReturnRows(rows)
 
 	mock.ExpectQuery("SELECT f.title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id WHERE fa.actor_id = \\$1").
@@ -369,11 +420,11 @@ func TestGetActors_GetFilmsError(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "name"}).
-		AddRow(1, "Actor 1").
-		AddRow(2, "Actor 2")
+	rows := sqlmock.NewRows([]string{"id", "name", "version", "photo_key"}).
+		AddRow(1, "Actor 1", 1, "").
+		AddRow(2, "Actor 2", 1, "")
 
-	mock.ExpectQuery("SELECT id, name FROM actors").
+	mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors").
 		WillReturnRows(rows)
 
 	mock.ExpectQuery("SELECT f.title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id WHERE fa.actor_id = \\$1").
@@ -398,12 +449,12 @@ func TestGetActorsWithFragment_Success_NoFilms(t *testing.T) {
 
 	actorFragment := "John"
 
-	rows := sqlmock.NewRows([]string{"id", "name"}).
-		AddRow(1, "John Doe").
-		AddRow(2, "Johnny Walker")
+	rows := sqlmock.NewRows([]string{"id", "name", "version", "photo_key"}).
+		AddRow(1, "John Doe", 1, "").
+		AddRow(2, "Johnny Walker", 1, "")
 
-	mock.ExpectQuery("SELECT id, name FROM actors WHERE name LIKE ?").
-		WithArgs("%" + actorFragment + "%").
+	mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors a WHERE \\(a.name LIKE (.+) OR a.id IN \\(SELECT actor_id FROM actor_aliases").
+		WithArgs(actorFragment).
 		WillReturnRows(rows)
 
 	mock.ExpectQuery("SELECT f.title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id WHERE fa.actor_id = \\$1").
@@ -446,12 +497,12 @@ func TestGetActorsWithFragment_Success_WithFilms(t *testing.T) {
 
 	actorFragment := "John"
 
-	rows := sqlmock.NewRows([]string{"id", "name"}).
-		AddRow(1, "John Doe").
-		AddRow(2, "Johnny Walker")
+	rows := sqlmock.NewRows([]string{"id", "name", "version", "photo_key"}).
+		AddRow(1, "John Doe", 1, "").
+		AddRow(2, "Johnny Walker", 1, "")
 
-	mock.ExpectQuery("SELECT id, name FROM actors WHERE name LIKE ?").
-		WithArgs("%" + actorFragment + "%").
+	mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors a WHERE \\(a.name LIKE (.+) OR a.id IN \\(SELECT actor_id FROM actor_aliases").
+		WithArgs(actorFragment).
 		WillReturnRows(rows)
 
 	mock.ExpectQuery("SELECT f.title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id WHERE fa.actor_id = \\$1").
@@ -494,8 +545,8 @@ func TestGetActorsWithFragment_Error_DB(t *testing.T) {
 
 	actorFragment := "John"
 
-	mock.ExpectQuery("SELECT id, name FROM actors WHERE name LIKE ?").
-		WithArgs("%" + actorFragment + "%").
+	mock.ExpectQuery("SELECT id, name, version, photo_key FROM actors a WHERE \\(a.name LIKE (.+) OR a.id IN \\(SELECT actor_id FROM actor_aliases").
+		WithArgs(actorFragment).
 		WillReturnError(errors.New("database error"))
 
 	_, err = orm.GetActorsWithFragment(actorFragment)
@@ -523,11 +574,14 @@ func TestCreateFilm_Success(t *testing.T) {
 		Actors:      []int{1, 2, 3},
 	}
 
-	mock.ExpectQuery("INSERT INTO films").WithArgs(mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO films").WithArgs(mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating, mockFilm.OriginalTitle, nil, "released", nil, "", nil, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
 	for _, actorID := range mockFilm.Actors {
 		mock.ExpectExec("INSERT INTO film_actors").WithArgs(1, actorID).WillReturnResult(sqlmock.NewResult(1, 1))
 	}
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(1, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	filmID, err := orm.CreateFilm(mockFilm)
 
@@ -537,6 +591,44 @@ func TestCreateFilm_Success(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestCreateFilm_WithDetails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	mockFilm := types.Film{
+		Title:       "Interstellar",
+		ReleaseDate: "2014-11-07",
+		Rating:      8.6,
+		Runtime:     169,
+		Status:      "released",
+		Budget:      &types.Money{Amount: 165000000, Currency: "USD"},
+		Countries:   []string{"US", "GB"},
+		Languages:   []string{},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO films").
+		WithArgs(mockFilm.Title, "", mockFilm.ReleaseDate, mockFilm.Rating, "", 169, "released", int64(165000000), "USD", nil, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectExec("DELETE FROM film_countries WHERE film_id = \\$1").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO film_countries \\(film_id, country\\)").WithArgs(4, "US").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO film_countries \\(film_id, country\\)").WithArgs(4, "GB").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM film_languages WHERE film_id = \\$1").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(4, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	filmID, err := orm.CreateFilm(mockFilm)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 4, filmID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestCreateFilm_DBError(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -554,7 +646,9 @@ func TestCreateFilm_DBError(t *testing.T) {
 		Actors:      []int{1, 2, 3},
 	}
 
-	mock.ExpectQuery("INSERT INTO films").WithArgs(mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating).WillReturnError(errors.New("database error"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO films").WithArgs(mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating, mockFilm.OriginalTitle, nil, "released", nil, "", nil, "").WillReturnError(errors.New("database error"))
+	mock.ExpectRollback()
 
 	filmID, err := orm.CreateFilm(mockFilm)
 
@@ -582,7 +676,14 @@ func TestUpdateFilm_Success(t *testing.T) {
 		Rating:      9.0,
 	}
 
-	mock.ExpectExec("UPDATE films").WithArgs(mockFilm.ID, mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, description, to_char\\(release_date, 'YYYY-MM-DD'\\), rating, version, original_title, (.+) FROM films WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}).
+			AddRow(1, "Stored Title", "", "2024-03-16", 5.0, 1, "", 175, "announced", 6000000, "USD", nil, ""))
+	mock.ExpectExec("UPDATE films").WithArgs(mockFilm.ID, mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating, mockFilm.OriginalTitle, 175, "announced", 6000000, "USD", nil, "", 0).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(mockFilm.ID, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	err = orm.UpdateFilm(mockFilm)
 
@@ -592,6 +693,41 @@ func TestUpdateFilm_Success(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUpdateFilm_ReplacesAltTitles(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	mockFilm := types.Film{
+		ID:            1,
+		Title:         "Spirited Away",
+		OriginalTitle: "Sen to Chihiro no kamikakushi",
+		ReleaseDate:   "2001-07-20",
+		Rating:        8.6,
+		AltTitles:     []types.AltTitle{{Title: "Unten Chihiro", Region: "DE", Type: "localized"}},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, description, to_char\\(release_date, 'YYYY-MM-DD'\\), rating, version, original_title, (.+) FROM films WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}).
+			AddRow(1, "Stored Title", "", "2024-03-16", 5.0, 1, "", 175, "announced", 6000000, "USD", nil, ""))
+	mock.ExpectExec("UPDATE films").WithArgs(mockFilm.ID, mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating, mockFilm.OriginalTitle, 175, "announced", 6000000, "USD", nil, "", 0).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM film_alt_titles WHERE film_id = \\$1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO film_alt_titles \\(film_id, title, region, type\\)").WithArgs(1, "Unten Chihiro", "DE", "localized").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(mockFilm.ID, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = orm.UpdateFilm(mockFilm)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestUpdateFilm_DBError(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -609,7 +745,13 @@ func TestUpdateFilm_DBError(t *testing.T) {
 		Rating:      9.0,
 	}
 
-	mock.ExpectExec("UPDATE films").WithArgs(mockFilm.ID, mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating).WillReturnError(errors.New("database error"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, description, to_char\\(release_date, 'YYYY-MM-DD'\\), rating, version, original_title, (.+) FROM films WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}).
+			AddRow(1, "Stored Title", "", "2024-03-16", 5.0, 1, "", 175, "announced", 6000000, "USD", nil, ""))
+	mock.ExpectExec("UPDATE films").WithArgs(mockFilm.ID, mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating, mockFilm.OriginalTitle, 175, "announced", 6000000, "USD", nil, "", 0).WillReturnError(errors.New("database error"))
+	mock.ExpectRollback()
 
 	err = orm.UpdateFilm(mockFilm)
 	assert.Error(t, err)
@@ -617,6 +759,85 @@ func TestUpdateFilm_DBError(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUpdateFilm_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	mockFilm := types.Film{ID: 42, Title: "Missing", ReleaseDate: "2024-03-16", Rating: 5.0}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, description, to_char\\(release_date, 'YYYY-MM-DD'\\), rating, version, original_title, (.+) FROM films WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(42).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	err = filmOrm.UpdateFilm(mockFilm)
+	assert.ErrorIs(t, err, orm.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateFilm_VersionConflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	mockFilm := types.Film{ID: 1, Title: "Stale", ReleaseDate: "2024-03-16", Rating: 5.0, Version: 2}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, title, description, to_char\\(release_date, 'YYYY-MM-DD'\\), rating, version, original_title, (.+) FROM films WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "original_title", "runtime", "status", "budget", "budget_currency", "box_office", "box_office_currency"}).
+			AddRow(1, "Stored Title", "", "2024-03-16", 5.0, 1, "", 175, "announced", 6000000, "USD", nil, ""))
+	mock.ExpectExec("UPDATE films").
+		WithArgs(mockFilm.ID, mockFilm.Title, mockFilm.Description, mockFilm.ReleaseDate, mockFilm.Rating, mockFilm.OriginalTitle, 175, "announced", 6000000, "USD", nil, "", 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM films WHERE id = \\$1 AND deleted_at IS NULL\\)").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	err = filmOrm.UpdateFilm(mockFilm)
+	assert.ErrorIs(t, err, orm.ErrConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateFilm_ReplacesDetails(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	mockFilm := types.Film{
+		ID: 1, Title: "The Godfather", ReleaseDate: "1972-03-24", Rating: 9.2,
+		Runtime: 175, Status: "released",
+		Budget:    &types.Money{Amount: 6000000, Currency: "USD"},
+		BoxOffice: &types.Money{Amount: 250000000, Currency: "USD"},
+	}
+
+	// nothing is omitted, the stored film is not read
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE films").
+		WithArgs(1, "The Godfather", "", "1972-03-24", 9.2, "", 175, "released", 6000000, "USD", 250000000, "USD", 0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(1, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, filmOrm.UpdateFilm(mockFilm))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 // get
 func TestGetFilms_Success_DefaultSortAscending(t *testing.T) {
 	db, mock, err := sqlmock.New()
@@ -627,14 +848,15 @@ func TestGetFilms_Success_DefaultSortAscending(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-	mock.ExpectQuery("SELECT \\* FROM films ORDER BY rating ASC").
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL ORDER BY rating ASC").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
-	films, err := orm.GetFilms("", true)
+	films, err := orm.GetFilms("", true, types.FilmFilter{})
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -664,14 +886,15 @@ func TestGetFilms_Success_SortByTitleAscending(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-	mock.ExpectQuery("SELECT \\* FROM films ORDER BY title ASC").
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL ORDER BY title ASC").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
-	films, err := orm.GetFilms("title", true)
+	films, err := orm.GetFilms("title", true, types.FilmFilter{})
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -701,14 +924,15 @@ func TestGetFilms_Success_SortByReleaseDateDescending(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "").
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "")
 
-	mock.ExpectQuery("SELECT \\* FROM films ORDER BY release_date DESC").
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL ORDER BY release_date DESC").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
-	films, err := orm.GetFilms("release_date", false)
+	films, err := orm.GetFilms("release_date", false, types.FilmFilter{})
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -738,13 +962,14 @@ func TestSearchFilmsByFragment_Success_WithActorFragment(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-	mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE a.name LIKE '%' \\|\\| \\$1 \\|\\| '%' UNION ALL SELECT id, title, description, release_date, rating FROM films WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'").
+	mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating, f.version, f.poster_key, f.original_title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE \\(a.name LIKE '%' \\|\\| \\$1 \\|\\| '%' OR a.id IN \\(SELECT actor_id FROM actor_aliases WHERE name LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND f.deleted_at IS NULL AND a.deleted_at IS NULL UNION ALL SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE \\(title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR original_title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR id IN \\(SELECT film_id FROM film_translations WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%' UNION SELECT film_id FROM film_alt_titles WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND deleted_at IS NULL").
 		WithArgs("ActorFragment").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
 	films, err := orm.SearchFilmsByFragment("ActorFragment")
 	if err != nil {
@@ -775,13 +1000,14 @@ func TestSearchFilmsByFragment_Success_WithTitleFragment(t *testing.T) {
 	defer db.Close()
 
 	orm := orm.NewORM(db)
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-	mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE a.name LIKE '%' \\|\\| \\$1 \\|\\| '%' UNION ALL SELECT id, title, description, release_date, rating FROM films WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'").
+	mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating, f.version, f.poster_key, f.original_title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE \\(a.name LIKE '%' \\|\\| \\$1 \\|\\| '%' OR a.id IN \\(SELECT actor_id FROM actor_aliases WHERE name LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND f.deleted_at IS NULL AND a.deleted_at IS NULL UNION ALL SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE \\(title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR original_title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR id IN \\(SELECT film_id FROM film_translations WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%' UNION SELECT film_id FROM film_alt_titles WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND deleted_at IS NULL").
 		WithArgs("TitleFragment").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
 	films, err := orm.SearchFilmsByFragment("TitleFragment")
 	if err != nil {
@@ -813,13 +1039,14 @@ func TestSearchFilmsByFragment_Success_WithBothFragment(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+		AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-	mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE a.name LIKE '%' \\|\\| \\$1 \\|\\| '%' UNION ALL SELECT id, title, description, release_date, rating FROM films WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'").
+	mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating, f.version, f.poster_key, f.original_title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE \\(a.name LIKE '%' \\|\\| \\$1 \\|\\| '%' OR a.id IN \\(SELECT actor_id FROM actor_aliases WHERE name LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND f.deleted_at IS NULL AND a.deleted_at IS NULL UNION ALL SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE \\(title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR original_title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR id IN \\(SELECT film_id FROM film_translations WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%' UNION SELECT film_id FROM film_alt_titles WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND deleted_at IS NULL").
 		WithArgs("Fragment").
 		WillReturnRows(rows)
+	expectAltTitles(mock)
 
 	films, err := orm.SearchFilmsByFragment("Fragment")
 	if err != nil {
@@ -851,13 +1078,14 @@ func TestSearchFilmsByActorFragment_Success(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-        AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-        AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+    rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+        AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+        AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-    mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE a.name LIKE '%' \\|\\| \\$1 \\|\\| '%'").
+    mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating, f.version, f.poster_key, f.original_title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE \\(a.name LIKE '%' \\|\\| \\$1 \\|\\| '%' OR a.id IN \\(SELECT actor_id FROM actor_aliases WHERE name LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND f.deleted_at IS NULL AND a.deleted_at IS NULL").
         WithArgs("Actor").
         WillReturnRows(rows)
+    expectAltTitles(mock)
 
     films, err := orm.SearchFilmsByActorFragment("Actor")
     if err != nil {
@@ -889,9 +1117,9 @@ func TestSearchFilmsByActorFragment_EmptyResult(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"})
+    rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"})
 
-    mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE a.name LIKE '%' \\|\\| \\$1 \\|\\| '%'").
+    mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating, f.version, f.poster_key, f.original_title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE \\(a.name LIKE '%' \\|\\| \\$1 \\|\\| '%' OR a.id IN \\(SELECT actor_id FROM actor_aliases WHERE name LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND f.deleted_at IS NULL AND a.deleted_at IS NULL").
         WithArgs("Actor").
         WillReturnRows(rows)
 
@@ -914,7 +1142,7 @@ func TestSearchFilmsByActorFragment_DatabaseError(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE a.name LIKE '%' \\|\\| \\$1 \\|\\| '%'").
+    mock.ExpectQuery("SELECT f.id, f.title, f.description, f.release_date, f.rating, f.version, f.poster_key, f.original_title FROM films AS f JOIN film_actors AS fa ON f.id = fa.film_id JOIN actors AS a ON fa.actor_id = a.id WHERE \\(a.name LIKE '%' \\|\\| \\$1 \\|\\| '%' OR a.id IN \\(SELECT actor_id FROM actor_aliases WHERE name LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND f.deleted_at IS NULL AND a.deleted_at IS NULL").
         WithArgs("Actor").
         WillReturnError(errors.New("database error"))
 
@@ -933,13 +1161,14 @@ func TestSearchFilmsByTitleFragment_Success(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"}).
-        AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5).
-        AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0)
+    rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+        AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 1, "", "").
+        AddRow(2, "Film 2", "Description 2", "2023-01-01", 8.0, 1, "", "")
 
-    mock.ExpectQuery("SELECT \\* FROM films WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'").
+    mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE \\(title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR original_title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR id IN \\(SELECT film_id FROM film_translations WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%' UNION SELECT film_id FROM film_alt_titles WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND deleted_at IS NULL").
         WithArgs("Fragment").
         WillReturnRows(rows)
+    expectAltTitles(mock)
 
     films, err := orm.SearchFilmsByTitleFragment("Fragment")
     if err != nil {
@@ -962,6 +1191,38 @@ func TestSearchFilmsByTitleFragment_Success(t *testing.T) {
     }
 }
 
+func TestSearchFilmsByTitleFragment_OriginalAndAltTitles(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE").
+		WithArgs("Amel").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+			AddRow(1, "Amelie", "Description 1", "2001-04-25", 8.3, 1, "", "Le Fabuleux Destin d'Amélie Poulain").
+			AddRow(2, "Amelie 2", "Description 2", "2003-01-01", 5.0, 1, "", ""))
+	mock.ExpectQuery("SELECT film_id, title, region, type FROM film_alt_titles WHERE film_id = ANY\\(\\$1\\) ORDER BY id").
+		WithArgs("{1,2}").
+		WillReturnRows(sqlmock.NewRows([]string{"film_id", "title", "region", "type"}).
+			AddRow(1, "Amélie", "", "working").
+			AddRow(1, "Die fabelhafte Welt der Amélie", "DE", "localized"))
+
+	films, err := orm.SearchFilmsByTitleFragment("Amel")
+	assert.NoError(t, err)
+	assert.Len(t, films, 2)
+	assert.Equal(t, "Le Fabuleux Destin d'Amélie Poulain", films[0].OriginalTitle)
+	assert.Equal(t, []types.AltTitle{
+		{Title: "Amélie", Region: "", Type: "working"},
+		{Title: "Die fabelhafte Welt der Amélie", Region: "DE", Type: "localized"},
+	}, films[0].AltTitles)
+	assert.Empty(t, films[1].AltTitles)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestSearchFilmsByTitleFragment_EmptyResult(t *testing.T) {
     db, mock, err := sqlmock.New()
     if err != nil {
@@ -971,9 +1232,9 @@ func TestSearchFilmsByTitleFragment_EmptyResult(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating"})
+    rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"})
 
-    mock.ExpectQuery("SELECT \\* FROM films WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'").
+    mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE \\(title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR original_title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR id IN \\(SELECT film_id FROM film_translations WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%' UNION SELECT film_id FROM film_alt_titles WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND deleted_at IS NULL").
         WithArgs("Fragment").
         WillReturnRows(rows)
 
@@ -996,7 +1257,7 @@ func TestSearchFilmsByTitleFragment_DatabaseError(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    mock.ExpectQuery("SELECT \\* FROM films WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'").
+    mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE \\(title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR original_title LIKE '%' \\|\\| \\$1 \\|\\| '%' OR id IN \\(SELECT film_id FROM film_translations WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%' UNION SELECT film_id FROM film_alt_titles WHERE title LIKE '%' \\|\\| \\$1 \\|\\| '%'\\)\\) AND deleted_at IS NULL").
         WithArgs("Fragment").
         WillReturnError(errors.New("database error"))
 
@@ -1015,13 +1276,11 @@ func TestDeleteFilmByID_Success(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    mock.ExpectExec("DELETE FROM film_actors WHERE film_id = \\$1").
-        WithArgs(1).
-        WillReturnResult(sqlmock.NewResult(0, 0))
-
-    mock.ExpectExec("DELETE FROM films WHERE id = \\$1").
+    mock.ExpectBegin()
+    mock.ExpectExec("UPDATE films SET deleted_at = NOW\\(\\) WHERE id = \\$1 AND deleted_at IS NULL").
         WithArgs(1).
         WillReturnResult(sqlmock.NewResult(0, 1))
+    mock.ExpectCommit()
 
     err = orm.DeleteFilmByID(1)
     if err != nil {
@@ -1029,7 +1288,7 @@ func TestDeleteFilmByID_Success(t *testing.T) {
     }
 }
 
-func TestDeleteFilmByID_DeleteFilmActorsError(t *testing.T) {
+func TestDeleteFilmByID_Error(t *testing.T) {
     db, mock, err := sqlmock.New()
     if err != nil {
         t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
@@ -1038,9 +1297,11 @@ func TestDeleteFilmByID_DeleteFilmActorsError(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    mock.ExpectExec("DELETE FROM film_actors WHERE film_id = \\$1").
+    mock.ExpectBegin()
+    mock.ExpectExec("UPDATE films SET deleted_at = NOW\\(\\) WHERE id = \\$1 AND deleted_at IS NULL").
         WithArgs(1).
         WillReturnError(errors.New("delete error"))
+    mock.ExpectRollback()
 
     err = orm.DeleteFilmByID(1)
     if err == nil || err.Error() != "delete error" {
@@ -1048,27 +1309,209 @@ func TestDeleteFilmByID_DeleteFilmActorsError(t *testing.T) {
     }
 }
 
-func TestDeleteFilmByID_DeleteFilmError(t *testing.T) {
-    db, mock, err := sqlmock.New()
-    if err != nil {
-        t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
-    }
-    defer db.Close()
+func TestDeleteFilmByID_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
 
-    orm := orm.NewORM(db)
+	filmOrm := orm.NewORM(db)
 
-    mock.ExpectExec("DELETE FROM film_actors WHERE film_id = \\$1").
-        WithArgs(1).
-        WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE films SET deleted_at").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
 
-    mock.ExpectExec("DELETE FROM films WHERE id = \\$1").
-        WithArgs(1).
-        WillReturnError(errors.New("delete error"))
+	err = filmOrm.DeleteFilmByID(1)
+	assert.ErrorIs(t, err, orm.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
-    err = orm.DeleteFilmByID(1)
-    if err == nil || err.Error() != "delete error" {
-        t.Errorf("Expected delete error, got %v", err)
-    }
+func TestDeleteActorByID_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	actorOrm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE actors SET deleted_at").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err = actorOrm.DeleteActorByID(7)
+	assert.ErrorIs(t, err, orm.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRestoreFilmByID_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE films SET deleted_at = NULL WHERE id = \\$1 AND deleted_at IS NOT NULL").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = filmOrm.RestoreFilmByID(1)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRestoreActorByID_NotInTrash(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	actorOrm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE actors SET deleted_at = NULL").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err = actorOrm.RestoreActorByID(3)
+	assert.ErrorIs(t, err, orm.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetDeletedFilms_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	rows := sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "deleted_at"}).
+		AddRow(1, "Film 1", "Description 1", "2022-01-01", 7.5, 2, "", "2024-03-20T10:00:00Z")
+
+	mock.ExpectQuery("SELECT (.+) FROM films WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC").
+		WillReturnRows(rows)
+
+	films, err := filmOrm.GetDeletedFilms()
+	assert.NoError(t, err)
+	assert.Len(t, films, 1)
+	assert.Equal(t, "Film 1", films[0].Title)
+	if assert.NotNil(t, films[0].DeletedAt) {
+		assert.Equal(t, "2024-03-20T10:00:00Z", *films[0].DeletedAt)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPurgeDeleted_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+	before := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("DELETE FROM films WHERE deleted_at IS NOT NULL AND deleted_at < \\$1 RETURNING poster_key").
+		WithArgs(before).
+		WillReturnRows(sqlmock.NewRows([]string{"poster_key"}).AddRow("films/1/poster/ab12").AddRow(""))
+	mock.ExpectQuery("DELETE FROM actors WHERE deleted_at IS NOT NULL AND deleted_at < \\$1 RETURNING photo_key").
+		WithArgs(before).
+		WillReturnRows(sqlmock.NewRows([]string{"photo_key"}).AddRow("actors/4/photo/cd34"))
+
+	purged, imageKeys, err := filmOrm.PurgeDeleted(before)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+	assert.Equal(t, []string{"films/1/poster/ab12", "actors/4/photo/cd34"}, imageKeys)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateActor_WithAudit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	actorOrm := orm.NewORM(db).WithAudit(types.AuditMeta{Username: "admin", RequestID: "req-1", IP: "127.0.0.1"})
+
+	actor := types.Actor{ID: 1, Name: "Jane Doe", Gender: "Female", Birthdate: "1985-02-02"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, name, gender, (.+) FROM actors WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "gender", "date_of_birth", "version", "biography", "birthplace", "nationality", "date_of_death", "death_place"}).AddRow(1, "Jane Roe", "Female", "1985-02-02", 1, "", "", "", "", ""))
+	mock.ExpectExec("UPDATE actors").
+		WithArgs(actor.Name, actor.Gender, actor.Birthdate, "", "", "", nil, "", actor.ID, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO actor_revisions").WithArgs(1, "admin", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("admin", "update", "actor", 1, []byte(`{"name":"Jane Roe","version":1}`), []byte(`{"name":"Jane Doe","version":2}`), "req-1", "127.0.0.1", nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = actorOrm.UpdateActor(actor)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateFilm_AuditErrorRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db).WithAudit(types.AuditMeta{Username: "admin"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO films").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(5, "admin", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WillReturnError(errors.New("audit error"))
+	mock.ExpectRollback()
+
+	filmID, err := filmOrm.CreateFilm(types.Film{Title: "Film", ReleaseDate: "2024-01-01"})
+	assert.Error(t, err)
+	assert.Equal(t, 0, filmID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAuditLog_Filters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	auditOrm := orm.NewORM(db)
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	rows := sqlmock.NewRows([]string{"id", "username", "action", "entity_type", "entity_id", "before_data", "after_data", "request_id", "ip", "created_at"}).
+		AddRow(1, "admin", "update", "film", 3, []byte(`{"title":"Old"}`), []byte(`{"title":"New"}`), "req-1", "127.0.0.1", createdAt)
+
+	mock.ExpectQuery("FROM audit_log WHERE 1 = 1 AND username = \\$1 AND entity_type = \\$2 AND created_at >= \\$3 ORDER BY created_at DESC, id DESC LIMIT \\$4").
+		WithArgs("admin", "film", from, 10).
+		WillReturnRows(rows)
+
+	entries, err := auditOrm.GetAuditLog(types.AuditFilter{Username: "admin", EntityType: "film", From: from, Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "2024-03-02T10:00:00Z", entries[0].CreatedAt)
+	assert.JSONEq(t, `{"title":"New"}`, string(entries[0].After))
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 // endpoint /users
@@ -1121,9 +1564,11 @@ func TestCreateUser_Success(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	mock.ExpectExec("INSERT INTO users").WithArgs("test_username", "test_email", "hashed_password").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO users").WithArgs("test_username", "test_email", "hashed_password").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
 
-	err = orm.CreateUser("test_username", "test_email", "hashed_password")
+	_, err = orm.CreateUser("test_username", "test_email", "hashed_password")
 
 	assert.NoError(t, err)
 	err = mock.ExpectationsWereMet()
@@ -1139,16 +1584,18 @@ func TestCreateUser_DBError(t *testing.T) {
 
 	orm := orm.NewORM(db)
 
-	mock.ExpectExec("INSERT INTO users").WithArgs("test_username", "test_email", "hashed_password").WillReturnError(errors.New("database error"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO users").WithArgs("test_username", "test_email", "hashed_password").WillReturnError(errors.New("database error"))
+	mock.ExpectRollback()
 
-	err = orm.CreateUser("test_username", "test_email", "hashed_password")
+	_, err = orm.CreateUser("test_username", "test_email", "hashed_password")
 	assert.Error(t, err)
 	err = mock.ExpectationsWereMet()
 	assert.NoError(t, err)
 }
 
 // utility function
-func TestGetUserPasswordByEmail_Success(t *testing.T) {
+func TestGetUserByLogin_Success(t *testing.T) {
     db, mock, err := sqlmock.New()
     if err != nil {
         t.Fatalf("Ошибка '%s' при инициализации mock базы данных", err)
@@ -1157,46 +1604,44 @@ func TestGetUserPasswordByEmail_Success(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    email := "test@example.com"
-    expectedPassword := "hashedPassword"
-    expectedAdminFlag := true
+    login := "test@example.com"
 
-    mock.ExpectQuery("SELECT password, adminflag FROM users WHERE email=?").
-        WithArgs(email).
-        WillReturnRows(sqlmock.NewRows([]string{"password", "adminflag"}).AddRow(expectedPassword, expectedAdminFlag))
+    mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users WHERE email = \\$1 OR username = \\$1").
+        WithArgs(login).
+        WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}).AddRow(3, "testuser", login, true, true, false, "hashedPassword"))
 
-    storedPassword, adminflag, err := orm.GetUserPasswordByEmail(email)
+    user, err := orm.GetUserByLogin(login)
     if err != nil {
         t.Errorf("Неожиданная ошибка: %v", err)
     }
 
-    if storedPassword != expectedPassword || adminflag != expectedAdminFlag {
+    if user.ID != 3 || user.Username != "testuser" || user.Password != "hashedPassword" || !user.Admin || !user.Verified {
         t.Errorf("Полученные данные не соответствуют ожидаемым")
     }
 }
 
-func TestGetUserPasswordByEmail_UserNotFound(t *testing.T) {
+func TestGetUserByLogin_UserNotFound(t *testing.T) {
     db, mock, err := sqlmock.New()
     if err != nil {
         t.Fatalf("Ошибка '%s' при инициализации mock базы данных", err)
     }
     defer db.Close()
 
-    orm := orm.NewORM(db)
+    userOrm := orm.NewORM(db)
 
-    email := "nonexistent@example.com"
+    login := "nonexistent"
 
-    mock.ExpectQuery("SELECT password, adminflag FROM users WHERE email=?").
-        WithArgs(email).
+    mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").
+        WithArgs(login).
         WillReturnError(sql.ErrNoRows)
 
-    _, _, err = orm.GetUserPasswordByEmail(email)
-    if err == nil || !errors.Is(err, sql.ErrNoRows) {
+    _, err = userOrm.GetUserByLogin(login)
+    if !errors.Is(err, orm.ErrNotFound) {
         t.Errorf("Ожидалась ошибка о отсутствии пользователя, получено %v", err)
     }
 }
 
-func TestGetUserPasswordByEmail_DatabaseError(t *testing.T) {
+func TestGetUserByLogin_DatabaseError(t *testing.T) {
     db, mock, err := sqlmock.New()
     if err != nil {
         t.Fatalf("Ошибка '%s' при инициализации mock базы данных", err)
@@ -1205,14 +1650,678 @@ func TestGetUserPasswordByEmail_DatabaseError(t *testing.T) {
 
     orm := orm.NewORM(db)
 
-    email := "test@example.com"
+    login := "test@example.com"
 
-    mock.ExpectQuery("SELECT password, adminflag FROM users WHERE email=?").
-        WithArgs(email).
+    mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").
+        WithArgs(login).
         WillReturnError(errors.New("ошибка базы данных"))
 
-    _, _, err = orm.GetUserPasswordByEmail(email)
+    _, err = orm.GetUserByLogin(login)
     if err == nil || err.Error() != "ошибка базы данных" {
         t.Errorf("Ожидалась ошибка базы данных, получено %v", err)
     }
-}
\ No newline at end of file
+}
+
+// endpoint /user/me
+func TestUpdateUser_NewEmail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	userRows := []string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(userRows).AddRow(3, "testuser", "old@example.com", false, true, false, "hash"))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users WHERE id <> \\$1").WithArgs(3, "testuser", "new@example.com").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("UPDATE users SET username = \\$1, email = \\$2, password = \\$3").WithArgs("testuser", "new@example.com", "hash", true, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE user_tokens SET used_at = NOW\\(\\) WHERE user_id = \\$1 AND used_at IS NULL").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("testuser", "update", "user", 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	user, err := orm.WithAudit(types.AuditMeta{Username: "testuser"}).UpdateUser(3, "", "new@example.com", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "new@example.com", user.Email)
+	assert.Equal(t, "testuser", user.Username)
+	assert.False(t, user.Verified)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateUser_UsernameTaken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}).AddRow(3, "testuser", "test@example.com", false, true, false, "hash"))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users WHERE id <> \\$1").WithArgs(3, "taken", "test@example.com").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	_, err = userOrm.UpdateUser(3, "taken", "", "")
+	assert.ErrorIs(t, err, orm.ErrConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// endpoint /film/{id}/history
+
+func TestGetFilmHistory_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT revision, username, created_at FROM film_revisions WHERE film_id = \\$1 ORDER BY revision DESC").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "username", "created_at"}).
+			AddRow(2, "admin", createdAt).
+			AddRow(1, "", createdAt))
+
+	revisions, err := filmOrm.GetFilmHistory(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []types.Revision{
+		{Revision: 2, Username: "admin", CreatedAt: "2024-03-01T12:00:00Z"},
+		{Revision: 1, Username: "", CreatedAt: "2024-03-01T12:00:00Z"},
+	}, revisions)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFilmRevision_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	mock.ExpectQuery("SELECT revision, username, created_at, snapshot FROM film_revisions").
+		WithArgs(1, 7).
+		WillReturnError(sql.ErrNoRows)
+
+	_, err = filmOrm.GetFilmRevision(1, 7)
+	assert.ErrorIs(t, err, orm.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevertFilm_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	snapshot := `{"id": 1, "title": "Old Title", "description": "Old", "release_date": "2020-01-01", "rating": 7.5, "version": 2, "actors": [3, 4]}`
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT revision, username, created_at, snapshot FROM film_revisions").
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "username", "created_at", "snapshot"}).AddRow(2, "admin", time.Now(), []byte(snapshot)))
+	mock.ExpectExec("DELETE FROM film_actors WHERE film_id = \\$1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO film_actors").WithArgs(1, 3).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO film_actors").WithArgs(1, 4).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE films").WithArgs(1, "Old Title", "Old", "2020-01-01", 7.5, "", nil, "released", nil, "", nil, "", 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(1, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = filmOrm.RevertFilm(1, 2, 5)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevertFilm_VersionConflict(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT revision, username, created_at, snapshot FROM film_revisions").
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "username", "created_at", "snapshot"}).AddRow(1, "", time.Now(), []byte(`{"title": "First", "actors": []}`)))
+	mock.ExpectExec("DELETE FROM film_actors").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE films").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	err = filmOrm.RevertFilm(1, 1, 3)
+	assert.ErrorIs(t, err, orm.ErrConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevertActor_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	actorOrm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT revision, username, created_at, snapshot FROM actor_revisions").
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"revision", "username", "created_at", "snapshot"}).
+			AddRow(1, "", time.Now(), []byte(`{"id": 1, "name": "John", "gender": "male", "birthdate": "2000-01-01", "version": 1}`)))
+	mock.ExpectExec("UPDATE actors").WithArgs("John", "male", "2000-01-01", "", "", "", nil, "", 1, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO actor_revisions").WithArgs(1, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = actorOrm.RevertActor(1, 1, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// endpoint /admin/import
+
+func TestImportFilms_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	films := []types.ImportFilm{
+		{Title: "Film 1", ReleaseDate: "2020-01-01", Rating: 7.5, Actors: []types.Actor{{Name: "John Doe", Birthdate: "1980-05-01"}}},
+		{Title: "Film 2", ReleaseDate: "2021-01-01", Rating: 6},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE import_actors").WillReturnResult(sqlmock.NewResult(0, 0))
+	actorsCopy := mock.ExpectPrepare(`COPY "import_actors" \("name", "gender", "date_of_birth"\) FROM STDIN`)
+	actorsCopy.ExpectExec().WithArgs("John Doe", "", "1980-05-01").WillReturnResult(sqlmock.NewResult(0, 1))
+	actorsCopy.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT\\(DISTINCT \\(i.name, i.date_of_birth\\)\\) FROM import_actors").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec("WITH created AS \\(\\s+INSERT INTO actors").WithArgs("", nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TEMP TABLE import_films").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TEMP TABLE import_credits").WillReturnResult(sqlmock.NewResult(0, 0))
+	filmsCopy := mock.ExpectPrepare(`COPY "import_films"`)
+	filmsCopy.ExpectExec().WithArgs(0, "Film 1", "", "2020-01-01", 7.5).WillReturnResult(sqlmock.NewResult(0, 1))
+	filmsCopy.ExpectExec().WithArgs(1, "Film 2", "", "2021-01-01", 6.0).WillReturnResult(sqlmock.NewResult(0, 1))
+	filmsCopy.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	creditsCopy := mock.ExpectPrepare(`COPY "import_credits"`)
+	creditsCopy.ExpectExec().WithArgs(0, "John Doe", "1980-05-01").WillReturnResult(sqlmock.NewResult(0, 1))
+	creditsCopy.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE import_films SET id = nextval").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO films \\(id, title, description, release_date, rating\\) SELECT").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO film_actors").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs("", nil).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	report, err := filmOrm.ImportFilms(films, false)
+	assert.NoError(t, err)
+	assert.Equal(t, types.ImportReport{FilmsCreated: 2, ActorsCreated: 0, ActorsMatched: 1}, report)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportActors_DryRunRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	actorOrm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE import_actors").WillReturnResult(sqlmock.NewResult(0, 0))
+	actorsCopy := mock.ExpectPrepare(`COPY "import_actors"`)
+	actorsCopy.ExpectExec().WithArgs("Jane Doe", "female", "1985-02-03").WillReturnResult(sqlmock.NewResult(0, 1))
+	actorsCopy.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("INSERT INTO actors").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	report, err := actorOrm.ImportActors([]types.Actor{{Name: "Jane Doe", Gender: "female", Birthdate: "1985-02-03"}}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, types.ImportReport{DryRun: true, ActorsCreated: 1}, report)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// endpoint /admin/export
+
+func TestExportActors_FetchesInBatches(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+	defer db.Close()
+
+	actorOrm := orm.NewORM(db)
+
+	fullBatch := sqlmock.NewRows([]string{"id", "name", "gender", "date_of_birth", "version"})
+	for i := 1; i <= 500; i++ {
+		fullBatch.AddRow(i, fmt.Sprintf("Actor %d", i), "male", "1980-01-01", 1)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE export_cursor NO SCROLL CURSOR FOR SELECT id, name, gender").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FETCH FORWARD 500 FROM export_cursor").WillReturnRows(fullBatch)
+	mock.ExpectQuery("FETCH FORWARD 500 FROM export_cursor").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "gender", "date_of_birth", "version"}).AddRow(501, "Actor 501", "female", "1990-01-01", 3))
+	mock.ExpectRollback()
+
+	count := 0
+	var last types.Actor
+	err = actorOrm.ExportActors(func(actor types.Actor) error {
+		count++
+		last = actor
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 501, count)
+	assert.Equal(t, types.Actor{ID: 501, Name: "Actor 501", Gender: "female", Birthdate: "1990-01-01", Version: 3}, last)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// endpoint /admin/metadata/accept
+
+func TestAcceptExternalFilm_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	external := types.ExternalFilm{
+		Provider:   "tmdb",
+		ExternalID: "603",
+		Film:       types.Film{Title: "The Matrix", Description: "Neo", ReleaseDate: "1999-03-30", Rating: 8.2},
+		Cast: []types.ExternalActor{
+			{ExternalID: "6384", Actor: types.Actor{Name: "Keanu Reeves", Gender: "male", Birthdate: "1964-09-02"}},
+			{ExternalID: "530", Actor: types.Actor{Name: "Carrie-Anne Moss", Gender: "female", Birthdate: "1967-08-21"}},
+			{ExternalID: "2975", Actor: types.Actor{Name: "Laurence Fishburne"}},
+		},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT film_id FROM film_external_ids").WithArgs("tmdb", "603").WillReturnError(sql.ErrNoRows)
+	// known by external id
+	mock.ExpectQuery("SELECT a.id FROM actor_external_ids").WithArgs("tmdb", "6384").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO actor_external_ids").WithArgs(7, "tmdb", "6384").WillReturnResult(sqlmock.NewResult(0, 0))
+	// unknown, created
+	mock.ExpectQuery("SELECT a.id FROM actor_external_ids").WithArgs("tmdb", "530").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT id FROM actors WHERE name = \\$1 AND date_of_birth = \\$2").WithArgs("Carrie-Anne Moss", "1967-08-21").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO actors").WithArgs("Carrie-Anne Moss", "female", "1967-08-21", "", "", "", nil, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+	mock.ExpectExec("INSERT INTO actor_revisions").WithArgs(8, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO actor_external_ids").WithArgs(8, "tmdb", "530").WillReturnResult(sqlmock.NewResult(0, 1))
+	// no birthdate, skipped
+	mock.ExpectQuery("SELECT a.id FROM actor_external_ids").WithArgs("tmdb", "2975").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO films").WithArgs("The Matrix", "Neo", "1999-03-30", 8.2, "", nil, "released", nil, "", nil, "").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectExec("INSERT INTO film_actors").WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO film_actors").WithArgs(3, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO film_revisions").WithArgs(3, "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO film_external_ids").WithArgs(3, "tmdb", "603").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	filmID, err := filmOrm.AcceptExternalFilm(external)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, filmID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAcceptExternalFilm_AlreadyAccepted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT film_id FROM film_external_ids").WithArgs("tmdb", "603").WillReturnRows(sqlmock.NewRows([]string{"film_id"}).AddRow(3))
+	mock.ExpectRollback()
+
+	_, err = filmOrm.AcceptExternalFilm(types.ExternalFilm{Provider: "tmdb", ExternalID: "603", Film: types.Film{Title: "The Matrix"}})
+	assert.ErrorIs(t, err, orm.ErrConflict)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetFilmPoster_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db).WithAudit(types.AuditMeta{Username: "admin"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT poster_key FROM films WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"poster_key"}).AddRow("films/1/poster/old"))
+	mock.ExpectExec("UPDATE films SET poster_key = \\$1 WHERE id = \\$2").WithArgs("films/1/poster/new", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("admin", "update", "film", 1, []byte(`{"poster_key":"films/1/poster/old"}`), []byte(`{"poster_key":"films/1/poster/new"}`), "", "", nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	oldKey, err := filmOrm.SetFilmPoster(1, "films/1/poster/new")
+	assert.NoError(t, err)
+	assert.Equal(t, "films/1/poster/old", oldKey)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetActorPhoto_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	actorOrm := orm.NewORM(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT photo_key FROM actors").WithArgs(5).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, err = actorOrm.SetActorPhoto(5, "actors/5/photo/new")
+	assert.ErrorIs(t, err, orm.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFilms_Translated(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	filmOrm := orm.NewORM(db).WithLocales([]string{"ru-RU", "ru", "en"})
+
+	mock.ExpectQuery("SELECT id, title, description, release_date, rating, version, poster_key, original_title FROM films WHERE deleted_at IS NULL ORDER BY rating DESC").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "release_date", "rating", "version", "poster_key", "original_title"}).
+			AddRow(1, "The Godfather", "Original 1", "1972-03-24", 9.2, 1, "", "").
+			AddRow(2, "Amelie", "Original 2", "2001-04-25", 8.3, 1, "", "").
+			AddRow(3, "Stalker", "Original 3", "1979-05-25", 8.1, 1, "", ""))
+	expectAltTitles(mock)
+	mock.ExpectQuery("SELECT film_id, locale, title, description FROM film_translations WHERE film_id = ANY\\(\\$1\\) AND locale = ANY\\(\\$2\\)").
+		WithArgs("{1,2,3}", "{\"ru-RU\",\"ru\",\"en\"}").
+		WillReturnRows(sqlmock.NewRows([]string{"film_id", "locale", "title", "description"}).
+			AddRow(1, "en", "The Godfather (en)", "English 1").
+			AddRow(1, "ru", "Крестный отец", "").
+			AddRow(2, "en", "Amelie (en)", "English 2"))
+
+	films, err := filmOrm.GetFilms("", false, types.FilmFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, films, 3)
+
+	// ru wins over en, empty description falls back to the original
+	assert.Equal(t, "Крестный отец", films[0].Title)
+	assert.Equal(t, "Original 1", films[0].Description)
+	assert.Equal(t, "ru", films[0].Locale)
+	assert.Equal(t, "Amelie (en)", films[1].Title)
+	assert.Equal(t, "English 2", films[1].Description)
+	// no translation
+	assert.Equal(t, "Stalker", films[2].Title)
+	assert.Equal(t, "", films[2].Locale)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginWithIdentity_Linked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db)
+	identity := types.ExternalIdentity{Provider: "corp", Subject: "u-1", Email: "alice@corp.test", EmailVerified: true, Username: "alice"}
+	admin := true
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id = \\(SELECT user_id FROM user_identities WHERE provider = \\$1 AND subject = \\$2\\) FOR UPDATE").
+		WithArgs("corp", "u-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}).AddRow(4, "alice", "alice@corp.test", false, true, false, ""))
+	mock.ExpectExec("UPDATE user_identities SET email = \\$1, last_login_at = NOW\\(\\)").WithArgs("alice@corp.test", "corp", "u-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET adminflag = \\$1, sessions_revoked_at = CASE WHEN \\$1 THEN sessions_revoked_at ELSE date_trunc\\('second', NOW\\(\\)\\) END WHERE id = \\$2").WithArgs(true, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := userOrm.LoginWithIdentity(identity, &admin)
+	assert.NoError(t, err)
+	assert.Equal(t, types.UserProfile{ID: 4, Username: "alice", Email: "alice@corp.test", Admin: true, Verified: true}, user)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginWithIdentity_Demoted(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db).WithAudit(types.AuditMeta{RequestID: "req-1", IP: "192.0.2.1"})
+	identity := types.ExternalIdentity{Provider: "corp", Subject: "u-1", Email: "alice@corp.test", EmailVerified: true, Username: "alice"}
+	admin := false
+
+	// the groups no longer make alice an admin: the role goes, and with it the tokens issued as admin
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id = \\(SELECT user_id FROM user_identities WHERE provider = \\$1 AND subject = \\$2\\) FOR UPDATE").
+		WithArgs("corp", "u-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}).AddRow(4, "alice", "alice@corp.test", true, true, false, ""))
+	mock.ExpectExec("UPDATE user_identities SET email = \\$1, last_login_at = NOW\\(\\)").WithArgs("alice@corp.test", "corp", "u-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET adminflag = \\$1, sessions_revoked_at = CASE WHEN \\$1 THEN sessions_revoked_at ELSE date_trunc\\('second', NOW\\(\\)\\) END WHERE id = \\$2").
+		WithArgs(false, 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("alice", "update", "user", 4, []byte(`{"admin":true}`), []byte(`{"admin":false,"provider":"corp"}`), "req-1", "192.0.2.1", 4).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	user, err := userOrm.LoginWithIdentity(identity, &admin)
+	assert.NoError(t, err)
+	assert.False(t, user.Admin)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginWithIdentity_Provisioned(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db)
+	identity := types.ExternalIdentity{Provider: "corp", Subject: "u-2", Email: "bob@corp.test", EmailVerified: true, Username: "bob@corp.test"}
+	profileColumns := []string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id = \\(SELECT user_id FROM user_identities").WithArgs("corp", "u-2").WillReturnRows(sqlmock.NewRows(profileColumns))
+	mock.ExpectQuery("FROM users WHERE email = \\$1 FOR UPDATE").WithArgs("bob@corp.test").WillReturnRows(sqlmock.NewRows(profileColumns))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users WHERE username = \\$1\\)").WithArgs("bob").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users WHERE username = \\$1\\)").WithArgs("bob-2").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("INSERT INTO users \\(username, email, password, verified_at\\) VALUES \\(\\$1, \\$2, '', NOW\\(\\)\\) RETURNING id").
+		WithArgs("bob-2", "bob@corp.test").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectExec("INSERT INTO user_identities \\(provider, subject, user_id, email\\)").WithArgs("corp", "u-2", 9, "bob@corp.test").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := userOrm.LoginWithIdentity(identity, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, types.UserProfile{ID: 9, Username: "bob-2", Email: "bob@corp.test", Verified: true}, user)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginWithIdentity_LongUsername(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db)
+	// 50 two-byte characters, cut to 45 characters and not to 45 bytes
+	long := strings.Repeat("ж", 50)
+	identity := types.ExternalIdentity{Provider: "corp", Subject: "u-5", Email: "zh@corp.test", EmailVerified: true, Username: long}
+	profileColumns := []string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id = \\(SELECT user_id FROM user_identities").WithArgs("corp", "u-5").WillReturnRows(sqlmock.NewRows(profileColumns))
+	mock.ExpectQuery("FROM users WHERE email = \\$1 FOR UPDATE").WithArgs("zh@corp.test").WillReturnRows(sqlmock.NewRows(profileColumns))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users WHERE username = \\$1\\)").WithArgs(strings.Repeat("ж", 45)).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs(strings.Repeat("ж", 45), "zh@corp.test").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectExec("INSERT INTO user_identities").WithArgs("corp", "u-5", 11, "zh@corp.test").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := userOrm.LoginWithIdentity(identity, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("ж", 45), user.Username)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginWithIdentity_UnverifiedAccount(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db)
+	profileColumns := []string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id = \\(SELECT user_id FROM user_identities").WithArgs("corp", "u-3").WillReturnRows(sqlmock.NewRows(profileColumns))
+	mock.ExpectQuery("FROM users WHERE email = \\$1 FOR UPDATE").WithArgs("carol@corp.test").
+		WillReturnRows(sqlmock.NewRows(profileColumns).AddRow(5, "carol", "carol@corp.test", false, false, false, "hash"))
+	mock.ExpectRollback()
+
+	_, err = userOrm.LoginWithIdentity(types.ExternalIdentity{Provider: "corp", Subject: "u-3", Email: "carol@corp.test", EmailVerified: true}, nil)
+	assert.ErrorIs(t, err, orm.ErrIdentityLink)
+
+	// the provider did not confirm the email
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id = \\(SELECT user_id FROM user_identities").WithArgs("corp", "u-3").WillReturnRows(sqlmock.NewRows(profileColumns))
+	mock.ExpectRollback()
+
+	_, err = userOrm.LoginWithIdentity(types.ExternalIdentity{Provider: "corp", Subject: "u-3", Email: "carol@corp.test"}, nil)
+	assert.ErrorIs(t, err, orm.ErrIdentityEmail)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUseOIDCState(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db)
+
+	mock.ExpectQuery("DELETE FROM oidc_states WHERE state_hash = \\$1 AND provider = \\$2 AND expires_at > NOW\\(\\) RETURNING nonce, verifier").
+		WithArgs("hash", "corp").
+		WillReturnRows(sqlmock.NewRows([]string{"nonce", "verifier"}).AddRow("n", "v"))
+	mock.ExpectQuery("DELETE FROM oidc_states").WithArgs("hash", "corp").WillReturnRows(sqlmock.NewRows([]string{"nonce", "verifier"}))
+
+	nonce, verifier, err := userOrm.UseOIDCState("hash", "corp")
+	assert.NoError(t, err)
+	assert.Equal(t, "n", nonce)
+	assert.Equal(t, "v", verifier)
+
+	_, _, err = userOrm.UseOIDCState("hash", "corp")
+	assert.ErrorIs(t, err, orm.ErrBadToken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetUserDetail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db)
+	columns := []string{"id", "username", "email", "adminflag", "verified", "created_at", "disabled_at", "delete_after", "two_factor", "providers", "api_keys", "locked_for"}
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("FROM users u WHERE u.id = \\$1").WithArgs(5, "user").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(5, "bob", "bob@example.com", false, true, createdAt, createdAt.Add(time.Hour), nil, true, "{corp,google}", 2, 0))
+	mock.ExpectQuery("FROM users u WHERE u.id = \\$1").WithArgs(6, "user").WillReturnRows(sqlmock.NewRows(columns))
+
+	user, err := userOrm.GetUserDetail(5)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "bob", user.Username)
+		assert.Equal(t, "2024-03-01T12:00:00Z", user.CreatedAt)
+		assert.True(t, user.Disabled)
+		assert.Equal(t, "2024-03-01T13:00:00Z", user.DisabledAt)
+		assert.True(t, user.TwoFactor)
+		assert.Equal(t, []string{"corp", "google"}, user.Providers)
+		assert.Equal(t, 2, user.APIKeys)
+		assert.Empty(t, user.DeleteAfter)
+	}
+
+	_, err = userOrm.GetUserDetail(6)
+	assert.ErrorIs(t, err, orm.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetUserDisabled_Unchanged(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db)
+
+	// enabling a user that is not disabled writes nothing to the audit log
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT disabled_at IS NOT NULL FROM users WHERE id = \\$1 FOR UPDATE").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"disabled"}).AddRow(false))
+	mock.ExpectCommit()
+
+	assert.NoError(t, userOrm.EnableUser(5))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteScheduledUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	userOrm := orm.NewORM(db)
+
+	mock.ExpectQuery("SELECT id FROM users WHERE delete_after <= NOW\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5).AddRow(6))
+	// the user deletes themselves under the name their changes get, the changes are found by the user id
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM users WHERE id = \\$1 AND delete_after <= NOW\\(\\)").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE audit_log SET username = \\$2, ip = '' WHERE user_id = \\$1").WithArgs(5, "deleted-5").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE film_revisions SET username = \\$2 WHERE user_id = \\$1").WithArgs(5, "deleted-5").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE actor_revisions SET username = \\$2 WHERE user_id = \\$1").WithArgs(5, "deleted-5").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE audit_log SET before_data = NULL").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "5").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("deleted-5", "delete", "user", 5, nil, nil, "", "", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	// restored in the meantime
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM users WHERE id = \\$1 AND delete_after <= NOW\\(\\)").WithArgs(6).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	deleted, err := userOrm.DeleteScheduledUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
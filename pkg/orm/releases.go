@@ -0,0 +1,130 @@
+// pkg/orm/releases.go
+package orm
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+
+	"github.com/lib/pq"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// endpoint: /film/{id}/releases
+// get, ErrNotFound when there is no such film
+func (orm *ORM) GetFilmReleases(filmID int) ([]types.FilmRelease, error) {
+	var exists bool
+	err := orm.db.QueryRow("SELECT EXISTS (SELECT 1 FROM films WHERE id = $1 AND deleted_at IS NULL)", filmID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return getFilmReleases(orm.db, filmID)
+}
+
+// endpoint: /film/{id}/releases
+// post, ErrNotFound when the film does not exist or is in trash
+func (orm *ORM) CreateFilmRelease(release types.FilmRelease) (int, error) {
+	err := orm.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(
+			`INSERT INTO film_releases (film_id, country, release_date, type, certification)
+			SELECT $1, $2, $3, $4, $5 WHERE EXISTS (SELECT 1 FROM films WHERE id = $1 AND deleted_at IS NULL)
+			RETURNING id`,
+			release.FilmID, release.Country, release.ReleaseDate, release.Type, release.Certification,
+		).Scan(&release.ID)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "create", "film_release", release.ID, nil, release)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return release.ID, nil
+}
+
+// endpoint: /film/{id}/releases/{releaseId}
+// delete
+func (orm *ORM) DeleteFilmRelease(filmID, releaseID int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		var release types.FilmRelease
+		err := tx.QueryRow(
+			`SELECT r.id, r.film_id, r.country, to_char(r.release_date, 'YYYY-MM-DD'), r.type, r.certification
+			FROM film_releases r JOIN films f ON f.id = r.film_id
+			WHERE r.id = $1 AND r.film_id = $2 AND f.deleted_at IS NULL`,
+			releaseID, filmID,
+		).Scan(&release.ID, &release.FilmID, &release.Country, &release.ReleaseDate, &release.Type, &release.Certification)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM film_releases WHERE id = $1", releaseID); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "delete", "film_release", releaseID, release, nil)
+	})
+}
+
+// utility: earliest first
+func getFilmReleases(q querier, filmID int) ([]types.FilmRelease, error) {
+	rows, err := q.Query("SELECT id, film_id, country, to_char(release_date, 'YYYY-MM-DD'), type, certification FROM film_releases WHERE film_id = $1 ORDER BY release_date, country, id", filmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := []types.FilmRelease{}
+	for rows.Next() {
+		var release types.FilmRelease
+		if err := rows.Scan(&release.ID, &release.FilmID, &release.Country, &release.ReleaseDate, &release.Type, &release.Certification); err != nil {
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	return releases, rows.Err()
+}
+
+// utility: the release part of filmFilterSQL, "" when the filter has no release conditions
+func releaseFilterSQL(filter types.FilmFilter, args []interface{}) (string, []interface{}) {
+	var conditions string
+	placeholder := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if filter.Country != "" {
+		conditions += " AND r.country = " + placeholder(filter.Country)
+	}
+	if filter.MaxAge != nil {
+		conditions += " AND r.certification = ANY(" + placeholder(pq.Array(certificationsUpTo(*filter.MaxAge))) + ")"
+	}
+	if filter.ReleasedFrom != "" {
+		conditions += " AND r.release_date >= " + placeholder(filter.ReleasedFrom)
+	}
+	if filter.ReleasedTo != "" {
+		conditions += " AND r.release_date <= " + placeholder(filter.ReleasedTo)
+	}
+	if conditions == "" {
+		return "", args
+	}
+	return " AND EXISTS (SELECT 1 FROM film_releases r WHERE r.film_id = films.id" + conditions + ")", args
+}
+
+// utility: sorted, so the query arguments do not depend on map order
+func certificationsUpTo(age int) []string {
+	var certifications []string
+	for certification, minAge := range types.CertificationAges {
+		if minAge <= age {
+			certifications = append(certifications, certification)
+		}
+	}
+	sort.Strings(certifications)
+	return certifications
+}
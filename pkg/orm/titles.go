@@ -0,0 +1,79 @@
+// pkg/orm/titles.go
+package orm
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// utility: alternative titles of the film in the order they were given
+func getAltTitles(q querier, filmID int) ([]types.AltTitle, error) {
+	rows, err := q.Query("SELECT title, region, type FROM film_alt_titles WHERE film_id = $1 ORDER BY id", filmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := []types.AltTitle{}
+	for rows.Next() {
+		var title types.AltTitle
+		if err := rows.Scan(&title.Title, &title.Region, &title.Type); err != nil {
+			return nil, err
+		}
+		titles = append(titles, title)
+	}
+	return titles, rows.Err()
+}
+
+// utility: titles of a list of films in one query
+func addAltTitles(q querier, films []types.Film) error {
+	if len(films) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(films))
+	for i, film := range films {
+		ids[i] = int64(film.ID)
+	}
+	rows, err := q.Query("SELECT film_id, title, region, type FROM film_alt_titles WHERE film_id = ANY($1) ORDER BY id", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	titles := make(map[int][]types.AltTitle)
+	for rows.Next() {
+		var filmID int
+		var title types.AltTitle
+		if err := rows.Scan(&filmID, &title.Title, &title.Region, &title.Type); err != nil {
+			return err
+		}
+		titles[filmID] = append(titles[filmID], title)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i := range films {
+		films[i].AltTitles = titles[films[i].ID]
+	}
+	return nil
+}
+
+// utility: the film gets exactly the given titles, nothing is done for nil
+func replaceAltTitles(tx *sql.Tx, filmID int, titles []types.AltTitle) error {
+	if titles == nil {
+		return nil
+	}
+	if _, err := tx.Exec("DELETE FROM film_alt_titles WHERE film_id = $1", filmID); err != nil {
+		return err
+	}
+	for _, title := range titles {
+		_, err := tx.Exec("INSERT INTO film_alt_titles (film_id, title, region, type) VALUES ($1, $2, $3, $4)", filmID, title.Title, title.Region, title.Type)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+// pkg/orm/translations.go
+package orm
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// title search matches the title, the original title, every translation and every alternative title, $1 is the fragment
+const titleMatchSQL = `(title LIKE '%' || $1 || '%' OR original_title LIKE '%' || $1 || '%' OR id IN (SELECT film_id FROM film_translations WHERE title LIKE '%' || $1 || '%' UNION SELECT film_id FROM film_alt_titles WHERE title LIKE '%' || $1 || '%'))`
+
+// WithLocales returns a copy of ORM which reads film titles and descriptions in the first of locales
+// the film has a translation for, falling back to the original text.
+// Sorting by title still uses the original title
+func (orm *ORM) WithLocales(locales []string) *ORM {
+	localized := *orm
+	localized.locales = locales
+	return &localized
+}
+
+// utility: replaces texts of films with their best translation, no query without locales
+func (orm *ORM) translate(films []types.Film) error {
+	if len(orm.locales) == 0 || len(films) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(films))
+	for i, film := range films {
+		ids[i] = int64(film.ID)
+	}
+	rows, err := orm.db.Query("SELECT film_id, locale, title, description FROM film_translations WHERE film_id = ANY($1) AND locale = ANY($2)", pq.Array(ids), pq.Array(orm.locales))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	rank := make(map[string]int, len(orm.locales))
+	for i, locale := range orm.locales {
+		if _, ok := rank[locale]; !ok {
+			rank[locale] = i
+		}
+	}
+	best := make(map[int]types.FilmTranslation)
+	for rows.Next() {
+		var translation types.FilmTranslation
+		if err := rows.Scan(&translation.FilmID, &translation.Locale, &translation.Title, &translation.Description); err != nil {
+			return err
+		}
+		current, ok := best[translation.FilmID]
+		if !ok || rank[translation.Locale] < rank[current.Locale] {
+			best[translation.FilmID] = translation
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range films {
+		translation, ok := best[films[i].ID]
+		if !ok {
+			continue
+		}
+		films[i].Title = translation.Title
+		// untranslated description stays original
+		if translation.Description != "" {
+			films[i].Description = translation.Description
+		}
+		films[i].Locale = translation.Locale
+	}
+	return nil
+}
+
+// endpoint: /film/{id}/translations
+// get, ErrNotFound when there is no such film
+func (orm *ORM) GetFilmTranslations(filmID int) ([]types.FilmTranslation, error) {
+	var exists bool
+	err := orm.db.QueryRow("SELECT EXISTS (SELECT 1 FROM films WHERE id = $1 AND deleted_at IS NULL)", filmID).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	rows, err := orm.db.Query("SELECT film_id, locale, title, description FROM film_translations WHERE film_id = $1 ORDER BY locale", filmID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := []types.FilmTranslation{}
+	for rows.Next() {
+		var translation types.FilmTranslation
+		if err := rows.Scan(&translation.FilmID, &translation.Locale, &translation.Title, &translation.Description); err != nil {
+			return nil, err
+		}
+		translations = append(translations, translation)
+	}
+	return translations, rows.Err()
+}
+
+// endpoint: /film/{id}/translations/{locale}
+// put, creates or replaces the translation. ErrNotFound when the film does not exist or is in trash
+func (orm *ORM) SetFilmTranslation(translation types.FilmTranslation) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		before, err := filmTranslationSnapshot(tx, translation.FilmID, translation.Locale)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO film_translations (film_id, locale, title, description) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (film_id, locale) DO UPDATE SET title = EXCLUDED.title, description = EXCLUDED.description`,
+			translation.FilmID, translation.Locale, translation.Title, translation.Description,
+		)
+		if err != nil {
+			return err
+		}
+		// entity_id of the audit row is the film id
+		action := "update"
+		if before == nil {
+			action = "create"
+		}
+		return orm.writeAudit(tx, action, "film_translation", translation.FilmID, translationAudit(before), translationAudit(&translation))
+	})
+}
+
+// endpoint: /film/{id}/translations/{locale}
+// delete
+func (orm *ORM) DeleteFilmTranslation(filmID int, locale string) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		before, err := filmTranslationSnapshot(tx, filmID, locale)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return ErrNotFound
+		}
+		if _, err := tx.Exec("DELETE FROM film_translations WHERE film_id = $1 AND locale = $2", filmID, locale); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "delete", "film_translation", filmID, translationAudit(before), nil)
+	})
+}
+
+// utility: locks the film row; nil translation when there is none yet, ErrNotFound when there is no film
+func filmTranslationSnapshot(tx *sql.Tx, filmID int, locale string) (*types.FilmTranslation, error) {
+	var id int
+	err := tx.QueryRow("SELECT id FROM films WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", filmID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	translation := types.FilmTranslation{FilmID: filmID, Locale: locale}
+	err = tx.QueryRow("SELECT title, description FROM film_translations WHERE film_id = $1 AND locale = $2", filmID, locale).
+		Scan(&translation.Title, &translation.Description)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &translation, nil
+}
+
+// utility: keyed by locale, so the audit diff keeps the locale of the changed texts
+func translationAudit(translation *types.FilmTranslation) interface{} {
+	if translation == nil {
+		return nil
+	}
+	return map[string]types.FilmTranslation{translation.Locale: *translation}
+}
@@ -0,0 +1,156 @@
+// pkg/orm/twofactor.go
+package orm
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// endpoint: /user/2fa
+// get, secret of the user and whether it is confirmed. ErrNotFound when two-factor authentication was never set up
+func (orm *ORM) GetTOTP(userID int) (secret string, enabled bool, err error) {
+	err = orm.db.QueryRow("SELECT secret, enabled_at IS NOT NULL FROM user_totp WHERE user_id = $1", userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return "", false, ErrNotFound
+	}
+	return secret, enabled, err
+}
+
+// utility: unused recovery codes of the user
+func (orm *ORM) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := orm.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// endpoint: /user/2fa/setup
+// post, a new secret replaces the one not confirmed yet. ErrConflict when two-factor authentication is on already
+func (orm *ORM) SetTOTPSecret(userID int, secret string) error {
+	result, err := orm.db.Exec(
+		`INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret WHERE user_totp.enabled_at IS NULL`,
+		userID, secret,
+	)
+	if err != nil {
+		return err
+	}
+	err = checkAffected(result)
+	if errors.Is(err, ErrNotFound) {
+		return ErrConflict
+	}
+	return err
+}
+
+// endpoint: /user/2fa/enable
+// post, the secret is confirmed by the code of step, which can not be used again. ErrNotFound when there is no secret
+// waiting for confirmation
+func (orm *ORM) EnableTwoFactor(userID int, step int64, recoveryHashes []string) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE user_totp SET enabled_at = NOW(), last_step = $1 WHERE user_id = $2 AND enabled_at IS NULL", step, userID)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(result); err != nil {
+			return err
+		}
+		if err := replaceRecoveryCodes(tx, userID, recoveryHashes); err != nil {
+			return err
+		}
+		// secrets and codes never go to the audit log
+		return orm.writeAudit(tx, "enable_2fa", "user", userID, nil, nil)
+	})
+}
+
+// endpoint: /user/2fa
+// delete, the secret and the recovery codes are dropped. ErrNotFound when it was not on
+func (orm *ORM) DisableTwoFactor(userID int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM user_totp WHERE user_id = $1", userID)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(result); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "disable_2fa", "user", userID, nil, nil)
+	})
+}
+
+// endpoint: /user/2fa/recovery-codes
+// post, the old codes stop working
+func (orm *ORM) ReplaceRecoveryCodes(userID int, recoveryHashes []string) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		if err := replaceRecoveryCodes(tx, userID, recoveryHashes); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "recovery_codes", "user", userID, nil, map[string]interface{}{"count": len(recoveryHashes)})
+	})
+}
+
+// utility
+func replaceRecoveryCodes(tx *sql.Tx, userID int, recoveryHashes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		if _, err := tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// utility: a code is accepted once, so the step must be later than the last accepted one. ErrBadToken otherwise
+func (orm *ORM) UseTOTPStep(userID int, step int64) error {
+	result, err := orm.db.Exec("UPDATE user_totp SET last_step = $1 WHERE user_id = $2 AND enabled_at IS NOT NULL AND last_step < $1", step, userID)
+	if err != nil {
+		return err
+	}
+	err = checkAffected(result)
+	if errors.Is(err, ErrNotFound) {
+		return ErrBadToken
+	}
+	return err
+}
+
+// utility: ErrBadToken for unknown and used codes
+func (orm *ORM) UseRecoveryCode(userID int, codeHash string) error {
+	result, err := orm.db.Exec("UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL", userID, codeHash)
+	if err != nil {
+		return err
+	}
+	err = checkAffected(result)
+	if errors.Is(err, ErrNotFound) {
+		return ErrBadToken
+	}
+	return err
+}
+
+// endpoint: /user/login/2fa
+// utility: user of the login challenge, the challenge stays valid until UseUserToken, so a mistyped code can be retried.
+// ErrBadToken for unknown, used or expired challenges
+func (orm *ORM) GetUserTokenUser(kind, tokenHash string) (int, error) {
+	var userID int
+	err := orm.db.QueryRow(
+		"SELECT user_id FROM user_tokens WHERE token_hash = $1 AND kind = $2 AND used_at IS NULL AND expires_at > NOW()",
+		tokenHash, kind,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrBadToken
+	}
+	return userID, err
+}
+
+// utility: marks the token as used, ErrBadToken when it was used in the meantime
+func (orm *ORM) UseUserToken(kind, tokenHash string) (int, error) {
+	var userID int
+	err := orm.withTx(func(tx *sql.Tx) error {
+		var err error
+		userID, err = useUserToken(tx, kind, tokenHash)
+		return err
+	})
+	return userID, err
+}
@@ -0,0 +1,428 @@
+// pkg/orm/users.go
+package orm
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// utility: false for disabled and deleted users and for tokens issued before the sessions of the user were revoked.
+// age is how long ago the token was issued, the moment is counted by postgres, so that it is compared with NOW()
+// in the same time zone
+func (orm *ORM) LoginTokenValid(userID int, age time.Duration) (bool, error) {
+	var valid bool
+	err := orm.db.QueryRow(
+		`SELECT disabled_at IS NULL AND (sessions_revoked_at IS NULL OR sessions_revoked_at < NOW() - $2 * INTERVAL '1 second')
+		FROM users WHERE id = $1`,
+		userID, int(age.Seconds()),
+	).Scan(&valid)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return valid, err
+}
+
+// endpoint: /admin/users
+// get, users ordered by id
+func (orm *ORM) GetUsers(filter types.UserFilter) (types.UserPage, error) {
+	where := " WHERE 1 = 1"
+	var args []interface{}
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		where += " AND " + strings.ReplaceAll(condition, "$n", "$"+strconv.Itoa(len(args)))
+	}
+
+	if filter.Query != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Query) + "%"
+		addCondition("(username ILIKE $n OR email ILIKE $n)", pattern)
+	}
+	if filter.Admin != nil {
+		addCondition("adminflag = $n", *filter.Admin)
+	}
+	if filter.Disabled != nil {
+		addCondition("(disabled_at IS NOT NULL) = $n", *filter.Disabled)
+	}
+
+	page := types.UserPage{Users: []types.UserProfile{}, Limit: filter.Limit, Offset: filter.Offset}
+	if err := orm.db.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&page.Total); err != nil {
+		return types.UserPage{}, err
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	rows, err := orm.db.Query(userProfileSQL+where+" ORDER BY id LIMIT $"+strconv.Itoa(len(args)-1)+" OFFSET $"+strconv.Itoa(len(args)), args...)
+	if err != nil {
+		return types.UserPage{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUserProfile(rows)
+		if err != nil {
+			return types.UserPage{}, err
+		}
+		page.Users = append(page.Users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return types.UserPage{}, err
+	}
+	return page, nil
+}
+
+// endpoint: /admin/users/{id}
+// get, ErrNotFound when there is no such user
+func (orm *ORM) GetUserDetail(userID int) (types.UserDetail, error) {
+	var user types.UserDetail
+	var createdAt time.Time
+	var disabledAt, deleteAfter sql.NullTime
+	err := orm.db.QueryRow(
+		`SELECT u.id, u.username, u.email, u.adminflag, u.verified_at IS NOT NULL, u.created_at, u.disabled_at, u.delete_after,
+		EXISTS (SELECT 1 FROM user_totp t WHERE t.user_id = u.id AND t.enabled_at IS NOT NULL),
+		ARRAY(SELECT i.provider FROM user_identities i WHERE i.user_id = u.id ORDER BY i.provider),
+		(SELECT COUNT(*) FROM api_keys k WHERE k.user_id = u.id AND k.revoked_at IS NULL AND k.expires_at > NOW()),
+		COALESCE((SELECT CEIL(EXTRACT(EPOCH FROM f.blocked_until - NOW()))::int FROM login_failures f
+			WHERE f.scope = $2 AND f.subject = u.id::text AND f.blocked_until > NOW()), 0)
+		FROM users u WHERE u.id = $1`,
+		userID, LoginScopeUser,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.Admin, &user.Verified, &createdAt, &disabledAt, &deleteAfter,
+		&user.TwoFactor, pq.Array(&user.Providers), &user.APIKeys, &user.LockedFor)
+	if err == sql.ErrNoRows {
+		return types.UserDetail{}, ErrNotFound
+	}
+	if err != nil {
+		return types.UserDetail{}, err
+	}
+
+	user.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+	if disabledAt.Valid {
+		user.Disabled = true
+		user.DisabledAt = disabledAt.Time.UTC().Format(time.RFC3339)
+	}
+	if deleteAfter.Valid {
+		user.DeleteAfter = deleteAfter.Time.UTC().Format(time.RFC3339)
+	}
+	if user.Providers == nil {
+		user.Providers = []string{}
+	}
+	return user, nil
+}
+
+// endpoint: /admin/users/{id}
+// patch, ErrNotFound when there is no such user. Tokens of a demoted admin stop working, the api keys lose
+// admin rights by themselves, since they take the role from the database
+func (orm *ORM) SetUserAdmin(userID int, admin bool) (types.UserProfile, error) {
+	var user types.UserProfile
+	err := orm.withTx(func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUserProfile(tx.QueryRow(userProfileSQL+" WHERE id = $1 FOR UPDATE", userID))
+		if err != nil || user.Admin == admin {
+			return err
+		}
+		_, err = tx.Exec(
+			"UPDATE users SET adminflag = $1, sessions_revoked_at = CASE WHEN $1 THEN sessions_revoked_at ELSE NOW() END WHERE id = $2",
+			admin, userID,
+		)
+		if err != nil {
+			return err
+		}
+		before := map[string]interface{}{"admin": user.Admin}
+		user.Admin = admin
+		return orm.writeAudit(tx, "update", "user", userID, before, map[string]interface{}{"admin": user.Admin})
+	})
+	if err != nil {
+		return types.UserProfile{}, err
+	}
+	return user, nil
+}
+
+// endpoint: /admin/users/{id}/disable
+// post, the user can not log in and the tokens and api keys of the user stop working.
+// Disabling a disabled user changes nothing. ErrNotFound when there is no such user
+func (orm *ORM) DisableUser(userID int) error {
+	return orm.setUserDisabled(userID, true)
+}
+
+// endpoint: /admin/users/{id}/enable
+// post, the tokens issued before the user was disabled stay revoked. ErrNotFound when there is no such user
+func (orm *ORM) EnableUser(userID int) error {
+	return orm.setUserDisabled(userID, false)
+}
+
+// utility
+func (orm *ORM) setUserDisabled(userID int, disabled bool) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		var wasDisabled bool
+		err := tx.QueryRow("SELECT disabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&wasDisabled)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil || wasDisabled == disabled {
+			return err
+		}
+
+		action := "enable"
+		query := "UPDATE users SET disabled_at = NULL WHERE id = $1"
+		if disabled {
+			action = "disable"
+			query = "UPDATE users SET disabled_at = NOW(), sessions_revoked_at = NOW() WHERE id = $1"
+		}
+		if _, err := tx.Exec(query, userID); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, action, "user", userID, map[string]interface{}{"disabled": wasDisabled}, map[string]interface{}{"disabled": disabled})
+	})
+}
+
+// endpoint: /admin/users/{id}/reset-password
+// post, the password stops working until the user sets a new one by a reset token, the tokens of the user
+// and the reset links sent before stop working. Returns the user to mail the new link to.
+// ErrNotFound when there is no such user
+func (orm *ORM) ForcePasswordReset(userID int) (types.UserProfile, error) {
+	var user types.UserProfile
+	err := orm.withTx(func(tx *sql.Tx) error {
+		var err error
+		user, err = scanUserProfile(tx.QueryRow(userProfileSQL+" WHERE id = $1 FOR UPDATE", userID))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE users SET password = '', sessions_revoked_at = NOW() WHERE id = $1", userID); err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND kind = $2 AND used_at IS NULL", userID, TokenResetPassword)
+		if err != nil {
+			return err
+		}
+		// password hash never goes to the audit log
+		return orm.writeAudit(tx, "force_reset", "user", userID, nil, nil)
+	})
+	if err != nil {
+		return types.UserProfile{}, err
+	}
+	user.Password = ""
+	return user, nil
+}
+
+// usernames of deleted users in the audit log and the revisions start with it, nobody else may have such a username
+const DeletedUsernamePrefix = "deleted-"
+
+// utility: name that replaces the username of a deleted user in the audit log and the revisions
+func deletedUsername(userID int) string {
+	return DeletedUsernamePrefix + strconv.Itoa(userID)
+}
+
+// endpoint: /admin/users/{id}
+// delete, the user goes together with the personal data: tokens, api keys, identities, second factor and failed logins.
+// What the user wrote for others is anonymized, not deleted: the changes stay in the audit log and the revisions
+// under a made up name, without the ip, and the records about the user lose their data. ErrNotFound when there is no such user
+func (orm *ORM) DeleteUser(userID int) error {
+	return orm.deleteUser(userID, "")
+}
+
+// utility: condition narrows the DELETE of the user row, so that a deletion restored in the meantime is skipped
+func (orm *ORM) deleteUser(userID int, condition string) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM users WHERE id = $1"+condition, userID)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(result); err != nil {
+			return err
+		}
+
+		// by id, the username may have been somebody else's before or after
+		anonymous := deletedUsername(userID)
+		for _, query := range []string{
+			"UPDATE audit_log SET username = $2, ip = '' WHERE user_id = $1",
+			"UPDATE film_revisions SET username = $2 WHERE user_id = $1",
+			"UPDATE actor_revisions SET username = $2 WHERE user_id = $1",
+		} {
+			if _, err := tx.Exec(query, userID, anonymous); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec("UPDATE audit_log SET before_data = NULL, after_data = NULL WHERE entity_type = 'user' AND entity_id = $1", userID); err != nil {
+			return err
+		}
+		// no foreign key there, the subject is the user id as text
+		if _, err := tx.Exec("DELETE FROM login_failures WHERE scope = $1 AND subject = $2", LoginScopeUser, strconv.Itoa(userID)); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "delete", "user", userID, nil, nil)
+	})
+}
+
+// endpoint: /user/me
+// delete, the account is deleted by DeleteScheduledUsers after grace, until then it works as before and the deletion
+// can be restored. Returns the moment of the deletion. ErrConflict when the deletion is scheduled already
+func (orm *ORM) ScheduleUserDeletion(userID int, grace time.Duration) (time.Time, error) {
+	var deleteAfter time.Time
+	err := orm.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(
+			"UPDATE users SET delete_after = NOW() + $2 * INTERVAL '1 second' WHERE id = $1 AND delete_after IS NULL RETURNING delete_after",
+			userID, int(grace.Seconds()),
+		).Scan(&deleteAfter)
+		if err == sql.ErrNoRows {
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "schedule_delete", "user", userID, nil, map[string]interface{}{"delete_after": deleteAfter.UTC().Format(time.RFC3339)})
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return deleteAfter, nil
+}
+
+// endpoint: /user/me/restore
+// post, ErrNotFound when no deletion is scheduled
+func (orm *ORM) RestoreUserDeletion(userID int) error {
+	return orm.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE users SET delete_after = NULL WHERE id = $1 AND delete_after IS NOT NULL", userID)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(result); err != nil {
+			return err
+		}
+		return orm.writeAudit(tx, "restore", "user", userID, nil, nil)
+	})
+}
+
+// retention
+// deletes the users whose grace period is over, like DeleteUser. The users are the authors of their own deletion,
+// under the name their changes get
+func (orm *ORM) DeleteScheduledUsers() (int, error) {
+	rows, err := orm.db.Query("SELECT id FROM users WHERE delete_after <= NOW() ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, userID := range userIDs {
+		err := orm.WithAudit(types.AuditMeta{Username: deletedUsername(userID)}).deleteUser(userID, " AND delete_after <= NOW()")
+		// restored or deleted by an admin in the meantime
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// endpoint: /user/me/export
+// get, ErrNotFound when the user was deleted after the token was issued
+func (orm *ORM) ExportUserData(userID int) (types.UserExport, error) {
+	account, err := orm.GetUserDetail(userID)
+	if err != nil {
+		return types.UserExport{}, err
+	}
+	export := types.UserExport{ExportedAt: time.Now().UTC().Format(time.RFC3339), Account: account}
+
+	if export.APIKeys, err = orm.GetAPIKeys(userID); err != nil {
+		return types.UserExport{}, err
+	}
+	if export.Identities, err = orm.getLinkedIdentities(userID); err != nil {
+		return types.UserExport{}, err
+	}
+
+	// by id, entries of an earlier owner of the username are not the user's.
+	// The ip of the admins who changed the account is theirs, not the user's
+	rows, err := orm.db.Query(
+		`SELECT id, username, action, entity_type, entity_id, before_data, after_data, request_id,
+		CASE WHEN user_id = $1 THEN ip ELSE '' END, created_at
+		FROM audit_log WHERE user_id = $1 OR (entity_type = 'user' AND entity_id = $1) ORDER BY created_at, id`,
+		userID,
+	)
+	if err != nil {
+		return types.UserExport{}, err
+	}
+	export.AuditLog, err = scanAuditEntries(rows)
+	rows.Close()
+	if err != nil {
+		return types.UserExport{}, err
+	}
+	if export.AuditLog == nil {
+		export.AuditLog = []types.AuditEntry{}
+	}
+
+	if export.Revisions, err = orm.getAuthoredRevisions(userID); err != nil {
+		return types.UserExport{}, err
+	}
+	return export, nil
+}
+
+// utility
+func (orm *ORM) getLinkedIdentities(userID int) ([]types.LinkedIdentity, error) {
+	rows, err := orm.db.Query(
+		"SELECT provider, subject, email, created_at, last_login_at FROM user_identities WHERE user_id = $1 ORDER BY created_at",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []types.LinkedIdentity{}
+	for rows.Next() {
+		var identity types.LinkedIdentity
+		var createdAt, lastLoginAt time.Time
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &createdAt, &lastLoginAt); err != nil {
+			return nil, err
+		}
+		identity.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		identity.LastLoginAt = lastLoginAt.UTC().Format(time.RFC3339)
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// utility
+func (orm *ORM) getAuthoredRevisions(userID int) ([]types.AuthoredRevision, error) {
+	rows, err := orm.db.Query(
+		`SELECT 'film', film_id, revision, created_at FROM film_revisions WHERE user_id = $1
+		UNION ALL
+		SELECT 'actor', actor_id, revision, created_at FROM actor_revisions WHERE user_id = $1
+		ORDER BY 4`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []types.AuthoredRevision{}
+	for rows.Next() {
+		var revision types.AuthoredRevision
+		var createdAt time.Time
+		if err := rows.Scan(&revision.EntityType, &revision.EntityID, &revision.Revision, &createdAt); err != nil {
+			return nil, err
+		}
+		revision.CreatedAt = createdAt.UTC().Format(time.RFC3339)
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
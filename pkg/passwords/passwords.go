@@ -0,0 +1,150 @@
+// pkg/passwords/passwords.go
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher makes password hashes that carry the algorithm and its parameters, so that a stored hash
+// can be checked after the settings have changed
+type Hasher interface {
+	Hash(password string) (string, error)
+	// the hash was made by this algorithm, whatever the parameters were
+	Owns(encoded string) bool
+	Verify(encoded, password string) bool
+	// the hash was made by this algorithm with other parameters than the hasher has now
+	Outdated(encoded string) bool
+}
+
+// hasher of new passwords, see PASSWORD_HASH in main.go
+var Default Hasher = Bcrypt{Cost: bcrypt.DefaultCost}
+
+// every algorithm a stored hash can have, the parameters are taken from the hash
+var known = []Hasher{Bcrypt{}, Argon2id{}}
+
+// utility
+func Hash(password string) (string, error) {
+	return Default.Hash(password)
+}
+
+// rehash is true when the password is right but the hash is not what Default makes now,
+// the caller stores a new hash while it has the password at hand.
+// Users made by a login provider have an empty hash, no password matches it
+func Verify(encoded, password string) (ok, rehash bool) {
+	for _, hasher := range known {
+		if !hasher.Owns(encoded) {
+			continue
+		}
+		if !hasher.Verify(encoded, password) {
+			return false, false
+		}
+		return true, !Default.Owns(encoded) || Default.Outdated(encoded)
+	}
+	return false, false
+}
+
+// bcrypt, passwords longer than 72 bytes are cut, see Policy.MaxLength
+type Bcrypt struct {
+	Cost int
+}
+
+func (h Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h Bcrypt) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h Bcrypt) Verify(encoded, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+}
+
+func (h Bcrypt) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// argon2id in the PHC string format of the reference implementation:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>, salt and key in base64 without padding
+type Argon2id struct {
+	// KiB
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var argon2Encoding = base64.RawStdEncoding
+
+func (h Argon2id) Hash(password string) (string, error) {
+	if h.Memory == 0 || h.Time == 0 || h.Threads == 0 {
+		return "", errors.New("argon2id parameters must be positive")
+	}
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads, argon2Encoding.EncodeToString(salt), argon2Encoding.EncodeToString(key)), nil
+}
+
+func (h Argon2id) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h Argon2id) Verify(encoded, password string) bool {
+	params, salt, key, err := parseArgon2id(encoded)
+	if err != nil {
+		return false
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1
+}
+
+func (h Argon2id) Outdated(encoded string) bool {
+	params, _, key, err := parseArgon2id(encoded)
+	return err != nil || params != h || len(key) != argon2KeyLength
+}
+
+// utility
+func parseArgon2id(encoded string) (params Argon2id, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("not an argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	if params.Memory == 0 || params.Time == 0 || params.Threads == 0 {
+		return params, nil, nil, errors.New("invalid argon2id parameters")
+	}
+	salt, err = argon2Encoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err = argon2Encoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2id key")
+	}
+	return params, salt, key, nil
+}
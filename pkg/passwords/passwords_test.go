@@ -0,0 +1,127 @@
+package passwords_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/vexrina/cinemaLibrary/pkg/passwords"
+)
+
+// small parameters, the tests do not need slow hashes
+var fastArgon2id = passwords.Argon2id{Memory: 64, Time: 1, Threads: 1}
+
+func withDefault(t *testing.T, hasher passwords.Hasher) {
+	previous := passwords.Default
+	passwords.Default = hasher
+	t.Cleanup(func() { passwords.Default = previous })
+}
+
+func TestVerify_Bcrypt(t *testing.T) {
+	withDefault(t, passwords.Bcrypt{Cost: bcrypt.MinCost})
+
+	hash, err := passwords.Hash("secret password")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$2a$04$"))
+
+	ok, rehash := passwords.Verify(hash, "secret password")
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, rehash = passwords.Verify(hash, "wrong password")
+	assert.False(t, ok)
+	assert.False(t, rehash)
+
+	// the cost was raised
+	withDefault(t, passwords.Bcrypt{Cost: bcrypt.MinCost + 1})
+	ok, rehash = passwords.Verify(hash, "secret password")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestVerify_Argon2id(t *testing.T) {
+	withDefault(t, fastArgon2id)
+
+	hash, err := passwords.Hash("secret password")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"))
+
+	other, _ := passwords.Hash("secret password")
+	assert.NotEqual(t, hash, other, "salt is random")
+
+	ok, rehash := passwords.Verify(hash, "secret password")
+	assert.True(t, ok)
+	assert.False(t, rehash)
+
+	ok, _ = passwords.Verify(hash, "wrong password")
+	assert.False(t, ok)
+
+	withDefault(t, passwords.Argon2id{Memory: 128, Time: 1, Threads: 1})
+	ok, rehash = passwords.Verify(hash, "secret password")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestVerify_OtherAlgorithm(t *testing.T) {
+	bcryptHash, _ := passwords.Bcrypt{Cost: bcrypt.MinCost}.Hash("secret password")
+	argon2Hash, _ := fastArgon2id.Hash("secret password")
+
+	// stored hashes of the old algorithm keep working and are replaced
+	withDefault(t, fastArgon2id)
+	ok, rehash := passwords.Verify(bcryptHash, "secret password")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+
+	withDefault(t, passwords.Bcrypt{Cost: bcrypt.MinCost})
+	ok, rehash = passwords.Verify(argon2Hash, "secret password")
+	assert.True(t, ok)
+	assert.True(t, rehash)
+}
+
+func TestVerify_Invalid(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plain text",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$",
+		"$2a$10$short",
+	} {
+		ok, rehash := passwords.Verify(hash, "")
+		assert.False(t, ok, hash)
+		assert.False(t, rehash, hash)
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy := passwords.Policy{MinLength: 8, MaxLength: passwords.BcryptMaxLength, Breached: map[string]bool{"password123": true}}
+
+	assert.NoError(t, policy.Check("correct horse"))
+	assert.EqualError(t, policy.Check("short"), "password must be at least 8 characters")
+	// characters, not bytes
+	assert.Error(t, policy.Check("пароль"))
+	assert.NoError(t, policy.Check("пароль12"))
+	assert.EqualError(t, policy.Check(strings.Repeat("a", 73)), "password must be up to 72 bytes")
+	assert.NoError(t, policy.Check(strings.Repeat("a", 72)))
+	assert.EqualError(t, policy.Check("Password123"), "password is too common, it is known from data breaches")
+
+	policy.MaxLength = 0
+	assert.NoError(t, policy.Check(strings.Repeat("a", 200)))
+}
+
+func TestLoadBreached(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("123456\r\nQwerty\n\npassword\n"), 0o600))
+
+	breached, err := passwords.LoadBreached(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"123456": true, "qwerty": true, "password": true}, breached)
+
+	_, err = passwords.LoadBreached(filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,65 @@
+// pkg/passwords/policy.go
+package passwords
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// what a new password must be like, checked on registration, password change and reset
+type Policy struct {
+	// characters
+	MinLength int
+	// bytes, 0 for no limit. bcrypt ignores everything after 72 bytes, so with bcrypt it must not be more
+	MaxLength int
+	// lowercased passwords known from breaches, see LoadBreached
+	Breached map[string]bool
+}
+
+// bcrypt only looks at the first 72 bytes of a password
+const BcryptMaxLength = 72
+
+// see PASSWORD_MIN_LENGTH, PASSWORD_MAX_LENGTH and BREACHED_PASSWORDS_FILE in main.go
+var DefaultPolicy = Policy{MinLength: 8, MaxLength: BcryptMaxLength}
+
+// utility
+func Check(password string) error {
+	return DefaultPolicy.Check(password)
+}
+
+// the error text is meant for the user
+func (p Policy) Check(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters", p.MinLength)
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		return fmt.Errorf("password must be up to %d bytes", p.MaxLength)
+	}
+	// case does not make a known password much harder to guess
+	if p.Breached[strings.ToLower(password)] {
+		return errors.New("password is too common, it is known from data breaches")
+	}
+	return nil
+}
+
+// one password per line, like the lists of the most common passwords. Empty lines are skipped
+func LoadBreached(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			breached[strings.ToLower(line)] = true
+		}
+	}
+	return breached, scanner.Err()
+}
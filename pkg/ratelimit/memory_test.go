@@ -0,0 +1,56 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/ratelimit"
+)
+
+func TestMemory_Take(t *testing.T) {
+	store := ratelimit.NewMemory()
+	limit := ratelimit.Limit{Requests: 3, Per: 3 * time.Second}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for remaining := 2; remaining >= 0; remaining-- {
+		result, err := store.Take("user:alice", limit, now)
+		assert.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, remaining, result.Remaining)
+	}
+
+	result, err := store.Take("user:alice", limit, now)
+	assert.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Second, result.RetryAfter)
+	assert.Equal(t, 3*time.Second, result.Reset)
+
+	// other keys have their own buckets
+	result, _ = store.Take("user:bob", limit, now)
+	assert.True(t, result.Allowed)
+
+	// one token per second comes back
+	result, _ = store.Take("user:alice", limit, now.Add(time.Second))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	result, _ = store.Take("user:alice", limit, now.Add(time.Hour))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+}
+
+func TestMemory_Burst(t *testing.T) {
+	store := ratelimit.NewMemory()
+	limit := ratelimit.PerMinute(60)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		if result, _ := store.Take("ip:192.0.2.1", limit, now); result.Allowed {
+			allowed++
+		}
+	}
+	assert.Equal(t, 60, allowed)
+}
@@ -0,0 +1,155 @@
+// pkg/ratelimit/ratelimit.go
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+)
+
+// token bucket: Requests fit into a burst, and the bucket refills with Requests per Per.
+// A limit with no requests means no limit
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func PerMinute(requests int) Limit {
+	return Limit{Requests: requests, Per: time.Minute}
+}
+
+// answer of the store for one request
+type Result struct {
+	Allowed   bool
+	Remaining int
+	// time until the next request is allowed, 0 when this one was
+	RetryAfter time.Duration
+	// time until the bucket is full again
+	Reset time.Duration
+}
+
+// keeps the buckets. Memory is the only backend for now; a shared one (redis and alike) has to take the token
+// atomically, so that several API instances count against the same bucket
+type Store interface {
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// roles of the caller, from the token
+const (
+	RoleAnonymous = "anonymous"
+	RoleUser      = "user"
+	RoleAdmin     = "admin"
+)
+
+// Path works like in http.ServeMux: "/film" is the path itself, "/film/" is everything under it, "" is any path.
+// Empty Method and Role match any. Rules with the same Name share the bucket
+type Rule struct {
+	Name   string
+	Method string
+	Path   string
+	Role   string
+	Limit  Limit
+}
+
+func (rule Rule) matches(method, path, role string) bool {
+	if rule.Method != "" && rule.Method != method {
+		return false
+	}
+	if rule.Role != "" && rule.Role != role {
+		return false
+	}
+	switch {
+	case rule.Path == "":
+		return true
+	case strings.HasSuffix(rule.Path, "/"):
+		return strings.HasPrefix(path, rule.Path)
+	default:
+		return path == rule.Path
+	}
+}
+
+// the first matching rule applies, so the specific ones go first. Requests that match no rule are not limited
+type Limiter struct {
+	store Store
+	rules []Rule
+}
+
+func New(store Store, rules ...Rule) *Limiter {
+	return &Limiter{store: store, rules: rules}
+}
+
+// utility: callers with a signed token are counted by username, callers with an api key by the prefix of the key,
+// the others by client ip. Nothing is looked up in the database before the limit is checked: revoked tokens
+// and unknown keys get a budget here and are refused by the handler. Keys count as users, admin or not.
+// X-Forwarded-For counts only from auditapi.TrustedProxies, a client rotating it still gets one budget
+func Identify(r *http.Request) (key string, role string) {
+	if apiKey := tokens.ExtractAPIKeyFromRequest(r); apiKey != "" {
+		if len(apiKey) > tokens.APIKeyPrefixLength {
+			apiKey = apiKey[:tokens.APIKeyPrefixLength]
+		}
+		sum := sha256.Sum256([]byte(apiKey))
+		return "key:" + hex.EncodeToString(sum[:]), RoleUser
+	}
+	claims, err := tokens.SignedClaims(r)
+	if err == nil && claims.Username != "" {
+		role = RoleUser
+		if claims.Admin {
+			role = RoleAdmin
+		}
+		return "user:" + claims.Username, role
+	}
+	return "ip:" + auditapi.ClientIP(r), RoleAnonymous
+}
+
+// utility: the rule for the request, false when it is not limited
+func (l *Limiter) Rule(method, path, role string) (Rule, bool) {
+	for _, rule := range l.rules {
+		if rule.matches(method, path, role) {
+			return rule, rule.Limit.Requests > 0
+		}
+	}
+	return Rule{}, false
+}
+
+// answers 429 with Retry-After when the bucket is empty, X-RateLimit-* headers go with every limited response.
+// Errors of the store are logged and the request goes through
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, role := Identify(r)
+		rule, limited := l.Rule(r.Method, r.URL.Path, role)
+		if !limited {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		result, err := l.store.Take(rule.Name+":"+key, rule.Limit, time.Now())
+		if err != nil {
+			log.Println("Error checking rate limit:", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rule.Limit.Requests))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// utility: whole seconds rounded up, so that clients do not come back too early
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
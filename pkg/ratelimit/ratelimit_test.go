@@ -0,0 +1,189 @@
+package ratelimit_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/ratelimit"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+var testRules = []ratelimit.Rule{
+	{Name: "search", Method: http.MethodGet, Path: "/film", Limit: ratelimit.PerMinute(1)},
+	{Name: "admin", Role: ratelimit.RoleAdmin, Limit: ratelimit.Limit{}},
+	{Name: "user", Role: ratelimit.RoleUser, Limit: ratelimit.PerMinute(3)},
+	{Name: "anonymous", Path: "/user/", Limit: ratelimit.PerMinute(2)},
+}
+
+func request(method, path, username string, admin bool) *http.Request {
+	req := httptest.NewRequest(method, path, nil)
+	if username != "" {
+		token, _ := tokens.CreateToken(1, username, admin)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func TestLimiter_Rule(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemory(), testRules...)
+
+	// the endpoint rules come before the roles, admins included
+	rule, limited := limiter.Rule(http.MethodGet, "/film", ratelimit.RoleAdmin)
+	assert.Equal(t, "search", rule.Name)
+	assert.True(t, limited)
+
+	rule, limited = limiter.Rule(http.MethodGet, "/film/1", ratelimit.RoleAdmin)
+	assert.Equal(t, "admin", rule.Name)
+	assert.False(t, limited)
+
+	rule, limited = limiter.Rule(http.MethodGet, "/film", ratelimit.RoleUser)
+	assert.Equal(t, "search", rule.Name)
+	assert.True(t, limited)
+
+	rule, _ = limiter.Rule(http.MethodGet, "/film/1", ratelimit.RoleUser)
+	assert.Equal(t, "user", rule.Name)
+
+	rule, _ = limiter.Rule(http.MethodPost, "/user/login", ratelimit.RoleAnonymous)
+	assert.Equal(t, "anonymous", rule.Name)
+
+	_, limited = limiter.Rule(http.MethodGet, "/images/a.jpg", ratelimit.RoleAnonymous)
+	assert.False(t, limited)
+}
+
+func TestIdentify(t *testing.T) {
+	key, role := ratelimit.Identify(request(http.MethodGet, "/film", "alice", false))
+	assert.Equal(t, "user:alice", key)
+	assert.Equal(t, ratelimit.RoleUser, role)
+
+	_, role = ratelimit.Identify(request(http.MethodGet, "/film", "root", true))
+	assert.Equal(t, ratelimit.RoleAdmin, role)
+
+	req := request(http.MethodGet, "/film", "", false)
+	req.Header.Set("Authorization", "Bearer broken")
+	key, role = ratelimit.Identify(req)
+	assert.Equal(t, "ip:192.0.2.1", key)
+	assert.Equal(t, ratelimit.RoleAnonymous, role)
+}
+
+func TestIdentify_NoDatabase(t *testing.T) {
+	defer func(resolver func(string) (*types.Claims, error)) { tokens.APIKeyClaims = resolver }(tokens.APIKeyClaims)
+	defer func(check func(*types.Claims) bool) { tokens.LoginTokenValid = check }(tokens.LoginTokenValid)
+	tokens.APIKeyClaims = func(key string) (*types.Claims, error) {
+		t.Error("api key looked up before the limit")
+		return nil, errors.New("not found")
+	}
+	tokens.LoginTokenValid = func(claims *types.Claims) bool {
+		t.Error("token checked in the database before the limit")
+		return false
+	}
+
+	key, role := ratelimit.Identify(request(http.MethodGet, "/film", "alice", false))
+	assert.Equal(t, "user:alice", key)
+	assert.Equal(t, ratelimit.RoleUser, role)
+
+	// keys with the same prefix share the bucket, the key itself is not part of it
+	req := request(http.MethodGet, "/film", "", false)
+	req.Header.Set("Authorization", "ApiKey ck_1234567secret")
+	key, role = ratelimit.Identify(req)
+	assert.Equal(t, ratelimit.RoleUser, role)
+	assert.NotContains(t, key, "ck_")
+	req.Header.Set("Authorization", "ApiKey ck_1234567other")
+	other, _ := ratelimit.Identify(req)
+	assert.Equal(t, key, other)
+	req.Header.Set("Authorization", "ApiKey ck_7654321secret")
+	other, _ = ratelimit.Identify(req)
+	assert.NotEqual(t, key, other)
+}
+
+func TestIdentify_ForwardedFor(t *testing.T) {
+	previous := auditapi.TrustedProxies
+	t.Cleanup(func() { auditapi.TrustedProxies = previous })
+	auditapi.TrustedProxies = nil
+
+	// straight from the client, the header is ignored
+	req := request(http.MethodGet, "/film", "", false)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	key, _ := ratelimit.Identify(req)
+	assert.Equal(t, "ip:192.0.2.1", key)
+
+	// through the proxy
+	auditapi.TrustedProxies, _ = auditapi.ParseTrustedProxies("192.0.2.1")
+	key, _ = ratelimit.Identify(req)
+	assert.Equal(t, "ip:203.0.113.7", key)
+
+	req.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+	key, _ = ratelimit.Identify(req)
+	assert.Equal(t, "ip:203.0.113.7", key)
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	limiter := ratelimit.New(ratelimit.NewMemory(), testRules...)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, request(http.MethodPost, "/user/login", "", false))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "30", rr.Header().Get("X-RateLimit-Reset"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), request(http.MethodPost, "/user/login", "", false))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, request(http.MethodPost, "/user/login", "", false))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+
+	// the search bucket of a user is separate from the user's default one
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, request(http.MethodGet, "/film", "alice", false))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, request(http.MethodGet, "/film", "alice", false))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, request(http.MethodGet, "/film/1", "alice", false))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "3", rr.Header().Get("X-RateLimit-Limit"))
+
+	// admins are not limited elsewhere and get no headers, but search counts for them too
+	for i := 0; i < 5; i++ {
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, request(http.MethodGet, "/film/1", "root", true))
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	assert.Empty(t, rr.Header().Get("X-RateLimit-Limit"))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, request(http.MethodGet, "/film", "root", true))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, request(http.MethodGet, "/film", "root", true))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+}
+
+type brokenStore struct{}
+
+func (brokenStore) Take(key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store is down")
+}
+
+func TestLimiter_MiddlewareStoreError(t *testing.T) {
+	limiter := ratelimit.New(brokenStore{}, testRules...)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, request(http.MethodPost, "/user/login", "", false))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,129 @@
+// pkg/releaseapi/releaseapi.go
+package releaseapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/isocode"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+var Types = map[string]bool{
+	"theatrical": true,
+	"streaming":  true,
+	"festival":   true,
+	"physical":   true,
+}
+
+// utility
+// /film/{id}/releases -> id, 0; /film/{id}/releases/{releaseId} -> id, releaseId
+func ParseReleasePath(path string) (filmID int, releaseID int, ok bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/film/"), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "releases" {
+		return 0, 0, false
+	}
+	filmID, err := strconv.Atoi(parts[0])
+	if err != nil || filmID <= 0 {
+		return 0, 0, false
+	}
+	if len(parts) == 3 {
+		releaseID, err = strconv.Atoi(parts[2])
+		if err != nil || releaseID <= 0 {
+			return 0, 0, false
+		}
+	}
+	return filmID, releaseID, true
+}
+
+// utility
+func Validate(release types.FilmRelease) error {
+	if !isocode.Country.MatchString(release.Country) {
+		return errors.New("country must be a country code like US")
+	}
+	if _, err := time.Parse("2006-01-02", release.ReleaseDate); err != nil {
+		return errors.New("release_date must look like 2006-01-02")
+	}
+	if !Types[release.Type] {
+		return errors.New("type must be one of theatrical, streaming, festival, physical")
+	}
+	if _, ok := types.CertificationAges[release.Certification]; release.Certification != "" && !ok {
+		return errors.New("certification must be one of 0+, 6+, 12+, 16+, 18+, G, PG, PG-13, R, NC-17")
+	}
+	return nil
+}
+
+// get method
+// url like /film/{id}/releases
+func GetFilmReleasesHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, releaseID, ok := ParseReleasePath(r.URL.Path)
+	if !ok || releaseID != 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	releases, err := orm.GetFilmReleases(filmID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(releases)
+}
+
+// post method
+// url like /film/{id}/releases, response is {"id": <new release id>}
+func CreateFilmReleaseHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, releaseID, ok := ParseReleasePath(r.URL.Path)
+	if !ok || releaseID != 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	var release types.FilmRelease
+	if err := json.NewDecoder(r.Body).Decode(&release); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	release.FilmID = filmID
+	if err := Validate(release); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	releaseID, err := orm.WithAudit(auditapi.MetaFromRequest(r)).CreateFilmRelease(release)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]int{"id": releaseID})
+}
+
+// delete method
+// url like /film/{id}/releases/{releaseId}
+func DeleteFilmReleaseHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, releaseID, ok := ParseReleasePath(r.URL.Path)
+	if !ok || releaseID == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := orm.WithAudit(auditapi.MetaFromRequest(r)).DeleteFilmRelease(filmID, releaseID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
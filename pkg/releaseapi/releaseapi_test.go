@@ -0,0 +1,93 @@
+package releaseapi_test
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/releaseapi"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+func TestParseReleasePath(t *testing.T) {
+	filmID, releaseID, ok := releaseapi.ParseReleasePath("/film/3/releases")
+	assert.True(t, ok)
+	assert.Equal(t, 3, filmID)
+	assert.Equal(t, 0, releaseID)
+
+	filmID, releaseID, ok = releaseapi.ParseReleasePath("/film/3/releases/12")
+	assert.True(t, ok)
+	assert.Equal(t, 3, filmID)
+	assert.Equal(t, 12, releaseID)
+
+	for _, path := range []string{"/film/3", "/film/x/releases", "/film/3/releases/0", "/film/3/media", "/film/3/releases/1/2"} {
+		_, _, ok := releaseapi.ParseReleasePath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, releaseapi.Validate(types.FilmRelease{Country: "RU", ReleaseDate: "2023-03-01", Type: "theatrical", Certification: "16+"}))
+	assert.NoError(t, releaseapi.Validate(types.FilmRelease{Country: "US", ReleaseDate: "2023-03-01", Type: "streaming", Certification: "PG-13"}))
+	assert.NoError(t, releaseapi.Validate(types.FilmRelease{Country: "FR", ReleaseDate: "2023-05-20", Type: "festival"}))
+
+	invalid := []types.FilmRelease{
+		{Country: "rus", ReleaseDate: "2023-03-01", Type: "theatrical"},
+		{Country: "RU", ReleaseDate: "01.03.2023", Type: "theatrical"},
+		{Country: "RU", ReleaseDate: "2023-03-01", Type: "tv"},
+		{Country: "RU", ReleaseDate: "2023-03-01", Type: "theatrical", Certification: "21+"},
+	}
+	for _, release := range invalid {
+		assert.Error(t, releaseapi.Validate(release), release)
+	}
+}
+
+func TestCreateFilmReleaseHandler_Success(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO film_releases").
+		WithArgs(1, "RU", "2023-03-01", "theatrical", "16+").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	body := []byte(`{"country": "RU", "release_date": "2023-03-01", "type": "theatrical", "certification": "16+"}`)
+	rr := httptest.NewRecorder()
+	releaseapi.CreateFilmReleaseHandler(rr, httptest.NewRequest(http.MethodPost, "/film/1/releases", bytes.NewReader(body)), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.JSONEq(t, `{"id": 4}`, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteFilmReleaseHandler_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM film_releases r JOIN films f ON f.id = r.film_id").
+		WithArgs(9, 1).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	rr := httptest.NewRecorder()
+	releaseapi.DeleteFilmReleaseHandler(rr, httptest.NewRequest(http.MethodDelete, "/film/1/releases/9", nil), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
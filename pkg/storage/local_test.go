@@ -0,0 +1,50 @@
+package storage_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/storage"
+)
+
+func TestLocal_PutOpenDelete(t *testing.T) {
+	store := storage.NewLocal(t.TempDir())
+
+	assert.NoError(t, store.Put("films/1/poster/ab/small.jpg", []byte("small")))
+	assert.NoError(t, store.Put("films/1/poster/ab/original", []byte("original")))
+
+	object, err := store.Open("films/1/poster/ab/small.jpg")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(object)
+	object.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "small", string(content))
+	assert.False(t, object.ModTime.IsZero())
+
+	assert.NoError(t, store.DeletePrefix("films/1/poster/ab"))
+	_, err = store.Open("films/1/poster/ab/original")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// missing prefix is fine
+	assert.NoError(t, store.DeletePrefix("films/2/poster/cd"))
+}
+
+func TestLocal_OpenDirectory(t *testing.T) {
+	store := storage.NewLocal(t.TempDir())
+	assert.NoError(t, store.Put("films/1/poster/ab/original", []byte("original")))
+
+	_, err := store.Open("films/1/poster/ab")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestLocal_BadKeys(t *testing.T) {
+	store := storage.NewLocal(t.TempDir())
+	for _, key := range []string{"", "../secret", "films/../../secret", "/etc/passwd", "films//1", "films\\1", "films/./1"} {
+		_, err := store.Open(key)
+		assert.ErrorIs(t, err, storage.ErrBadKey, key)
+		assert.ErrorIs(t, store.Put(key, []byte("x")), storage.ErrBadKey, key)
+		assert.ErrorIs(t, store.DeletePrefix(key), storage.ErrBadKey, key)
+	}
+}
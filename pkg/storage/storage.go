@@ -0,0 +1,39 @@
+// pkg/storage/storage.go
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// blob storage for uploaded files (posters, photos). Keys are slash separated paths like films/1/poster/ab12/small.jpg.
+// Local filesystem is the only backend for now, S3 and alike have to implement the same interface
+type Storage interface {
+	Put(key string, data []byte) error
+	Open(key string) (*Object, error)
+	// removes every object under prefix/, missing prefix is not an error
+	DeletePrefix(prefix string) error
+}
+
+type Object struct {
+	io.ReadSeekCloser
+	ModTime time.Time
+}
+
+var ErrNotFound = errors.New("object not found")
+var ErrBadKey = errors.New("bad storage key")
+
+// utility: keys come from urls, so no empty, "." or ".." segments and no leading slash
+func ValidKey(key string) bool {
+	if key == "" || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
@@ -13,14 +13,79 @@ import (
 
 var JWTKey = []byte("your_secret_key")
 
-func CreateToken(username string, adminflag bool) (string, error) {
+// Authorization: ApiKey {key} is looked up by this function, set in main.go, so that tokens do not depend on the database.
+// Without it api keys are refused
+var APIKeyClaims func(key string) (*types.Claims, error)
+
+// login tokens are checked by this function as well, set in main.go: tokens of disabled and deleted users and tokens
+// issued before the sessions of the user were revoked are refused. Without it only the signature and expiry count
+var LoginTokenValid func(claims *types.Claims) bool
+
+// api keys are told apart by their first characters: in the lists of keys and by the rate limiter
+const APIKeyPrefixLength = 10
+
+// scopes of api keys: read is for GET and HEAD, write for every other method
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// utility: login tokens have no scopes and may use every method
+func ScopeAllows(scopes []string, method string) bool {
+	if scopes == nil {
+		return true
+	}
+	needed := ScopeWrite
+	if method == http.MethodGet || method == http.MethodHead {
+		needed = ScopeRead
+	}
+	for _, scope := range scopes {
+		if scope == needed {
+			return true
+		}
+	}
+	return false
+}
+
+// the api key in the Authorization header, "" when there is none
+func ExtractAPIKeyFromRequest(r *http.Request) string {
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "ApiKey" {
+		return ""
+	}
+	return parts[1]
+}
+
+// utility: claims of the api key in the header, ok is false when the header is not an api key at all
+func apiKeyClaims(r *http.Request) (claims *types.Claims, ok bool, err error) {
+	key := ExtractAPIKeyFromRequest(r)
+	if key == "" {
+		return nil, false, nil
+	}
+	if APIKeyClaims == nil {
+		return nil, true, errors.New("api keys are not enabled")
+	}
+	claims, err = APIKeyClaims(key)
+	if err != nil {
+		return nil, true, errors.New("bad api key or api key expired")
+	}
+	if !ScopeAllows(claims.Scopes, r.Method) {
+		return nil, true, errors.New("api key has no scope for " + r.Method)
+	}
+	return claims, true, nil
+}
+
+// userID and username are the stored ones, not what the client sent
+func CreateToken(userID int, username string, adminflag bool) (string, error) {
 	expirationTime := time.Now().Add(24 * time.Hour)
 
 	claims := &types.Claims{
+		UserID:   userID,
 		Username: username,
 		Admin:    adminflag,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
+			IssuedAt:  time.Now().Unix(),
 		},
 	}
 
@@ -54,7 +119,60 @@ func ParseToken(tokenString string) (*jwt.Token, error) {
 	})
 }
 
+// claims of the request token, used by handlers that need to know who is calling
+func GetClaims(r *http.Request) (*types.Claims, error) {
+	if claims, ok, err := apiKeyClaims(r); ok {
+		return claims, err
+	}
+
+	tokenString := ExtractTokenFromRequest(r)
+	if tokenString == "" {
+		return nil, errors.New("token doesnot exist")
+	}
+
+	token, err := ParseToken(tokenString)
+	if err != nil || !token.Valid {
+		return nil, errors.New("bad token or token expired")
+	}
+
+	claims, ok := token.Claims.(*types.Claims)
+	if !ok {
+		return nil, errors.New("can not retrieve claims from token")
+	}
+	if LoginTokenValid != nil && !LoginTokenValid(claims) {
+		return nil, errors.New("token revoked")
+	}
+
+	return claims, nil
+}
+
+// claims of the login token checked by signature and expiry only, without the database: a revoked token still passes.
+// For what may be wrong about the caller, like the rate limit budget, never for access
+func SignedClaims(r *http.Request) (*types.Claims, error) {
+	tokenString := ExtractTokenFromRequest(r)
+	if tokenString == "" {
+		return nil, errors.New("token doesnot exist")
+	}
+	token, err := ParseToken(tokenString)
+	if err != nil || !token.Valid {
+		return nil, errors.New("bad token or token expired")
+	}
+	claims, ok := token.Claims.(*types.Claims)
+	if !ok {
+		return nil, errors.New("can not retrieve claims from token")
+	}
+	return claims, nil
+}
+
 func ValidateToken(w http.ResponseWriter, r *http.Request) (bool, error) {
+	if claims, ok, err := apiKeyClaims(r); ok {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return false, err
+		}
+		return claims.Admin, nil
+	}
+
 	// get token from request
 	tokenString := ExtractTokenFromRequest(r)
 
@@ -75,6 +193,10 @@ func ValidateToken(w http.ResponseWriter, r *http.Request) (bool, error) {
 		http.Error(w, "Can not retrieve claims from token", http.StatusUnauthorized)
 		return false, errors.New("can not retrieve claims from token")
 	}
+	if LoginTokenValid != nil && !LoginTokenValid(claims) {
+		http.Error(w, "Token revoked", http.StatusUnauthorized)
+		return false, errors.New("token revoked")
+	}
 
 	return claims.Admin, nil
 }
@@ -1,6 +1,7 @@
 package tokens_test
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -12,10 +13,11 @@ import (
 )
 
 func TestCreateToken(t *testing.T) {
+	userID := 42
 	username := "testuser"
 	adminFlag := true
 
-	tokenString, err := tokens.CreateToken(username, adminFlag)
+	tokenString, err := tokens.CreateToken(userID, username, adminFlag)
 	if err != nil {
 		t.Fatalf("Error creating token: %v", err)
 	}
@@ -32,7 +34,7 @@ func TestCreateToken(t *testing.T) {
 	}
 
 	claims, ok := parsedToken.Claims.(*types.Claims)
-	if !ok || !parsedToken.Valid || claims.Username != username || claims.Admin != adminFlag {
+	if !ok || !parsedToken.Valid || claims.UserID != userID || claims.Username != username || claims.Admin != adminFlag {
 		t.Fatalf("Invalid token: %v", tokenString)
 	}
 }
@@ -148,3 +150,123 @@ func TestValidateToken_ValidToken(t *testing.T) {
 	assert.NoError(t, err, "Unexpected error")
 	assert.Equal(t, http.StatusOK, rr.Code, "Expected status code to be OK")
 }
+
+func TestGetClaims(t *testing.T) {
+	tokenString, err := tokens.CreateToken(1, "admin_user", true)
+	if err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	claims, err := tokens.GetClaims(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, claims.UserID)
+	assert.Equal(t, "admin_user", claims.Username)
+	assert.True(t, claims.Admin)
+
+	req.Header.Set("Authorization", "Bearer broken")
+	_, err = tokens.GetClaims(req)
+	assert.Error(t, err)
+}
+
+func TestScopeAllows(t *testing.T) {
+	assert.True(t, tokens.ScopeAllows(nil, http.MethodDelete))
+	assert.True(t, tokens.ScopeAllows([]string{tokens.ScopeRead}, http.MethodGet))
+	assert.True(t, tokens.ScopeAllows([]string{tokens.ScopeRead}, http.MethodHead))
+	assert.False(t, tokens.ScopeAllows([]string{tokens.ScopeRead}, http.MethodPost))
+	assert.False(t, tokens.ScopeAllows([]string{tokens.ScopeWrite}, http.MethodGet))
+	assert.True(t, tokens.ScopeAllows([]string{tokens.ScopeRead, tokens.ScopeWrite}, http.MethodPatch))
+	assert.False(t, tokens.ScopeAllows([]string{}, http.MethodGet))
+}
+
+func TestAPIKey(t *testing.T) {
+	defer func(resolver func(string) (*types.Claims, error)) { tokens.APIKeyClaims = resolver }(tokens.APIKeyClaims)
+	tokens.APIKeyClaims = func(key string) (*types.Claims, error) {
+		if key != "ck_good" {
+			return nil, errors.New("not found")
+		}
+		return &types.Claims{UserID: 3, Username: "robot", Admin: true, KeyID: 7, Scopes: []string{tokens.ScopeRead}}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/film", nil)
+	req.Header.Set("Authorization", "ApiKey ck_good")
+	claims, err := tokens.GetClaims(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "robot", claims.Username)
+		assert.Equal(t, 7, claims.KeyID)
+	}
+	admin, err := tokens.ValidateToken(httptest.NewRecorder(), req)
+	assert.NoError(t, err)
+	assert.True(t, admin)
+
+	// read scope only
+	req = httptest.NewRequest(http.MethodPost, "/film", nil)
+	req.Header.Set("Authorization", "ApiKey ck_good")
+	rr := httptest.NewRecorder()
+	admin, err = tokens.ValidateToken(rr, req)
+	assert.Error(t, err)
+	assert.False(t, admin)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/film", nil)
+	req.Header.Set("Authorization", "ApiKey ck_bad")
+	_, err = tokens.GetClaims(req)
+	assert.Error(t, err)
+
+	tokens.APIKeyClaims = nil
+	req.Header.Set("Authorization", "ApiKey ck_good")
+	_, err = tokens.GetClaims(req)
+	assert.Error(t, err)
+}
+
+func TestLoginTokenValid(t *testing.T) {
+	defer func(check func(*types.Claims) bool) { tokens.LoginTokenValid = check }(tokens.LoginTokenValid)
+	tokens.LoginTokenValid = func(claims *types.Claims) bool { return claims.UserID != 13 }
+
+	for userID, valid := range map[int]bool{12: true, 13: false} {
+		tokenString, err := tokens.CreateToken(userID, "testuser", true)
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/film", nil)
+		req.Header.Set("Authorization", "Bearer "+tokenString)
+
+		claims, err := tokens.GetClaims(req)
+		rr := httptest.NewRecorder()
+		admin, validateErr := tokens.ValidateToken(rr, req)
+		if valid {
+			assert.NoError(t, err)
+			assert.NotZero(t, claims.IssuedAt)
+			assert.NoError(t, validateErr)
+			assert.True(t, admin)
+		} else {
+			assert.Error(t, err)
+			assert.Error(t, validateErr)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		}
+	}
+}
+
+func TestSignedClaims(t *testing.T) {
+	defer func(check func(*types.Claims) bool) { tokens.LoginTokenValid = check }(tokens.LoginTokenValid)
+	tokens.LoginTokenValid = func(claims *types.Claims) bool {
+		t.Error("the signature alone is checked")
+		return false
+	}
+
+	tokenString, err := tokens.CreateToken(13, "testuser", false)
+	assert.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, "/film", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+	claims, err := tokens.SignedClaims(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "testuser", claims.Username)
+
+	for _, header := range []string{"", "Bearer broken", "ApiKey ck_abc"} {
+		req.Header.Set("Authorization", header)
+		_, err = tokens.SignedClaims(req)
+		assert.Error(t, err, header)
+	}
+}
@@ -0,0 +1,71 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/totp"
+)
+
+// secret of the SHA1 test vectors of RFC 6238, appendix B
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// the RFC lists 8 digits, the last 6 of them are the 6 digit code
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := totp.Code(rfcSecret, totp.Step(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, unix)
+	}
+
+	_, err := totp.Code("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	step, ok := totp.Validate(rfcSecret, "081804", now)
+	assert.True(t, ok)
+	assert.Equal(t, totp.Step(now), step)
+
+	// a step of clock drift either way
+	_, ok = totp.Validate(rfcSecret, "081804", now.Add(totp.Period))
+	assert.True(t, ok)
+	_, ok = totp.Validate(rfcSecret, "081804", now.Add(-totp.Period))
+	assert.True(t, ok)
+	_, ok = totp.Validate(rfcSecret, "081804", now.Add(3*totp.Period))
+	assert.False(t, ok)
+
+	_, ok = totp.Validate(rfcSecret, "000000", now)
+	assert.False(t, ok)
+	_, ok = totp.Validate(rfcSecret, "81804", now)
+	assert.False(t, ok)
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := totp.NewSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+	_, ok := totp.Validate(secret, code, time.Now())
+	assert.True(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	assert.Equal(t,
+		"otpauth://totp/cinemaLibrary:alice%20smith?algorithm=SHA1&digits=6&issuer=cinemaLibrary&period=30&secret=JBSWY3DPEHPK3PXP",
+		totp.URI("cinemaLibrary", "alice smith", "JBSWY3DPEHPK3PXP"),
+	)
+}
@@ -0,0 +1,193 @@
+// pkg/translationapi/translationapi.go
+package translationapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/isocode"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// how many locales of Accept-Language are considered
+const maxLocales = 10
+
+// utility
+// "pt-br" -> "pt-BR", "EN" -> "en"; "" when the tag is not a language[-REGION] tag
+func NormalizeLocale(tag string) string {
+	parts := strings.Split(strings.TrimSpace(tag), "-")
+	locale := strings.ToLower(parts[0])
+	if len(parts) == 2 {
+		locale += "-" + strings.ToUpper(parts[1])
+	}
+	if len(parts) > 2 || !isocode.Locale.MatchString(locale) {
+		return ""
+	}
+	return locale
+}
+
+// utility
+// preferred locales, best first: ?lang= wins over Accept-Language. A regional locale is followed by its language,
+// so "pt-BR" also accepts "pt" translations. nil when the client has no preference
+func LocalesFromRequest(r *http.Request) []string {
+	var tags []string
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		tags = []string{lang}
+	} else {
+		tags = parseAcceptLanguage(r.Header.Get("Accept-Language"))
+	}
+
+	var locales []string
+	seen := make(map[string]bool)
+	add := func(locale string) {
+		if locale != "" && !seen[locale] && len(locales) < maxLocales {
+			seen[locale] = true
+			locales = append(locales, locale)
+		}
+	}
+	for _, tag := range tags {
+		locale := NormalizeLocale(tag)
+		add(locale)
+		if i := strings.Index(locale, "-"); i > 0 {
+			add(locale[:i])
+		}
+	}
+	return locales
+}
+
+// utility: "ru-RU,ru;q=0.9,en;q=0.8" -> [ru-RU ru en], q=0 and * are dropped
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		tag    string
+		weight float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if tag == "" || tag == "*" || weight <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{tag, weight})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].weight > tags[j].weight })
+
+	result := make([]string, len(tags))
+	for i, tag := range tags {
+		result[i] = tag.tag
+	}
+	return result
+}
+
+// utility
+// /film/{id}/translations -> id, ""; /film/{id}/translations/{locale} -> id, normalized locale
+func ParseTranslationPath(path string) (filmID int, locale string, ok bool) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/film/"), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "translations" {
+		return 0, "", false
+	}
+	filmID, err := strconv.Atoi(parts[0])
+	if err != nil || filmID <= 0 {
+		return 0, "", false
+	}
+	if len(parts) == 3 {
+		locale = NormalizeLocale(parts[2])
+		if locale == "" {
+			return 0, "", false
+		}
+	}
+	return filmID, locale, true
+}
+
+// get method
+// url like /film/{id}/translations
+func GetTranslationsHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, locale, ok := ParseTranslationPath(r.URL.Path)
+	if !ok || locale != "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	translations, err := orm.GetFilmTranslations(filmID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(translations)
+}
+
+// put method
+// url like /film/{id}/translations/{locale}, body is {"title": ..., "description": ...}
+func SetTranslationHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, locale, ok := ParseTranslationPath(r.URL.Path)
+	if !ok || locale == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var translation types.FilmTranslation
+	if err := json.NewDecoder(r.Body).Decode(&translation); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	translation.FilmID, translation.Locale = filmID, locale
+	if err := Validate(translation); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := orm.WithAudit(auditapi.MetaFromRequest(r)).SetFilmTranslation(translation)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete method
+// url like /film/{id}/translations/{locale}
+func DeleteTranslationHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	filmID, locale, ok := ParseTranslationPath(r.URL.Path)
+	if !ok || locale == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	err := orm.WithAudit(auditapi.MetaFromRequest(r)).DeleteFilmTranslation(filmID, locale)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// utility: the same limits as for the original title and description
+func Validate(translation types.FilmTranslation) error {
+	titleLength := utf8.RuneCountInString(translation.Title)
+	if titleLength < 1 || titleLength > 150 {
+		return errors.New("title must be 1-150 characters long")
+	}
+	if utf8.RuneCountInString(translation.Description) > 1000 {
+		return errors.New("description must be at most 1000 characters long")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package translationapi_test
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/translationapi"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	assert.Equal(t, "en", translationapi.NormalizeLocale("EN"))
+	assert.Equal(t, "pt-BR", translationapi.NormalizeLocale("pt-br"))
+	assert.Equal(t, "", translationapi.NormalizeLocale("zh-Hant-TW"))
+	assert.Equal(t, "", translationapi.NormalizeLocale("english"))
+	assert.Equal(t, "", translationapi.NormalizeLocale("*"))
+}
+
+func TestLocalesFromRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "/film", nil)
+	assert.Nil(t, translationapi.LocalesFromRequest(req))
+
+	req.Header.Set("Accept-Language", "en;q=0.8, ru-RU, fr;q=0, *;q=0.5, de;q=0.9")
+	assert.Equal(t, []string{"ru-RU", "ru", "de", "en"}, translationapi.LocalesFromRequest(req))
+
+	req = httptest.NewRequest("GET", "/film?lang=en-us", nil)
+	req.Header.Set("Accept-Language", "ru")
+	assert.Equal(t, []string{"en-US", "en"}, translationapi.LocalesFromRequest(req))
+}
+
+func TestParseTranslationPath(t *testing.T) {
+	filmID, locale, ok := translationapi.ParseTranslationPath("/film/4/translations")
+	assert.True(t, ok)
+	assert.Equal(t, 4, filmID)
+	assert.Equal(t, "", locale)
+
+	filmID, locale, ok = translationapi.ParseTranslationPath("/film/4/translations/en-gb")
+	assert.True(t, ok)
+	assert.Equal(t, 4, filmID)
+	assert.Equal(t, "en-GB", locale)
+
+	for _, path := range []string{"/film/4", "/film/0/translations", "/film/4/translations/english", "/film/4/media/1"} {
+		_, _, ok := translationapi.ParseTranslationPath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestSetTranslationHandler_Create(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM films WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT title, description FROM film_translations").
+		WithArgs(1, "ru").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO film_translations").
+		WithArgs(1, "ru", "Крестный отец", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").
+		WithArgs("", "create", "film_translation", 1, nil, []byte(`{"ru":{"description":"","film_id":1,"locale":"ru","title":"Крестный отец"}}`), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	req := httptest.NewRequest("PUT", "/film/1/translations/ru", bytes.NewReader([]byte(`{"title": "Крестный отец"}`)))
+	rr := httptest.NewRecorder()
+	translationapi.SetTranslationHandler(rr, req, orm.NewORM(db))
+
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSetTranslationHandler_BadInput(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	for _, body := range []string{`{"title": ""}`, `{"title": "` + strings.Repeat("я", 151) + `"}`, `{"title": "x", "description": "` + strings.Repeat("я", 1001) + `"}`, `not json`} {
+		rr := httptest.NewRecorder()
+		translationapi.SetTranslationHandler(rr, httptest.NewRequest("PUT", "/film/1/translations/ru", strings.NewReader(body)), orm.NewORM(db))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteTranslationHandler_NotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM films").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT title, description FROM film_translations").WithArgs(1, "de").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	rr := httptest.NewRecorder()
+	translationapi.DeleteTranslationHandler(rr, httptest.NewRequest("DELETE", "/film/1/translations/de", nil), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetTranslationsHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT film_id, locale, title, description FROM film_translations WHERE film_id = \\$1 ORDER BY locale").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"film_id", "locale", "title", "description"}).
+			AddRow(1, "en", "The Godfather", "").
+			AddRow(1, "ru", "Крестный отец", "Описание"))
+
+	rr := httptest.NewRecorder()
+	translationapi.GetTranslationsHandler(rr, httptest.NewRequest("GET", "/film/1/translations", nil), orm.NewORM(db))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var translations []types.FilmTranslation
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &translations))
+	assert.Len(t, translations, 2)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,27 +1,196 @@
 package types
 
-import "github.com/dgrijalva/jwt-go"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
 
 type Film struct {
-	ID          int     `json:"id"`
-	Title       string  `json:"title"`
-	Description string  `json:"description"`
-	ReleaseDate string  `json:"release_date"`
-	Rating      float64 `json:"rating"`
-	Actors      []int   `json:"actors"`
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+	// title in the language of the film, filled by the film detail endpoint
+	OriginalTitle string  `json:"original_title,omitempty"`
+	Description   string  `json:"description"`
+	ReleaseDate   string  `json:"release_date"`
+	Rating        float64 `json:"rating"`
+	Actors        []int   `json:"actors"`
+	Version       int     `json:"version"`
+	// minutes, 0 when unknown. This and the fields up to Languages are filled by the film detail endpoint.
+	// On update 0 runtime, empty status and nil money keep the stored values
+	Runtime int `json:"runtime,omitempty"`
+	// one of FilmStatuses, empty means released on create
+	Status    string `json:"status,omitempty"`
+	Budget    *Money `json:"budget,omitempty"`
+	BoxOffice *Money `json:"box_office,omitempty"`
+	// production countries, ISO 3166-1 alpha-2; on create and update nil keeps the stored ones
+	Countries []string `json:"countries,omitempty"`
+	// spoken languages, ISO 639-1; on create and update nil keeps the stored ones
+	Languages []string   `json:"languages,omitempty"`
+	Poster    *ImageURLs `json:"poster,omitempty"`
+	// filled only by the film detail endpoint
+	Media []FilmMedia `json:"media,omitempty"`
+	// filled only by the film detail endpoint; on create and update nil keeps the stored titles
+	AltTitles []AltTitle `json:"alternative_titles,omitempty"`
+	// filled only by the film detail endpoint
+	Series []SeriesEntry `json:"series,omitempty"`
+	// filled only by the film detail endpoint, ReleaseDate above stays the world premiere
+	Releases []FilmRelease `json:"releases,omitempty"`
+	// translation title and description are taken from, empty for the original text
+	Locale    string  `json:"locale,omitempty"`
+	DeletedAt *string `json:"deleted_at,omitempty"`
+}
+
+// trailer, teaser or clip hosted by an external video provider
+type FilmMedia struct {
+	ID       int    `json:"id"`
+	FilmID   int    `json:"film_id"`
+	Type     string `json:"type"`
+	Provider string `json:"provider"`
+	URL      string `json:"url"`
+	Language string `json:"language"`
+	// seconds, 0 when unknown
+	Duration int `json:"duration"`
+}
+
+var FilmStatuses = map[string]bool{
+	"announced":     true,
+	"in_production": true,
+	"released":      true,
+}
+
+// amount in whole units of the currency, currency is ISO 4217
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// another title the film is known under: working, festival or localized.
+// region is an ISO 3166-1 alpha-2 code, empty when the title is not bound to a country
+type AltTitle struct {
+	Title  string `json:"title"`
+	Region string `json:"region"`
+	Type   string `json:"type"`
+}
+
+// series or franchise, may be a part of another collection
+type Collection struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ParentID    *int   `json:"parent_id"`
+	// order among the sub-collections of the parent
+	Position int `json:"position"`
+	// filled only by the collection detail endpoint
+	Films       []CollectionFilm `json:"films,omitempty"`
+	Collections []Collection     `json:"collections,omitempty"`
+}
+
+type CollectionFilm struct {
+	Position    int    `json:"position"`
+	FilmID      int    `json:"film_id"`
+	Title       string `json:"title"`
+	ReleaseDate string `json:"release_date"`
+}
+
+// place of a film in one of its collections, previous/next are nil at the ends of the series
+type SeriesEntry struct {
+	CollectionID   int      `json:"collection_id"`
+	CollectionName string   `json:"collection_name"`
+	Position       int      `json:"position"`
+	Previous       *FilmRef `json:"previous"`
+	Next           *FilmRef `json:"next"`
+}
+
+type FilmRef struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+// localized title and description of a film, locale looks like "en" or "pt-BR"
+type FilmTranslation struct {
+	FilmID      int    `json:"film_id"`
+	Locale      string `json:"locale"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// release of a film in one country: theatrical, streaming, festival or physical
+type FilmRelease struct {
+	ID     int `json:"id"`
+	FilmID int `json:"film_id"`
+	// ISO 3166-1 alpha-2
+	Country     string `json:"country"`
+	ReleaseDate string `json:"release_date"`
+	Type        string `json:"type"`
+	// one of CertificationAges, empty when unknown
+	Certification string `json:"certification"`
+}
+
+// age certifications: russian ones and MPAA, mapped to the minimal age so that a ceiling works across both
+var CertificationAges = map[string]int{
+	"0+":    0,
+	"6+":    6,
+	"12+":   12,
+	"16+":   16,
+	"18+":   18,
+	"G":     0,
+	"PG":    10,
+	"PG-13": 13,
+	"R":     17,
+	"NC-17": 18,
+}
+
+// filters of the film list, zero value of a field means "no filter"
+type FilmFilter struct {
+	HasTrailer *bool
+	// release conditions, all of them have to hold for the same release
+	Country string
+	// certifications up to this age, uncertified releases do not match
+	MaxAge            *int
+	ReleasedFrom      string
+	ReleasedTo        string
+	Status            string
+	ProductionCountry string
+	Language          string
+	// minutes, 0 means no bound
+	MinRuntime int
+	MaxRuntime int
 }
 
 type Actor struct {
-	ID        int    `json:"id"`
-	Name      string `json:"name"`
-	Gender    string `json:"gender"`
-	Birthdate string `json:"birthdate"`
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Gender    string     `json:"gender"`
+	Birthdate string     `json:"birthdate"`
+	Version   int        `json:"version"`
+	Photo     *ImageURLs `json:"photo,omitempty"`
+	DeletedAt *string    `json:"deleted_at,omitempty"`
+	// profile, filled by the actor detail endpoint
+	Biography   string      `json:"biography,omitempty"`
+	Birthplace  string      `json:"birthplace,omitempty"`
+	Nationality string      `json:"nationality,omitempty"`
+	DeathDate   string      `json:"death_date,omitempty"`
+	DeathPlace  string      `json:"death_place,omitempty"`
+	Aliases     []string    `json:"aliases,omitempty"`
+	Links       []ActorLink `json:"links,omitempty"`
+	// full years, at death for actors who died; computed, never stored
+	Age *int `json:"age,omitempty"`
+}
+
+// profile of the actor on another site
+type ActorLink struct {
+	Site string `json:"site"`
+	URL  string `json:"url"`
 }
 
 type ActorWithFilms struct {
-	ID         int      `json:"id"`
-	Name       string   `json:"name"`
-	FilmTitles []string `json:"film_titles"`
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	FilmTitles []string   `json:"film_titles"`
+	Version    int        `json:"version"`
+	Photo      *ImageURLs `json:"photo,omitempty"`
 }
 
 type User struct {
@@ -30,8 +199,235 @@ type User struct {
 	Password string `json:"password"`
 }
 
+// stored user as seen by its owner, the password hash is never encoded
+type UserProfile struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Admin    bool   `json:"admin"`
+	Verified bool   `json:"verified"`
+	Disabled bool   `json:"disabled"`
+	Password string `json:"-"`
+}
+
+// user as seen by the admins at /admin/users/{id}
+type UserDetail struct {
+	UserProfile
+	CreatedAt  string `json:"created_at"`
+	DisabledAt string `json:"disabled_at,omitempty"`
+	TwoFactor  bool   `json:"two_factor"`
+	// providers of the linked OpenID Connect identities
+	Providers []string `json:"providers"`
+	// keys that are neither revoked nor expired
+	APIKeys int `json:"api_keys"`
+	// seconds until the lockout after failed logins ends, 0 when the account is not locked
+	LockedFor int `json:"locked_for"`
+	// set when the user asked to delete the account, it is deleted after this moment unless restored
+	DeleteAfter string `json:"delete_after,omitempty"`
+}
+
+// everything stored about the user, GET /user/me/export. Secrets and hashes are left out
+type UserExport struct {
+	ExportedAt string           `json:"exported_at"`
+	Account    UserDetail       `json:"account"`
+	APIKeys    []APIKey         `json:"api_keys"`
+	Identities []LinkedIdentity `json:"identities"`
+	// changes made by the user and changes of the account, the ip is only there for the changes made by the user
+	AuditLog  []AuditEntry       `json:"audit_log"`
+	Revisions []AuthoredRevision `json:"revisions"`
+}
+
+// OpenID Connect identity linked to the user
+type LinkedIdentity struct {
+	Provider    string `json:"provider"`
+	Subject     string `json:"subject"`
+	Email       string `json:"email"`
+	CreatedAt   string `json:"created_at"`
+	LastLoginAt string `json:"last_login_at"`
+}
+
+// film or actor revision saved by the user, the snapshot is the catalog data, not the user's
+type AuthoredRevision struct {
+	EntityType string `json:"entity_type"`
+	EntityID   int    `json:"entity_id"`
+	Revision   int    `json:"revision"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// filters of the admin user list, zero value of a field means "no filter"
+type UserFilter struct {
+	// part of the username or email, case does not matter
+	Query    string
+	Admin    *bool
+	Disabled *bool
+	Limit    int
+	Offset   int
+}
+
+// page of the admin user list, Total counts all the users matching the filter
+type UserPage struct {
+	Users  []UserProfile `json:"users"`
+	Total  int           `json:"total"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
 type Claims struct {
+	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
 	Admin    bool   `json:"admin"`
+	// set only for api keys, a login token may do everything the role allows
+	KeyID  int      `json:"key_id,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
 	jwt.StandardClaims
 }
+
+// personal api key, Key is filled only in the answer to its creation, only its hash is stored
+type APIKey struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	Key        string   `json:"key,omitempty"`
+}
+
+// two-factor authentication of the user, Required when the user is an admin and admins must use it
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	Required          bool `json:"required"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// user as asserted by an OpenID Connect provider (pkg/oidc), Subject is stable, the rest may change between logins
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Groups        []string
+}
+
+// who made the change, written to audit_log together with the change
+type AuditMeta struct {
+	// 0 when the author is not a user: anonymous caller, cli, background job
+	UserID    int
+	Username  string
+	RequestID string
+	IP        string
+}
+
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	Username   string          `json:"username"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	RequestID  string          `json:"request_id"`
+	IP         string          `json:"ip"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+// zero value of a field means "no filter"
+type AuditFilter struct {
+	Username   string
+	EntityType string
+	EntityID   int
+	From       time.Time
+	To         time.Time
+	// always applied, the table grows with every change
+	Limit int
+}
+
+// one stored state of a film/actor, Revision equals the row version it was taken at
+type Revision struct {
+	Revision  int             `json:"revision"`
+	Username  string          `json:"username"`
+	CreatedAt string          `json:"created_at"`
+	Snapshot  json.RawMessage `json:"snapshot,omitempty"`
+}
+
+// film row of /admin/import, actors are matched by name + birthdate and created if missing
+type ImportFilm struct {
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	ReleaseDate string  `json:"release_date"`
+	Rating      float64 `json:"rating"`
+	Actors      []Actor `json:"actors"`
+}
+
+type ImportRowError struct {
+	Line   int      `json:"line"`
+	Errors []string `json:"errors"`
+}
+
+type ImportReport struct {
+	DryRun        bool             `json:"dry_run"`
+	Rows          int              `json:"rows"`
+	Invalid       []ImportRowError `json:"invalid,omitempty"`
+	FilmsCreated  int64            `json:"films_created"`
+	ActorsCreated int64            `json:"actors_created"`
+	ActorsMatched int64            `json:"actors_matched"`
+}
+
+// row of the credits export, film <-> actor link with names for readability
+type Credit struct {
+	FilmID    int    `json:"film_id"`
+	FilmTitle string `json:"film_title"`
+	ActorID   int    `json:"actor_id"`
+	ActorName string `json:"actor_name"`
+}
+
+// film as returned by an external catalog (pkg/metadata), Film.ID and Film.Actors are not filled
+type ExternalFilm struct {
+	Provider   string          `json:"provider"`
+	ExternalID string          `json:"external_id"`
+	Film       Film            `json:"film"`
+	Cast       []ExternalActor `json:"cast,omitempty"`
+}
+
+type ExternalActor struct {
+	ExternalID string `json:"external_id"`
+	Actor      Actor  `json:"actor"`
+}
+
+// what accepting the external film would do with each cast member: link to ActorID, create or skip
+type CastMatch struct {
+	ExternalActor
+	Action  string `json:"action"`
+	ActorID int    `json:"actor_id,omitempty"`
+}
+
+type ExternalFilmPreview struct {
+	ExternalFilm
+	// film already accepted from the same external record, accepting again is a conflict
+	ExistingFilmID int         `json:"existing_film_id,omitempty"`
+	CastMatches    []CastMatch `json:"cast_matches"`
+}
+
+// urls of a stored image (poster or photo), all served by GET /images/
+type ImageURLs struct {
+	Original string `json:"original"`
+	Small    string `json:"small"`
+	Medium   string `json:"medium"`
+	Large    string `json:"large"`
+}
+
+// utility: urls for the storage key prefix of an image, nil when there is no image
+func NewImageURLs(key string) *ImageURLs {
+	if key == "" {
+		return nil
+	}
+	base := "/images/" + key + "/"
+	return &ImageURLs{
+		Original: base + "original",
+		Small:    base + "small.jpg",
+		Medium:   base + "medium.jpg",
+		Large:    base + "large.jpg",
+	}
+}
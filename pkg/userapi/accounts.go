@@ -0,0 +1,187 @@
+// pkg/userapi/accounts.go
+package userapi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/mailer"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/passwords"
+)
+
+// login is refused until the email is verified, see EMAIL_VERIFICATION in main.go
+var RequireVerification = true
+
+// links in mails point to the frontend at this url, which posts the token back to the API
+var AppURL = "http://localhost:8080"
+
+const (
+	verifyTokenTTL = 48 * time.Hour
+	resetTokenTTL  = time.Hour
+)
+
+// utility: random token for the mail and its hash for the database
+func NewToken() (token string, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashToken(token), nil
+}
+
+// utility
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// utility
+func sendVerification(users *orm.ORM, mail mailer.Mailer, userID int, username, email string) error {
+	token, hash, err := NewToken()
+	if err != nil {
+		return err
+	}
+	if err := users.CreateUserToken(userID, orm.TokenVerifyEmail, hash, verifyTokenTTL); err != nil {
+		return err
+	}
+	return mail.Send(mailer.Message{
+		To:      email,
+		Subject: "Confirm your email",
+		Body: "Hello, " + username + "!\n\n" +
+			"Open the link to confirm your email, it works for 48 hours:\n" +
+			AppURL + "/verify-email?token=" + url.QueryEscape(token) + "\n\n" +
+			"If you did not register, just ignore this mail.\n",
+	})
+}
+
+// utility
+func sendPasswordReset(users *orm.ORM, mail mailer.Mailer, userID int, username, email string) error {
+	token, hash, err := NewToken()
+	if err != nil {
+		return err
+	}
+	if err := users.CreateUserToken(userID, orm.TokenResetPassword, hash, resetTokenTTL); err != nil {
+		return err
+	}
+	return mail.Send(mailer.Message{
+		To:      email,
+		Subject: "Password reset",
+		Body: "Hello, " + username + "!\n\n" +
+			"Open the link to set a new password, it works for 1 hour and only once:\n" +
+			AppURL + "/reset-password?token=" + url.QueryEscape(token) + "\n\n" +
+			"If you did not ask for it, just ignore this mail, the password stays the same.\n",
+	})
+}
+
+// post method
+// url /user/verify, body {"token": "..."} from the verification mail
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	err := orm.WithAudit(auditapi.MetaFromRequest(r)).VerifyEmail(HashToken(body.Token))
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// post method
+// url /user/verify/resend, body {"email": "..."}. The answer is the same whether the email is known or not
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, mail mailer.Mailer) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, username, verified, err := orm.GetUserByEmail(body.Email)
+	if err == nil && !verified {
+		err = sendVerification(orm, mail, userID, username, body.Email)
+	}
+	if err != nil && !isNotFound(err) {
+		log.Println("Error sending verification mail:", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// post method
+// url /user/password/forgot, body {"email": "..."}. The answer is the same whether the email is known or not
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, mail mailer.Mailer) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, username, _, err := orm.GetUserByEmail(body.Email)
+	if err == nil {
+		err = sendPasswordReset(orm, mail, userID, username, body.Email)
+	}
+	if err != nil && !isNotFound(err) {
+		log.Println("Error sending password reset mail:", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// post method
+// url /user/password/reset, body {"token": "...", "password": "..."}
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" || body.Password == "" {
+		http.Error(w, "token and password are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := passwords.Check(body.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := passwords.Hash(body.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = orm.WithAudit(auditapi.MetaFromRequest(r)).ResetPassword(HashToken(body.Token), hashedPassword)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// utility: unknown emails are not reported, so that the endpoints can not be used to find accounts
+func isNotFound(err error) bool {
+	return errors.Is(err, orm.ErrNotFound)
+}
@@ -0,0 +1,219 @@
+package userapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/vexrina/cinemaLibrary/pkg/mailer"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/userapi"
+)
+
+// keeps the messages instead of sending them
+type sentMail struct {
+	messages []mailer.Message
+}
+
+func (m *sentMail) Send(msg mailer.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func postJSON(handler http.HandlerFunc, url string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRegisterHandler_SendsVerificationMail(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	mail := &sentMail{}
+
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO users").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec("INSERT INTO audit_log").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("INSERT INTO user_tokens").WithArgs(7, "verify_email", sqlmock.AnyArg(), 48*60*60).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.RegisterHandler(w, r, orm, mail) },
+		"/user/register", map[string]string{"username": "testuser", "email": "test@example.com", "password": "testpassword"})
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	if assert.Len(t, mail.messages, 1) {
+		assert.Equal(t, "test@example.com", mail.messages[0].To)
+		assert.Contains(t, mail.messages[0].Body, userapi.AppURL+"/verify-email?token=")
+	}
+}
+
+func TestLoginHandler_NotVerified(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	expectLoginNotBlocked(mock, "ip", sqlmock.AnyArg())
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").WithArgs("test@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}).AddRow(1, "testuser", "test@example.com", false, false, false, "$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"))
+	expectLoginNotBlocked(mock, "user", "1")
+
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, orm) },
+		"/user/login", map[string]string{"email": "test@example.com", "password": "testpassword"})
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.Equal(t, "Email is not verified\n", rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginHandler_RehashesOutdatedPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("testpassword"), bcrypt.MinCost)
+
+	expectLoginNotBlocked(mock, "ip", sqlmock.AnyArg())
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").WithArgs("test@example.com").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "testuser", "test@example.com", false, true, false, string(hash)))
+	expectLoginNotBlocked(mock, "user", "1")
+	mock.ExpectExec("UPDATE users SET password = \\$3 WHERE id = \\$1 AND password = \\$2").
+		WithArgs(1, string(hash), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectTwoFactor(mock, 1, "", false)
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "1").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, orm) },
+		"/user/login", map[string]string{"email": "test@example.com", "password": "testpassword"})
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestVerifyEmailHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := func(w http.ResponseWriter, r *http.Request) { userapi.VerifyEmailHandler(w, r, orm) }
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE user_tokens SET used_at = NOW\\(\\) WHERE token_hash = \\$1 AND kind = \\$2").
+		WithArgs(userapi.HashToken("good"), "verify_email").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(3))
+	mock.ExpectExec("UPDATE users SET verified_at = NOW\\(\\)").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs(sqlmock.AnyArg(), "verify", "user", 3, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr := postJSON(handler, "/user/verify", map[string]string{"token": "good"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// used or expired token
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE user_tokens").WithArgs(userapi.HashToken("good"), "verify_email").WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+
+	rr = postJSON(handler, "/user/verify", map[string]string{"token": "good"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = postJSON(handler, "/user/verify", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestForgotPasswordHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	mail := &sentMail{}
+	handler := func(w http.ResponseWriter, r *http.Request) { userapi.ForgotPasswordHandler(w, r, orm, mail) }
+
+	mock.ExpectQuery("SELECT id, username, verified_at IS NOT NULL FROM users").WithArgs("test@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "verified"}).AddRow(1, "testuser", true))
+	mock.ExpectExec("INSERT INTO user_tokens").WithArgs(1, "reset_password", sqlmock.AnyArg(), 60*60).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr := postJSON(handler, "/user/password/forgot", map[string]string{"email": "test@example.com"})
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	// unknown email gets the same answer and no mail
+	mock.ExpectQuery("SELECT id, username, verified_at IS NOT NULL FROM users").WithArgs("nobody@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "verified"}))
+
+	rr = postJSON(handler, "/user/password/forgot", map[string]string{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	if assert.Len(t, mail.messages, 1) {
+		assert.Equal(t, "test@example.com", mail.messages[0].To)
+		assert.Contains(t, mail.messages[0].Body, "/reset-password?token=")
+	}
+}
+
+func TestResetPasswordHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := func(w http.ResponseWriter, r *http.Request) { userapi.ResetPasswordHandler(w, r, orm) }
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE user_tokens SET used_at = NOW\\(\\) WHERE token_hash").
+		WithArgs(userapi.HashToken("reset"), "reset_password").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(5))
+	mock.ExpectExec("UPDATE users SET password = \\$1").WithArgs(sqlmock.AnyArg(), 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE user_tokens SET used_at = NOW\\(\\) WHERE user_id").WithArgs(5, "reset_password").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "5").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs(sqlmock.AnyArg(), "reset_password", "user", 5, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr := postJSON(handler, "/user/password/reset", map[string]string{"token": "reset", "password": "newpassword"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = postJSON(handler, "/user/password/reset", map[string]string{"token": "reset"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = postJSON(handler, "/user/password/reset", map[string]string{"token": "reset", "password": "short"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "password must be at least 8 characters\n", rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := userapi.NewToken()
+	assert.NoError(t, err)
+	assert.Len(t, hash, 64)
+	assert.Equal(t, userapi.HashToken(token), hash)
+	assert.False(t, strings.ContainsAny(token, "+/="))
+
+	other, _, _ := userapi.NewToken()
+	assert.NotEqual(t, token, other)
+}
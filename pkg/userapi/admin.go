@@ -0,0 +1,228 @@
+// pkg/userapi/admin.go
+package userapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/mailer"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 500
+)
+
+// utility: for tokens.LoginTokenValid. One query per request, a token that can not be checked is refused
+func LoginTokenChecker(users *orm.ORM) func(claims *types.Claims) bool {
+	return func(claims *types.Claims) bool {
+		// tokens issued before the user id went into the claims can not be checked, they expire within a day
+		if claims.UserID == 0 {
+			return true
+		}
+		age := time.Since(time.Unix(claims.IssuedAt, 0))
+		valid, err := users.LoginTokenValid(claims.UserID, age)
+		if err != nil {
+			log.Println("Error checking token:", err)
+			return false
+		}
+		return valid
+	}
+}
+
+// utility: url like /admin/users/{id} or /admin/users/{id}/{action}, action is empty for the first one
+func ParseAdminUserPath(path string) (userID int, action string, ok bool) {
+	rest, found := strings.CutPrefix(path, "/admin/users/")
+	if !found {
+		return 0, "", false
+	}
+	id, action, _ := strings.Cut(rest, "/")
+	userID, err := strconv.Atoi(id)
+	if err != nil || userID <= 0 || strings.Contains(action, "/") {
+		return 0, "", false
+	}
+	return userID, action, true
+}
+
+// utility: the user of the url, admins can not disable, delete or demote their own account,
+// so that there is always an admin left to undo it
+func adminTarget(w http.ResponseWriter, r *http.Request, protectSelf bool) (int, bool) {
+	userID, _, ok := ParseAdminUserPath(r.URL.Path)
+	if !ok {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return 0, false
+	}
+	if protectSelf {
+		if claims, err := currentClaims(r); err == nil && claims.UserID == userID {
+			http.Error(w, "Admins can not disable, delete or demote their own account", http.StatusBadRequest)
+			return 0, false
+		}
+	}
+	return userID, true
+}
+
+// get method
+// url like /admin/users?q=alice&admin=true&disabled=false&limit=50&offset=0, q is a part of the username or email
+func GetUsersHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	queryValues := r.URL.Query()
+	filter := types.UserFilter{Query: queryValues.Get("q"), Limit: defaultUserPageSize}
+
+	for name, target := range map[string]**bool{"admin": &filter.Admin, "disabled": &filter.Disabled} {
+		value := queryValues.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "Invalid value for "+name+" parameter", http.StatusBadRequest)
+			return
+		}
+		*target = &parsed
+	}
+	var err error
+	if limit := queryValues.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxUserPageSize {
+			http.Error(w, "Invalid value for limit parameter, 1 to "+strconv.Itoa(maxUserPageSize), http.StatusBadRequest)
+			return
+		}
+	}
+	if offset := queryValues.Get("offset"); offset != "" {
+		filter.Offset, err = strconv.Atoi(offset)
+		if err != nil || filter.Offset < 0 {
+			http.Error(w, "Invalid value for offset parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := orm.GetUsers(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// get method
+// url /admin/users/{id}
+func GetUserHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := adminTarget(w, r, false)
+	if !ok {
+		return
+	}
+
+	user, err := orm.GetUserDetail(userID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// patch method
+// url /admin/users/{id}, body {"admin": true}. A demoted admin has to log in again
+func UpdateUserRoleHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	var body struct {
+		Admin *bool `json:"admin"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Admin == nil {
+		http.Error(w, "admin is required", http.StatusBadRequest)
+		return
+	}
+	userID, ok := adminTarget(w, r, !*body.Admin)
+	if !ok {
+		return
+	}
+
+	user, err := orm.WithAudit(auditapi.MetaFromRequest(r)).SetUserAdmin(userID, *body.Admin)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// post method
+// url /admin/users/{id}/disable, the user is logged out everywhere and can not log in until enabled again
+func DisableUserHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := adminTarget(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := orm.WithAudit(auditapi.MetaFromRequest(r)).DisableUser(userID); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// post method
+// url /admin/users/{id}/enable
+func EnableUserHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := adminTarget(w, r, false)
+	if !ok {
+		return
+	}
+
+	if err := orm.WithAudit(auditapi.MetaFromRequest(r)).EnableUser(userID); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// post method
+// url /admin/users/{id}/reset-password, the password stops working, the user is logged out everywhere
+// and gets a mail with a link to set a new one
+func ForcePasswordResetHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, mail mailer.Mailer) {
+	userID, ok := adminTarget(w, r, false)
+	if !ok {
+		return
+	}
+
+	user, err := orm.WithAudit(auditapi.MetaFromRequest(r)).ForcePasswordReset(userID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	if err := sendPasswordReset(orm, mail, user.ID, user.Username, user.Email); err != nil {
+		log.Println("Error sending password reset mail:", err)
+		http.Error(w, "Password was reset, but the mail was not sent, the user can ask for it at /user/password/forgot", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// delete method
+// url /admin/users/{id}, the user and the personal data are deleted, the changes the user made stay anonymous
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := adminTarget(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := orm.WithAudit(auditapi.MetaFromRequest(r)).DeleteUser(userID); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,235 @@
+package userapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+	"github.com/vexrina/cinemaLibrary/pkg/userapi"
+)
+
+func TestParseAdminUserPath(t *testing.T) {
+	userID, action, ok := userapi.ParseAdminUserPath("/admin/users/12")
+	assert.True(t, ok)
+	assert.Equal(t, 12, userID)
+	assert.Equal(t, "", action)
+
+	userID, action, ok = userapi.ParseAdminUserPath("/admin/users/12/disable")
+	assert.True(t, ok)
+	assert.Equal(t, 12, userID)
+	assert.Equal(t, "disable", action)
+
+	for _, path := range []string{"/admin/users/", "/admin/users/abc", "/admin/users/0/disable", "/admin/users/12/disable/now", "/user/12"} {
+		_, _, ok := userapi.ParseAdminUserPath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestGetUsersHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.GetUsersHandler(w, r, orm) })
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM users WHERE 1 = 1 AND \\(username ILIKE \\$1 OR email ILIKE \\$1\\) AND \\(disabled_at IS NOT NULL\\) = \\$2").
+		WithArgs("%al\\_ce%", false).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectQuery("FROM users WHERE 1 = 1 AND .* ORDER BY id LIMIT \\$3 OFFSET \\$4").
+		WithArgs("%al\\_ce%", false, 2, 2).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(7, "al_ce", "alice@example.com", true, true, false, "hash"))
+
+	rr := serve(handler, authorized(http.MethodGet, "/admin/users?q=al_ce&disabled=false&limit=2&offset=2", nil, 1, "admin"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var page types.UserPage
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, 2, page.Limit)
+	if assert.Len(t, page.Users, 1) {
+		assert.Equal(t, "al_ce", page.Users[0].Username)
+	}
+	assert.NotContains(t, rr.Body.String(), "hash")
+
+	for _, query := range []string{"admin=maybe", "limit=0", "limit=501", "offset=-1"} {
+		rr := serve(handler, authorized(http.MethodGet, "/admin/users?"+query, nil, 1, "admin"))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+}
+
+func TestUpdateUserRoleHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.UpdateUserRoleHandler(w, r, orm) })
+
+	// an admin can not demote themselves
+	rr := serve(handler, authorized(http.MethodPatch, "/admin/users/1", map[string]bool{"admin": false}, 1, "admin"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// the demoted admin is logged out
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id = \\$1 FOR UPDATE").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(5, "bob", "bob@example.com", true, true, false, "hash"))
+	mock.ExpectExec("UPDATE users SET adminflag = \\$1, sessions_revoked_at = CASE WHEN \\$1 THEN sessions_revoked_at ELSE NOW\\(\\) END WHERE id = \\$2").
+		WithArgs(false, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("admin", "update", "user", 5, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr = serve(handler, authorized(http.MethodPatch, "/admin/users/5", map[string]bool{"admin": false}, 1, "admin"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"admin":false`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDisableUserHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.DisableUserHandler(w, r, orm) })
+
+	rr := serve(handler, authorized(http.MethodPost, "/admin/users/1/disable", nil, 1, "admin"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT disabled_at IS NOT NULL FROM users WHERE id = \\$1 FOR UPDATE").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"disabled"}).AddRow(false))
+	mock.ExpectExec("UPDATE users SET disabled_at = NOW\\(\\), sessions_revoked_at = NOW\\(\\) WHERE id = \\$1").WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("admin", "disable", "user", 5, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr = serve(handler, authorized(http.MethodPost, "/admin/users/5/disable", nil, 1, "admin"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	// unknown user
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT disabled_at IS NOT NULL FROM users").WithArgs(6).WillReturnRows(sqlmock.NewRows([]string{"disabled"}))
+	mock.ExpectRollback()
+
+	rr = serve(handler, authorized(http.MethodPost, "/admin/users/6/disable", nil, 1, "admin"))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginHandler_Disabled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	expectLoginNotBlocked(mock, "ip", "192.0.2.1")
+	mock.ExpectQuery("FROM users WHERE email = \\$1 OR username = \\$1").WithArgs("testuser").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(2, "testuser", "test@example.com", false, true, true, "$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"))
+	expectLoginNotBlocked(mock, "user", "2")
+
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, orm) },
+		"/user/login", map[string]string{"login": "testuser", "password": "testpassword"})
+
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "token")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestForcePasswordResetHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	mail := &sentMail{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.ForcePasswordResetHandler(w, r, orm, mail) })
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id = \\$1 FOR UPDATE").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(5, "bob", "bob@example.com", false, true, false, "hash"))
+	mock.ExpectExec("UPDATE users SET password = '', sessions_revoked_at = NOW\\(\\) WHERE id = \\$1").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE user_tokens SET used_at = NOW\\(\\)").WithArgs(5, "reset_password").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("admin", "force_reset", "user", 5, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("INSERT INTO user_tokens").WithArgs(5, "reset_password", sqlmock.AnyArg(), 3600).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr := serve(handler, authorized(http.MethodPost, "/admin/users/5/reset-password", nil, 1, "admin"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	if assert.Len(t, mail.messages, 1) {
+		assert.Equal(t, "bob@example.com", mail.messages[0].To)
+		assert.Contains(t, mail.messages[0].Body, "/reset-password?token=")
+	}
+}
+
+func TestDeleteUserHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.DeleteUserHandler(w, r, orm) })
+
+	rr := serve(handler, authorized(http.MethodDelete, "/admin/users/1", nil, 1, "admin"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM users WHERE id = \\$1").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE audit_log SET username = \\$2, ip = '' WHERE user_id = \\$1").WithArgs(5, "deleted-5").WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("UPDATE film_revisions SET username = \\$2 WHERE user_id = \\$1").WithArgs(5, "deleted-5").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE actor_revisions SET username = \\$2 WHERE user_id = \\$1").WithArgs(5, "deleted-5").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE audit_log SET before_data = NULL, after_data = NULL WHERE entity_type = 'user' AND entity_id = \\$1").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "5").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("admin", "delete", "user", 5, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr = serve(handler, authorized(http.MethodDelete, "/admin/users/5", nil, 1, "admin"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginTokenChecker(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	check := userapi.LoginTokenChecker(orm.NewORM(db))
+	claims := &types.Claims{UserID: 5}
+	claims.IssuedAt = time.Now().Add(-time.Hour).Unix()
+
+	mock.ExpectQuery("SELECT disabled_at IS NULL AND").WithArgs(5, 3600).WillReturnRows(sqlmock.NewRows([]string{"valid"}).AddRow(true))
+	assert.True(t, check(claims))
+	mock.ExpectQuery("SELECT disabled_at IS NULL AND").WithArgs(5, 3600).WillReturnRows(sqlmock.NewRows([]string{"valid"}).AddRow(false))
+	assert.False(t, check(claims))
+	// deleted user
+	mock.ExpectQuery("SELECT disabled_at IS NULL AND").WithArgs(5, 3600).WillReturnRows(sqlmock.NewRows([]string{"valid"}))
+	assert.False(t, check(claims))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,190 @@
+// pkg/userapi/apikeys.go
+package userapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// keys look like ck_ and 43 random characters, the first tokens.APIKeyPrefixLength characters are kept to tell the keys apart in lists
+const (
+	apiKeyPrefix = "ck_"
+
+	defaultAPIKeyDays = 90
+	maxAPIKeyDays     = 365
+)
+
+// utility: the key for the user and what is stored of it
+func NewAPIKey() (key, prefix, hash string, err error) {
+	token, _, err := NewToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = apiKeyPrefix + token
+	return key, key[:tokens.APIKeyPrefixLength], HashToken(key), nil
+}
+
+// utility: for tokens.APIKeyClaims. Keys of admins are admin keys only under the same rule as logins, see RequireAdminTwoFactor
+func APIKeyResolver(users *orm.ORM) func(key string) (*types.Claims, error) {
+	return func(key string) (*types.Claims, error) {
+		claims, twoFactor, err := users.GetAPIKeyClaims(HashToken(key))
+		if err != nil {
+			return nil, err
+		}
+		claims.Admin = adminAllowed(claims.Admin, twoFactor)
+		return claims, nil
+	}
+}
+
+// utility: scopes must be known, at least one, without repeats
+func ValidateAPIKey(name string, scopes []string, expiresInDays int) error {
+	if name == "" || len(name) > 100 {
+		return errors.New("name must be 1 to 100 characters")
+	}
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required: read, write")
+	}
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		if scope != tokens.ScopeRead && scope != tokens.ScopeWrite {
+			return errors.New("unknown scope " + scope + ", known are read, write")
+		}
+		if seen[scope] {
+			return errors.New("scope " + scope + " is repeated")
+		}
+		seen[scope] = true
+	}
+	if expiresInDays < 0 || expiresInDays > maxAPIKeyDays {
+		return errors.New("expires_in_days must be 1 to " + strconv.Itoa(maxAPIKeyDays))
+	}
+	return nil
+}
+
+// utility: id of the caller with a login token. Keys and the account are managed only with one,
+// so that a leaked key can not make new keys or get a login token
+func loginClaims(w http.ResponseWriter, r *http.Request) (*types.Claims, bool) {
+	claims, err := currentClaims(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	if claims.KeyID != 0 {
+		http.Error(w, "Not allowed with an API key, log in", http.StatusForbidden)
+		return nil, false
+	}
+	return claims, true
+}
+
+// utility
+func loginUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	claims, ok := loginClaims(w, r)
+	if !ok {
+		return 0, false
+	}
+	return claims.UserID, true
+}
+
+// utility: url like /user/api-keys/{id}
+func ParseAPIKeyPath(path string) (int, bool) {
+	rest, found := strings.CutPrefix(path, "/user/api-keys/")
+	if !found {
+		return 0, false
+	}
+	keyID, err := strconv.Atoi(strings.TrimSuffix(rest, "/"))
+	if err != nil || keyID <= 0 {
+		return 0, false
+	}
+	return keyID, true
+}
+
+// post method
+// url /user/api-keys, body {"name": "...", "scopes": ["read", "write"], "expires_in_days": 90}.
+// The key is in the answer and only there
+func CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := loginUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := ValidateAPIKey(body.Name, body.Scopes, body.ExpiresInDays); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.ExpiresInDays == 0 {
+		body.ExpiresInDays = defaultAPIKeyDays
+	}
+
+	key, prefix, hash, err := NewAPIKey()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	created, err := orm.WithAudit(auditapi.MetaFromRequest(r)).CreateAPIKey(userID, body.Name, prefix, hash, body.Scopes, body.ExpiresInDays)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	created.Key = key
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// get method
+// url /user/api-keys, keys of the caller without the secrets
+func GetAPIKeysHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := loginUserID(w, r)
+	if !ok {
+		return
+	}
+
+	keys, err := orm.GetAPIKeys(userID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// delete method
+// url /user/api-keys/{id}, the key stops working at once
+func RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := loginUserID(w, r)
+	if !ok {
+		return
+	}
+	keyID, ok := ParseAPIKeyPath(r.URL.Path)
+	if !ok {
+		http.Error(w, "Invalid api key id", http.StatusBadRequest)
+		return
+	}
+
+	if err := orm.WithAudit(auditapi.MetaFromRequest(r)).RevokeAPIKey(userID, keyID); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,196 @@
+package userapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+	"github.com/vexrina/cinemaLibrary/pkg/userapi"
+)
+
+func TestValidateAPIKey(t *testing.T) {
+	assert.NoError(t, userapi.ValidateAPIKey("backup script", []string{"read"}, 0))
+	assert.NoError(t, userapi.ValidateAPIKey("importer", []string{"read", "write"}, 365))
+
+	assert.Error(t, userapi.ValidateAPIKey("", []string{"read"}, 30))
+	assert.Error(t, userapi.ValidateAPIKey(strings.Repeat("a", 101), []string{"read"}, 30))
+	assert.Error(t, userapi.ValidateAPIKey("script", nil, 30))
+	assert.Error(t, userapi.ValidateAPIKey("script", []string{"admin"}, 30))
+	assert.Error(t, userapi.ValidateAPIKey("script", []string{"read", "read"}, 30))
+	assert.Error(t, userapi.ValidateAPIKey("script", []string{"read"}, 366))
+	assert.Error(t, userapi.ValidateAPIKey("script", []string{"read"}, -1))
+}
+
+func TestParseAPIKeyPath(t *testing.T) {
+	keyID, ok := userapi.ParseAPIKeyPath("/user/api-keys/5")
+	assert.True(t, ok)
+	assert.Equal(t, 5, keyID)
+
+	for _, path := range []string{"/user/api-keys/", "/user/api-keys/x", "/user/api-keys/0", "/user/me/5"} {
+		_, ok := userapi.ParseAPIKeyPath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestCreateAPIKeyHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.CreateAPIKeyHandler(w, r, orm) })
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO api_keys").
+		WithArgs(4, "backup script", sqlmock.AnyArg(), sqlmock.AnyArg(), "{\"read\"}", 90).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "expires_at"}).AddRow(11, created, created.AddDate(0, 0, 90)))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("testuser", "create", "api_key", 11, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodPost, "/user/api-keys", map[string]interface{}{"name": "backup script", "scopes": []string{"read"}}, 4, "testuser"))
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var key types.APIKey
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &key))
+	assert.Equal(t, 11, key.ID)
+	assert.True(t, strings.HasPrefix(key.Key, "ck_"), key.Key)
+	assert.Equal(t, key.Key[:10], key.Prefix)
+	assert.Equal(t, "2024-05-30T12:00:00Z", key.ExpiresAt)
+}
+
+func TestAPIKeyHandlers_RefuseAPIKeys(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	defer func(resolver func(string) (*types.Claims, error)) { tokens.APIKeyClaims = resolver }(tokens.APIKeyClaims)
+	tokens.APIKeyClaims = func(key string) (*types.Claims, error) {
+		return &types.Claims{UserID: 4, Username: "testuser", KeyID: 2, Scopes: []string{"read", "write"}}, nil
+	}
+
+	for _, handler := range []http.HandlerFunc{
+		func(w http.ResponseWriter, r *http.Request) { userapi.CreateAPIKeyHandler(w, r, orm) },
+		func(w http.ResponseWriter, r *http.Request) { userapi.RevokeAPIKeyHandler(w, r, orm) },
+		func(w http.ResponseWriter, r *http.Request) { userapi.UpdateMeHandler(w, r, orm, &sentMail{}) },
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/user/api-keys/2", strings.NewReader(`{"name": "more", "scopes": ["write"]}`))
+		req.Header.Set("Authorization", "ApiKey ck_something")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestGetAPIKeysHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.GetAPIKeysHandler(w, r, orm) })
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT id, name, prefix, scopes, created_at, expires_at, last_used_at FROM api_keys WHERE user_id = \\$1 AND revoked_at IS NULL").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "prefix", "scopes", "created_at", "expires_at", "last_used_at"}).
+			AddRow(11, "backup script", "ck_abcdefg", "{read,write}", created, created.AddDate(0, 0, 90), created.Add(time.Hour)).
+			AddRow(10, "old", "ck_1234567", "{read}", created, created.AddDate(0, 0, 30), nil))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodGet, "/user/api-keys", nil, 4, "testuser"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), `"key"`)
+	var keys []types.APIKey
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &keys))
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, []string{"read", "write"}, keys[0].Scopes)
+		assert.Equal(t, "2024-03-01T13:00:00Z", keys[0].LastUsedAt)
+		assert.Empty(t, keys[1].LastUsedAt)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeAPIKeyHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.RevokeAPIKeyHandler(w, r, orm) })
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE api_keys SET revoked_at = NOW\\(\\) WHERE id = \\$1 AND user_id = \\$2").WithArgs(11, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("testuser", "revoke", "api_key", 11, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodDelete, "/user/api-keys/11", nil, 4, "testuser"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	// key of another user
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE api_keys SET revoked_at").WithArgs(12, 4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodDelete, "/user/api-keys/12", nil, 4, "testuser"))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAPIKeyResolver(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	resolve := userapi.APIKeyResolver(orm.NewORM(db))
+
+	keyColumns := []string{"id", "scopes", "user_id", "username", "adminflag", "two_factor"}
+	mock.ExpectQuery("UPDATE api_keys SET last_used_at = NOW\\(\\)\\s+WHERE id IN \\(SELECT id FROM key WHERE last_used_at IS NULL OR last_used_at < NOW\\(\\) - INTERVAL '1 minute'\\)").
+		WithArgs(userapi.HashToken("ck_good")).
+		WillReturnRows(sqlmock.NewRows(keyColumns).AddRow(11, "{read}", 4, "testuser", true, true))
+	mock.ExpectQuery("UPDATE api_keys SET last_used_at").
+		WithArgs(userapi.HashToken("ck_no2fa")).
+		WillReturnRows(sqlmock.NewRows(keyColumns).AddRow(12, "{read}", 5, "admin2", true, false))
+	mock.ExpectQuery("UPDATE api_keys SET last_used_at").
+		WithArgs(userapi.HashToken("ck_revoked")).
+		WillReturnRows(sqlmock.NewRows(keyColumns))
+
+	claims, err := resolve("ck_good")
+	if assert.NoError(t, err) {
+		assert.Equal(t, types.Claims{UserID: 4, Username: "testuser", Admin: true, KeyID: 11, Scopes: []string{"read"}}, *claims)
+	}
+	// the key of an admin without two-factor authentication is not an admin key
+	claims, err = resolve("ck_no2fa")
+	if assert.NoError(t, err) {
+		assert.False(t, claims.Admin)
+	}
+	_, err = resolve("ck_revoked")
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,154 @@
+// pkg/userapi/oidc.go
+package userapi
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/oidc"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+)
+
+// the browser must come back from the provider within this time
+const oidcStateTTL = 10 * time.Minute
+
+// the state is also kept in a cookie of the browser that started the login, so that a callback
+// with a state of somebody else's login (login CSRF) is refused
+const oidcStateCookie = "oidc_state"
+
+// utility: maxAge -1 removes the cookie
+func setOIDCStateCookie(w http.ResponseWriter, provider *oidc.Provider, state string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/user/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   provider.SecureCallback(),
+		// the provider brings the browser back with a top-level get, Lax cookies are sent with it
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// utility: url like /user/oidc/{provider}/login or /user/oidc/{provider}/callback
+func ParseOIDCPath(path string) (provider, action string, ok bool) {
+	rest, found := strings.CutPrefix(path, "/user/oidc/")
+	if !found {
+		return "", "", false
+	}
+	provider, action, found = strings.Cut(rest, "/")
+	if !found || provider == "" || (action != "login" && action != "callback") {
+		return "", "", false
+	}
+	return provider, action, true
+}
+
+// utility: the provider from the url, answers 404 when it is not configured
+func oidcProvider(w http.ResponseWriter, r *http.Request, providers oidc.Providers) (*oidc.Provider, bool) {
+	name, _, ok := ParseOIDCPath(r.URL.Path)
+	provider, configured := providers[name]
+	if !ok || !configured {
+		http.Error(w, "Unknown login provider", http.StatusNotFound)
+		return nil, false
+	}
+	return provider, true
+}
+
+// get method
+// url /user/oidc/{provider}/login, sends the browser to the login page of the provider.
+// State, nonce and PKCE verifier stay in the database until the browser comes back
+func OIDCLoginHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, providers oidc.Providers) {
+	provider, ok := oidcProvider(w, r, providers)
+	if !ok {
+		return
+	}
+
+	state, stateHash, err := NewToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.NewVerifier()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthURL(state, nonce, verifier)
+	if err != nil {
+		log.Println("Error discovering login provider:", err)
+		http.Error(w, "Login provider is not available", http.StatusBadGateway)
+		return
+	}
+	if err := orm.CreateOIDCState(stateHash, provider.Name(), nonce, verifier, oidcStateTTL); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setOIDCStateCookie(w, provider, state, int(oidcStateTTL/time.Second))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// get method
+// url /user/oidc/{provider}/callback?code=...&state=..., where the provider sends the browser back.
+// Answers like /user/login, the user is linked or made on the first login
+func OIDCCallbackHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, providers oidc.Providers) {
+	provider, ok := oidcProvider(w, r, providers)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		http.Error(w, "Login at the provider failed: "+providerError, http.StatusUnauthorized)
+		return
+	}
+	code, state := query.Get("code"), query.Get("state")
+	if code == "" || state == "" {
+		http.Error(w, "code and state are required", http.StatusBadRequest)
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		http.Error(w, "Login was not started in this browser", http.StatusBadRequest)
+		return
+	}
+	setOIDCStateCookie(w, provider, "", -1)
+
+	nonce, verifier, err := orm.UseOIDCState(HashToken(state), provider.Name())
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	identity, err := provider.Exchange(code, verifier, nonce)
+	if err != nil {
+		log.Println("Error exchanging login code:", err)
+		http.Error(w, "Login at the provider could not be confirmed", http.StatusUnauthorized)
+		return
+	}
+
+	var admin *bool
+	if isAdmin, mapped := provider.Admin(identity.Groups); mapped {
+		admin = &isAdmin
+	}
+
+	// the user is not logged in yet, the orm makes them the author of their own record
+	user, err := orm.WithAudit(auditapi.MetaFromRequest(r)).LoginWithIdentity(identity, admin)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	completeLogin(w, orm, user)
+}
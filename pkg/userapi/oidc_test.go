@@ -0,0 +1,176 @@
+package userapi_test
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/oidc"
+	"github.com/vexrina/cinemaLibrary/pkg/oidc/oidctest"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+	"github.com/vexrina/cinemaLibrary/pkg/userapi"
+)
+
+// sqlmock argument that matches anything and remembers it
+type captured struct {
+	value driver.Value
+}
+
+func (c *captured) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func TestParseOIDCPath(t *testing.T) {
+	provider, action, ok := userapi.ParseOIDCPath("/user/oidc/corp/callback")
+	assert.True(t, ok)
+	assert.Equal(t, "corp", provider)
+	assert.Equal(t, "callback", action)
+
+	for _, path := range []string{"/user/oidc/", "/user/oidc/corp", "/user/oidc//login", "/user/oidc/corp/logout"} {
+		_, _, ok := userapi.ParseOIDCPath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	idp := oidctest.NewServer("cinema", "secret")
+	defer idp.Close()
+	config := idp.Config("corp", "http://app.test/user/oidc/corp/callback")
+	config.AdminGroups = []string{"cinema-admins"}
+	providers := oidc.Providers{}
+	providers.Add(oidc.New(config))
+
+	// roles from the groups are checked here, not the two-factor rule for admins
+	defer func(required bool) { userapi.RequireAdminTwoFactor = required }(userapi.RequireAdminTwoFactor)
+	userapi.RequireAdminTwoFactor = false
+
+	orm := orm.NewORM(db)
+	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.OIDCLoginHandler(w, r, orm, providers) })
+	callback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.OIDCCallbackHandler(w, r, orm, providers) })
+
+	// the browser is sent to the provider
+	stateHash, nonce, verifier := &captured{}, &captured{}, &captured{}
+	mock.ExpectExec("DELETE FROM oidc_states WHERE expires_at < NOW\\(\\)").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO oidc_states").WithArgs(stateHash, "corp", nonce, verifier, 600).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rr := httptest.NewRecorder()
+	login.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/user/oidc/corp/login", nil))
+	assert.Equal(t, http.StatusFound, rr.Code)
+	cookies := rr.Result().Cookies()
+	if !assert.Len(t, cookies, 1) {
+		return
+	}
+	stateCookie := cookies[0]
+	assert.Equal(t, "oidc_state", stateCookie.Name)
+	assert.Equal(t, "/user/oidc/", stateCookie.Path)
+	assert.True(t, stateCookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, stateCookie.SameSite)
+	assert.Equal(t, 600, stateCookie.MaxAge)
+
+	// the user logs in there and the provider sends the browser back
+	idp.Login(map[string]interface{}{"sub": "u-1", "email": "alice@corp.test", "email_verified": true, "preferred_username": "alice", "groups": []string{"cinema-admins"}})
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(rr.Header().Get("Location"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	response.Body.Close()
+	back, err := url.Parse(response.Header.Get("Location"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "/user/oidc/corp/callback", back.Path)
+	assert.Equal(t, userapi.HashToken(back.Query().Get("state")), stateHash.value)
+	assert.Equal(t, back.Query().Get("state"), stateCookie.Value)
+
+	mock.ExpectQuery("DELETE FROM oidc_states WHERE state_hash = \\$1 AND provider = \\$2").
+		WithArgs(stateHash.value, "corp").
+		WillReturnRows(sqlmock.NewRows([]string{"nonce", "verifier"}).AddRow(nonce.value, verifier.value))
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM users WHERE id = \\(SELECT user_id FROM user_identities").WithArgs("corp", "u-1").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(4, "alice", "alice@corp.test", false, true, false, ""))
+	mock.ExpectExec("UPDATE user_identities SET email").WithArgs("alice@corp.test", "corp", "u-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET adminflag = \\$1, sessions_revoked_at = CASE WHEN \\$1 THEN sessions_revoked_at ELSE date_trunc\\('second', NOW\\(\\)\\) END WHERE id = \\$2").WithArgs(true, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("alice", "update", "user", 4, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectTwoFactor(mock, 4, "", false)
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "4").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, back.RequestURI(), nil)
+	req.AddCookie(&http.Cookie{Name: stateCookie.Name, Value: stateCookie.Value})
+	callback.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// the state is used up, so is the cookie
+	if cookies := rr.Result().Cookies(); assert.Len(t, cookies, 1) {
+		assert.Equal(t, "oidc_state", cookies[0].Name)
+		assert.True(t, cookies[0].MaxAge < 0)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var body map[string]string
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	claims := &types.Claims{}
+	_, err = jwt.ParseWithClaims(body["token"], claims, func(token *jwt.Token) (interface{}, error) { return tokens.JWTKey, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 4, claims.UserID)
+	assert.Equal(t, "alice", claims.Username)
+	assert.True(t, claims.Admin)
+}
+
+func TestOIDCCallback_Refused(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	providers := oidc.Providers{}
+	providers.Add(oidc.New(oidc.Config{Name: "corp", Issuer: "http://127.0.0.1:1", ClientID: "cinema"}))
+	orm := orm.NewORM(db)
+	callback := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.OIDCCallbackHandler(w, r, orm, providers) })
+
+	rr := httptest.NewRecorder()
+	callback.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/user/oidc/other/callback?code=c&state=s", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	callback.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/user/oidc/corp/callback?error=access_denied", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	// login started in another browser: no cookie or the cookie of another login. The state is not used up
+	for _, cookie := range []*http.Cookie{nil, {Name: "oidc_state", Value: "other"}} {
+		req := httptest.NewRequest(http.MethodGet, "/user/oidc/corp/callback?code=c&state=s", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rr = httptest.NewRecorder()
+		callback.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	}
+
+	// unknown, used or expired state
+	mock.ExpectQuery("DELETE FROM oidc_states").WithArgs(userapi.HashToken("s"), "corp").WillReturnRows(sqlmock.NewRows([]string{"nonce", "verifier"}))
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/user/oidc/corp/callback?code=c&state=s", nil)
+	req.AddCookie(&http.Cookie{Name: "oidc_state", Value: "s"})
+	callback.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
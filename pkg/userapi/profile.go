@@ -0,0 +1,170 @@
+// pkg/userapi/profile.go
+package userapi
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"strings"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/mailer"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/passwords"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// utility: empty values are not checked, callers decide what is required.
+// Usernames can not contain @, so that a login is never both a username and an email
+func validateProfile(username, email string) error {
+	if username != "" && (len(username) > 50 || strings.Contains(username, "@")) {
+		return errors.New("username must be up to 50 characters without @")
+	}
+	if strings.HasPrefix(username, orm.DeletedUsernamePrefix) {
+		return errors.New("username must not start with " + orm.DeletedUsernamePrefix)
+	}
+	if email != "" {
+		address, err := netmail.ParseAddress(email)
+		if err != nil || address.Address != email || len(email) > 100 {
+			return errors.New("email must be a plain address up to 100 characters")
+		}
+	}
+	return nil
+}
+
+// utility: claims of the caller. Tokens issued before ids were put into claims have none, such users log in again
+func currentClaims(r *http.Request) (*types.Claims, error) {
+	claims, err := tokens.GetClaims(r)
+	if err != nil {
+		return nil, err
+	}
+	if claims.UserID == 0 {
+		return nil, errors.New("token has no user id, log in again")
+	}
+	return claims, nil
+}
+
+// utility
+func currentUserID(r *http.Request) (int, error) {
+	claims, err := currentClaims(r)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
+
+// get method
+// url /user/me, profile of the token owner
+func GetMeHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := orm.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// patch method
+// url /user/me, body {"username": "...", "email": "...", "password": "...", "current_password": "..."}, every field is optional.
+// A new email or password needs the current password. A new email is verified again by mail,
+// a new username comes with a new token, since the old one carries the old name
+func UpdateMeHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, mail mailer.Mailer) {
+	claims, ok := loginClaims(w, r)
+	if !ok {
+		return
+	}
+	userID := claims.UserID
+
+	var body struct {
+		Username        string `json:"username"`
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Username == "" && body.Email == "" && body.Password == "" {
+		http.Error(w, "nothing to change", http.StatusBadRequest)
+		return
+	}
+	if err := validateProfile(body.Username, body.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Password != "" {
+		if err := passwords.Check(body.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	current, err := orm.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	emailChanged := body.Email != "" && body.Email != current.Email
+	if emailChanged || body.Password != "" {
+		if body.CurrentPassword == "" {
+			http.Error(w, "current_password is required to change email or password", http.StatusBadRequest)
+			return
+		}
+		if valid, _ := passwords.Verify(current.Password, body.CurrentPassword); !valid {
+			http.Error(w, "Current password is wrong", http.StatusForbidden)
+			return
+		}
+	}
+
+	hashedPassword := ""
+	if body.Password != "" {
+		hashedPassword, err = passwords.Hash(body.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	user, err := orm.WithAudit(auditapi.MetaFromRequest(r)).UpdateUser(userID, body.Username, body.Email, hashedPassword)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	// the email is changed already, a failed mail is sent again through /user/verify/resend
+	if emailChanged {
+		if err := sendVerification(orm, mail, user.ID, user.Username, user.Email); err != nil {
+			log.Println("Error sending verification mail:", err)
+		}
+	}
+
+	response := struct {
+		types.UserProfile
+		Token string `json:"token,omitempty"`
+	}{UserProfile: user}
+	if user.Username != current.Username {
+		// the role of the new token is that of the current one, which had to pass two-factor authentication for it
+		response.Token, err = tokens.CreateToken(user.ID, user.Username, user.Admin && claims.Admin)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,223 @@
+package userapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/userapi"
+)
+
+var userColumns = []string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}
+
+func authorized(method, url string, body interface{}, userID int, username string) *http.Request {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, url, bytes.NewReader(data))
+	token, _ := tokens.CreateToken(userID, username, false)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestLoginHandler_ByUsername(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	expectLoginNotBlocked(mock, "ip", sqlmock.AnyArg())
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users WHERE email = \\$1 OR username = \\$1").
+		WithArgs("testuser").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(9, "testuser", "test@example.com", true, true, false, "$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"))
+	expectLoginNotBlocked(mock, "user", "9")
+	expectTwoFactor(mock, 9, "", false)
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "9").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// the username in the body is not the one that goes to the token
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, orm) },
+		"/user/login", map[string]string{"login": "testuser", "username": "someone_else", "password": "testpassword"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var response map[string]string
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	req := httptest.NewRequest(http.MethodGet, "/user/me", nil)
+	req.Header.Set("Authorization", "Bearer "+response["token"])
+	claims, err := tokens.GetClaims(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 9, claims.UserID)
+		assert.Equal(t, "testuser", claims.Username)
+		// an admin without two-factor authentication
+		assert.False(t, claims.Admin)
+	}
+	assert.NotEmpty(t, response["warning"])
+}
+
+func TestLoginHandler_MissingLogin(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, orm) },
+		"/user/login", map[string]string{"password": "testpassword"})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestGetMeHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.GetMeHandler(w, r, orm) })
+
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users WHERE id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(4, "testuser", "test@example.com", false, true, false, "secret-hash"))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodGet, "/user/me", nil, 4, "testuser"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"id":4,"username":"testuser","email":"test@example.com","admin":false,"verified":true,"disabled":false}`, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// token issued before ids were put into claims
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodGet, "/user/me", nil, 0, "testuser"))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/user/me", nil))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestUpdateMeHandler_Username(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	mail := &sentMail{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.UpdateMeHandler(w, r, orm, mail) })
+
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users WHERE id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(4, "testuser", "test@example.com", false, true, false, "hash"))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(4, "testuser", "test@example.com", false, true, false, "hash"))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(4, "newname", "test@example.com").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("UPDATE users").WithArgs("newname", "test@example.com", "hash", false, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("testuser", "update", "user", 4, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodPatch, "/user/me", map[string]string{"username": "newname"}, 4, "testuser"))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Empty(t, mail.messages)
+
+	var response struct {
+		Username string `json:"username"`
+		Token    string `json:"token"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "newname", response.Username)
+	assert.NotEmpty(t, response.Token)
+}
+
+func TestUpdateMeHandler_EmailNeedsCurrentPassword(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	mail := &sentMail{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.UpdateMeHandler(w, r, orm, mail) })
+	hash, _ := bcrypt.GenerateFromPassword([]byte("current"), bcrypt.MinCost)
+
+	expectUser := func() {
+		mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users WHERE id = \\$1").
+			WithArgs(4).
+			WillReturnRows(sqlmock.NewRows(userColumns).AddRow(4, "testuser", "test@example.com", false, true, false, string(hash)))
+	}
+
+	expectUser()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodPatch, "/user/me", map[string]string{"email": "new@example.com"}, 4, "testuser"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	expectUser()
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodPatch, "/user/me", map[string]string{"email": "new@example.com", "current_password": "wrong"}, 4, "testuser"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	expectUser()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(4, "testuser", "test@example.com", false, true, false, string(hash)))
+	mock.ExpectQuery("SELECT COUNT").WithArgs(4, "testuser", "new@example.com").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("UPDATE users").WithArgs("testuser", "new@example.com", string(hash), true, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE user_tokens").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO audit_log").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("INSERT INTO user_tokens").WithArgs(4, "verify_email", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodPatch, "/user/me", map[string]string{"email": "new@example.com", "current_password": "current"}, 4, "testuser"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"verified":false`)
+	assert.NotContains(t, rr.Body.String(), `"token"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	if assert.Len(t, mail.messages, 1) {
+		assert.Equal(t, "new@example.com", mail.messages[0].To)
+	}
+}
+
+func TestUpdateMeHandler_Validation(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.UpdateMeHandler(w, r, orm, &sentMail{}) })
+
+	for _, body := range []map[string]string{
+		{},
+		{"username": "has@at"},
+		// names of deleted users in the audit log
+		{"username": "deleted-5"},
+		{"email": "not an email"},
+		{"email": "Name <name@example.com>"},
+	} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, authorized(http.MethodPatch, "/user/me", body, 4, "testuser"))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, body)
+	}
+}
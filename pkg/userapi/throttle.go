@@ -0,0 +1,174 @@
+// pkg/userapi/throttle.go
+package userapi
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/passwords"
+)
+
+// brute-force protection of /user/login, see LOGIN_* in main.go.
+// Failed logins are counted per account and per client ip, every failure after the free ones doubles the wait,
+// starting from a second. An account with LockoutThreshold failures is locked until an admin unlocks it or LockoutDuration passes.
+// The ip is the one of auditapi.ClientIP, X-Forwarded-For counts only when it is set by a trusted proxy
+var (
+	FreeLoginAttempts  = 3
+	FreeIPAttempts     = 20
+	MaxLoginBackoff    = 15 * time.Minute
+	LockoutThreshold   = 10
+	LockoutDuration    = 30 * time.Minute
+	LoginFailureWindow = time.Hour
+)
+
+// utility: wait after the failures-th failure in a row
+func LoginBackoff(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	shift := failures - free - 1
+	if shift > 30 {
+		return MaxLoginBackoff
+	}
+	backoff := time.Second << shift
+	if backoff > MaxLoginBackoff {
+		return MaxLoginBackoff
+	}
+	return backoff
+}
+
+// utility: Retry-After is in whole seconds, rounded up so that the client does not come back too early
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// utility: errors of the throttling are logged, a broken counter does not stop logins
+func ipBlocked(users *orm.ORM, ip string) time.Duration {
+	wait, err := users.GetLoginBlock(orm.LoginScopeIP, ip)
+	if err != nil {
+		log.Println("Error checking login block:", err)
+	}
+	return wait
+}
+
+// utility
+func accountBlocked(users *orm.ORM, userID int) time.Duration {
+	wait, err := users.GetLoginBlock(orm.LoginScopeUser, strconv.Itoa(userID))
+	if err != nil {
+		log.Println("Error checking login block:", err)
+	}
+	return wait
+}
+
+// utility: counts the failure for the ip and, when the login matched a user, for the account.
+// Returns how long the client has to wait before the next try
+func failedLogin(users *orm.ORM, r *http.Request, ip string, userID int) time.Duration {
+	wait := recordFailure(users, orm.LoginScopeIP, ip, FreeIPAttempts)
+	if userID == 0 {
+		return wait
+	}
+
+	subject := strconv.Itoa(userID)
+	failures, err := users.RecordLoginFailure(orm.LoginScopeUser, subject, LoginFailureWindow)
+	if err != nil {
+		log.Println("Error recording login failure:", err)
+		return wait
+	}
+	if failures >= LockoutThreshold {
+		if err := users.WithAudit(auditapi.MetaFromRequest(r)).LockUser(userID, LockoutDuration, failures); err != nil {
+			log.Println("Error locking account:", err)
+		}
+		log.Printf("Account %d locked for %s after %d failed logins, last from %s", userID, LockoutDuration, failures, ip)
+		return maxDuration(wait, LockoutDuration)
+	}
+	if backoff := LoginBackoff(failures, FreeLoginAttempts); backoff > 0 {
+		if err := users.BlockLogin(orm.LoginScopeUser, subject, backoff); err != nil {
+			log.Println("Error blocking login:", err)
+		}
+		wait = maxDuration(wait, backoff)
+	}
+	return wait
+}
+
+// utility
+func recordFailure(users *orm.ORM, scope, subject string, free int) time.Duration {
+	failures, err := users.RecordLoginFailure(scope, subject, LoginFailureWindow)
+	if err != nil {
+		log.Println("Error recording login failure:", err)
+		return 0
+	}
+	backoff := LoginBackoff(failures, free)
+	if backoff == 0 {
+		return 0
+	}
+	if err := users.BlockLogin(scope, subject, backoff); err != nil {
+		log.Println("Error blocking login:", err)
+	}
+	if failures == free+1 {
+		log.Printf("Logins from %s %s are throttled after %d failures", scope, subject, failures)
+	}
+	return backoff
+}
+
+// hash of the configured algorithm that logins without an account to check are verified against
+var (
+	noPasswordOnce sync.Once
+	noPasswordHash string
+)
+
+// utility: spends the time of a real password check, the result does not matter
+func verifyNoPassword(password string) {
+	noPasswordOnce.Do(func() {
+		hash, err := passwords.Hash("no password")
+		if err != nil {
+			log.Println("Error hashing the dummy password:", err)
+		}
+		noPasswordHash = hash
+	})
+	passwords.Verify(noPasswordHash, password)
+}
+
+// utility
+func succeededLogin(users *orm.ORM, userID int) {
+	if err := users.ClearLoginFailures(orm.LoginScopeUser, strconv.Itoa(userID)); err != nil {
+		log.Println("Error clearing login failures:", err)
+	}
+}
+
+// utility
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// utility: url like /admin/users/{id}/unlock
+func ParseUnlockPath(path string) (int, bool) {
+	userID, action, ok := ParseAdminUserPath(path)
+	return userID, ok && action == "unlock"
+}
+
+// post method
+// url /admin/users/{id}/unlock, lifts the lockout and the backoff of the account
+func UnlockUserHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := ParseUnlockPath(r.URL.Path)
+	if !ok {
+		http.Error(w, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	if err := orm.WithAudit(auditapi.MetaFromRequest(r)).UnlockUser(userID); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,174 @@
+package userapi_test
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/userapi"
+)
+
+func expectLoginNotBlocked(mock sqlmock.Sqlmock, scope string, subject driver.Value) {
+	mock.ExpectQuery("SELECT CEIL\\(EXTRACT\\(EPOCH FROM blocked_until - NOW\\(\\)\\)\\)::int FROM login_failures").
+		WithArgs(scope, subject).
+		WillReturnRows(sqlmock.NewRows([]string{"seconds"}))
+}
+
+func expectLoginFailure(mock sqlmock.Sqlmock, scope string, subject driver.Value, failures int) {
+	mock.ExpectQuery("INSERT INTO login_failures").
+		WithArgs(scope, subject, 3600).
+		WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(failures))
+}
+
+func TestLoginBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), userapi.LoginBackoff(3, 3))
+	assert.Equal(t, time.Second, userapi.LoginBackoff(4, 3))
+	assert.Equal(t, 2*time.Second, userapi.LoginBackoff(5, 3))
+	assert.Equal(t, 32*time.Second, userapi.LoginBackoff(9, 3))
+	assert.Equal(t, userapi.MaxLoginBackoff, userapi.LoginBackoff(20, 3))
+	assert.Equal(t, userapi.MaxLoginBackoff, userapi.LoginBackoff(1000, 3))
+}
+
+func TestLoginHandler_IPBlocked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	mock.ExpectQuery("SELECT CEIL").WithArgs("ip", "192.0.2.1").WillReturnRows(sqlmock.NewRows([]string{"seconds"}).AddRow(42))
+
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, orm) },
+		"/user/login", map[string]string{"login": "testuser", "password": "testpassword"})
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "42", rr.Header().Get("Retry-After"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginHandler_Backoff(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	expectLoginNotBlocked(mock, "ip", "192.0.2.1")
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").
+		WithArgs("testuser").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(2, "testuser", "test@example.com", false, true, false, "$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"))
+	expectLoginNotBlocked(mock, "user", "2")
+	expectLoginFailure(mock, "ip", "192.0.2.1", 1)
+	expectLoginFailure(mock, "user", "2", 5)
+	mock.ExpectExec("UPDATE login_failures SET blocked_until").WithArgs("user", "2", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, orm) },
+		"/user/login", map[string]string{"login": "testuser", "password": "wrong"})
+
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLoginHandler_Lockout(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, orm) }
+
+	expectLoginNotBlocked(mock, "ip", "192.0.2.1")
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").
+		WithArgs("testuser").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(2, "testuser", "test@example.com", false, true, false, "$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"))
+	expectLoginNotBlocked(mock, "user", "2")
+	expectLoginFailure(mock, "ip", "192.0.2.1", 1)
+	expectLoginFailure(mock, "user", "2", userapi.LockoutThreshold)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE login_failures SET blocked_until").WithArgs("user", "2", int(userapi.LockoutDuration.Seconds())).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("", "lock", "user", 2, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), "192.0.2.1", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr := postJSON(handler, "/user/login", map[string]string{"login": "testuser", "password": "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Equal(t, "1800", rr.Header().Get("Retry-After"))
+
+	// the right password does not help while the account is locked
+	expectLoginNotBlocked(mock, "ip", "192.0.2.1")
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").
+		WithArgs("testuser").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(2, "testuser", "test@example.com", false, true, false, "$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"))
+	mock.ExpectQuery("SELECT CEIL").WithArgs("user", "2").WillReturnRows(sqlmock.NewRows([]string{"seconds"}).AddRow(1799))
+	expectLoginFailure(mock, "ip", "192.0.2.1", 2)
+
+	locked := postJSON(handler, "/user/login", map[string]string{"login": "testuser", "password": "testpassword"})
+
+	// and the answer is the one of a login that matches no account
+	expectLoginNotBlocked(mock, "ip", "192.0.2.1")
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").
+		WithArgs("nobody").
+		WillReturnRows(sqlmock.NewRows(userColumns))
+	expectLoginFailure(mock, "ip", "192.0.2.1", 3)
+
+	unknown := postJSON(handler, "/user/login", map[string]string{"login": "nobody", "password": "testpassword"})
+
+	assert.Equal(t, http.StatusUnauthorized, locked.Code)
+	assert.Equal(t, unknown.Code, locked.Code)
+	assert.Equal(t, unknown.Body.String(), locked.Body.String())
+	assert.Equal(t, unknown.Header(), locked.Header())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestParseUnlockPath(t *testing.T) {
+	userID, ok := userapi.ParseUnlockPath("/admin/users/12/unlock")
+	assert.True(t, ok)
+	assert.Equal(t, 12, userID)
+
+	for _, path := range []string{"/admin/users/12", "/admin/users/abc/unlock", "/admin/users/0/unlock", "/admin/users/12/lock"} {
+		_, ok := userapi.ParseUnlockPath(path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestUnlockUserHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.UnlockUserHandler(w, r, orm) })
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users WHERE id = \\$1\\)").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "2").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("admin", "unlock", "user", 2, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodPost, "/admin/users/2/unlock", nil, 1, "admin"))
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectRollback()
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, authorized(http.MethodPost, "/admin/users/3/unlock", nil, 1, "admin"))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,386 @@
+// pkg/userapi/twofactor.go
+package userapi
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/totp"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// admins get admin rights only with two-factor authentication, see TWO_FACTOR_ADMINS in main.go.
+// Without it their tokens and api keys are those of a regular user, enough to set it up
+var RequireAdminTwoFactor = true
+
+// name of the service in authenticator apps
+var TwoFactorIssuer = "cinemaLibrary"
+
+const (
+	loginChallengeTTL = 5 * time.Minute
+	recoveryCodeCount = 10
+	// the handlers take an orm argument that hides the package
+	loginChallengeKind = orm.TokenLoginChallenge
+)
+
+// utility
+func adminAllowed(admin, twoFactor bool) bool {
+	return admin && (twoFactor || !RequireAdminTwoFactor)
+}
+
+// utility: codes look like abcd-efgh-ijkl-mnop, 80 random bits each. Only the hashes are stored
+func NewRecoveryCodes() (codes []string, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(raw))
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:16])
+		hashes = append(hashes, HashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// utility: case, spaces and dashes of recovery codes do not matter
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// utility: true when the code is a code of the user's authenticator or a recovery code.
+// Either is accepted once, false when two-factor authentication is off
+func checkSecondFactor(users *orm.ORM, userID int, code string) (bool, error) {
+	secret, enabled, err := users.GetTOTP(userID)
+	if isNotFound(err) || (err == nil && !enabled) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		err = users.UseTOTPStep(userID, step)
+	} else {
+		err = users.UseRecoveryCode(userID, HashToken(normalizeRecoveryCode(code)))
+	}
+	if errors.Is(err, orm.ErrBadToken) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// utility: a wrong code counts as a failed login, so the codes can not be guessed faster than passwords
+func wrongSecondFactor(w http.ResponseWriter, r *http.Request, users *orm.ORM, userID int) {
+	if wait := failedLogin(users, r, auditapi.ClientIP(r), userID); wait > 0 {
+		setRetryAfter(w, wait)
+	}
+	http.Error(w, "Invalid code", http.StatusUnauthorized)
+}
+
+// utility: last step of the password and provider logins. A user with two-factor authentication gets
+// a challenge for /user/login/2fa instead of the token, the failed logins are cleared only after it
+func completeLogin(w http.ResponseWriter, users *orm.ORM, user types.UserProfile) {
+	if refuseDisabled(w, user) {
+		return
+	}
+	_, enabled, err := users.GetTOTP(user.ID)
+	if err != nil && !isNotFound(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !enabled {
+		succeededLogin(users, user.ID)
+		issueToken(w, user, false)
+		return
+	}
+
+	challenge, challengeHash, err := NewToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := users.CreateUserToken(user.ID, loginChallengeKind, challengeHash, loginChallengeTTL); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"two_factor_required": true,
+		"challenge":           challenge,
+		"expires_in":          int(loginChallengeTTL.Seconds()),
+	})
+}
+
+// utility: the password or the provider may be right, the account is still closed by the admins
+func refuseDisabled(w http.ResponseWriter, user types.UserProfile) bool {
+	if user.Disabled {
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+	}
+	return user.Disabled
+}
+
+// utility: admins without the second factor get a token without admin rights and a warning why
+func issueToken(w http.ResponseWriter, user types.UserProfile, twoFactor bool) {
+	admin := adminAllowed(user.Admin, twoFactor)
+	tokenString, err := tokens.CreateToken(user.ID, user.Username, admin)
+	if err != nil {
+		http.Error(w, "Error with creating token", http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]string{"token": tokenString}
+	if user.Admin && !admin {
+		response["warning"] = "Admin rights need two-factor authentication, set it up at /user/2fa/setup and log in again"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// post method
+// url /user/login/2fa, body {"challenge": "...", "code": "123456"}, code of the authenticator or a recovery code.
+// A wrong code may be retried while the challenge lives, every try counts as a failed login
+func LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	var body struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Challenge == "" || body.Code == "" {
+		http.Error(w, "challenge and code are required", http.StatusBadRequest)
+		return
+	}
+
+	if wait := ipBlocked(orm, auditapi.ClientIP(r)); wait > 0 {
+		setRetryAfter(w, wait)
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	challengeHash := HashToken(body.Challenge)
+	userID, err := orm.GetUserTokenUser(loginChallengeKind, challengeHash)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	if wait := accountBlocked(orm, userID); wait > 0 {
+		setRetryAfter(w, wait)
+		http.Error(w, "Account is locked after failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	ok, err := checkSecondFactor(orm, userID, body.Code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		wrongSecondFactor(w, r, orm, userID)
+		return
+	}
+
+	// a challenge makes one token, even when the same code is sent twice at once
+	if _, err := orm.UseUserToken(loginChallengeKind, challengeHash); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	succeededLogin(orm, userID)
+
+	user, err := orm.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	if refuseDisabled(w, user) {
+		return
+	}
+	issueToken(w, user, true)
+}
+
+// get method
+// url /user/2fa, {"enabled": true, "required": false, "recovery_codes_left": 10}
+func GetTwoFactorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := loginUserID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := orm.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	_, enabled, err := orm.GetTOTP(userID)
+	if err != nil && !isNotFound(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	left, err := orm.CountRecoveryCodes(userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(types.TwoFactorStatus{
+		Enabled:           enabled,
+		Required:          user.Admin && RequireAdminTwoFactor,
+		RecoveryCodesLeft: left,
+	})
+}
+
+// post method
+// url /user/2fa/setup, a new secret to be confirmed at /user/2fa/enable. The uri goes to a QR code for the app
+func SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	claims, ok := loginClaims(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := orm.SetTOTPSecret(claims.UserID, secret); err != nil {
+		if httperror.Status(err) == http.StatusConflict {
+			http.Error(w, "Two-factor authentication is on already", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(TwoFactorIssuer, claims.Username, secret),
+	})
+}
+
+// post method
+// url /user/2fa/enable, body {"code": "123456"} from the app set up with /user/2fa/setup.
+// Answers with the recovery codes, they are shown only here
+func EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := loginUserID(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	secret, enabled, err := orm.GetTOTP(userID)
+	if isNotFound(err) || (err == nil && enabled) {
+		http.Error(w, "Nothing to enable, start with /user/2fa/setup", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	step, valid := totp.Validate(secret, strings.TrimSpace(body.Code), time.Now())
+	if !valid {
+		http.Error(w, "Invalid code, check the time on the device", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := orm.WithAudit(auditapi.MetaFromRequest(r)).EnableTwoFactor(userID, step, hashes); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// post method
+// url /user/2fa/recovery-codes, body {"code": "..."}, new recovery codes instead of the old ones
+func RecoveryCodesHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := secondFactorRequest(w, r, orm)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := orm.WithAudit(auditapi.MetaFromRequest(r)).ReplaceRecoveryCodes(userID, hashes); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"recovery_codes": codes})
+}
+
+// delete method
+// url /user/2fa, body {"code": "..."}. Admins lose admin rights from the next login while RequireAdminTwoFactor
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := secondFactorRequest(w, r, orm)
+	if !ok {
+		return
+	}
+
+	if err := orm.WithAudit(auditapi.MetaFromRequest(r)).DisableTwoFactor(userID); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// utility: caller with a login token and a right second factor in the body {"code": "..."}
+func secondFactorRequest(w http.ResponseWriter, r *http.Request, users *orm.ORM) (int, bool) {
+	userID, ok := loginUserID(w, r)
+	if !ok {
+		return 0, false
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return 0, false
+	}
+
+	if wait := accountBlocked(users, userID); wait > 0 {
+		setRetryAfter(w, wait)
+		http.Error(w, "Account is locked after failed logins, try again later", http.StatusTooManyRequests)
+		return 0, false
+	}
+	valid, err := checkSecondFactor(users, userID, body.Code)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if !valid {
+		wrongSecondFactor(w, r, users, userID)
+		return 0, false
+	}
+	return userID, true
+}
@@ -0,0 +1,253 @@
+package userapi_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/totp"
+	"github.com/vexrina/cinemaLibrary/pkg/userapi"
+)
+
+const testSecret = "JBSWY3DPEHPK3PXP"
+
+// an empty secret means two-factor authentication was never set up
+func expectTwoFactor(mock sqlmock.Sqlmock, userID int, secret string, enabled bool) {
+	rows := sqlmock.NewRows([]string{"secret", "enabled"})
+	if secret != "" {
+		rows.AddRow(secret, enabled)
+	}
+	mock.ExpectQuery("SELECT secret, enabled_at IS NOT NULL FROM user_totp WHERE user_id = \\$1").WithArgs(userID).WillReturnRows(rows)
+}
+
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func currentCode(t *testing.T) string {
+	code, err := totp.Code(testSecret, totp.Step(time.Now()))
+	assert.NoError(t, err)
+	return code
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, hashes, err := userapi.NewRecoveryCodes()
+	assert.NoError(t, err)
+	assert.Len(t, codes, 10)
+	assert.Len(t, hashes, 10)
+	for i, code := range codes {
+		assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`), code)
+		assert.Equal(t, userapi.HashToken(strings.ReplaceAll(code, "-", "")), hashes[i])
+	}
+	assert.NotEqual(t, codes[0], codes[1])
+}
+
+func TestLoginHandler_TwoFactorChallenge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	expectLoginNotBlocked(mock, "ip", sqlmock.AnyArg())
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").
+		WithArgs("test@example.com").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(9, "testuser", "test@example.com", true, true, false, "$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"))
+	expectLoginNotBlocked(mock, "user", "9")
+	expectTwoFactor(mock, 9, testSecret, true)
+	// failed logins are not cleared before the second factor
+	mock.ExpectExec("INSERT INTO user_tokens").WithArgs(9, "login_challenge", sqlmock.AnyArg(), 300).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.LoginHandler(w, r, orm) },
+		"/user/login", map[string]string{"login": "test@example.com", "password": "testpassword"})
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, true, response["two_factor_required"])
+	assert.NotEmpty(t, response["challenge"])
+	assert.Nil(t, response["token"])
+}
+
+func TestLoginTwoFactorHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := func(w http.ResponseWriter, r *http.Request) { userapi.LoginTwoFactorHandler(w, r, orm) }
+	challengeHash := userapi.HashToken("challenge")
+	expectChallenge := func() {
+		expectLoginNotBlocked(mock, "ip", sqlmock.AnyArg())
+		mock.ExpectQuery("SELECT user_id FROM user_tokens WHERE token_hash = \\$1 AND kind = \\$2").
+			WithArgs(challengeHash, "login_challenge").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(9))
+		expectLoginNotBlocked(mock, "user", "9")
+		expectTwoFactor(mock, 9, testSecret, true)
+	}
+
+	// a wrong code is a failed login, the challenge stays
+	expectChallenge()
+	mock.ExpectExec("UPDATE recovery_codes SET used_at = NOW\\(\\)").WithArgs(9, userapi.HashToken("000000")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectLoginFailure(mock, "ip", sqlmock.AnyArg(), 1)
+	expectLoginFailure(mock, "user", "9", 1)
+
+	rr := postJSON(handler, "/user/login/2fa", map[string]string{"challenge": "challenge", "code": "000000"})
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// the code of the app makes an admin token
+	expectChallenge()
+	mock.ExpectExec("UPDATE user_totp SET last_step = \\$1 WHERE user_id = \\$2 AND enabled_at IS NOT NULL AND last_step < \\$1").
+		WithArgs(sqlmock.AnyArg(), 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE user_tokens SET used_at = NOW\\(\\)").WithArgs(challengeHash, "login_challenge").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(9))
+	mock.ExpectCommit()
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "9").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM users WHERE id = \\$1").WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(9, "testuser", "test@example.com", true, true, false, "hash"))
+
+	rr = postJSON(handler, "/user/login/2fa", map[string]string{"challenge": "challenge", "code": currentCode(t)})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var response map[string]string
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	req, _ := http.NewRequest(http.MethodGet, "/admin/audit", nil)
+	req.Header.Set("Authorization", "Bearer "+response["token"])
+	claims, err := tokens.GetClaims(req)
+	if assert.NoError(t, err) {
+		assert.True(t, claims.Admin)
+	}
+	assert.Empty(t, response["warning"])
+}
+
+func TestLoginTwoFactorHandler_RecoveryCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	challengeHash := userapi.HashToken("challenge")
+
+	expectLoginNotBlocked(mock, "ip", sqlmock.AnyArg())
+	mock.ExpectQuery("SELECT user_id FROM user_tokens").WithArgs(challengeHash, "login_challenge").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(9))
+	expectLoginNotBlocked(mock, "user", "9")
+	expectTwoFactor(mock, 9, testSecret, true)
+	// case and dashes of the code do not matter
+	mock.ExpectExec("UPDATE recovery_codes SET used_at = NOW\\(\\)").WithArgs(9, userapi.HashToken("abcdefghijklmnop")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE user_tokens SET used_at = NOW\\(\\)").WithArgs(challengeHash, "login_challenge").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(9))
+	mock.ExpectCommit()
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "9").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("FROM users WHERE id = \\$1").WithArgs(9).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(9, "testuser", "test@example.com", false, true, false, "hash"))
+
+	rr := postJSON(func(w http.ResponseWriter, r *http.Request) { userapi.LoginTwoFactorHandler(w, r, orm) },
+		"/user/login/2fa", map[string]string{"challenge": "challenge", "code": "ABCD-EFGH-IJKL-MNOP"})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTwoFactorSetup(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	mock.ExpectExec("INSERT INTO user_totp \\(user_id, secret\\) VALUES \\(\\$1, \\$2\\)").WithArgs(4, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rr := serve(func(w http.ResponseWriter, r *http.Request) { userapi.SetupTwoFactorHandler(w, r, orm) },
+		authorized(http.MethodPost, "/user/2fa/setup", nil, 4, "testuser"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var setup map[string]string
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &setup))
+	assert.Len(t, setup["secret"], 32)
+	assert.True(t, strings.HasPrefix(setup["uri"], "otpauth://totp/cinemaLibrary:testuser?"), setup["uri"])
+
+	// on already
+	mock.ExpectExec("INSERT INTO user_totp").WithArgs(4, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	rr = serve(func(w http.ResponseWriter, r *http.Request) { userapi.SetupTwoFactorHandler(w, r, orm) },
+		authorized(http.MethodPost, "/user/2fa/setup", nil, 4, "testuser"))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTwoFactorEnable(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := func(w http.ResponseWriter, r *http.Request) { userapi.EnableTwoFactorHandler(w, r, orm) }
+
+	// a wrong code does not enable it
+	expectTwoFactor(mock, 4, testSecret, false)
+	rr := serve(handler, authorized(http.MethodPost, "/user/2fa/enable", map[string]string{"code": "000000"}, 4, "testuser"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	expectTwoFactor(mock, 4, testSecret, false)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE user_totp SET enabled_at = NOW\\(\\), last_step = \\$1 WHERE user_id = \\$2 AND enabled_at IS NULL").
+		WithArgs(sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM recovery_codes WHERE user_id = \\$1").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+	for i := 0; i < 10; i++ {
+		mock.ExpectExec("INSERT INTO recovery_codes").WithArgs(4, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("testuser", "enable_2fa", "user", 4, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr = serve(handler, authorized(http.MethodPost, "/user/2fa/enable", map[string]string{"code": currentCode(t)}, 4, "testuser"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var response map[string][]string
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Len(t, response["recovery_codes"], 10)
+}
+
+func TestTwoFactorDisable_WrongCode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+
+	expectLoginNotBlocked(mock, "user", "4")
+	expectTwoFactor(mock, 4, testSecret, true)
+	mock.ExpectExec("UPDATE recovery_codes SET used_at").WithArgs(4, userapi.HashToken("nope")).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectLoginFailure(mock, "ip", sqlmock.AnyArg(), 1)
+	expectLoginFailure(mock, "user", "4", 1)
+
+	rr := serve(func(w http.ResponseWriter, r *http.Request) { userapi.DisableTwoFactorHandler(w, r, orm) },
+		authorized(http.MethodDelete, "/user/2fa", map[string]string{"code": "nope"}, 4, "testuser"))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
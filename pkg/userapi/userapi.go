@@ -2,17 +2,19 @@ package userapi
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 
-	"golang.org/x/crypto/bcrypt"
-
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/mailer"
 	"github.com/vexrina/cinemaLibrary/pkg/orm"
-	"github.com/vexrina/cinemaLibrary/pkg/tokens"
+	"github.com/vexrina/cinemaLibrary/pkg/passwords"
 	"github.com/vexrina/cinemaLibrary/pkg/types"
 )
 
 
-func RegisterHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+// the account is created unverified, the verification link goes by mail
+func RegisterHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, mail mailer.Mailer) {
 	var user types.User
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
@@ -24,6 +26,14 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 		http.Error(w, "missing required fields", http.StatusBadRequest)
 		return
 	}
+	if err := validateProfile(user.Username, user.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := passwords.Check(user.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	count, err := orm.CountUsersWithUsernameAndEmail(user.Username, user.Email)
 	if err != nil {
@@ -35,54 +45,98 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	hashedPassword, err := passwords.Hash(user.Password)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = orm.CreateUser(user.Username, user.Email, string(hashedPassword))
+	// the new user is not logged in yet, the orm makes them the author of their own record
+	userID, err := orm.WithAudit(auditapi.MetaFromRequest(r)).CreateUser(user.Username, user.Email, hashedPassword)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// the account exists already, so a failed mail is not an error of registration: the link can be sent again
+	if err := sendVerification(orm, mail, userID, user.Username, user.Email); err != nil {
+		log.Println("Error sending verification mail:", err)
+	}
+
 	w.WriteHeader(http.StatusCreated)
 }
 
+// login is the email or the username, "email" and "username" fields are accepted for it as well.
+// The token is signed with the stored id and username, never with what the client sent
 func LoginHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
-	var user types.User
-	err := json.NewDecoder(r.Body).Decode(&user)
+	var credentials struct {
+		Login    string `json:"login"`
+		Email    string `json:"email"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&credentials)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	storedPassword, adminflag, err := orm.GetUserPasswordByEmail(user.Email)
-	if err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+	login := credentials.Login
+	if login == "" {
+		login = credentials.Email
+	}
+	if login == "" {
+		login = credentials.Username
+	}
+	if login == "" || credentials.Password == "" {
+		http.Error(w, "missing required fields", http.StatusBadRequest)
 		return
 	}
-	
-	err = bcrypt.CompareHashAndPassword([]byte(storedPassword), []byte(user.Password))
-	if err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+
+	ip := auditapi.ClientIP(r)
+	if wait := ipBlocked(orm, ip); wait > 0 {
+		setRetryAfter(w, wait)
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
 		return
 	}
 
-	tokenString, err := tokens.CreateToken(user.Username, adminflag)
-	if err != nil {
-		http.Error(w, "Error with creating token", http.StatusUnauthorized)
-		w.WriteHeader(http.StatusUnauthorized)
+	// a locked account answers like an unknown login and takes as long, so that neither tells which accounts exist
+	user, err := orm.GetUserByLogin(login)
+	if err != nil || accountBlocked(orm, user.ID) > 0 {
+		verifyNoPassword(credentials.Password)
+		if wait := failedLogin(orm, r, ip, 0); wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		http.Error(w, "Invalid login or password", http.StatusUnauthorized)
 		return
 	}
 
-	response := map[string]string{"token":tokenString}
-	
-	err = json.NewEncoder(w).Encode(response)
-	if err!=nil{
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+	valid, rehash := passwords.Verify(user.Password, credentials.Password)
+	if !valid {
+		if wait := failedLogin(orm, r, ip, user.ID); wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		http.Error(w, "Invalid login or password", http.StatusUnauthorized)
+		return
+	}
+	if rehash {
+		rehashPassword(orm, user, credentials.Password)
+	}
+	if RequireVerification && !user.Verified {
+		http.Error(w, "Email is not verified", http.StatusForbidden)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-}
\ No newline at end of file
+
+	completeLogin(w, orm, user)
+}
+
+// utility: a failed rehash is not an error of the login, the old hash keeps working
+func rehashPassword(orm *orm.ORM, user types.UserProfile, password string) {
+	hash, err := passwords.Hash(password)
+	if err == nil {
+		err = orm.RehashPassword(user.ID, user.Password, hash)
+	}
+	if err != nil {
+		log.Println("Error rehashing password:", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/vexrina/cinemaLibrary/pkg/mailer"
 	"github.com/vexrina/cinemaLibrary/pkg/orm"
 	"github.com/vexrina/cinemaLibrary/pkg/userapi"
 )
@@ -26,7 +27,7 @@ func TestRegisterHandler(t *testing.T) {
 	orm := orm.NewORM(db)
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		userapi.RegisterHandler(w, r, orm)
+		userapi.RegisterHandler(w, r, orm, mailer.NewLog("noreply@cinema.local"))
 	})
 
 
@@ -69,7 +70,11 @@ func TestRegisterHandler(t *testing.T) {
 					mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 				} else {
 					mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
-					mock.ExpectExec("INSERT INTO users").WithArgs("testuser", "test@example.com", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+					mock.ExpectBegin()
+					mock.ExpectQuery("INSERT INTO users").WithArgs("testuser", "test@example.com", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+					mock.ExpectExec("INSERT INTO audit_log").WithArgs("testuser", "create", "user", 1, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(1, 1))
+					mock.ExpectCommit()
+					mock.ExpectExec("INSERT INTO user_tokens").WithArgs(1, "verify_email", sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
 				}
 			}
 
@@ -98,7 +103,11 @@ func TestLoginHandler_SuccessfulLogin(t *testing.T) {
 		userapi.LoginHandler(w, r, orm)
 	})
 
-	mock.ExpectQuery("SELECT password, adminflag FROM users WHERE email=?").WithArgs("test@example.com").WillReturnRows(sqlmock.NewRows([]string{"password", "adminflag"}).AddRow("$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG", false))
+	expectLoginNotBlocked(mock, "ip", sqlmock.AnyArg())
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").WithArgs("test@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}).AddRow(1, "testuser", "test@example.com", false, true, false, "$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"))
+	expectLoginNotBlocked(mock, "user", "1")
+	expectTwoFactor(mock, 1, "", false)
+	mock.ExpectExec("DELETE FROM login_failures").WithArgs("user", "1").WillReturnResult(sqlmock.NewResult(0, 0))
 
 	requestBody := map[string]string{
 		"email":    "test@example.com",
@@ -138,7 +147,9 @@ func TestLoginHandler_InvalidCredentials(t *testing.T) {
         userapi.LoginHandler(w, r, orm)
     })
 
-    mock.ExpectQuery("SELECT password, adminflag FROM users WHERE email=?").WithArgs("test@example.com").WillReturnError(errors.New("invalid credentials"))
+    expectLoginNotBlocked(mock, "ip", sqlmock.AnyArg())
+    mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").WithArgs("test@example.com").WillReturnError(errors.New("invalid credentials"))
+    expectLoginFailure(mock, "ip", sqlmock.AnyArg(), 1)
 
     requestBody := map[string]string{
         "email":    "test@example.com",
@@ -171,7 +182,11 @@ func TestLoginHandler_UnsuccessfulLogin(t *testing.T) {
 		userapi.LoginHandler(w, r, orm)
 	})
 
-	mock.ExpectQuery("SELECT password, adminflag FROM users WHERE email=?").WithArgs("test@example.com").WillReturnRows(sqlmock.NewRows([]string{"password", "adminflag"}).AddRow("$2a$Y7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG", false))
+	expectLoginNotBlocked(mock, "ip", sqlmock.AnyArg())
+	mock.ExpectQuery("SELECT id, username, email, adminflag, verified_at IS NOT NULL, disabled_at IS NOT NULL, password FROM users").WithArgs("test@example.com").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "adminflag", "verified", "disabled", "password"}).AddRow(1, "testuser", "test@example.com", false, true, false, "$2a$Y7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"))
+	expectLoginNotBlocked(mock, "user", "1")
+	expectLoginFailure(mock, "ip", sqlmock.AnyArg(), 1)
+	expectLoginFailure(mock, "user", "1", 1)
 
 	requestBody := map[string]string{
 		"email":    "test@example.com",
@@ -199,7 +214,7 @@ func TestRegisterHandler_InternalServerError(t *testing.T) {
     orm := orm.NewORM(db)
 
     handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        userapi.RegisterHandler(w, r, orm)
+        userapi.RegisterHandler(w, r, orm, mailer.NewLog("noreply@cinema.local"))
     })
 
     mock.ExpectQuery("SELECT count\\(\\*\\) FROM users WHERE username=? OR email=?").WithArgs("existinguser", "existing@example.com").WillReturnError(errors.New("database error"))
@@ -0,0 +1,174 @@
+// pkg/userapi/userdata.go
+package userapi
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vexrina/cinemaLibrary/pkg/auditapi"
+	"github.com/vexrina/cinemaLibrary/pkg/httperror"
+	"github.com/vexrina/cinemaLibrary/pkg/mailer"
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/passwords"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+)
+
+// how long a deleted account can still be restored, see ACCOUNT_DELETION_DAYS in main.go
+var AccountDeletionGrace = 30 * 24 * time.Hour
+
+// utility: files of the zip export, one for each part of types.UserExport
+func writeExportZip(w http.ResponseWriter, export types.UserExport) error {
+	archive := zip.NewWriter(w)
+	parts := []struct {
+		name  string
+		value interface{}
+	}{
+		{"account.json", export.Account},
+		{"api_keys.json", export.APIKeys},
+		{"identities.json", export.Identities},
+		{"audit_log.json", export.AuditLog},
+		{"revisions.json", export.Revisions},
+	}
+	for _, part := range parts {
+		file, err := archive.Create(part.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(part.value); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// get method
+// url /user/me/export?format=json or ?format=zip, everything stored about the token owner as a download.
+// Needs a login token, api keys can not take the personal data out
+func ExportMeHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := loginUserID(w, r)
+	if !ok {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "zip" {
+		http.Error(w, "Invalid value for format parameter, json or zip", http.StatusBadRequest)
+		return
+	}
+
+	export, err := orm.ExportUserData(userID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="user-export.`+format+`"`)
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(export)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	if err := writeExportZip(w, export); err != nil {
+		// the headers are gone already, the client gets a broken archive
+		log.Println("Error writing export:", err)
+	}
+}
+
+// utility
+func sendDeletionNotice(mail mailer.Mailer, username, email string, deleteAfter time.Time) error {
+	return mail.Send(mailer.Message{
+		To:      email,
+		Subject: "Account deletion",
+		Body: "Hello, " + username + "!\n\n" +
+			"Your account and your personal data will be deleted on " + deleteAfter.UTC().Format("2006-01-02 15:04 MST") + ".\n" +
+			"Until then you can cancel it after logging in at " + AppURL + ".\n\n" +
+			"If you did not ask for it, log in, cancel the deletion and change your password.\n",
+	})
+}
+
+// delete method
+// url /user/me, body {"password": "...", "code": "123456"}. The account is deleted after AccountDeletionGrace
+// and works until then. The password is needed when the user has one, the code when two-factor authentication is on
+func DeleteMeHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM, mail mailer.Mailer) {
+	userID, ok := loginUserID(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := orm.GetUserByID(userID)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	// users made by a login provider have no password
+	if valid, _ := passwords.Verify(user.Password, body.Password); user.Password != "" && !valid {
+		http.Error(w, "Password is wrong", http.StatusForbidden)
+		return
+	}
+	_, enabled, err := orm.GetTOTP(userID)
+	if err != nil && !isNotFound(err) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if enabled {
+		if wait := accountBlocked(orm, userID); wait > 0 {
+			setRetryAfter(w, wait)
+			http.Error(w, "Account is locked after failed logins, try again later", http.StatusTooManyRequests)
+			return
+		}
+		valid, err := checkSecondFactor(orm, userID, body.Code)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !valid {
+			wrongSecondFactor(w, r, orm, userID)
+			return
+		}
+	}
+
+	deleteAfter, err := orm.WithAudit(auditapi.MetaFromRequest(r)).ScheduleUserDeletion(userID, AccountDeletionGrace)
+	if err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+	if err := sendDeletionNotice(mail, user.Username, user.Email, deleteAfter); err != nil {
+		log.Println("Error sending deletion mail:", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"delete_after": deleteAfter.UTC().Format(time.RFC3339)})
+}
+
+// post method
+// url /user/me/restore, cancels the deletion of the account
+func RestoreMeHandler(w http.ResponseWriter, r *http.Request, orm *orm.ORM) {
+	userID, ok := loginUserID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := orm.WithAudit(auditapi.MetaFromRequest(r)).RestoreUserDeletion(userID); err != nil {
+		http.Error(w, err.Error(), httperror.Status(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
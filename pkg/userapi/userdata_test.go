@@ -0,0 +1,141 @@
+package userapi_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/vexrina/cinemaLibrary/pkg/orm"
+	"github.com/vexrina/cinemaLibrary/pkg/types"
+	"github.com/vexrina/cinemaLibrary/pkg/userapi"
+)
+
+func expectExport(mock sqlmock.Sqlmock, userID int, username string) {
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM users u WHERE u.id = \\$1").WithArgs(userID, "user").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "adminflag", "verified", "created_at", "disabled_at", "delete_after", "two_factor", "providers", "api_keys", "locked_for"}).
+			AddRow(userID, username, "bob@example.com", false, true, createdAt, nil, nil, false, "{corp}", 1, 0))
+	mock.ExpectQuery("FROM api_keys WHERE user_id = \\$1").WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "prefix", "scopes", "created_at", "expires_at", "last_used_at"}).
+			AddRow(3, "backup", "ck_abcdefg", "{read}", createdAt, createdAt.Add(90*24*time.Hour), nil))
+	mock.ExpectQuery("FROM user_identities WHERE user_id = \\$1").WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"provider", "subject", "email", "created_at", "last_login_at"}).AddRow("corp", "u-1", "bob@example.com", createdAt, createdAt))
+	mock.ExpectQuery("FROM audit_log WHERE user_id = \\$1 OR \\(entity_type = 'user' AND entity_id = \\$1\\)").WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "action", "entity_type", "entity_id", "before_data", "after_data", "request_id", "ip", "created_at"}).
+			AddRow(8, username, "update", "film", 2, nil, []byte(`{"title":"New"}`), "req-1", "192.0.2.1", createdAt))
+	mock.ExpectQuery("FROM film_revisions WHERE user_id = \\$1").WithArgs(userID).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "id", "revision", "created_at"}).AddRow("film", 2, 4, createdAt))
+}
+
+func TestExportMeHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.ExportMeHandler(w, r, orm) })
+
+	expectExport(mock, 5, "bob")
+	rr := serve(handler, authorized(http.MethodGet, "/user/me/export", nil, 5, "bob"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), "user-export.json")
+
+	var export types.UserExport
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &export))
+	assert.Equal(t, "bob", export.Account.Username)
+	assert.Equal(t, []string{"corp"}, export.Account.Providers)
+	assert.Len(t, export.APIKeys, 1)
+	assert.Len(t, export.Identities, 1)
+	assert.Len(t, export.AuditLog, 1)
+	assert.Equal(t, []types.AuthoredRevision{{EntityType: "film", EntityID: 2, Revision: 4, CreatedAt: "2024-03-01T12:00:00Z"}}, export.Revisions)
+
+	expectExport(mock, 5, "bob")
+	rr = serve(handler, authorized(http.MethodGet, "/user/me/export?format=zip", nil, 5, "bob"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/zip", rr.Header().Get("Content-Type"))
+	archive, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if assert.NoError(t, err) {
+		var names []string
+		for _, file := range archive.File {
+			names = append(names, file.Name)
+		}
+		assert.Equal(t, []string{"account.json", "api_keys.json", "identities.json", "audit_log.json", "revisions.json"}, names)
+
+		file, err := archive.File[0].Open()
+		if assert.NoError(t, err) {
+			data, _ := io.ReadAll(file)
+			assert.Contains(t, string(data), `"username": "bob"`)
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rr = serve(handler, authorized(http.MethodGet, "/user/me/export?format=csv", nil, 5, "bob"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestDeleteMeHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	mail := &sentMail{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.DeleteMeHandler(w, r, orm, mail) })
+	hash := "$2a$10$jbRk/x7EcY7yM7jjLo/uYuCfJ48pJXQo2nFpPOJg.4LNmlvX3JPIG"
+
+	mock.ExpectQuery("FROM users WHERE id = \\$1").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(5, "bob", "bob@example.com", false, true, false, hash))
+	rr := serve(handler, authorized(http.MethodDelete, "/user/me", map[string]string{"password": "wrong"}, 5, "bob"))
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	deleteAfter := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM users WHERE id = \\$1").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(5, "bob", "bob@example.com", false, true, false, hash))
+	expectTwoFactor(mock, 5, "", false)
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE users SET delete_after = NOW\\(\\) \\+ \\$2 \\* INTERVAL '1 second' WHERE id = \\$1 AND delete_after IS NULL").
+		WithArgs(5, 30*24*3600).
+		WillReturnRows(sqlmock.NewRows([]string{"delete_after"}).AddRow(deleteAfter))
+	mock.ExpectExec("INSERT INTO audit_log").WithArgs("bob", "schedule_delete", "user", 5, nil, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	rr = serve(handler, authorized(http.MethodDelete, "/user/me", map[string]string{"password": "testpassword"}, 5, "bob"))
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	assert.JSONEq(t, `{"delete_after":"2024-04-01T12:00:00Z"}`, rr.Body.String())
+	assert.NoError(t, mock.ExpectationsWereMet())
+	if assert.Len(t, mail.messages, 1) {
+		assert.Equal(t, "bob@example.com", mail.messages[0].To)
+		assert.Contains(t, mail.messages[0].Body, "2024-04-01")
+	}
+}
+
+func TestRestoreMeHandler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	orm := orm.NewORM(db)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { userapi.RestoreMeHandler(w, r, orm) })
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET delete_after = NULL WHERE id = \\$1 AND delete_after IS NOT NULL").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	rr := serve(handler, authorized(http.MethodPost, "/user/me/restore", nil, 5, "bob"))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}